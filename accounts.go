@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/cockroachdb/apd"
+)
+
+// ErrInsufficientFunds is returned when an account doesn't have enough free balance.
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// AccountID is a unique account id.
+type AccountID string
+
+// Balance is a balance of one Asset in the account.
+type Balance struct {
+	// Available is a free balance, it can be reserved or withdrawn.
+	Available *apd.Decimal
+	// Reserved is a balance locked by the open orders.
+	Reserved *apd.Decimal
+}
+
+// Accounts is a ledger of balances of all accounts.
+// It can be shared between several order books, because one Asset can be used in different pairs.
+type Accounts struct {
+	balances map[AccountID]map[Asset]*Balance
+
+	mx sync.Mutex
+}
+
+// NewAccounts creates a new instance of Accounts.
+func NewAccounts() *Accounts {
+	return &Accounts{
+		balances: map[AccountID]map[Asset]*Balance{},
+		mx:       sync.Mutex{},
+	}
+}
+
+// balance returns the balance of the account, it creates an empty one if there is no balance yet.
+func (a *Accounts) balance(account AccountID, asset Asset) *Balance {
+	assets, ok := a.balances[account]
+	if !ok {
+		assets = map[Asset]*Balance{}
+		a.balances[account] = assets
+	}
+
+	b, ok := assets[asset]
+	if !ok {
+		b = &Balance{
+			Available: apd.New(0, 0),
+			Reserved:  apd.New(0, 0),
+		}
+		assets[asset] = b
+	}
+
+	return b
+}
+
+// Balance returns a copy of the balance of the account.
+func (a *Accounts) Balance(account AccountID, asset Asset) Balance {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	b := a.balance(account, asset)
+
+	return Balance{
+		Available: apd.New(0, 0).Set(b.Available),
+		Reserved:  apd.New(0, 0).Set(b.Reserved),
+	}
+}
+
+// Deposit adds amount to the available balance. It isn't journaled, OrderBook.Deposit is.
+func (a *Accounts) Deposit(account AccountID, asset Asset, amount *apd.Decimal) error {
+	if amount.Sign() < 0 {
+		return fmt.Errorf("can't deposit negative amount: %s", amount)
+	}
+
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	b := a.balance(account, asset)
+	_, err := apd.BaseContext.Add(b.Available, b.Available, amount)
+
+	return err
+}
+
+// Withdraw takes amount from the available balance. It isn't journaled, OrderBook.Withdraw is.
+func (a *Accounts) Withdraw(account AccountID, asset Asset, amount *apd.Decimal) error {
+	if amount.Sign() < 0 {
+		return fmt.Errorf("can't withdraw negative amount: %s", amount)
+	}
+
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	b := a.balance(account, asset)
+	if b.Available.Cmp(amount) < 0 {
+		return fmt.Errorf("can't withdraw %s %s from %s: %w", amount, asset, account, ErrInsufficientFunds)
+	}
+
+	_, err := apd.BaseContext.Sub(b.Available, b.Available, amount)
+
+	return err
+}
+
+// Reserve moves amount from the available balance to the reserved one.
+func (a *Accounts) Reserve(account AccountID, asset Asset, amount *apd.Decimal) error {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	b := a.balance(account, asset)
+	if b.Available.Cmp(amount) < 0 {
+		return fmt.Errorf("can't reserve %s %s for %s: %w", amount, asset, account, ErrInsufficientFunds)
+	}
+
+	_, err := apd.BaseContext.Sub(b.Available, b.Available, amount)
+	if err != nil {
+		return err
+	}
+
+	_, err = apd.BaseContext.Add(b.Reserved, b.Reserved, amount)
+
+	return err
+}
+
+// Release moves amount from the reserved balance back to the available one.
+func (a *Accounts) Release(account AccountID, asset Asset, amount *apd.Decimal) error {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	b := a.balance(account, asset)
+	if b.Reserved.Cmp(amount) < 0 {
+		return fmt.Errorf("can't release %s %s for %s: %w", amount, asset, account, ErrInsufficientFunds)
+	}
+
+	_, err := apd.BaseContext.Sub(b.Reserved, b.Reserved, amount)
+	if err != nil {
+		return err
+	}
+
+	_, err = apd.BaseContext.Add(b.Available, b.Available, amount)
+
+	return err
+}
+
+// TransferReserved moves amount from the reserved balance of one account to the available balance of another.
+// It's used to settle the fills: reserved funds of the order go to the counterparty.
+func (a *Accounts) TransferReserved(from, to AccountID, asset Asset, amount *apd.Decimal) error {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	src := a.balance(from, asset)
+	if src.Reserved.Cmp(amount) < 0 {
+		return fmt.Errorf("can't transfer %s %s from %s: %w", amount, asset, from, ErrInsufficientFunds)
+	}
+
+	_, err := apd.BaseContext.Sub(src.Reserved, src.Reserved, amount)
+	if err != nil {
+		return err
+	}
+
+	dst := a.balance(to, asset)
+	_, err = apd.BaseContext.Add(dst.Available, dst.Available, amount)
+
+	return err
+}
+
+// TransferAvailable moves amount from the available balance of one account to the available balance of another.
+func (a *Accounts) TransferAvailable(from, to AccountID, asset Asset, amount *apd.Decimal) error {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	src := a.balance(from, asset)
+	if src.Available.Cmp(amount) < 0 {
+		return fmt.Errorf("can't transfer %s %s from %s: %w", amount, asset, from, ErrInsufficientFunds)
+	}
+
+	_, err := apd.BaseContext.Sub(src.Available, src.Available, amount)
+	if err != nil {
+		return err
+	}
+
+	dst := a.balance(to, asset)
+	_, err = apd.BaseContext.Add(dst.Available, dst.Available, amount)
+
+	return err
+}
+
+// balanceOp is a kind of balanceMove.
+type balanceOp int
+
+const (
+	// opReserve moves the amount from the available balance to the reserved one.
+	opReserve balanceOp = iota
+	// opRelease moves the amount from the reserved balance to the available one.
+	opRelease
+	// opTransferAvailable moves the amount from the available balance of one account to the available balance of another.
+	opTransferAvailable
+	// opTransferReserved moves the amount from the reserved balance of one account to the available balance of another.
+	opTransferReserved
+	// opDeposit adds the amount to the available balance.
+	opDeposit
+	// opWithdraw takes the amount from the available balance.
+	opWithdraw
+)
+
+// balanceMove is a change of the balances which is applied by apply with the others.
+type balanceMove struct {
+	op     balanceOp
+	from   AccountID
+	to     AccountID
+	asset  Asset
+	amount *apd.Decimal
+}
+
+// apply applies all moves in order or nothing if some of them fails. The commit is called under the lock
+// when all moves are checked, they are applied only if it succeeds, so the balances can't be changed between.
+func (a *Accounts) apply(moves []balanceMove, commit func() error) error {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	balances, err := a.move(moves)
+	if err != nil {
+		return err
+	}
+
+	if commit != nil {
+		err = commit()
+		if err != nil {
+			return err
+		}
+	}
+
+	for k, b := range balances {
+		dst := a.balance(k.account, k.asset)
+		dst.Available.Set(b.Available)
+		dst.Reserved.Set(b.Reserved)
+	}
+
+	return nil
+}
+
+// balanceKey is a balance of the account in the asset.
+type balanceKey struct {
+	account AccountID
+	asset   Asset
+}
+
+// move applies the moves to the copies of the balances and returns them, it must be called under the lock.
+func (a *Accounts) move(moves []balanceMove) (map[balanceKey]*Balance, error) {
+	// the moves are applied to the copies of the balances, they are set only if all moves are done.
+	balances := map[balanceKey]*Balance{}
+	balance := func(account AccountID, asset Asset) *Balance {
+		k := balanceKey{account: account, asset: asset}
+		b, ok := balances[k]
+		if !ok {
+			src := a.balance(account, asset)
+			b = &Balance{Available: apd.New(0, 0).Set(src.Available), Reserved: apd.New(0, 0).Set(src.Reserved)}
+			balances[k] = b
+		}
+
+		return b
+	}
+
+	for _, m := range moves {
+		var src, dst *apd.Decimal
+		switch m.op {
+		case opReserve:
+			b := balance(m.from, m.asset)
+			if b.Available.Cmp(m.amount) < 0 {
+				return nil, fmt.Errorf("can't reserve %s %s for %s: %w", m.amount, m.asset, m.from, ErrInsufficientFunds)
+			}
+			src, dst = b.Available, b.Reserved
+
+		case opRelease:
+			b := balance(m.from, m.asset)
+			if b.Reserved.Cmp(m.amount) < 0 {
+				return nil, fmt.Errorf("can't release %s %s for %s: %w", m.amount, m.asset, m.from, ErrInsufficientFunds)
+			}
+			src, dst = b.Reserved, b.Available
+
+		case opTransferAvailable:
+			src = balance(m.from, m.asset).Available
+			if src.Cmp(m.amount) < 0 {
+				return nil, fmt.Errorf("can't transfer %s %s from %s: %w", m.amount, m.asset, m.from, ErrInsufficientFunds)
+			}
+			dst = balance(m.to, m.asset).Available
+
+		case opTransferReserved:
+			src = balance(m.from, m.asset).Reserved
+			if src.Cmp(m.amount) < 0 {
+				return nil, fmt.Errorf("can't transfer %s %s from %s: %w", m.amount, m.asset, m.from, ErrInsufficientFunds)
+			}
+			dst = balance(m.to, m.asset).Available
+
+		case opDeposit:
+			dst = balance(m.from, m.asset).Available
+
+		case opWithdraw:
+			src = balance(m.from, m.asset).Available
+			if src.Cmp(m.amount) < 0 {
+				return nil, fmt.Errorf("can't withdraw %s %s from %s: %w", m.amount, m.asset, m.from, ErrInsufficientFunds)
+			}
+
+		default:
+			return nil, fmt.Errorf("unknown balance operation: %d", m.op)
+		}
+
+		if src != nil {
+			_, err := apd.BaseContext.Sub(src, src, m.amount)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if dst != nil {
+			_, err := apd.BaseContext.Add(dst, dst, m.amount)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return balances, nil
+}
+
+// Deposit adds the amount to the available balance of the account in Accounts of the order book.
+// The deposit is journaled, so the recovery rebuilds the balance.
+func (ob *OrderBook) Deposit(ctx context.Context, account AccountID, asset Asset, amount *apd.Decimal) error {
+	_, err := ob.Apply(ctx, Command{Type: CommandDeposit, AccountID: account, Asset: asset, Amount: amount})
+
+	return err
+}
+
+// Withdraw takes the amount from the available balance of the account in Accounts of the order book.
+// The withdrawal is journaled, so the recovery rebuilds the balance.
+func (ob *OrderBook) Withdraw(ctx context.Context, account AccountID, asset Asset, amount *apd.Decimal) error {
+	_, err := ob.Apply(ctx, Command{Type: CommandWithdraw, AccountID: account, Asset: asset, Amount: amount})
+
+	return err
+}
+
+// transfer deposits or withdraws the amount of the command, the asset must be traded by the order book.
+func (ob *OrderBook) transfer(c Command) error {
+	if ob.Accounts == nil {
+		return ob.reject(c, fmt.Errorf("can't %s: order book has no accounts", c.Type))
+	}
+	if c.Asset != ob.BaseAsset && c.Asset != ob.QuoteAsset {
+		return ob.reject(c, fmt.Errorf("can't %s: asset: %s isn't traded by %s", c.Type, c.Asset, ob.Instrument()))
+	}
+	if c.Amount == nil || c.Amount.Sign() <= 0 {
+		return ob.reject(c, fmt.Errorf("can't %s: amount must be positive", c.Type))
+	}
+
+	op := opDeposit
+	if c.Type == CommandWithdraw {
+		op = opWithdraw
+	}
+
+	err := ob.commit(c, []balanceMove{{op: op, from: c.AccountID, asset: c.Asset, amount: c.Amount}})
+	if err != nil {
+		return ob.reject(c, fmt.Errorf("can't %s: %w", c.Type, err))
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cockroachdb/apd"
+)
+
+func checkBalance(t *testing.T, a *Accounts, account AccountID, asset Asset, available, reserved string) {
+	t.Helper()
+
+	b := a.Balance(account, asset)
+	if b.Available.Cmp(decimal(t, available)) != 0 {
+		t.Fatalf("expected available %s %s for %s, but got: %s", available, asset, account, b.Available)
+	}
+	if b.Reserved.Cmp(decimal(t, reserved)) != 0 {
+		t.Fatalf("expected reserved %s %s for %s, but got: %s", reserved, asset, account, b.Reserved)
+	}
+}
+
+func decimal(t *testing.T, s string) *apd.Decimal {
+	t.Helper()
+
+	d, _, err := apd.NewFromString(s)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	return d
+}
+
+func testAccountsOrderBook(t *testing.T) (*OrderBook, *Accounts) {
+	t.Helper()
+
+	accounts := NewAccounts()
+	for _, deposit := range []struct {
+		account AccountID
+		asset   Asset
+		amount  string
+	}{
+		{"seller", "BTC", "10"},
+		{"buyer", "USDT", "100000"},
+	} {
+		err := accounts.Deposit(deposit.account, deposit.asset, decimal(t, deposit.amount))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	ob := NewOrderBook("BTC", "USDT")
+	ob.Accounts = accounts
+
+	return ob, accounts
+}
+
+// testJournaledAccountsOrderBook is like testAccountsOrderBook, but the deposits are written to the journal,
+// so the balances can be recovered.
+func testJournaledAccountsOrderBook(t *testing.T, j *Journal) (*OrderBook, *Accounts) {
+	t.Helper()
+
+	accounts := NewAccounts()
+	ob := NewOrderBook("BTC", "USDT")
+	ob.Accounts = accounts
+	ob.Journal = j
+
+	for _, deposit := range []struct {
+		account AccountID
+		asset   Asset
+		amount  string
+	}{
+		{"seller", "BTC", "10"},
+		{"buyer", "USDT", "100000"},
+	} {
+		err := ob.Deposit(context.Background(), deposit.account, deposit.asset, decimal(t, deposit.amount))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	return ob, accounts
+}
+
+func Test_AccountsReservation(t *testing.T) {
+	ob, accounts := testAccountsOrderBook(t)
+
+	_, err := ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID:       "1",
+		accountID:     "seller",
+		operationType: Ask,
+		amount:        apd.New(11, 0),
+		price:         apd.New(20000, 0),
+	})
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("expected insufficient funds err, but got: %v", err)
+	}
+
+	_, err = ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID:       "2",
+		accountID:     "buyer",
+		operationType: Bid,
+		amount:        apd.New(5, -1),
+		price:         apd.New(20000, 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	checkBalance(t, accounts, "buyer", "USDT", "90000", "10000")
+
+	err = ob.Cancel(context.Background(), "2")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	checkBalance(t, accounts, "buyer", "USDT", "100000", "0")
+
+	if ob.Bids.String() != "prices:" {
+		t.Fatalf("expected empty bids, but got: %s", ob.Bids.String())
+	}
+
+	err = ob.Cancel(context.Background(), "2")
+	if err == nil || err.Error() != "order: 2 not found - nothing to cancel" {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+func Test_AccountsSettlement(t *testing.T) {
+	ob, accounts := testAccountsOrderBook(t)
+
+	for _, o := range []*Order{
+		{
+			orderID:       "1",
+			accountID:     "seller",
+			operationType: Ask,
+			amount:        apd.New(1, 0),
+			price:         apd.New(20000, 0),
+		},
+		{
+			orderID:       "2",
+			accountID:     "seller",
+			operationType: Ask,
+			amount:        apd.New(1, 0),
+			price:         apd.New(20100, 0),
+		},
+	} {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+	checkBalance(t, accounts, "seller", "BTC", "8", "2")
+
+	// buy 3 BTC by 20200, 2 BTC are executed and the rest stays in the order book.
+	ordersExecuted, err := ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID:       "3",
+		accountID:     "buyer",
+		operationType: Bid,
+		amount:        apd.New(3, 0),
+		price:         apd.New(20200, 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if ordersExecuted != 2 {
+		t.Fatalf("expected 2 ordersExecuted, but got: %d", ordersExecuted)
+	}
+
+	// 20000 + 20100 was paid, 20200 is reserved for the rest, 200 + 100 of the price improvement was released.
+	checkBalance(t, accounts, "buyer", "USDT", "39700", "20200")
+	checkBalance(t, accounts, "buyer", "BTC", "2", "0")
+	checkBalance(t, accounts, "seller", "BTC", "8", "0")
+	checkBalance(t, accounts, "seller", "USDT", "40100", "0")
+
+	// sell into the resting bid by market order.
+	_, amountLeft, err := ob.PlaceMarketOrder(context.Background(), &Order{
+		orderID:       "4",
+		accountID:     "seller",
+		operationType: Ask,
+		amount:        apd.New(3, 0),
+		price:         apd.New(20000, 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if amountLeft.Cmp(apd.New(2, 0)) != 0 {
+		t.Fatalf("expected 2 amountLeft, but got: %s", amountLeft)
+	}
+
+	checkBalance(t, accounts, "buyer", "USDT", "39700", "0")
+	checkBalance(t, accounts, "buyer", "BTC", "3", "0")
+	checkBalance(t, accounts, "seller", "BTC", "7", "0")
+	checkBalance(t, accounts, "seller", "USDT", "60300", "0")
+}
+
+func Test_AccountsRollback(t *testing.T) {
+	ob, accounts := testAccountsOrderBook(t)
+
+	_, err := ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID:       "1",
+		accountID:     "seller",
+		operationType: Ask,
+		amount:        apd.New(1, 0),
+		price:         apd.New(20000, 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	_, _, err = ob.PlaceMarketOrder(context.Background(), &Order{
+		orderID:       "2",
+		accountID:     "buyer",
+		operationType: Bid,
+		amount:        apd.New(5, -1),
+		price:         apd.New(20000, 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	checkBalance(t, accounts, "buyer", "USDT", "90000", "0")
+
+	err = ob.Rollback(context.Background(), "2")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	checkBalance(t, accounts, "buyer", "USDT", "100000", "0")
+	checkBalance(t, accounts, "buyer", "BTC", "0", "0")
+	checkBalance(t, accounts, "seller", "BTC", "9", "1")
+	checkBalance(t, accounts, "seller", "USDT", "0", "0")
+}
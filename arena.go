@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math/big"
+
+	"github.com/cockroachdb/apd"
+)
+
+// reportChunk is a number of the execution reports which are allocated at once.
+const reportChunk = 256
+
+// maxFreeLevels is a max number of the removed price levels which are kept for the reuse by the side.
+const maxFreeLevels = 256
+
+// newReport returns a new execution report. The reports are kept by the orders, so they aren't reused,
+// but they are allocated by chunks, so the matching doesn't allocate every one of them.
+func (os *OrderSide) newReport() *ExecutionReport {
+	if len(os.reports) == 0 {
+		os.reports = make([]ExecutionReport, reportChunk)
+	}
+
+	r := &os.reports[0]
+	os.reports = os.reports[1:]
+	r.amount = &r.amountValue
+
+	return r
+}
+
+// newLevel returns the price level of the order, the removed levels are reused.
+func (os *OrderSide) newLevel(o *Order) *OrdersBySpecificPrice {
+	n := len(os.freeLevels)
+	if n == 0 {
+		level := NewOrdersBySpecificPrice(o.price, o.amount)
+		level.fixedPrice, level.fixedTotal = o.fixedPrice, o.fixedAmount
+
+		return level
+	}
+
+	level := os.freeLevels[n-1]
+	os.freeLevels[n-1] = nil
+	os.freeLevels = os.freeLevels[:n-1]
+
+	level.price = o.price
+	if os.fixed == nil {
+		level.totalAmount.Set(o.amount)
+	}
+	level.fixedPrice, level.fixedTotal = o.fixedPrice, o.fixedAmount
+
+	return level
+}
+
+// freeLevel keeps the removed empty price level for the reuse.
+func (os *OrderSide) freeLevel(level *OrdersBySpecificPrice) {
+	if len(os.freeLevels) < maxFreeLevels {
+		os.freeLevels = append(os.freeLevels, level)
+	}
+}
+
+// setDecimal sets x to d, the coefficient which fits the word is kept by it, so nothing is allocated.
+func setDecimal(d *apd.Decimal, word *[1]big.Word, x *apd.Decimal) {
+	bits := x.Coeff.Bits()
+	if len(bits) > len(word) {
+		d.Set(x)
+		return
+	}
+
+	copy(word[:], bits)
+	d.Coeff.SetBits(word[:len(bits)])
+	d.Form, d.Negative, d.Exponent = x.Form, x.Negative, x.Exponent
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/apd"
+)
+
+// errShortBuffer is returned when the encoded data ends too early.
+var errShortBuffer = errors.New("unexpected end of data")
+
+// encoder writes the values in the binary format of the journal and snapshots.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.uint8(1)
+	} else {
+		e.uint8(0)
+	}
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, v)
+}
+
+func (e *encoder) uvarint(v uint64) {
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) string(v string) {
+	e.uvarint(uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+// decimal writes the decimal as a string, so it keeps the exact coefficient and exponent.
+// A nil decimal is written as an empty string.
+func (e *encoder) decimal(v *apd.Decimal) {
+	if v == nil {
+		e.string("")
+		return
+	}
+
+	e.string(v.String())
+}
+
+// time writes the time as unix nanoseconds, the zero time is written as a flag only.
+func (e *encoder) time(v time.Time) {
+	e.bool(!v.IsZero())
+	if !v.IsZero() {
+		e.uint64(uint64(v.UnixNano()))
+	}
+}
+
+// decoder reads the values written by the encoder. The first error is kept and returned by finish.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.buf) < n {
+		d.err = errShortBuffer
+		return nil
+	}
+
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+
+	return b
+}
+
+func (d *decoder) uint8() uint8 {
+	b := d.next(1)
+	if b == nil {
+		return 0
+	}
+
+	return b[0]
+}
+
+func (d *decoder) bool() bool {
+	return d.uint8() == 1
+}
+
+func (d *decoder) uint64() uint64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errShortBuffer
+		return 0
+	}
+	d.buf = d.buf[n:]
+
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		d.err = errShortBuffer
+		return ""
+	}
+
+	return string(d.next(int(n)))
+}
+
+func (d *decoder) decimal() *apd.Decimal {
+	s := d.string()
+	if s == "" || d.err != nil {
+		return nil
+	}
+
+	v, _, err := apd.NewFromString(s)
+	if err != nil {
+		d.err = fmt.Errorf("wrong decimal: %s: %w", s, err)
+		return nil
+	}
+
+	return v
+}
+
+func (d *decoder) time() time.Time {
+	if !d.bool() {
+		return time.Time{}
+	}
+
+	return time.Unix(0, int64(d.uint64()))
+}
+
+// finish returns the first error of decoding, the whole data must be read.
+func (d *decoder) finish() error {
+	if d.err != nil {
+		return d.err
+	}
+	if len(d.buf) != 0 {
+		return fmt.Errorf("%d bytes left after decoding", len(d.buf))
+	}
+
+	return nil
+}
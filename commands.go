@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cockroachdb/apd"
+)
+
+// String returns the string.
+func (ot OperationType) String() string {
+	switch ot {
+	case Ask:
+		return "ask"
+	case Bid:
+		return "bid"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(ot))
+	}
+}
+
+// ParseOperationType parses the side: ask (sell) or bid (buy).
+func ParseOperationType(s string) (OperationType, error) {
+	switch s {
+	case "ask", "sell":
+		return Ask, nil
+	case "bid", "buy":
+		return Bid, nil
+	default:
+		return 0, fmt.Errorf("unknown side: %q", s)
+	}
+}
+
+// ParseCommandType parses the command type, like place_limit.
+func ParseCommandType(s string) (CommandType, error) {
+	for _, ct := range []CommandType{
+		CommandPlaceLimit, CommandPlaceMarket, CommandRollback, CommandCancel, CommandCancelAll, CommandReplace,
+		CommandDeposit, CommandWithdraw,
+	} {
+		if ct.String() == s {
+			return ct, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown command type: %q", s)
+}
+
+// CommandResult is a result of the command applied to the order book.
+type CommandResult struct {
+	// OrdersExecuted is a number of executed orders for place commands.
+	OrdersExecuted int
+	// AmountLeft is an amount which wasn't found for the market order.
+	AmountLeft *apd.Decimal
+	// Executions are the executions of the placed order.
+	Executions []*ExecutionReport
+	// Canceled are ids of the orders canceled by cancel and replace commands.
+	Canceled []OrderID
+}
+
+// Apply applies the command to the order book by the same checks and the journal as the direct calls.
+// If the order book has the sequencer, the command goes through it.
+func (ob *OrderBook) Apply(ctx context.Context, c Command) (CommandResult, error) {
+	if ob.Sequencer != nil {
+		return ob.Sequencer.Apply(ctx, c)
+	}
+
+	err := ob.lock(ctx)
+	if err != nil {
+		return CommandResult{}, err
+	}
+	defer ob.unlock()
+
+	return ob.apply(ctx, c)
+}
+
+// apply applies the command, it must be called under the lock of the order book.
+func (ob *OrderBook) apply(ctx context.Context, c Command) (CommandResult, error) {
+	var (
+		res CommandResult
+		err error
+	)
+
+	placing := c.Type == CommandPlaceLimit || c.Type == CommandPlaceMarket || c.Type == CommandReplace
+	if placing && (c.Price == nil || c.Amount == nil) {
+		return res, fmt.Errorf("order: %s must have price and amount", c.OrderID)
+	}
+
+	switch c.Type {
+	case CommandPlaceLimit:
+		o := c.placedOrder()
+		res.OrdersExecuted, err = ob.placeLimitOrder(ctx, o)
+		res.Executions = o.executions
+
+	case CommandPlaceMarket:
+		o := c.placedOrder()
+		res.OrdersExecuted, res.AmountLeft, err = ob.placeMarketOrder(ctx, o)
+		res.Executions = o.executions
+
+	case CommandRollback:
+		err = ob.rollback(ctx, c)
+
+	case CommandCancel:
+		err = ob.cancelOrder(ctx, c.OrderID)
+		if err == nil {
+			res.Canceled = []OrderID{c.OrderID}
+		}
+
+	case CommandReplace:
+		o := c.placedOrder()
+		res.OrdersExecuted, err = ob.replaceOrder(ctx, c.ReplacedOrderID, o)
+		res.Executions = o.executions
+		if err == nil {
+			res.Canceled = []OrderID{c.ReplacedOrderID}
+		}
+
+	case CommandCancelAll:
+		var side *OperationType
+		if !c.AnySide {
+			side = &c.Side
+		}
+		res.Canceled, err = ob.cancelAllOrders(ctx, c.AccountID, side)
+
+	case CommandDeposit, CommandWithdraw:
+		err = ob.transfer(c)
+
+	default:
+		err = fmt.Errorf("unknown command type: %s", c.Type)
+	}
+
+	return res, err
+}
+
+// placedOrder returns the order of the direct call or creates the order of the command.
+func (c Command) placedOrder() *Order {
+	if c.placed != nil {
+		return c.placed
+	}
+
+	return c.order()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+
+	"github.com/cockroachdb/apd"
+)
+
+// PriceLevel is an aggregated price level of the order side.
+type PriceLevel struct {
+	Price  *apd.Decimal
+	Amount *apd.Decimal
+	Orders int
+}
+
+// Depth returns up to limit price levels of the side starting from the best price.
+// If limit is 0 - all price levels are returned.
+func (os *OrderSide) Depth(limit int) []PriceLevel {
+	levels := os.levels()
+	if os.sideType == Bid {
+		// the best bid is the max one.
+		for i, j := 0, len(levels)-1; i < j; i, j = i+1, j-1 {
+			levels[i], levels[j] = levels[j], levels[i]
+		}
+	}
+
+	if limit > 0 && len(levels) > limit {
+		levels = levels[:limit]
+	}
+
+	depth := make([]PriceLevel, 0, len(levels))
+	for _, level := range levels {
+		depth = append(depth, PriceLevel{
+			Price:  apd.New(0, 0).Set(level.price),
+			Amount: os.total(level),
+			Orders: level.orders.Len(),
+		})
+	}
+
+	return depth
+}
+
+// Depth returns up to limit price levels of both sides starting from the best prices.
+// It's read from the last view, so it doesn't wait for the lock of the order book.
+func (ob *OrderBook) Depth(ctx context.Context, limit int) (asks, bids []PriceLevel) {
+	return ob.View().Depth(limit)
+}
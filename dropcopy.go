@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/cockroachdb/apd"
+)
+
+// defaultDropCopyRetention is a default number of the messages which are kept for the replay by the account.
+const defaultDropCopyRetention = 100000
+
+// ErrDropCopyGap is returned when the requested messages of the drop copy are dropped already.
+var ErrDropCopyGap = errors.New("drop copy messages are dropped")
+
+// Types of the drop copy messages.
+const (
+	DropCopyNew             = "new"
+	DropCopyRested          = "rested"
+	DropCopyPartiallyFilled = "partially_filled"
+	DropCopyFilled          = "filled"
+	DropCopyCanceled        = "canceled"
+	DropCopyRolledBack      = "rolled_back"
+)
+
+// DropCopyMessage is a message of the drop copy of the account: the state change or the execution of its order.
+type DropCopyMessage struct {
+	// Seq is a sequence number of the message in the drop copy of the account, it starts from 1.
+	Seq        uint64    `json:"seq"`
+	Instrument string    `json:"instrument"`
+	AccountID  AccountID `json:"account_id"`
+	Type       string    `json:"type"`
+	OrderID    OrderID   `json:"order_id"`
+	Side       string    `json:"side"`
+	// Price is a price of the order or of the execution.
+	Price *apd.Decimal `json:"price"`
+	// Amount is an amount of the order, executed, canceled or rolled back one.
+	Amount *apd.Decimal `json:"amount"`
+	// Remaining is an amount of the order which isn't executed yet, it's set for the executions.
+	Remaining *apd.Decimal `json:"remaining,omitempty"`
+	// Maker is true for the execution of the order which was in the order book.
+	Maker          bool         `json:"maker,omitempty"`
+	CounterOrderID OrderID      `json:"counter_order_id,omitempty"`
+	FeeAsset       Asset        `json:"fee_asset,omitempty"`
+	Fee            *apd.Decimal `json:"fee,omitempty"`
+}
+
+// DropCopy is a copy of the executions and the order state changes by the accounts for the risk desks
+// and the prime brokers. Every account has its own sequence numbers, so the subscriber can replay
+// the messages from the last one it has seen. The same drop copy can be used by many order books.
+type DropCopy struct {
+	retention int
+	streams   map[AccountID]*dropCopyStream
+
+	mx sync.Mutex
+}
+
+// dropCopyStream is a sequence of the messages of the account.
+type dropCopyStream struct {
+	seq uint64
+	// history is the last messages, the last one has seq.
+	history []DropCopyMessage
+	// updated is closed when there is a new message.
+	updated chan struct{}
+}
+
+// NewDropCopy creates a new instance of DropCopy which keeps retention last messages of every account,
+// the default one is used if it isn't positive.
+func NewDropCopy(retention int) *DropCopy {
+	if retention <= 0 {
+		retention = defaultDropCopyRetention
+	}
+
+	return &DropCopy{
+		retention: retention,
+		streams:   map[AccountID]*dropCopyStream{},
+		mx:        sync.Mutex{},
+	}
+}
+
+// stream returns the stream of the account, it must be called under the lock.
+func (dc *DropCopy) stream(account AccountID) *dropCopyStream {
+	s, ok := dc.streams[account]
+	if !ok {
+		s = &dropCopyStream{updated: make(chan struct{})}
+		dc.streams[account] = s
+	}
+
+	return s
+}
+
+// publish adds the message with the next sequence number of the account.
+func (dc *DropCopy) publish(m DropCopyMessage) {
+	dc.mx.Lock()
+	defer dc.mx.Unlock()
+
+	s := dc.stream(m.AccountID)
+	s.seq++
+	m.Seq = s.seq
+	s.history = append(s.history, m)
+	// the history is cut by the half of the retention to not copy it for every message.
+	if len(s.history) >= dc.retention+dc.retention/2+1 {
+		s.history = append(make([]DropCopyMessage, 0, dc.retention), s.history[len(s.history)-dc.retention:]...)
+	}
+
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+// Sequence returns the sequence number of the last message of the account.
+func (dc *DropCopy) Sequence(account AccountID) uint64 {
+	dc.mx.Lock()
+	defer dc.mx.Unlock()
+
+	return dc.stream(account).seq
+}
+
+// Messages returns the messages of the account from the sequence number, at most limit if it's positive.
+// It returns ErrDropCopyGap if the messages from the sequence number are dropped already.
+func (dc *DropCopy) Messages(account AccountID, from uint64, limit int) ([]DropCopyMessage, error) {
+	dc.mx.Lock()
+	defer dc.mx.Unlock()
+
+	messages, _, err := dc.messages(account, from, limit)
+
+	return messages, err
+}
+
+// messages returns the messages and the channel which is closed when there are new messages,
+// it must be called under the lock.
+func (dc *DropCopy) messages(account AccountID, from uint64, limit int) ([]DropCopyMessage, <-chan struct{}, error) {
+	s := dc.stream(account)
+	if from == 0 {
+		from = 1
+	}
+
+	oldest := s.seq - uint64(len(s.history)) + 1
+	if from < oldest {
+		return nil, nil, fmt.Errorf("%w: account: %s, seq: %d, the oldest one: %d", ErrDropCopyGap, account, from, oldest)
+	}
+	if from > s.seq {
+		return nil, s.updated, nil
+	}
+
+	messages := s.history[from-oldest:]
+	if limit > 0 && len(messages) > limit {
+		messages = messages[:limit]
+	}
+
+	return append([]DropCopyMessage(nil), messages...), s.updated, nil
+}
+
+// DropCopySubscription reads the drop copy of the account by the sequence numbers.
+type DropCopySubscription struct {
+	dc      *DropCopy
+	account AccountID
+	next    uint64
+}
+
+// Subscribe subscribes to the drop copy of the account from the sequence number,
+// 1 replays all kept messages, Sequence+1 starts from the next message.
+func (dc *DropCopy) Subscribe(account AccountID, from uint64) *DropCopySubscription {
+	if from == 0 {
+		from = 1
+	}
+
+	return &DropCopySubscription{dc: dc, account: account, next: from}
+}
+
+// Next returns the next message, it waits for it till the context is done.
+// It returns ErrDropCopyGap if the subscriber is too slow and the next message is dropped already.
+func (s *DropCopySubscription) Next(ctx context.Context) (DropCopyMessage, error) {
+	for {
+		s.dc.mx.Lock()
+		messages, updated, err := s.dc.messages(s.account, s.next, 1)
+		s.dc.mx.Unlock()
+		if err != nil {
+			return DropCopyMessage{}, err
+		}
+
+		if len(messages) == 1 {
+			s.next++
+			return messages[0], nil
+		}
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return DropCopyMessage{}, ctx.Err()
+		}
+	}
+}
+
+// dropCopyListener copies the order state changes of the order book to its drop copy,
+// the executions are copied by dropCopyExecutions when their fees are known.
+type dropCopyListener struct {
+	NopEventListener
+
+	ob *OrderBook
+}
+
+// OrderAccepted copies the new order.
+func (l dropCopyListener) OrderAccepted(e OrderAccepted) {
+	l.ob.DropCopy.publish(DropCopyMessage{
+		Instrument: l.ob.Instrument(),
+		AccountID:  e.AccountID,
+		Type:       DropCopyNew,
+		OrderID:    e.OrderID,
+		Side:       e.Side.String(),
+		Price:      e.Price,
+		Amount:     e.Amount,
+	})
+}
+
+// OrderRested copies the order which is added to the order book.
+func (l dropCopyListener) OrderRested(e OrderRested) {
+	l.ob.DropCopy.publish(DropCopyMessage{
+		Instrument: l.ob.Instrument(),
+		AccountID:  e.AccountID,
+		Type:       DropCopyRested,
+		OrderID:    e.OrderID,
+		Side:       e.Side.String(),
+		Price:      e.Price,
+		Amount:     e.Amount,
+	})
+}
+
+// OrderCanceled copies the canceled order.
+func (l dropCopyListener) OrderCanceled(e OrderCanceled) {
+	l.ob.DropCopy.publish(DropCopyMessage{
+		Instrument: l.ob.Instrument(),
+		AccountID:  e.AccountID,
+		Type:       DropCopyCanceled,
+		OrderID:    e.OrderID,
+		Side:       e.Side.String(),
+		Price:      e.Price,
+		Amount:     e.Amount,
+	})
+}
+
+// OrderRolledBack copies the rolled back execution to the accounts of the both orders.
+func (l dropCopyListener) OrderRolledBack(e OrderRolledBack) {
+	l.ob.DropCopy.publish(DropCopyMessage{
+		Instrument:     l.ob.Instrument(),
+		AccountID:      e.AccountID,
+		Type:           DropCopyRolledBack,
+		OrderID:        e.OrderID,
+		Side:           e.Side.String(),
+		Price:          e.Price,
+		Amount:         e.Amount,
+		CounterOrderID: e.ExecutorOrderID,
+	})
+	l.ob.DropCopy.publish(DropCopyMessage{
+		Instrument:     l.ob.Instrument(),
+		AccountID:      e.ExecutorAccountID,
+		Type:           DropCopyRolledBack,
+		OrderID:        e.ExecutorOrderID,
+		Side:           opposite(e.Side).String(),
+		Price:          apd.New(0, 0).Set(e.Price),
+		Amount:         apd.New(0, 0).Set(e.Amount),
+		Maker:          true,
+		CounterOrderID: e.OrderID,
+	})
+}
+
+// dropCopyExecutions copies the executions of the order to the accounts of the both orders,
+// it's called after the fees are charged.
+func (ob *OrderBook) dropCopyExecutions(o *Order) {
+	if ob.DropCopy == nil || len(o.executions) == 0 {
+		return
+	}
+
+	instrument := ob.Instrument()
+	remaining := apd.New(0, 0).Set(o.amount)
+	for _, e := range o.executions {
+		// it can't fail - there is no rounding with BaseContext.
+		_, _ = apd.BaseContext.Sub(remaining, remaining, e.amount)
+
+		// the executor is executed once by the order, what is left of it is in the order book.
+		executorRemaining := apd.New(0, 0)
+		if el, ok := ob.Orders[e.executorOrderID]; ok {
+			executorRemaining = ob.remaining(el.Value.(*Order))
+		}
+
+		ob.DropCopy.publish(DropCopyMessage{
+			Instrument:     instrument,
+			AccountID:      e.initiatorAccountID,
+			Type:           dropCopyFillType(remaining),
+			OrderID:        e.initiatorOrderID,
+			Side:           o.operationType.String(),
+			Price:          apd.New(0, 0).Set(e.price),
+			Amount:         apd.New(0, 0).Set(e.amount),
+			Remaining:      apd.New(0, 0).Set(remaining),
+			CounterOrderID: e.executorOrderID,
+			FeeAsset:       e.feeAsset,
+			Fee:            copyDecimal(e.takerFee),
+		})
+		ob.DropCopy.publish(DropCopyMessage{
+			Instrument:     instrument,
+			AccountID:      e.executorAccountID,
+			Type:           dropCopyFillType(executorRemaining),
+			OrderID:        e.executorOrderID,
+			Side:           opposite(o.operationType).String(),
+			Price:          apd.New(0, 0).Set(e.price),
+			Amount:         apd.New(0, 0).Set(e.amount),
+			Remaining:      executorRemaining,
+			Maker:          true,
+			CounterOrderID: e.initiatorOrderID,
+			FeeAsset:       e.feeAsset,
+			Fee:            copyDecimal(e.makerFee),
+		})
+	}
+}
+
+// dropCopyFillType returns the type of the execution by the remaining amount of the order.
+func dropCopyFillType(remaining *apd.Decimal) string {
+	if remaining.IsZero() {
+		return DropCopyFilled
+	}
+
+	return DropCopyPartiallyFilled
+}
+
+// copyDecimal returns the copy of the decimal, nil stays nil.
+func copyDecimal(d *apd.Decimal) *apd.Decimal {
+	if d == nil {
+		return nil
+	}
+
+	return apd.New(0, 0).Set(d)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/apd"
+)
+
+// dropCopyLines returns the messages as the short strings.
+func dropCopyLines(messages []DropCopyMessage) []string {
+	lines := make([]string, 0, len(messages))
+	for _, m := range messages {
+		line := fmt.Sprintf("%d %s %s %s %s@%s", m.Seq, m.Type, m.OrderID, m.Side, m.Amount, m.Price)
+		if m.Remaining != nil {
+			line += fmt.Sprintf(" left=%s maker=%t fee=%s %s", m.Remaining, m.Maker, m.Fee, m.FeeAsset)
+		}
+		if m.CounterOrderID != "" {
+			line += " x " + string(m.CounterOrderID)
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+func Test_DropCopy(t *testing.T) {
+	ob, _ := testAccountsOrderBook(t)
+	ob.Fees = testFeeSchedule(t)
+	ob.DropCopy = NewDropCopy(0)
+
+	for _, o := range []*Order{
+		{orderID: "s1", accountID: "seller", operationType: Ask, amount: apd.New(2, 0), price: apd.New(20000, 0)},
+		{orderID: "b1", accountID: "buyer", operationType: Bid, amount: apd.New(1, 0), price: apd.New(20000, 0)},
+	} {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	_, _, err := ob.PlaceMarketOrder(context.Background(), &Order{
+		orderID: "m1", accountID: "buyer", operationType: Bid, amount: apd.New(2, 0), price: apd.New(20000, 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	err = ob.Rollback(context.Background(), "m1")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	messages, err := ob.DropCopy.Messages("seller", 1, 0)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	expected := []string{
+		"1 new s1 ask 2@20000",
+		"2 rested s1 ask 2@20000",
+		"3 partially_filled s1 ask 1@20000 left=1 maker=true fee=20.00 USDT x b1",
+		"4 filled s1 ask 1@20000 left=0 maker=true fee=20.00 USDT x m1",
+		// s1 was filled, it gets the amount back at its place in the order book.
+		"5 rolled_back s1 ask 1@20000 x m1",
+	}
+	if lines := dropCopyLines(messages); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("expected seller messages:\n%q\nbut got:\n%q", expected, lines)
+	}
+
+	// the subscriber replays the messages from the sequence number and waits for the next ones.
+	sub := ob.DropCopy.Subscribe("buyer", 2)
+	messages = nil
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		m, err := sub.Next(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		messages = append(messages, m)
+	}
+
+	expected = []string{
+		"2 filled b1 bid 1@20000 left=0 maker=false fee=40.00 USDT x s1",
+		"3 new m1 bid 2@20000",
+		"4 partially_filled m1 bid 1@20000 left=1 maker=false fee=40.00 USDT x s1",
+		"5 canceled m1 bid 1@20000",
+		"6 rolled_back m1 bid 1@20000 x s1",
+	}
+	if lines := dropCopyLines(messages); !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("expected buyer messages:\n%q\nbut got:\n%q", expected, lines)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = sub.Next(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline err, but got: %v", err)
+	}
+}
+
+func Test_DropCopyRetention(t *testing.T) {
+	dc := NewDropCopy(2)
+	for i := 0; i < 10; i++ {
+		dc.publish(DropCopyMessage{AccountID: "alice", Type: DropCopyNew, OrderID: OrderID(fmt.Sprint(i))})
+	}
+
+	if seq := dc.Sequence("alice"); seq != 10 {
+		t.Fatalf("expected %d seq, but got: %d", 10, seq)
+	}
+
+	messages, err := dc.Messages("alice", 9, 0)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(messages) != 2 || messages[0].Seq != 9 || messages[1].OrderID != "9" {
+		t.Fatalf("unexpected messages: %+v", messages)
+	}
+
+	_, err = dc.Subscribe("alice", 1).Next(context.Background())
+	if !errors.Is(err, ErrDropCopyGap) {
+		t.Fatalf("expected gap err, but got: %v", err)
+	}
+}
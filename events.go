@@ -0,0 +1,252 @@
+package main
+
+import (
+	"github.com/cockroachdb/apd"
+)
+
+// EventListener receives the events of the order book. The events are raised under the lock of the order book,
+// synchronously and in the order of the changes, so the same commands always produce the same events.
+// The listener must not call the order book and should be fast, the matching waits for it.
+//
+// The events of the order go before the level change which they cause:
+//
+//	limit order:  accepted, (fill of the maker, fill of the taker)..., level change..., rested, level change
+//	market order: accepted, (fill of the maker, fill of the taker)..., level change..., canceled if something left
+//	cancel:       canceled, level change
+//	rollback:     canceled and level change if the rest of the order is in the order book, (rolled back, level change)...
+//
+// Decimals of the events are copies, the listener can keep them.
+type EventListener interface {
+	// OrderAccepted is raised when the order passed the checks and goes to the matching.
+	OrderAccepted(e OrderAccepted)
+	// OrderRested is raised when the order is added to the order book.
+	OrderRested(e OrderRested)
+	// OrderPartiallyFilled is raised for the execution of the order when some amount is left.
+	OrderPartiallyFilled(e OrderFill)
+	// OrderFilled is raised for the execution of the order when nothing is left.
+	OrderFilled(e OrderFill)
+	// OrderCanceled is raised when the order is canceled or the rest of the market order isn't found.
+	OrderCanceled(e OrderCanceled)
+	// OrderRolledBack is raised for every execution of the order which is rolled back.
+	OrderRolledBack(e OrderRolledBack)
+	// LevelChanged is raised when the price level is changed, the removed level has zero amount.
+	LevelChanged(e LevelChanged)
+}
+
+// OrderAccepted is an event of the accepted order.
+type OrderAccepted struct {
+	OrderID   OrderID
+	AccountID AccountID
+	Side      OperationType
+	// Market is true for the market order.
+	Market bool
+	Price  *apd.Decimal
+	Amount *apd.Decimal
+}
+
+// OrderRested is an event of the order which is added to the order book, Amount is in the order book.
+type OrderRested struct {
+	OrderID   OrderID
+	AccountID AccountID
+	Side      OperationType
+	Price     *apd.Decimal
+	Amount    *apd.Decimal
+}
+
+// OrderFill is an event of the execution of the order, the maker and the taker get their own events.
+type OrderFill struct {
+	OrderID   OrderID
+	AccountID AccountID
+	Side      OperationType
+	// Maker is true for the order which was in the order book.
+	Maker            bool
+	CounterOrderID   OrderID
+	CounterAccountID AccountID
+	Price            *apd.Decimal
+	Amount           *apd.Decimal
+	// Remaining is an amount of the order which isn't executed yet.
+	Remaining *apd.Decimal
+}
+
+// OrderCanceled is an event of the canceled order, Amount is canceled.
+type OrderCanceled struct {
+	OrderID   OrderID
+	AccountID AccountID
+	Side      OperationType
+	Price     *apd.Decimal
+	Amount    *apd.Decimal
+}
+
+// OrderRolledBack is an event of the execution which is rolled back.
+type OrderRolledBack struct {
+	OrderID   OrderID
+	AccountID AccountID
+	// Side is a side of the rolled back order, the executor order is on the opposite one.
+	Side              OperationType
+	ExecutorOrderID   OrderID
+	ExecutorAccountID AccountID
+	Price             *apd.Decimal
+	Amount            *apd.Decimal
+	// Refilled is true if the executor order gets Amount back in the order book at its place in the queue,
+	// otherwise it was canceled and gets only the funds back.
+	Refilled bool
+	// Restored is true if the executor order was filled, so it's added back to the order book by the rollback.
+	Restored bool
+}
+
+// LevelChanged is an event of the changed price level.
+type LevelChanged struct {
+	Side  OperationType
+	Level PriceLevel
+}
+
+// NopEventListener ignores all events, it can be embedded to implement only the required events.
+type NopEventListener struct{}
+
+// OrderAccepted does nothing.
+func (NopEventListener) OrderAccepted(OrderAccepted) {}
+
+// OrderRested does nothing.
+func (NopEventListener) OrderRested(OrderRested) {}
+
+// OrderPartiallyFilled does nothing.
+func (NopEventListener) OrderPartiallyFilled(OrderFill) {}
+
+// OrderFilled does nothing.
+func (NopEventListener) OrderFilled(OrderFill) {}
+
+// OrderCanceled does nothing.
+func (NopEventListener) OrderCanceled(OrderCanceled) {}
+
+// OrderRolledBack does nothing.
+func (NopEventListener) OrderRolledBack(OrderRolledBack) {}
+
+// LevelChanged does nothing.
+func (NopEventListener) LevelChanged(LevelChanged) {}
+
+// listening returns true if there are listeners of the events, the events aren't made otherwise.
+func (ob *OrderBook) listening() bool {
+	return ob.MarketData != nil || ob.OrderFeed != nil || ob.DropCopy != nil || len(ob.Listeners) > 0
+}
+
+// emit raises the event for the market data feed, the order feed, the drop copy and the listeners of the order book.
+func (ob *OrderBook) emit(fn func(l EventListener)) {
+	if ob.MarketData != nil {
+		fn(marketDataListener{ob: ob})
+	}
+	if ob.OrderFeed != nil {
+		fn(ob.OrderFeed)
+	}
+	if ob.DropCopy != nil {
+		fn(dropCopyListener{ob: ob})
+	}
+	for _, l := range ob.Listeners {
+		fn(l)
+	}
+}
+
+// emitFill raises the fill event by the remaining amount of the order.
+func (ob *OrderBook) emitFill(e OrderFill) {
+	ob.emit(func(l EventListener) {
+		if e.Remaining.IsZero() {
+			l.OrderFilled(e)
+		} else {
+			l.OrderPartiallyFilled(e)
+		}
+	})
+}
+
+// emitLevel raises the level change of the price on the side, fixedPrice is used by the fixed-point order book.
+func (ob *OrderBook) emitLevel(os *OrderSide, price *apd.Decimal, fixedPrice int64) {
+	os.changed = append(os.changed, levelChange{price: price, key: os.key(price, fixedPrice)})
+	if !ob.listening() {
+		return
+	}
+
+	e := LevelChanged{
+		Side: os.sideType,
+		Level: PriceLevel{
+			Price:  apd.New(0, 0).Set(price),
+			Amount: apd.New(0, 0),
+		},
+	}
+	if level, ok := os.index.Get(os.key(price, fixedPrice)); ok {
+		e.Level.Amount = os.total(level)
+		e.Level.Orders = level.orders.Len()
+	}
+
+	ob.emit(func(l EventListener) { l.LevelChanged(e) })
+}
+
+// emitAccepted raises the event of the accepted order.
+func (ob *OrderBook) emitAccepted(o *Order, market bool) {
+	if !ob.listening() {
+		return
+	}
+
+	e := OrderAccepted{
+		OrderID:   o.orderID,
+		AccountID: o.accountID,
+		Side:      o.operationType,
+		Market:    market,
+		Price:     apd.New(0, 0).Set(o.price),
+		Amount:    apd.New(0, 0).Set(o.amount),
+	}
+
+	ob.emit(func(l EventListener) { l.OrderAccepted(e) })
+}
+
+// emitRested raises the event of the order which is added to the order book.
+func (ob *OrderBook) emitRested(o *Order) {
+	if !ob.listening() {
+		return
+	}
+
+	e := OrderRested{
+		OrderID:   o.orderID,
+		AccountID: o.accountID,
+		Side:      o.operationType,
+		Price:     apd.New(0, 0).Set(o.price),
+		Amount:    apd.New(0, 0).Set(o.amount),
+	}
+
+	ob.emit(func(l EventListener) { l.OrderRested(e) })
+}
+
+// emitCanceled raises the event of the canceled amount of the order.
+func (ob *OrderBook) emitCanceled(o *Order, amount *apd.Decimal) {
+	if !ob.listening() {
+		return
+	}
+
+	e := OrderCanceled{
+		OrderID:   o.orderID,
+		AccountID: o.accountID,
+		Side:      o.operationType,
+		Price:     apd.New(0, 0).Set(o.price),
+		Amount:    apd.New(0, 0).Set(amount),
+	}
+
+	ob.emit(func(l EventListener) { l.OrderCanceled(e) })
+}
+
+// emitRolledBack raises the event of the execution which is rolled back.
+func (ob *OrderBook) emitRolledBack(o *Order, oe *ExecutionReport, refilled, restored bool) {
+	if !ob.listening() {
+		return
+	}
+
+	e := OrderRolledBack{
+		OrderID:           o.orderID,
+		AccountID:         o.accountID,
+		Side:              o.operationType,
+		ExecutorOrderID:   oe.executorOrderID,
+		ExecutorAccountID: oe.executorAccountID,
+		Price:             apd.New(0, 0).Set(oe.price),
+		Amount:            apd.New(0, 0).Set(oe.amount),
+		Refilled:          refilled,
+		Restored:          restored,
+	}
+
+	ob.emit(func(l EventListener) { l.OrderRolledBack(e) })
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// recordingListener records the events as the short strings.
+type recordingListener struct {
+	events []string
+}
+
+func (r *recordingListener) OrderAccepted(e OrderAccepted) {
+	r.events = append(r.events, fmt.Sprintf("accepted %s %s %s@%s market=%t", e.OrderID, e.Side, e.Amount, e.Price, e.Market))
+}
+
+func (r *recordingListener) OrderRested(e OrderRested) {
+	r.events = append(r.events, fmt.Sprintf("rested %s %s@%s", e.OrderID, e.Amount, e.Price))
+}
+
+func (r *recordingListener) OrderPartiallyFilled(e OrderFill) {
+	r.events = append(r.events, fmt.Sprintf("partially filled %s x %s %s@%s maker=%t left=%s",
+		e.OrderID, e.CounterOrderID, e.Amount, e.Price, e.Maker, e.Remaining))
+}
+
+func (r *recordingListener) OrderFilled(e OrderFill) {
+	r.events = append(r.events, fmt.Sprintf("filled %s x %s %s@%s maker=%t", e.OrderID, e.CounterOrderID, e.Amount, e.Price, e.Maker))
+}
+
+func (r *recordingListener) OrderCanceled(e OrderCanceled) {
+	r.events = append(r.events, fmt.Sprintf("canceled %s %s", e.OrderID, e.Amount))
+}
+
+func (r *recordingListener) OrderRolledBack(e OrderRolledBack) {
+	r.events = append(r.events, fmt.Sprintf("rolled back %s x %s %s@%s refilled=%t restored=%t",
+		e.OrderID, e.ExecutorOrderID, e.Amount, e.Price, e.Refilled, e.Restored))
+}
+
+func (r *recordingListener) LevelChanged(e LevelChanged) {
+	r.events = append(r.events, fmt.Sprintf("level %s %s: %s/%d", e.Side, e.Level.Price, e.Level.Amount, e.Level.Orders))
+}
+
+func Test_EventListener(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	l := &recordingListener{}
+	ob.Listeners = append(ob.Listeners, l)
+
+	limit := func(id OrderID, side OperationType, price, amount string) {
+		_, err := ob.PlaceLimitOrder(context.Background(), &Order{
+			orderID: id, operationType: side, price: decimal(t, price), amount: decimal(t, amount),
+		})
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	limit("a1", Ask, "100", "1")
+	limit("a2", Ask, "101", "2")
+	limit("b1", Bid, "101", "2")
+
+	err := ob.Rollback(context.Background(), "b1")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	_, _, err = ob.PlaceMarketOrder(context.Background(), &Order{
+		orderID: "m1", operationType: Bid, price: decimal(t, "102"), amount: decimal(t, "4"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	limit("b2", Bid, "99", "1")
+	err = ob.Cancel(context.Background(), "b2")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	expected := []string{
+		"accepted a1 ask 1@100 market=false",
+		"rested a1 1@100",
+		"level ask 100: 1/1",
+		"accepted a2 ask 2@101 market=false",
+		"rested a2 2@101",
+		"level ask 101: 2/1",
+		"accepted b1 bid 2@101 market=false",
+		"filled a1 x b1 1@100 maker=true",
+		"partially filled b1 x a1 1@100 maker=false left=1",
+		"level ask 100: 0/0",
+		"partially filled a2 x b1 1@101 maker=true left=1",
+		"filled b1 x a2 1@101 maker=false",
+		"level ask 101: 1/1",
+		// a1 was filled, it's restored at its place; a2 gets the amount back.
+		"rolled back b1 x a1 1@100 refilled=true restored=true",
+		"level ask 100: 1/1",
+		"rolled back b1 x a2 1@101 refilled=true restored=false",
+		"level ask 101: 2/1",
+		"accepted m1 bid 4@102 market=true",
+		"filled a1 x m1 1@100 maker=true",
+		"partially filled m1 x a1 1@100 maker=false left=3",
+		"level ask 100: 0/0",
+		"filled a2 x m1 2@101 maker=true",
+		"partially filled m1 x a2 2@101 maker=false left=1",
+		"level ask 101: 0/0",
+		"canceled m1 1",
+		"accepted b2 bid 1@99 market=false",
+		"rested b2 1@99",
+		"level bid 99: 1/1",
+		"canceled b2 1",
+		"level bid 99: 0/0",
+	}
+	if !reflect.DeepEqual(l.events, expected) {
+		t.Fatalf("expected events:\n%q\nbut got:\n%q", expected, l.events)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cockroachdb/apd"
+)
+
+// Exchange is a set of order books by instruments, like BTC-USDT.
+type Exchange struct {
+	books map[string]*OrderBook
+
+	mx sync.RWMutex
+}
+
+// NewExchange creates a new instance of Exchange.
+func NewExchange() *Exchange {
+	return &Exchange{
+		books: map[string]*OrderBook{},
+		mx:    sync.RWMutex{},
+	}
+}
+
+// ParseInstrument parses the instrument like BTC-USDT to the base and the quote assets.
+func ParseInstrument(instrument string) (baseAsset, quoteAsset Asset, err error) {
+	parts := strings.Split(instrument, "-")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("wrong instrument: %q, it should be like BTC-USDT", instrument)
+	}
+
+	return Asset(parts[0]), Asset(parts[1]), nil
+}
+
+// AddBook adds the order book to the exchange.
+func (e *Exchange) AddBook(ob *OrderBook) error {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	instrument := ob.Instrument()
+	if _, ok := e.books[instrument]; ok {
+		return fmt.Errorf("order book: %s already exists", instrument)
+	}
+	e.books[instrument] = ob
+
+	return nil
+}
+
+// Book returns the order book of the instrument.
+func (e *Exchange) Book(instrument string) (*OrderBook, bool) {
+	e.mx.RLock()
+	defer e.mx.RUnlock()
+
+	ob, ok := e.books[instrument]
+
+	return ob, ok
+}
+
+// Instruments returns the sorted instruments of the exchange.
+func (e *Exchange) Instruments() []string {
+	e.mx.RLock()
+	defer e.mx.RUnlock()
+
+	instruments := make([]string, 0, len(e.books))
+	for instrument := range e.books {
+		instruments = append(instruments, instrument)
+	}
+	sort.Strings(instruments)
+
+	return instruments
+}
+
+// Trade is a public view of the ExecutionReport.
+type Trade struct {
+	InitiatorOrderID OrderID      `json:"initiator_order_id"`
+	ExecutorOrderID  OrderID      `json:"executor_order_id"`
+	Price            *apd.Decimal `json:"price"`
+	Amount           *apd.Decimal `json:"amount"`
+	FeeAsset         Asset        `json:"fee_asset,omitempty"`
+	TakerFee         *apd.Decimal `json:"taker_fee,omitempty"`
+	MakerFee         *apd.Decimal `json:"maker_fee,omitempty"`
+}
+
+// Trade returns the public view of the execution.
+func (e *ExecutionReport) Trade() Trade {
+	return Trade{
+		InitiatorOrderID: e.initiatorOrderID,
+		ExecutorOrderID:  e.executorOrderID,
+		Price:            e.price,
+		Amount:           e.amount,
+		FeeAsset:         e.feeAsset,
+		TakerFee:         e.takerFee,
+		MakerFee:         e.makerFee,
+	}
+}
+
+// trades returns the public view of the executions.
+func trades(executions []*ExecutionReport) []Trade {
+	res := make([]Trade, 0, len(executions))
+	for _, e := range executions {
+		res = append(res, e.Trade())
+	}
+
+	return res
+}
+
+// OrderStatus is a state of the order.
+type OrderStatus struct {
+	OrderID   OrderID
+	AccountID AccountID
+	Side      OperationType
+	Price     *apd.Decimal
+	// Open is true while the order is in the order book.
+	Open bool
+	// Remaining is an amount which is in the order book, it's zero for the closed orders.
+	Remaining *apd.Decimal
+	// Executed is a total executed amount.
+	Executed *apd.Decimal
+	Trades   []Trade
+}
+
+// OrderStatus returns the state of the open or done order.
+func (ob *OrderBook) OrderStatus(ctx context.Context, orderID OrderID) (OrderStatus, error) {
+	err := ob.lock(ctx)
+	if err != nil {
+		return OrderStatus{}, err
+	}
+	defer ob.release()
+
+	var (
+		o    *Order
+		open bool
+	)
+	if el, ok := ob.Orders[orderID]; ok {
+		o, open = el.Value.(*Order), true
+	} else if o, ok = ob.OrdersDone[orderID]; !ok {
+		return OrderStatus{}, newOrderError(ErrOrderNotFound, "order: %s not found", orderID)
+	}
+
+	status := OrderStatus{
+		OrderID:   o.orderID,
+		AccountID: o.accountID,
+		Side:      o.operationType,
+		Price:     apd.New(0, 0).Set(o.price),
+		Open:      open,
+		Remaining: apd.New(0, 0),
+		Executed:  apd.New(0, 0),
+		Trades:    trades(o.executions),
+	}
+	if open {
+		status.Remaining = ob.remaining(o)
+	}
+
+	for _, e := range o.executions {
+		_, err := apd.BaseContext.Add(status.Executed, status.Executed, e.amount)
+		if err != nil {
+			return OrderStatus{}, err
+		}
+	}
+
+	return status, nil
+}
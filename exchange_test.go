@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func Test_Exchange(t *testing.T) {
+	exchange := NewExchange()
+	for _, instrument := range []string{"ETH-USDT", "BTC-USDT"} {
+		baseAsset, quoteAsset, err := ParseInstrument(instrument)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		err = exchange.AddBook(NewOrderBook(baseAsset, quoteAsset))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	err := exchange.AddBook(NewOrderBook("BTC", "USDT"))
+	if err == nil {
+		t.Fatalf("expected err, but got nil")
+	}
+
+	instruments := exchange.Instruments()
+	if len(instruments) != 2 || instruments[0] != "BTC-USDT" || instruments[1] != "ETH-USDT" {
+		t.Fatalf("wrong instruments: %v", instruments)
+	}
+
+	ob, ok := exchange.Book("ETH-USDT")
+	if !ok || ob.BaseAsset != "ETH" {
+		t.Fatalf("wrong order book: %v", ob)
+	}
+
+	for _, instrument := range []string{"BTC", "BTC-", "-USDT", "BTC-USDT-ETH"} {
+		_, _, err = ParseInstrument(instrument)
+		if err == nil {
+			t.Fatalf("expected err for %s, but got nil", instrument)
+		}
+	}
+}
+
+func Test_OrderStatus(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	for _, o := range testOrders() {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	o := &Order{orderID: "100500", operationType: Bid, price: decimal(t, "20100"), amount: decimal(t, "0.2")}
+	_, _, err := ob.PlaceMarketOrder(context.Background(), o)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	status, err := ob.OrderStatus(context.Background(), "100500")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if status.Open || !status.Remaining.IsZero() || status.Executed.String() != "0.2" || len(status.Trades) == 0 {
+		t.Fatalf("wrong status: %+v", status)
+	}
+
+	executor := status.Trades[0].ExecutorOrderID
+	status, err = ob.OrderStatus(context.Background(), executor)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if status.OrderID != executor || status.Side != Ask || len(status.Trades) == 0 {
+		t.Fatalf("wrong status: %+v", status)
+	}
+
+	_, err = ob.OrderStatus(context.Background(), "unknown")
+	if !errors.Is(err, ErrOrderNotFound) {
+		t.Fatalf("expected %v, but got: %v", ErrOrderNotFound, err)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxFeedDatagram is a max size of the UDP packet, it fits the usual MTU.
+	maxFeedDatagram = 1400
+	// maxFeedRetransmission is a max size of the retransmitted packet, the length of the message is uint16.
+	maxFeedRetransmission = 64 * 1024
+	// feedHeartbeatInterval is an interval of the heartbeats when there are no messages,
+	// the consumers see the gap at the end of the stream by them.
+	feedHeartbeatInterval = time.Second
+	// feedRequestSize is a size of the retransmission request: instrument, sequence number and count.
+	feedRequestSize = feedInstrumentSize + 8 + 2
+)
+
+// PublishOrderFeed sends the order feed of the order book to the connection by the packets, one packet
+// per write, till the context is canceled. It's made for the UDP connection: the lost packets are requested
+// from the retransmission service by the sequence numbers. The messages start from the oldest one in the feed.
+func PublishOrderFeed(ctx context.Context, ob *OrderBook, conn io.Writer) error {
+	feed := ob.OrderFeed
+	if feed == nil {
+		return errors.New("order book has no order feed")
+	}
+
+	instrument := ob.Instrument()
+	heartbeat := time.NewTicker(feedHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	next := uint64(1)
+	for {
+		first, messages, updated := feed.messages(next, maxFeedDatagram)
+		if len(messages) > 0 {
+			err := writeFeedPacket(conn, encodeFeedPacket(instrument, first, messages))
+			if err != nil {
+				return err
+			}
+
+			next = first + uint64(len(messages))
+			heartbeat.Reset(feedHeartbeatInterval)
+
+			continue
+		}
+		next = first
+
+		select {
+		case <-updated:
+		case <-heartbeat.C:
+			err := writeFeedPacket(conn, encodeFeedPacket(instrument, next, nil))
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// writeFeedPacket writes the packet, it's lost if the write fails unless the connection is closed,
+// for example UDP returns the error when nobody listens the port.
+func writeFeedPacket(conn io.Writer, packet []byte) error {
+	_, err := conn.Write(packet)
+	if errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("can't publish order feed: %w", err)
+	}
+
+	return nil
+}
+
+// FeedRetransmitter is a TCP service which resends the messages of the order feeds by the sequence numbers.
+// The client sends the requests: instrument (16 bytes padded by spaces), sequence number (uint64)
+// and count (uint16), and gets the packet for every request. If the messages are dropped already,
+// the packet starts from the oldest one. The packet without messages has the next sequence number of the feed.
+type FeedRetransmitter struct {
+	exchange *Exchange
+}
+
+// NewFeedRetransmitter creates a new instance of FeedRetransmitter.
+func NewFeedRetransmitter(exchange *Exchange) *FeedRetransmitter {
+	return &FeedRetransmitter{exchange: exchange}
+}
+
+// Serve accepts the connections till the context is canceled.
+func (s *FeedRetransmitter) Serve(ctx context.Context, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("can't accept retransmission connection: %w", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+// handle answers the requests of the connection.
+func (s *FeedRetransmitter) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-closed:
+		}
+	}()
+
+	req := make([]byte, feedRequestSize)
+	for {
+		_, err := io.ReadFull(conn, req)
+		if err != nil {
+			return
+		}
+
+		instrument := strings.TrimRight(string(req[:feedInstrumentSize]), " ")
+		seq := binary.BigEndian.Uint64(req[feedInstrumentSize:])
+		count := int(binary.BigEndian.Uint16(req[feedInstrumentSize+8:]))
+
+		ob, ok := s.exchange.Book(instrument)
+		if !ok || ob.OrderFeed == nil {
+			return
+		}
+
+		first, messages, _ := ob.OrderFeed.messages(seq, maxFeedRetransmission)
+		if len(messages) > count {
+			messages = messages[:count]
+		}
+
+		_, err = conn.Write(encodeFeedPacket(instrument, first, messages))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// RequestRetransmission requests the messages of the order feed from the retransmission service.
+func RequestRetransmission(conn net.Conn, instrument string, seq uint64, count uint16) (FeedPacket, error) {
+	req := make([]byte, 0, feedRequestSize)
+	req = append(req, fmt.Sprintf("%-*s", feedInstrumentSize, instrument)[:feedInstrumentSize]...)
+	req = binary.BigEndian.AppendUint64(req, seq)
+	req = binary.BigEndian.AppendUint16(req, count)
+
+	_, err := conn.Write(req)
+	if err != nil {
+		return FeedPacket{}, fmt.Errorf("can't request retransmission: %w", err)
+	}
+
+	return ReadFeedPacket(conn)
+}
+
+// ReadFeedPacket reads the packet from the stream, for example from the retransmission service.
+func ReadFeedPacket(r io.Reader) (FeedPacket, error) {
+	header := make([]byte, feedPacketHeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return FeedPacket{}, err
+	}
+
+	data := header
+	count := int(binary.BigEndian.Uint16(header[feedInstrumentSize+8:]))
+	for i := 0; i < count; i++ {
+		size := make([]byte, 2)
+		_, err = io.ReadFull(r, size)
+		if err != nil {
+			return FeedPacket{}, err
+		}
+
+		m := make([]byte, binary.BigEndian.Uint16(size))
+		_, err = io.ReadFull(r, m)
+		if err != nil {
+			return FeedPacket{}, err
+		}
+
+		data = append(append(data, size...), m...)
+	}
+
+	return DecodeFeedPacket(data)
+}
+
+// serveOrderFeeds publishes the order feeds of the books to the UDP address and serves the retransmission
+// if its address is set. The returned func stops them.
+func serveOrderFeeds(ctx context.Context, exchange *Exchange, feedAddr, retransmitAddr string) (func(), error) {
+	conn, err := net.Dial("udp", feedAddr)
+	if err != nil {
+		return nil, fmt.Errorf("can't dial order feed: %w", err)
+	}
+
+	var l net.Listener
+	if retransmitAddr != "" {
+		l, err = net.Listen("tcp", retransmitAddr)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("can't listen order feed retransmission: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+
+	for _, instrument := range exchange.Instruments() {
+		ob, _ := exchange.Book(instrument)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = PublishOrderFeed(ctx, ob, conn)
+		}()
+	}
+
+	if l != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = NewFeedRetransmitter(exchange).Serve(ctx, l)
+		}()
+	}
+
+	return func() {
+		cancel()
+		wg.Wait()
+		conn.Close()
+	}, nil
+}
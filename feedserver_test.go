@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_PublishOrderFeed(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	err := ob.SetOrderFeed(NewOrderFeed(0))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	for _, o := range testOrders() {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer udp.Close()
+
+	conn, err := net.Dial("udp", udp.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- PublishOrderFeed(ctx, ob, conn)
+	}()
+
+	// the messages of the orders which are placed after the start are published as well.
+	o := &Order{orderID: "100500", operationType: Bid, price: decimal(t, "20100"), amount: decimal(t, "2.5")}
+	_, _, err = ob.PlaceMarketOrder(context.Background(), o)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	expected := feedMessages(t, ob.OrderFeed, 1)
+	received := make([]FeedMessage, 0, len(expected))
+	buf := make([]byte, maxFeedDatagram)
+	for len(received) < len(expected) {
+		_ = udp.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := udp.Read(buf)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		p, err := DecodeFeedPacket(buf[:n])
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if p.Instrument != "BTC-USDT" || p.Sequence != uint64(len(received))+1 {
+			t.Fatalf("unexpected packet: %s %d, expected seq: %d", p.Instrument, p.Sequence, len(received)+1)
+		}
+
+		received = append(received, p.Messages...)
+	}
+
+	for i, m := range received {
+		if m.Type != expected[i].Type || m.OrderRef != expected[i].OrderRef || m.Amount.Cmp(expected[i].Amount) != 0 {
+			t.Fatalf("expected %+v, but got: %+v", expected[i], m)
+		}
+	}
+
+	cancel()
+	err = <-done
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+func Test_FeedRetransmitter(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	err := ob.SetOrderFeed(NewOrderFeed(5))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	for _, o := range testOrders() {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	exchange := NewExchange()
+	err = exchange.AddBook(ob)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- NewFeedRetransmitter(exchange).Serve(ctx, l)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer conn.Close()
+
+	last := ob.OrderFeed.seq
+	expected := feedMessages(t, ob.OrderFeed, last-3)
+
+	p, err := RequestRetransmission(conn, "BTC-USDT", last-3, 2)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if p.Sequence != last-3 || len(p.Messages) != 2 {
+		t.Fatalf("unexpected packet: %d with %d messages", p.Sequence, len(p.Messages))
+	}
+	for i, m := range p.Messages {
+		if m.Type != expected[i].Type || m.OrderRef != expected[i].OrderRef || m.Timestamp != expected[i].Timestamp {
+			t.Fatalf("expected %+v, but got: %+v", expected[i], m)
+		}
+	}
+
+	// the old messages are dropped - the packet starts from the oldest one.
+	p, err = RequestRetransmission(conn, "BTC-USDT", 1, 100)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if p.Sequence != last-4 || len(p.Messages) != 5 {
+		t.Fatalf("unexpected packet: %d with %d messages", p.Sequence, len(p.Messages))
+	}
+
+	// there are no messages after the last one.
+	p, err = RequestRetransmission(conn, "BTC-USDT", last+10, 100)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if p.Sequence != last+1 || len(p.Messages) != 0 {
+		t.Fatalf("unexpected packet: %d with %d messages", p.Sequence, len(p.Messages))
+	}
+
+	// the unknown instrument closes the connection.
+	_, err = RequestRetransmission(conn, "ETH-USDT", 1, 100)
+	if err == nil {
+		t.Fatal("expected err, but got nil")
+	}
+
+	cancel()
+	err = <-done
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/apd"
+)
+
+const (
+	// volumeWindow is a period of the trading volume which defines the fee tier.
+	volumeWindow = 30 * 24 * time.Hour
+	// defaultFeeDecimals is a default number of decimals of the fees.
+	defaultFeeDecimals = 8
+)
+
+// FeeTier is a level of fees which depends on the 30-day trading volume of the account.
+type FeeTier struct {
+	// MinVolume is a min 30-day volume in QuoteAsset to get this tier.
+	MinVolume *apd.Decimal
+	// MakerRate is a fee rate of the executor order. A negative rate is a rebate.
+	MakerRate *apd.Decimal
+	// TakerRate is a fee rate of the initiator order.
+	TakerRate *apd.Decimal
+}
+
+// FeeSchedule calculates maker/taker fees of the executions.
+type FeeSchedule struct {
+	// tiers are fee tiers of all instruments sorted by MinVolume.
+	tiers []FeeTier
+	// overrides are fee tiers of the specific instruments, like BTC-USDT, sorted by MinVolume.
+	overrides map[string][]FeeTier
+	// FeeAsset is an Asset the fees are charged in. It must be BaseAsset or QuoteAsset of the order book.
+	// If it's empty - QuoteAsset is used.
+	FeeAsset Asset
+	// FeeAccount is an account which collects fees and pays rebates.
+	FeeAccount AccountID
+	// Decimals is a number of decimals the fees are rounded to.
+	Decimals int32
+
+	// volumes is a trading volume of the accounts by days of the orders.
+	volumes map[AccountID]map[int64]*apd.Decimal
+	// now returns current time of Volume, it's replaced in tests.
+	now func() time.Time
+
+	mx sync.Mutex
+}
+
+// NewFeeSchedule creates a new instance of FeeSchedule.
+func NewFeeSchedule(feeAccount AccountID, tiers []FeeTier) *FeeSchedule {
+	return &FeeSchedule{
+		tiers:      sortTiers(tiers),
+		overrides:  map[string][]FeeTier{},
+		FeeAccount: feeAccount,
+		Decimals:   defaultFeeDecimals,
+		volumes:    map[AccountID]map[int64]*apd.Decimal{},
+		now:        time.Now,
+		mx:         sync.Mutex{},
+	}
+}
+
+// SetOverride sets the fee tiers of the instrument.
+func (fs *FeeSchedule) SetOverride(instrument string, tiers []FeeTier) {
+	fs.mx.Lock()
+	defer fs.mx.Unlock()
+
+	fs.overrides[instrument] = sortTiers(tiers)
+}
+
+// sortTiers returns a copy of the tiers sorted by MinVolume, so tier doesn't sort them on every fill.
+func sortTiers(tiers []FeeTier) []FeeTier {
+	sorted := make([]FeeTier, len(tiers))
+	copy(sorted, tiers)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].MinVolume.Cmp(sorted[j].MinVolume) < 0
+	})
+
+	return sorted
+}
+
+// Volume returns the 30-day trading volume of the account.
+func (fs *FeeSchedule) Volume(account AccountID) *apd.Decimal {
+	fs.mx.Lock()
+	defer fs.mx.Unlock()
+
+	return fs.volume(account, fs.now())
+}
+
+// volume returns the trading volume of the account in the window before at.
+func (fs *FeeSchedule) volume(account AccountID, at time.Time) *apd.Decimal {
+	total := apd.New(0, 0)
+	from := day(at.Add(-volumeWindow))
+
+	for d, v := range fs.volumes[account] {
+		if d <= from {
+			continue
+		}
+
+		// it can't fail - there is no rounding with BaseContext.
+		_, _ = apd.BaseContext.Add(total, total, v)
+	}
+
+	return total
+}
+
+// AddVolume adds the trading volume to the account at the day of at.
+// The days which are out of the window before at are dropped, so the volumes depend only on the added ones.
+func (fs *FeeSchedule) AddVolume(account AccountID, volume *apd.Decimal, at time.Time) error {
+	fs.mx.Lock()
+	defer fs.mx.Unlock()
+
+	days, ok := fs.volumes[account]
+	if !ok {
+		days = map[int64]*apd.Decimal{}
+		fs.volumes[account] = days
+	}
+
+	from := day(at.Add(-volumeWindow))
+	for d := range days {
+		if d <= from {
+			// it's out of the window - nobody needs it anymore.
+			delete(days, d)
+		}
+	}
+
+	d := day(at)
+	v, ok := days[d]
+	if !ok {
+		v = apd.New(0, 0)
+		days[d] = v
+	}
+
+	_, err := apd.BaseContext.Add(v, v, volume)
+
+	return err
+}
+
+// addVolume adds the quote amount of the execution to the trading volume of both accounts.
+func (fs *FeeSchedule) addVolume(e *ExecutionReport, at time.Time) {
+	// it can't fail - there is no rounding with BaseContext.
+	quoteAmount := apd.New(0, 0)
+	_, _ = apd.BaseContext.Mul(quoteAmount, e.price, e.amount)
+	_ = fs.AddVolume(e.initiatorAccountID, quoteAmount, at)
+	_ = fs.AddVolume(e.executorAccountID, quoteAmount, at)
+}
+
+// removeVolume removes the quote amount of the rolled back execution from the trading volume of both accounts.
+func (fs *FeeSchedule) removeVolume(e *ExecutionReport, at time.Time) {
+	// it can't fail - there is no rounding with BaseContext.
+	quoteAmount := apd.New(0, 0)
+	_, _ = apd.BaseContext.Mul(quoteAmount, e.price, e.amount)
+	quoteAmount.Neg(quoteAmount)
+	_ = fs.AddVolume(e.initiatorAccountID, quoteAmount, at)
+	_ = fs.AddVolume(e.executorAccountID, quoteAmount, at)
+}
+
+// tier returns the fee tier of the account for the instrument by the volume at the time and the pending volume,
+// which isn't added yet.
+func (fs *FeeSchedule) tier(instrument string, account AccountID, at time.Time, pending *apd.Decimal) (FeeTier, error) {
+	fs.mx.Lock()
+	defer fs.mx.Unlock()
+
+	tiers, ok := fs.overrides[instrument]
+	if !ok {
+		tiers = fs.tiers
+	}
+	if len(tiers) == 0 {
+		return FeeTier{}, fmt.Errorf("there are no fee tiers for: %s", instrument)
+	}
+
+	volume := fs.volume(account, at)
+	if pending != nil {
+		_, err := apd.BaseContext.Add(volume, volume, pending)
+		if err != nil {
+			return FeeTier{}, err
+		}
+	}
+
+	// the first tier is used even if the volume is less than its MinVolume.
+	i := sort.Search(len(tiers), func(i int) bool {
+		return volume.Cmp(tiers[i].MinVolume) < 0
+	})
+	if i == 0 {
+		return tiers[0], nil
+	}
+
+	return tiers[i-1], nil
+}
+
+// fee calculates the fee by the rate for the base amount or the quote amount, depending on the fee asset.
+func (fs *FeeSchedule) fee(rate, amount *apd.Decimal) (*apd.Decimal, error) {
+	ctx := apd.BaseContext.WithPrecision(34)
+	ctx.Rounding = apd.RoundHalfUp
+
+	fee := apd.New(0, 0)
+	_, err := ctx.Mul(fee, rate, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ctx.Quantize(fee, fee, -fs.Decimals)
+
+	return fee, err
+}
+
+// day returns the number of the day of the time.
+func day(t time.Time) int64 {
+	return t.Unix() / int64(24*time.Hour/time.Second)
+}
+
+// Instrument returns the name of the instrument of the order book, like BTC-USDT.
+func (ob *OrderBook) Instrument() string {
+	return string(ob.BaseAsset) + "-" + string(ob.QuoteAsset)
+}
+
+// planFees calculates fees of the fills of the order, sets them to the fills and returns the moves of the fees
+// to the fee account. The initiator order pays the taker fee, the executor order pays the maker fee.
+// The tiers are found by the volume at the time of the order and the volume of its previous fills,
+// so the replay of the journal charges the same fees.
+func (ob *OrderBook) planFees(o *Order, fills []*ExecutionReport) ([]balanceMove, error) {
+	feeAsset := ob.Fees.FeeAsset
+	if feeAsset == "" {
+		feeAsset = ob.QuoteAsset
+	}
+	if feeAsset != ob.BaseAsset && feeAsset != ob.QuoteAsset {
+		return nil, fmt.Errorf("fee asset: %s doesn't belong to: %s", feeAsset, ob.Instrument())
+	}
+
+	instrument := ob.Instrument()
+	pending := map[AccountID]*apd.Decimal{}
+	var moves []balanceMove
+	for _, e := range fills {
+		quoteAmount := apd.New(0, 0)
+		_, err := apd.BaseContext.Mul(quoteAmount, e.price, e.amount)
+		if err != nil {
+			return nil, err
+		}
+
+		feeBase := quoteAmount
+		if feeAsset == ob.BaseAsset {
+			feeBase = e.amount
+		}
+
+		takerTier, err := ob.Fees.tier(instrument, e.initiatorAccountID, o.createdAt, pending[e.initiatorAccountID])
+		if err != nil {
+			return nil, err
+		}
+
+		makerTier, err := ob.Fees.tier(instrument, e.executorAccountID, o.createdAt, pending[e.executorAccountID])
+		if err != nil {
+			return nil, err
+		}
+
+		e.feeAsset = feeAsset
+		e.takerFee, err = ob.Fees.fee(takerTier.TakerRate, feeBase)
+		if err != nil {
+			return nil, err
+		}
+
+		e.makerFee, err = ob.Fees.fee(makerTier.MakerRate, feeBase)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, account := range []AccountID{e.initiatorAccountID, e.executorAccountID} {
+			v, ok := pending[account]
+			if !ok {
+				v = apd.New(0, 0)
+				pending[account] = v
+			}
+
+			_, err = apd.BaseContext.Add(v, v, quoteAmount)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		moves = append(moves, ob.feeMoves(e.initiatorAccountID, feeAsset, e.takerFee)...)
+		moves = append(moves, ob.feeMoves(e.executorAccountID, feeAsset, e.makerFee)...)
+	}
+
+	return moves, nil
+}
+
+// feeMoves returns the move of the fee from the account to the fee account, a negative fee is a rebate and goes back.
+func (ob *OrderBook) feeMoves(account AccountID, asset Asset, fee *apd.Decimal) []balanceMove {
+	if ob.Accounts == nil || fee.IsZero() {
+		return nil
+	}
+
+	if fee.Sign() < 0 {
+		rebate := apd.New(0, 0).Neg(fee)
+		return []balanceMove{{op: opTransferAvailable, from: ob.Fees.FeeAccount, to: account, asset: asset, amount: rebate}}
+	}
+
+	return []balanceMove{{op: opTransferAvailable, from: account, to: ob.Fees.FeeAccount, asset: asset, amount: fee}}
+}
+
+// refundMoves returns the moves which give the fees of the execution back, the rebates go back to the fee account.
+func (ob *OrderBook) refundMoves(e *ExecutionReport) []balanceMove {
+	var moves []balanceMove
+	for _, fee := range []struct {
+		account AccountID
+		amount  *apd.Decimal
+	}{
+		{e.initiatorAccountID, e.takerFee},
+		{e.executorAccountID, e.makerFee},
+	} {
+		if fee.amount != nil {
+			moves = append(moves, ob.feeMoves(fee.account, e.feeAsset, apd.New(0, 0).Neg(fee.amount))...)
+		}
+	}
+
+	return moves
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/apd"
+)
+
+func testFeeSchedule(t *testing.T) *FeeSchedule {
+	t.Helper()
+
+	fs := NewFeeSchedule("fees", []FeeTier{
+		{
+			MinVolume: decimal(t, "0"),
+			MakerRate: decimal(t, "0.001"),
+			TakerRate: decimal(t, "0.002"),
+		},
+		{
+			MinVolume: decimal(t, "30000"),
+			MakerRate: decimal(t, "-0.0001"),
+			TakerRate: decimal(t, "0.001"),
+		},
+	})
+	fs.Decimals = 2
+	fs.now = func() time.Time {
+		return time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	}
+
+	return fs
+}
+
+func Test_FeesExecution(t *testing.T) {
+	ob, accounts := testAccountsOrderBook(t)
+	ob.Fees = testFeeSchedule(t)
+
+	for _, o := range []*Order{
+		{
+			orderID:       "1",
+			accountID:     "seller",
+			operationType: Ask,
+			amount:        apd.New(1, 0),
+			price:         apd.New(20000, 0),
+		},
+		{
+			orderID:       "2",
+			accountID:     "buyer",
+			operationType: Bid,
+			amount:        apd.New(1, 0),
+			price:         apd.New(20000, 0),
+		},
+	} {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	e := ob.OrdersDone["2"].executions[0]
+	if e.feeAsset != "USDT" || e.takerFee.String() != "40.00" || e.makerFee.String() != "20.00" {
+		t.Fatalf("unexpected fees: %s %s %s", e.feeAsset, e.takerFee, e.makerFee)
+	}
+
+	checkBalance(t, accounts, "buyer", "USDT", "79960", "0")
+	checkBalance(t, accounts, "seller", "USDT", "19980", "0")
+	checkBalance(t, accounts, "fees", "USDT", "60", "0")
+
+	// both accounts have 20000 of the volume.
+	if ob.Fees.Volume("buyer").Cmp(apd.New(20000, 0)) != 0 {
+		t.Fatalf("unexpected volume: %s", ob.Fees.Volume("buyer"))
+	}
+
+	// the second trade moves both accounts to the next tier, but the fee is calculated before the trade.
+	for _, o := range []*Order{
+		{
+			orderID:       "3",
+			accountID:     "seller",
+			operationType: Ask,
+			amount:        apd.New(1, 0),
+			price:         apd.New(20000, 0),
+		},
+		{
+			orderID:       "4",
+			accountID:     "buyer",
+			operationType: Bid,
+			amount:        apd.New(1, 0),
+			price:         apd.New(20000, 0),
+		},
+		{
+			orderID:       "5",
+			accountID:     "seller",
+			operationType: Ask,
+			amount:        apd.New(1, 0),
+			price:         apd.New(20000, 0),
+		},
+		{
+			orderID:       "6",
+			accountID:     "buyer",
+			operationType: Bid,
+			amount:        apd.New(1, 0),
+			price:         apd.New(20000, 0),
+		},
+	} {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	e = ob.OrdersDone["6"].executions[0]
+	if e.takerFee.String() != "20.00" || e.makerFee.String() != "-2.00" {
+		t.Fatalf("unexpected fees: %s %s", e.takerFee, e.makerFee)
+	}
+
+	checkBalance(t, accounts, "fees", "USDT", "138", "0")
+	checkBalance(t, accounts, "seller", "USDT", "59962", "0")
+}
+
+func Test_FeesOverrideAndAsset(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	ob.Fees = testFeeSchedule(t)
+	ob.Fees.FeeAsset = "BTC"
+	ob.Fees.Decimals = 8
+	ob.Fees.SetOverride("BTC-USDT", []FeeTier{
+		{
+			MinVolume: decimal(t, "0"),
+			MakerRate: decimal(t, "0"),
+			TakerRate: decimal(t, "0.0005"),
+		},
+	})
+
+	for _, o := range testOrders() {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	_, _, err := ob.PlaceMarketOrder(context.Background(), &Order{
+		orderID:       "100500",
+		operationType: Bid,
+		amount:        apd.New(5, -1),
+		price:         apd.New(20050, 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	executions := ob.OrdersDone["100500"].executions
+	if len(executions) != 2 {
+		t.Fatalf("expected 2 executions, but got: %d", len(executions))
+	}
+
+	for i, fee := range []string{"0.00015000", "0.00010000"} {
+		if executions[i].feeAsset != "BTC" || executions[i].takerFee.String() != fee || !executions[i].makerFee.IsZero() {
+			t.Fatalf("unexpected fees: %s %s %s", executions[i].feeAsset, executions[i].takerFee, executions[i].makerFee)
+		}
+	}
+
+	ob.Fees.FeeAsset = "ETH"
+	_, err = ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID:       "100501",
+		operationType: Bid,
+		amount:        apd.New(1, -1),
+		price:         apd.New(20050, 0),
+	})
+	if err == nil || err.Error() != "can't settle order: fee asset: ETH doesn't belong to: BTC-USDT" {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// the fees are calculated before the matching, so the order book isn't changed.
+	if _, ok := ob.OrdersDone["100501"]; ok {
+		t.Fatalf("order is placed")
+	}
+	if asks := ob.Asks.Depth(1); len(asks) != 1 || asks[0].Amount.Cmp(apd.New(5, -1)) != 0 {
+		t.Fatalf("unexpected asks: %+v", asks)
+	}
+}
+
+func Test_FeesInsufficientFunds(t *testing.T) {
+	ob, accounts := testAccountsOrderBook(t)
+	ob.Fees = testFeeSchedule(t)
+	ob.Fees.FeeAsset = "BTC"
+	ob.Fees.Decimals = 8
+	ob.Ledger = NewLedger()
+
+	// the trade is counted by the time of the order, it's out of the window now.
+	createdAt := ob.Fees.now().Add(-40 * 24 * time.Hour)
+	for _, o := range []*Order{
+		{orderID: "1", accountID: "seller", operationType: Ask, amount: decimal(t, "1"), price: decimal(t, "20000"), createdAt: createdAt},
+		{orderID: "2", accountID: "buyer", operationType: Bid, amount: decimal(t, "1"), price: decimal(t, "20000"), createdAt: createdAt},
+	} {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+	if !ob.Fees.Volume("buyer").IsZero() {
+		t.Fatalf("unexpected volume: %s", ob.Fees.Volume("buyer"))
+	}
+
+	// all BTC of the seller is reserved by the order, so it can't pay the maker fee.
+	_, err := ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID: "3", accountID: "seller", operationType: Ask, amount: decimal(t, "8.999"), price: decimal(t, "20000"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	checkBalance(t, accounts, "seller", "BTC", "0", "8.999")
+
+	_, err = ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID: "4", accountID: "buyer", operationType: Bid, amount: decimal(t, "1"), price: decimal(t, "20000"),
+	})
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// nothing is changed by the rejected order.
+	if _, ok := ob.OrdersDone["4"]; ok {
+		t.Fatalf("order is placed")
+	}
+	if asks := ob.Asks.Depth(0); len(asks) != 1 || asks[0].Amount.Cmp(decimal(t, "8.999")) != 0 {
+		t.Fatalf("unexpected asks: %+v", asks)
+	}
+	checkBalance(t, accounts, "seller", "BTC", "0", "8.999")
+	checkBalance(t, accounts, "buyer", "USDT", "80000", "0")
+	checkBalance(t, accounts, "buyer", "BTC", "0.998", "0")
+	if entries := ob.Ledger.Entries(0); len(entries) != 1 {
+		t.Fatalf("unexpected ledger entries: %d", len(entries))
+	}
+}
+
+func Test_FeeTier(t *testing.T) {
+	tiers := []FeeTier{
+		{MinVolume: decimal(t, "100"), MakerRate: decimal(t, "0"), TakerRate: decimal(t, "0.0005")},
+		{MinVolume: decimal(t, "10"), MakerRate: decimal(t, "0.0005"), TakerRate: decimal(t, "0.001")},
+		{MinVolume: decimal(t, "50"), MakerRate: decimal(t, "0.0001"), TakerRate: decimal(t, "0.0008")},
+	}
+	fs := NewFeeSchedule("fees", tiers)
+	at := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		volume    string
+		takerRate string
+	}{
+		{volume: "0", takerRate: "0.001"},
+		{volume: "10", takerRate: "0.001"},
+		{volume: "49.99", takerRate: "0.001"},
+		{volume: "50", takerRate: "0.0008"},
+		{volume: "100", takerRate: "0.0005"},
+		{volume: "1000", takerRate: "0.0005"},
+	} {
+		tier, err := fs.tier("BTC-USDT", "a1", at, decimal(t, tc.volume))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if tier.TakerRate.Cmp(decimal(t, tc.takerRate)) != 0 {
+			t.Fatalf("unexpected taker rate for %s: %s", tc.volume, tier.TakerRate)
+		}
+	}
+
+	if tiers[0].MinVolume.Cmp(decimal(t, "100")) != 0 {
+		t.Fatalf("tiers of the caller are sorted: %s", tiers[0].MinVolume)
+	}
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	fixBeginString = "FIX.4.4"
+	// fixTimeFormat is a format of UTCTimestamp.
+	fixTimeFormat = "20060102-15:04:05.000"
+	// fixMaxBodyLength is a max length of the message body.
+	fixMaxBodyLength = 64 * 1024
+	fixSOH           = '\x01'
+)
+
+// Tags of the FIX fields.
+const (
+	tagAccount             = 1
+	tagAvgPx               = 6
+	tagBeginSeqNo          = 7
+	tagBeginString         = 8
+	tagBodyLength          = 9
+	tagCheckSum            = 10
+	tagClOrdID             = 11
+	tagCumQty              = 14
+	tagEndSeqNo            = 16
+	tagExecID              = 17
+	tagLastPx              = 31
+	tagLastQty             = 32
+	tagMsgSeqNum           = 34
+	tagMsgType             = 35
+	tagNewSeqNo            = 36
+	tagOrderID             = 37
+	tagOrderQty            = 38
+	tagOrdStatus           = 39
+	tagOrdType             = 40
+	tagOrigClOrdID         = 41
+	tagPossDupFlag         = 43
+	tagPrice               = 44
+	tagRefSeqNum           = 45
+	tagSenderCompID        = 49
+	tagSendingTime         = 52
+	tagSide                = 54
+	tagSymbol              = 55
+	tagTargetCompID        = 56
+	tagText                = 58
+	tagTransactTime        = 60
+	tagEncryptMethod       = 98
+	tagCxlRejReason        = 102
+	tagOrdRejReason        = 103
+	tagHeartBtInt          = 108
+	tagTestReqID           = 112
+	tagOrigSendingTime     = 122
+	tagGapFillFlag         = 123
+	tagResetSeqNumFlag     = 141
+	tagExecType            = 150
+	tagLeavesQty           = 151
+	tagRefTagID            = 371
+	tagSessionRejectReason = 373
+	tagCxlRejResponseTo    = 434
+)
+
+// Types of the FIX messages.
+const (
+	fixHeartbeat                 = "0"
+	fixTestRequest               = "1"
+	fixResendRequest             = "2"
+	fixReject                    = "3"
+	fixSequenceReset             = "4"
+	fixLogout                    = "5"
+	fixExecutionReport           = "8"
+	fixOrderCancelReject         = "9"
+	fixLogon                     = "A"
+	fixNewOrderSingle            = "D"
+	fixOrderCancelRequest        = "F"
+	fixOrderCancelReplaceRequest = "G"
+)
+
+// fixHeaderTags are the tags of the standard header after MsgType, in the order of the encoding.
+var fixHeaderTags = []int{
+	tagSenderCompID, tagTargetCompID, tagMsgSeqNum, tagSendingTime, tagPossDupFlag, tagOrigSendingTime,
+}
+
+var (
+	// ErrFIXGarbled is returned for the message with the wrong checksum, it should be ignored.
+	ErrFIXGarbled = errors.New("garbled FIX message")
+	// errFIXFormat is returned when the stream isn't a FIX stream, the connection can't be recovered.
+	errFIXFormat = errors.New("wrong FIX format")
+)
+
+// FIXField is a field of the FIX message.
+type FIXField struct {
+	Tag   int
+	Value string
+}
+
+// FIXMessage is a FIX message without BeginString, BodyLength and CheckSum, they're added by the encoding.
+type FIXMessage struct {
+	MsgType string
+	Fields  []FIXField
+}
+
+// newFIXMessage creates the message of the type with the fields: tag, value, tag, value...
+// Fields with the empty values are skipped.
+func newFIXMessage(msgType string, tagValues ...interface{}) *FIXMessage {
+	m := &FIXMessage{MsgType: msgType}
+	for i := 0; i+1 < len(tagValues); i += 2 {
+		m.Set(tagValues[i].(int), fmt.Sprint(tagValues[i+1]))
+	}
+
+	return m
+}
+
+// Get returns the value of the tag.
+func (m *FIXMessage) Get(tag int) (string, bool) {
+	for _, f := range m.Fields {
+		if f.Tag == tag {
+			return f.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// Value returns the value of the tag or the empty string.
+func (m *FIXMessage) Value(tag int) string {
+	v, _ := m.Get(tag)
+
+	return v
+}
+
+// Uint returns the value of the tag as the number.
+func (m *FIXMessage) Uint(tag int) (uint64, error) {
+	v, ok := m.Get(tag)
+	if !ok {
+		return 0, fmt.Errorf("tag %d is required", tag)
+	}
+
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("wrong value of tag %d: %q", tag, v)
+	}
+
+	return n, nil
+}
+
+// Set sets the value of the tag, the empty value removes the tag.
+func (m *FIXMessage) Set(tag int, value string) {
+	for i, f := range m.Fields {
+		if f.Tag == tag {
+			if value == "" {
+				m.Fields = append(m.Fields[:i], m.Fields[i+1:]...)
+			} else {
+				m.Fields[i].Value = value
+			}
+
+			return
+		}
+	}
+
+	if value != "" {
+		m.Fields = append(m.Fields, FIXField{Tag: tag, Value: value})
+	}
+}
+
+// isAdmin returns true for the session level messages.
+func (m *FIXMessage) isAdmin() bool {
+	switch m.MsgType {
+	case fixHeartbeat, fixTestRequest, fixResendRequest, fixReject, fixSequenceReset, fixLogout, fixLogon:
+		return true
+	default:
+		return false
+	}
+}
+
+// String returns the message with | instead of SOH.
+func (m *FIXMessage) String() string {
+	return strings.ReplaceAll(string(m.Encode()), string(fixSOH), "|")
+}
+
+// Encode encodes the message: the header fields go first, then the other fields in their order.
+func (m *FIXMessage) Encode() []byte {
+	var body bytes.Buffer
+	writeField := func(tag int, value string) {
+		body.WriteString(strconv.Itoa(tag))
+		body.WriteByte('=')
+		body.WriteString(value)
+		body.WriteByte(fixSOH)
+	}
+
+	writeField(tagMsgType, m.MsgType)
+	for _, tag := range fixHeaderTags {
+		if v, ok := m.Get(tag); ok {
+			writeField(tag, v)
+		}
+	}
+	for _, f := range m.Fields {
+		if !isFIXHeaderTag(f.Tag) {
+			writeField(f.Tag, f.Value)
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d=%s%c%d=%d%c", tagBeginString, fixBeginString, fixSOH, tagBodyLength, body.Len(), fixSOH)
+	b.Write(body.Bytes())
+	fmt.Fprintf(&b, "%d=%03d%c", tagCheckSum, fixCheckSum(b.Bytes()), fixSOH)
+
+	return b.Bytes()
+}
+
+// isFIXHeaderTag returns true for the tags of the standard header.
+func isFIXHeaderTag(tag int) bool {
+	for _, t := range fixHeaderTags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return tag == tagBeginString || tag == tagBodyLength || tag == tagMsgType || tag == tagCheckSum
+}
+
+// fixCheckSum returns the sum of the bytes modulo 256.
+func fixCheckSum(b []byte) int {
+	sum := 0
+	for _, c := range b {
+		sum += int(c)
+	}
+
+	return sum % 256
+}
+
+// readFIXMessage reads the next message from the stream. ErrFIXGarbled is returned for the message
+// with the wrong checksum or fields, the stream can be read further. Other errors break the stream.
+func readFIXMessage(r *bufio.Reader) (*FIXMessage, error) {
+	begin, err := readFIXField(r, tagBeginString)
+	if err != nil {
+		return nil, err
+	}
+	if begin != fixBeginString {
+		return nil, fmt.Errorf("%w: unsupported BeginString: %q", errFIXFormat, begin)
+	}
+
+	length, err := readFIXField(r, tagBodyLength)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(length)
+	if err != nil || n <= 0 || n > fixMaxBodyLength {
+		return nil, fmt.Errorf("%w: wrong BodyLength: %q", errFIXFormat, length)
+	}
+
+	body := make([]byte, n)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return nil, err
+	}
+
+	checkSum, err := readFIXField(r, tagCheckSum)
+	if err != nil {
+		return nil, err
+	}
+
+	header := fmt.Sprintf("%d=%s%c%d=%s%c", tagBeginString, begin, fixSOH, tagBodyLength, length, fixSOH)
+	sum := (fixCheckSum([]byte(header)) + fixCheckSum(body)) % 256
+	if fmt.Sprintf("%03d", sum) != checkSum {
+		return nil, fmt.Errorf("%w: wrong CheckSum: %s, expected: %03d", ErrFIXGarbled, checkSum, sum)
+	}
+
+	return parseFIXBody(body)
+}
+
+// readFIXField reads the field with the tag up to SOH.
+func readFIXField(r *bufio.Reader, tag int) (string, error) {
+	field, err := r.ReadString(fixSOH)
+	if err != nil {
+		if errors.Is(err, io.EOF) && field != "" {
+			return "", io.ErrUnexpectedEOF
+		}
+
+		return "", err
+	}
+
+	prefix := strconv.Itoa(tag) + "="
+	if !strings.HasPrefix(field, prefix) || len(field) > 64 {
+		return "", fmt.Errorf("%w: expected tag %d, but got: %q", errFIXFormat, tag, field)
+	}
+
+	return field[len(prefix) : len(field)-1], nil
+}
+
+// parseFIXBody parses the fields of the body, the first one must be MsgType.
+func parseFIXBody(body []byte) (*FIXMessage, error) {
+	if body[len(body)-1] != fixSOH {
+		return nil, fmt.Errorf("%w: body must end with SOH", ErrFIXGarbled)
+	}
+
+	m := &FIXMessage{}
+	for i, field := range strings.Split(string(body[:len(body)-1]), string(fixSOH)) {
+		eq := strings.IndexByte(field, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("%w: wrong field: %q", ErrFIXGarbled, field)
+		}
+
+		tag, err := strconv.Atoi(field[:eq])
+		if err != nil || tag <= 0 {
+			return nil, fmt.Errorf("%w: wrong tag: %q", ErrFIXGarbled, field)
+		}
+
+		if i == 0 {
+			if tag != tagMsgType {
+				return nil, fmt.Errorf("%w: MsgType must be the first field", ErrFIXGarbled)
+			}
+			m.MsgType = field[eq+1:]
+
+			continue
+		}
+
+		m.Fields = append(m.Fields, FIXField{Tag: tag, Value: field[eq+1:]})
+	}
+
+	return m, nil
+}
+
+// fixStore persists the sequence numbers and the sent messages of the FIX session, so the session
+// continues after restart and the messages can be resent. The dir has:
+//
+//	seqnums       next sender and target sequence numbers
+//	messages.log  sent messages: seq (8 bytes), length (4 bytes), message
+type fixStore struct {
+	dir string
+	// nextSenderSeq is a sequence number of the next sent message.
+	nextSenderSeq uint64
+	// nextTargetSeq is an expected sequence number of the next received message.
+	nextTargetSeq uint64
+
+	log     *os.File
+	offsets map[uint64]int64
+	size    int64
+}
+
+// openFIXStore opens the store in the dir, the new store starts from 1 and 1.
+func openFIXStore(dir string) (*fixStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("can't create FIX store dir: %w", err)
+	}
+
+	s := &fixStore{
+		dir:           dir,
+		nextSenderSeq: 1,
+		nextTargetSeq: 1,
+		offsets:       map[uint64]int64{},
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "seqnums"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("can't read FIX seqnums: %w", err)
+	}
+	if err == nil {
+		_, err = fmt.Sscan(string(data), &s.nextSenderSeq, &s.nextTargetSeq)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse FIX seqnums: %w", err)
+		}
+	}
+
+	s.log, err = os.OpenFile(filepath.Join(dir, "messages.log"), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("can't open FIX messages: %w", err)
+	}
+
+	err = s.loadOffsets()
+	if err != nil {
+		s.log.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// loadOffsets reads the offsets of the messages, the torn record at the end is truncated.
+func (s *fixStore) loadOffsets() error {
+	r := bufio.NewReader(s.log)
+	header := make([]byte, 12)
+
+	var offset int64
+	for {
+		_, err := io.ReadFull(r, header)
+		if err != nil {
+			break
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[8:]))
+		n, err := r.Discard(int(size))
+		if err != nil || int64(n) != size {
+			break
+		}
+
+		s.offsets[binary.BigEndian.Uint64(header)] = offset
+		offset += 12 + size
+	}
+
+	err := s.log.Truncate(offset)
+	if err != nil {
+		return fmt.Errorf("can't truncate FIX messages: %w", err)
+	}
+	s.size = offset
+
+	return nil
+}
+
+// saveSeqNums writes the sequence numbers.
+func (s *fixStore) saveSeqNums() error {
+	path := filepath.Join(s.dir, "seqnums")
+	data := fmt.Sprintf("%d %d\n", s.nextSenderSeq, s.nextTargetSeq)
+
+	err := os.WriteFile(path+".tmp", []byte(data), 0o644)
+	if err != nil {
+		return fmt.Errorf("can't write FIX seqnums: %w", err)
+	}
+
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		return fmt.Errorf("can't write FIX seqnums: %w", err)
+	}
+
+	return nil
+}
+
+// setNextTargetSeq sets the expected sequence number of the next received message.
+func (s *fixStore) setNextTargetSeq(seq uint64) error {
+	s.nextTargetSeq = seq
+
+	return s.saveSeqNums()
+}
+
+// saveMessage saves the sent message with the next sender sequence number and increments it.
+func (s *fixStore) saveMessage(seq uint64, msg []byte) error {
+	record := make([]byte, 12, 12+len(msg))
+	binary.BigEndian.PutUint64(record, seq)
+	binary.BigEndian.PutUint32(record[8:], uint32(len(msg)))
+	record = append(record, msg...)
+
+	_, err := s.log.WriteAt(record, s.size)
+	if err != nil {
+		return fmt.Errorf("can't write FIX message: %w", err)
+	}
+
+	s.offsets[seq] = s.size
+	s.size += int64(len(record))
+	s.nextSenderSeq = seq + 1
+
+	return s.saveSeqNums()
+}
+
+// message returns the sent message by the sequence number.
+func (s *fixStore) message(seq uint64) (*FIXMessage, bool, error) {
+	offset, ok := s.offsets[seq]
+	if !ok {
+		return nil, false, nil
+	}
+
+	header := make([]byte, 12)
+	_, err := s.log.ReadAt(header, offset)
+	if err != nil {
+		return nil, false, fmt.Errorf("can't read FIX message: %w", err)
+	}
+
+	data := make([]byte, binary.BigEndian.Uint32(header[8:]))
+	_, err = s.log.ReadAt(data, offset+12)
+	if err != nil {
+		return nil, false, fmt.Errorf("can't read FIX message: %w", err)
+	}
+
+	m, err := readFIXMessage(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, false, err
+	}
+
+	return m, true, nil
+}
+
+// reset starts the session from 1 and 1, the sent messages are removed.
+func (s *fixStore) reset() error {
+	err := s.log.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("can't reset FIX messages: %w", err)
+	}
+
+	s.offsets = map[uint64]int64{}
+	s.size = 0
+	s.nextSenderSeq = 1
+	s.nextTargetSeq = 1
+
+	return s.saveSeqNums()
+}
+
+// Close closes the store.
+func (s *fixStore) Close() error {
+	return s.log.Close()
+}
+
+// fixTime formats the time as UTCTimestamp.
+func fixTime(t time.Time) string {
+	return t.UTC().Format(fixTimeFormat)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func Test_FIXMessageEncode(t *testing.T) {
+	m := newFIXMessage(fixNewOrderSingle, tagClOrdID, "1", tagSide, fixSideBuy, tagPrice, "")
+	m.Set(tagMsgSeqNum, "7")
+	m.Set(tagSenderCompID, "CLIENT")
+	m.Set(tagTargetCompID, "ME")
+
+	data := m.Encode()
+	raw := strings.ReplaceAll(string(data), "\x01", "|")
+	if !strings.HasPrefix(raw, "8=FIX.4.4|9=") || !strings.Contains(raw, "|35=D|49=CLIENT|56=ME|34=7|11=1|54=1|10=") {
+		t.Fatalf("unexpected message: %s", raw)
+	}
+
+	got, err := readFIXMessage(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if got.MsgType != fixNewOrderSingle || got.Value(tagClOrdID) != "1" || got.Value(tagMsgSeqNum) != "7" {
+		t.Fatalf("unexpected message: %s", got)
+	}
+	if _, ok := got.Get(tagPrice); ok {
+		t.Fatalf("empty field must be skipped: %s", got)
+	}
+
+	seq, err := got.Uint(tagMsgSeqNum)
+	if err != nil || seq != 7 {
+		t.Fatalf("unexpected seq: %d, err: %v", seq, err)
+	}
+}
+
+func Test_FIXMessageGarbled(t *testing.T) {
+	m := newFIXMessage(fixHeartbeat, tagMsgSeqNum, 1)
+	data := m.Encode()
+
+	garbled := bytes.Replace(data, []byte("34=1"), []byte("34=2"), 1)
+	stream := append(garbled, data...)
+	r := bufio.NewReader(bytes.NewReader(stream))
+
+	_, err := readFIXMessage(r)
+	if !errors.Is(err, ErrFIXGarbled) {
+		t.Fatalf("expected garbled err, but got: %v", err)
+	}
+
+	// the stream continues after the garbled message.
+	got, err := readFIXMessage(r)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if got.MsgType != fixHeartbeat || got.Value(tagMsgSeqNum) != "1" {
+		t.Fatalf("unexpected message: %s", got)
+	}
+
+	_, err = readFIXMessage(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n\x01")))
+	if !errors.Is(err, errFIXFormat) {
+		t.Fatalf("expected format err, but got: %v", err)
+	}
+}
+
+func Test_FIXStore(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := openFIXStore(dir)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if s.nextSenderSeq != 1 || s.nextTargetSeq != 1 {
+		t.Fatalf("unexpected seqnums: %d %d", s.nextSenderSeq, s.nextTargetSeq)
+	}
+
+	for seq := uint64(1); seq <= 3; seq++ {
+		m := newFIXMessage(fixExecutionReport, tagMsgSeqNum, seq)
+		err = s.saveMessage(seq, m.Encode())
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+	err = s.setNextTargetSeq(5)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	s.Close()
+
+	s, err = openFIXStore(dir)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer s.Close()
+
+	if s.nextSenderSeq != 4 || s.nextTargetSeq != 5 {
+		t.Fatalf("unexpected seqnums: %d %d", s.nextSenderSeq, s.nextTargetSeq)
+	}
+
+	m, ok, err := s.message(2)
+	if err != nil || !ok {
+		t.Fatalf("message isn't found, err: %v", err)
+	}
+	if m.Value(tagMsgSeqNum) != "2" {
+		t.Fatalf("unexpected message: %s", m)
+	}
+
+	_, ok, err = s.message(4)
+	if err != nil || ok {
+		t.Fatalf("unexpected message, err: %v", err)
+	}
+
+	err = s.reset()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if s.nextSenderSeq != 1 || s.nextTargetSeq != 1 {
+		t.Fatalf("unexpected seqnums: %d %d", s.nextSenderSeq, s.nextTargetSeq)
+	}
+	_, ok, _ = s.message(1)
+	if ok {
+		t.Fatal("messages must be removed by reset")
+	}
+}
@@ -0,0 +1,1195 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/apd"
+)
+
+const (
+	// fixLogonTimeout is a time to wait the Logon after the connection.
+	fixLogonTimeout = 10 * time.Second
+	// fixMaxHeartBtInt is a max heartbeat interval of the client.
+	fixMaxHeartBtInt = 300
+)
+
+// FIX values of the ExecutionReport.
+const (
+	fixExecNew      = "0"
+	fixExecCanceled = "4"
+	fixExecReplaced = "5"
+	fixExecRejected = "8"
+	fixExecTrade    = "F"
+
+	fixStatusNew             = "0"
+	fixStatusPartiallyFilled = "1"
+	fixStatusFilled          = "2"
+	fixStatusCanceled        = "4"
+	fixStatusRejected        = "8"
+
+	fixSideBuy  = "1"
+	fixSideSell = "2"
+
+	fixOrdTypeMarket = "1"
+	fixOrdTypeLimit  = "2"
+)
+
+// FIXConfig is a config of the FIX acceptor.
+type FIXConfig struct {
+	// CompID is SenderCompID of the acceptor, the clients send it as TargetCompID.
+	CompID string
+	// StoreDir is a dir of the session stores, every session has its own dir by the client CompID.
+	StoreDir string
+	// Clients are CompIDs of the clients which can log on with the accounts they can trade on.
+	// The client without accounts trades only on the account of its CompID, the first account is the default one.
+	Clients map[string][]AccountID
+}
+
+// fixCompIDPattern is a charset of the client CompID, it's a dir of the session store as well.
+var fixCompIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ParseFIXClients parses the clients like A:account1:account2,B - CompIDs separated by commas,
+// every one with the accounts it can trade on separated by colons.
+func ParseFIXClients(clients string) (map[string][]AccountID, error) {
+	res := map[string][]AccountID{}
+	for _, client := range strings.Split(clients, ",") {
+		parts := strings.Split(client, ":")
+		if !fixCompIDPattern.MatchString(parts[0]) {
+			return nil, fmt.Errorf("wrong FIX client: %q, it should be like A:account1:account2", client)
+		}
+
+		var accounts []AccountID
+		for _, account := range parts[1:] {
+			if account == "" {
+				return nil, fmt.Errorf("wrong FIX client: %q, it has an empty account", client)
+			}
+			accounts = append(accounts, AccountID(account))
+		}
+		res[parts[0]] = accounts
+	}
+
+	return res, nil
+}
+
+// FIXAcceptor is a FIX 4.4 order-entry gateway: clients log on over TCP, place orders by NewOrderSingle,
+// cancel them by OrderCancelRequest, replace them by OrderCancelReplaceRequest and get ExecutionReports.
+// Fills of the resting orders are taken from the market data feeds of the order books, so every book
+// of the exchange must have MarketData.
+//
+// Sequence numbers and sent messages are persisted by the session stores, so the sessions continue after restart
+// and the messages are resent by ResendRequest. Orders are identified by ClOrdID of the session,
+// they aren't persisted, so a restart loses the states of the orders which were placed before.
+type FIXAcceptor struct {
+	cfg      FIXConfig
+	exchange *Exchange
+	sessions map[string]*fixSession
+
+	mx sync.Mutex
+}
+
+// NewFIXAcceptor creates a new instance of FIXAcceptor.
+func NewFIXAcceptor(exchange *Exchange, cfg FIXConfig) (*FIXAcceptor, error) {
+	if cfg.CompID == "" {
+		return nil, errors.New("FIX CompID is required")
+	}
+	if len(cfg.Clients) == 0 {
+		return nil, errors.New("FIX clients are required")
+	}
+	for compID := range cfg.Clients {
+		if !fixCompIDPattern.MatchString(compID) {
+			return nil, fmt.Errorf("wrong FIX client CompID: %q, it should be like %s", compID, fixCompIDPattern)
+		}
+	}
+
+	for _, instrument := range exchange.Instruments() {
+		ob, _ := exchange.Book(instrument)
+		if ob.MarketData == nil {
+			return nil, fmt.Errorf("order book: %s has no market data feed", instrument)
+		}
+	}
+
+	return &FIXAcceptor{
+		cfg:      cfg,
+		exchange: exchange,
+		sessions: map[string]*fixSession{},
+		mx:       sync.Mutex{},
+	}, nil
+}
+
+// Serve accepts the connections till the context is canceled.
+func (a *FIXAcceptor) Serve(ctx context.Context, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	defer a.closeStores()
+	defer wg.Wait()
+
+	for _, instrument := range a.exchange.Instruments() {
+		ob, _ := a.exchange.Book(instrument)
+		sub, err := ob.SubscribeMarketData(0)
+		if err != nil {
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.routeFills(ctx, sub)
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("can't accept FIX connection: %w", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.handle(ctx, conn)
+		}()
+	}
+}
+
+// closeStores closes the stores of the sessions.
+func (a *FIXAcceptor) closeStores() {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	for compID, s := range a.sessions {
+		_ = s.store.Close()
+		delete(a.sessions, compID)
+	}
+}
+
+// routeFills reports the fills of the resting orders to their sessions.
+func (a *FIXAcceptor) routeFills(ctx context.Context, sub *MarketDataSubscription) {
+	defer sub.Close()
+
+	for {
+		select {
+		case m := <-sub.Messages():
+			switch m := m.(type) {
+			case *TradeUpdate:
+				if s := a.orderSession(m.ExecutorOrderID); s != nil {
+					s.reportFills(ctx, m.ExecutorOrderID)
+				}
+			case *DepthSnapshot:
+				// it's the snapshot after the lag, some fills may be missed.
+				for _, s := range a.allSessions() {
+					s.reportAllFills(ctx)
+				}
+			}
+
+			if len(sub.Messages()) == 0 && sub.Lagging() {
+				_ = sub.Resync()
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// orderSession returns the session of the order by the prefix of the order id.
+func (a *FIXAcceptor) orderSession(orderID OrderID) *fixSession {
+	i := strings.IndexByte(string(orderID), '/')
+	if i < 0 {
+		return nil
+	}
+
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	return a.sessions[string(orderID)[:i]]
+}
+
+// allSessions returns the sessions.
+func (a *FIXAcceptor) allSessions() []*fixSession {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	sessions := make([]*fixSession, 0, len(a.sessions))
+	for _, s := range a.sessions {
+		sessions = append(sessions, s)
+	}
+
+	return sessions
+}
+
+// session returns the session of the client, its store is opened on the first logon.
+func (a *FIXAcceptor) session(compID string) (*fixSession, error) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	if s, ok := a.sessions[compID]; ok {
+		return s, nil
+	}
+
+	store, err := openFIXStore(filepath.Join(a.cfg.StoreDir, compID))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fixSession{
+		acceptor: a,
+		compID:   compID,
+		store:    store,
+		orders:   map[OrderID]*fixOrder{},
+		clOrdIDs: map[string]*fixOrder{},
+		mx:       sync.Mutex{},
+	}
+	a.sessions[compID] = s
+
+	return s, nil
+}
+
+// allowed returns true if the client can log on.
+func (a *FIXAcceptor) allowed(compID string) bool {
+	if !fixCompIDPattern.MatchString(compID) {
+		return false
+	}
+
+	_, ok := a.cfg.Clients[compID]
+
+	return ok
+}
+
+// accounts returns the accounts the client can trade on, the first one is the default one.
+func (a *FIXAcceptor) accounts(compID string) []AccountID {
+	if accounts := a.cfg.Clients[compID]; len(accounts) > 0 {
+		return accounts
+	}
+
+	return []AccountID{AccountID(compID)}
+}
+
+// containsAccount returns true if the account is in the accounts.
+func containsAccount(accounts []AccountID, account AccountID) bool {
+	for _, a := range accounts {
+		if a == account {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handle runs the connection: the first message must be Logon, then the messages are processed till Logout.
+func (a *FIXAcceptor) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	closed := make(chan struct{})
+	defer close(closed)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-closed:
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(fixLogonTimeout))
+
+	logon, err := readFIXMessage(r)
+	if err != nil || logon.MsgType != fixLogon {
+		return
+	}
+
+	compID := logon.Value(tagSenderCompID)
+	heartBtInt, err := logon.Uint(tagHeartBtInt)
+	if err != nil || heartBtInt == 0 || heartBtInt > fixMaxHeartBtInt ||
+		logon.Value(tagTargetCompID) != a.cfg.CompID || !a.allowed(compID) {
+		return
+	}
+
+	s, err := a.session(compID)
+	if err != nil {
+		return
+	}
+
+	err = s.logon(conn, logon, time.Duration(heartBtInt)*time.Second)
+	if err != nil {
+		return
+	}
+	defer s.disconnect(conn)
+
+	done := make(chan struct{})
+	defer close(done)
+	go s.heartbeats(conn, done)
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(s.heartBtInt + s.heartBtInt/5))
+
+		m, err := readFIXMessage(r)
+		if errors.Is(err, ErrFIXGarbled) {
+			continue
+		}
+
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			// nothing is received in the heartbeat interval - let's check that the client is alive.
+			if !s.testRequest() {
+				return
+			}
+
+			continue
+		}
+		if err != nil || ctx.Err() != nil {
+			return
+		}
+
+		if !s.receive(ctx, m) {
+			return
+		}
+	}
+}
+
+// fixOrder is a state of the order of the FIX session.
+type fixOrder struct {
+	orderID     OrderID
+	clOrdID     string
+	origClOrdID string
+	ob          *OrderBook
+	account     AccountID
+	side        OperationType
+	ordType     string
+	price       *apd.Decimal
+	qty         *apd.Decimal
+	cum         *apd.Decimal
+	notional    *apd.Decimal
+	open        bool
+	// reported is a number of the executions of the order which are reported.
+	reported int
+	// execs is a number of the ExecutionReports of the order, it makes ExecID.
+	execs int
+}
+
+// fixSession is a FIX session of the client, it lives longer than the connection.
+type fixSession struct {
+	acceptor *FIXAcceptor
+	compID   string
+	store    *fixStore
+	orders   map[OrderID]*fixOrder
+	clOrdIDs map[string]*fixOrder
+
+	// conn is nil when the client isn't logged on, the messages are stored to be resent.
+	conn       net.Conn
+	heartBtInt time.Duration
+	lastSent   time.Time
+	// testRequestSent is true when TestRequest is sent and nothing is received after it.
+	testRequestSent bool
+	// resendRequested is a sequence number which is expected after the gap, 0 - there is no gap.
+	resendRequested uint64
+
+	mx sync.Mutex
+}
+
+// logon checks the sequence number of the Logon and attaches the connection.
+func (s *fixSession) logon(conn net.Conn, logon *FIXMessage, heartBtInt time.Duration) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.conn != nil {
+		return errors.New("session is logged on already")
+	}
+
+	seq, err := logon.Uint(tagMsgSeqNum)
+	if err != nil {
+		return err
+	}
+
+	reset := logon.Value(tagResetSeqNumFlag) == "Y"
+	if reset {
+		err = s.store.reset()
+		if err != nil {
+			return err
+		}
+	}
+
+	s.conn = conn
+	s.heartBtInt = heartBtInt
+	s.testRequestSent = false
+	s.resendRequested = 0
+
+	if seq < s.store.nextTargetSeq {
+		s.sendLocked(newFIXMessage(fixLogout,
+			tagText, fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", s.store.nextTargetSeq, seq),
+		))
+		s.conn = nil
+
+		return errors.New("sequence number is too low")
+	}
+
+	resp := newFIXMessage(fixLogon, tagEncryptMethod, 0, tagHeartBtInt, int(heartBtInt/time.Second))
+	if reset {
+		resp.Set(tagResetSeqNumFlag, "Y")
+	}
+	s.sendLocked(resp)
+
+	if seq > s.store.nextTargetSeq {
+		s.requestResend()
+		return nil
+	}
+
+	return s.store.setNextTargetSeq(seq + 1)
+}
+
+// disconnect detaches the connection.
+func (s *fixSession) disconnect(conn net.Conn) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.conn == conn {
+		s.conn = nil
+	}
+}
+
+// heartbeats sends Heartbeat when nothing is sent in the heartbeat interval.
+func (s *fixSession) heartbeats(conn net.Conn, done <-chan struct{}) {
+	s.mx.Lock()
+	interval := s.heartBtInt
+	s.mx.Unlock()
+
+	ticker := time.NewTicker(interval / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mx.Lock()
+			if s.conn == conn && time.Since(s.lastSent) >= interval {
+				s.sendLocked(newFIXMessage(fixHeartbeat))
+			}
+			s.mx.Unlock()
+
+		case <-done:
+			return
+		}
+	}
+}
+
+// testRequest sends TestRequest, it returns false if it was sent already and the client didn't answer.
+func (s *fixSession) testRequest() bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.testRequestSent {
+		s.sendLocked(newFIXMessage(fixLogout, tagText, "heartbeat timeout"))
+		return false
+	}
+
+	s.testRequestSent = true
+	s.sendLocked(newFIXMessage(fixTestRequest, tagTestReqID, fixTime(time.Now())))
+
+	return true
+}
+
+// sendLocked sends the message with the next sequence number and stores it for resend,
+// the message is only stored if the client isn't logged on.
+func (s *fixSession) sendLocked(m *FIXMessage) {
+	seq := s.store.nextSenderSeq
+	m.Set(tagSenderCompID, s.acceptor.cfg.CompID)
+	m.Set(tagTargetCompID, s.compID)
+	m.Set(tagMsgSeqNum, strconv.FormatUint(seq, 10))
+	m.Set(tagSendingTime, fixTime(time.Now()))
+
+	data := m.Encode()
+	err := s.store.saveMessage(seq, data)
+	if err != nil {
+		// the message can't be resent - the client must not get it with this sequence number.
+		s.closeLocked()
+		return
+	}
+
+	s.write(data)
+}
+
+// write writes the message to the connection.
+func (s *fixSession) write(data []byte) {
+	if s.conn == nil {
+		return
+	}
+
+	_ = s.conn.SetWriteDeadline(time.Now().Add(s.heartBtInt))
+	_, err := s.conn.Write(data)
+	if err != nil {
+		s.closeLocked()
+		return
+	}
+
+	s.lastSent = time.Now()
+}
+
+// closeLocked closes the connection.
+func (s *fixSession) closeLocked() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// requestResend sends ResendRequest of the messages from the expected sequence number.
+func (s *fixSession) requestResend() {
+	if s.resendRequested != 0 {
+		return
+	}
+
+	s.resendRequested = s.store.nextTargetSeq
+	s.sendLocked(newFIXMessage(fixResendRequest, tagBeginSeqNo, s.store.nextTargetSeq, tagEndSeqNo, 0))
+}
+
+// receive processes the message of the logged on client, it returns false when the connection must be closed.
+func (s *fixSession) receive(ctx context.Context, m *FIXMessage) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if s.conn == nil {
+		return false
+	}
+	s.testRequestSent = false
+
+	seq, err := m.Uint(tagMsgSeqNum)
+	if err != nil || m.Value(tagSenderCompID) != s.compID || m.Value(tagTargetCompID) != s.acceptor.cfg.CompID {
+		s.sendLocked(newFIXMessage(fixLogout, tagText, "wrong header"))
+		s.closeLocked()
+
+		return false
+	}
+
+	possDup := m.Value(tagPossDupFlag) == "Y"
+	expected := s.store.nextTargetSeq
+
+	if m.MsgType == fixSequenceReset {
+		return s.sequenceReset(m, seq, possDup)
+	}
+
+	switch {
+	case seq < expected:
+		if possDup {
+			return true
+		}
+
+		s.sendLocked(newFIXMessage(fixLogout,
+			tagText, fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", expected, seq),
+		))
+		s.closeLocked()
+
+		return false
+
+	case seq > expected:
+		if m.MsgType == fixLogout {
+			s.sendLocked(newFIXMessage(fixLogout))
+			s.closeLocked()
+
+			return false
+		}
+		if m.MsgType == fixResendRequest {
+			s.resend(m)
+		}
+
+		// the gap is filled by the resent messages, this one is resent as well.
+		s.requestResend()
+
+		return true
+	}
+
+	err = s.store.setNextTargetSeq(seq + 1)
+	if err != nil {
+		s.closeLocked()
+		return false
+	}
+	if s.resendRequested != 0 && seq+1 >= s.resendRequested {
+		s.resendRequested = 0
+	}
+
+	switch m.MsgType {
+	case fixHeartbeat, fixReject:
+
+	case fixTestRequest:
+		s.sendLocked(newFIXMessage(fixHeartbeat, tagTestReqID, m.Value(tagTestReqID)))
+
+	case fixResendRequest:
+		s.resend(m)
+
+	case fixLogout:
+		s.sendLocked(newFIXMessage(fixLogout))
+		s.closeLocked()
+
+		return false
+
+	case fixNewOrderSingle:
+		s.newOrderSingle(ctx, m)
+
+	case fixOrderCancelRequest:
+		s.cancelOrder(ctx, m)
+
+	case fixOrderCancelReplaceRequest:
+		s.replaceOrder(ctx, m)
+
+	default:
+		// 11 - invalid MsgType.
+		s.sendLocked(newFIXMessage(fixReject,
+			tagRefSeqNum, seq, tagSessionRejectReason, 11, tagText, "unsupported MsgType: "+m.MsgType,
+		))
+	}
+
+	return true
+}
+
+// sequenceReset moves the expected sequence number forward, by the gap fill or by the reset.
+func (s *fixSession) sequenceReset(m *FIXMessage, seq uint64, possDup bool) bool {
+	newSeq, err := m.Uint(tagNewSeqNo)
+	if err != nil {
+		s.sendLocked(newFIXMessage(fixReject,
+			tagRefSeqNum, seq, tagRefTagID, tagNewSeqNo, tagSessionRejectReason, 1, tagText, err.Error(),
+		))
+		return true
+	}
+
+	gapFill := m.Value(tagGapFillFlag) == "Y"
+	if gapFill && seq != s.store.nextTargetSeq {
+		if seq > s.store.nextTargetSeq {
+			s.requestResend()
+		}
+
+		return true
+	}
+
+	if newSeq < s.store.nextTargetSeq {
+		if gapFill && possDup {
+			return true
+		}
+
+		// 5 - value is incorrect for this tag.
+		s.sendLocked(newFIXMessage(fixReject,
+			tagRefSeqNum, seq, tagRefTagID, tagNewSeqNo, tagSessionRejectReason, 5, tagText, "NewSeqNo is too low",
+		))
+
+		return true
+	}
+
+	err = s.store.setNextTargetSeq(newSeq)
+	if err != nil {
+		s.closeLocked()
+		return false
+	}
+	if s.resendRequested != 0 && newSeq >= s.resendRequested {
+		s.resendRequested = 0
+	}
+
+	return true
+}
+
+// resend resends the stored messages by ResendRequest: the session messages are replaced by SequenceReset-GapFill,
+// the application messages are resent with PossDupFlag.
+func (s *fixSession) resend(m *FIXMessage) {
+	begin, err := m.Uint(tagBeginSeqNo)
+	if err != nil {
+		return
+	}
+
+	last := s.store.nextSenderSeq - 1
+	end, err := m.Uint(tagEndSeqNo)
+	if err != nil || end == 0 || end > last {
+		end = last
+	}
+	if begin == 0 || begin > end {
+		return
+	}
+
+	gapFrom := uint64(0)
+	gapFill := func(to uint64) {
+		if gapFrom == 0 {
+			return
+		}
+
+		gf := newFIXMessage(fixSequenceReset, tagGapFillFlag, "Y", tagNewSeqNo, to)
+		s.writeResent(gf, gapFrom, "")
+		gapFrom = 0
+	}
+
+	for seq := begin; seq <= end; seq++ {
+		msg, ok, err := s.store.message(seq)
+		if err != nil || !ok || msg.isAdmin() {
+			if gapFrom == 0 {
+				gapFrom = seq
+			}
+
+			continue
+		}
+
+		gapFill(seq)
+		s.writeResent(msg, seq, msg.Value(tagSendingTime))
+	}
+	gapFill(end + 1)
+}
+
+// writeResent writes the message with the sequence number of the original one, it isn't stored again.
+func (s *fixSession) writeResent(m *FIXMessage, seq uint64, origSendingTime string) {
+	m.Set(tagSenderCompID, s.acceptor.cfg.CompID)
+	m.Set(tagTargetCompID, s.compID)
+	m.Set(tagMsgSeqNum, strconv.FormatUint(seq, 10))
+	m.Set(tagSendingTime, fixTime(time.Now()))
+	m.Set(tagPossDupFlag, "Y")
+	m.Set(tagOrigSendingTime, origSendingTime)
+
+	s.write(m.Encode())
+}
+
+// reject sends the session Reject of the message without the required tag.
+func (s *fixSession) rejectMissingTag(m *FIXMessage, tag int) {
+	// 1 - required tag missing.
+	s.sendLocked(newFIXMessage(fixReject,
+		tagRefSeqNum, m.Value(tagMsgSeqNum), tagRefTagID, tag, tagSessionRejectReason, 1,
+		tagText, fmt.Sprintf("tag %d is required", tag),
+	))
+}
+
+// parseFIXSide parses the side: 1 - buy, 2 - sell.
+func parseFIXSide(s string) (OperationType, error) {
+	switch s {
+	case fixSideBuy:
+		return Bid, nil
+	case fixSideSell:
+		return Ask, nil
+	default:
+		return 0, fmt.Errorf("unsupported side: %q", s)
+	}
+}
+
+// fixSide returns the FIX side.
+func fixSide(side OperationType) string {
+	if side == Bid {
+		return fixSideBuy
+	}
+
+	return fixSideSell
+}
+
+// newOrderSingle places the order, ExecutionReport New is sent and then Trade for every execution.
+func (s *fixSession) newOrderSingle(ctx context.Context, m *FIXMessage) {
+	clOrdID := m.Value(tagClOrdID)
+	if clOrdID == "" {
+		s.rejectMissingTag(m, tagClOrdID)
+		return
+	}
+
+	o := &fixOrder{
+		orderID:  OrderID(s.compID + "/" + clOrdID),
+		clOrdID:  clOrdID,
+		account:  AccountID(m.Value(tagAccount)),
+		ordType:  m.Value(tagOrdType),
+		cum:      apd.New(0, 0),
+		notional: apd.New(0, 0),
+	}
+	accounts := s.acceptor.accounts(s.compID)
+	if o.account == "" {
+		o.account = accounts[0]
+	}
+
+	reject := func(reason int, err error) {
+		if o.price == nil {
+			o.price = apd.New(0, 0)
+		}
+		if o.qty == nil {
+			o.qty = apd.New(0, 0)
+		}
+
+		er := s.executionReport(o, fixExecRejected, fixStatusRejected, nil)
+		er.Set(tagOrdRejReason, strconv.Itoa(reason))
+		er.Set(tagText, err.Error())
+		s.sendLocked(er)
+	}
+
+	if _, ok := s.clOrdIDs[clOrdID]; ok {
+		// 6 - duplicate order.
+		reject(6, fmt.Errorf("ClOrdID: %s is used already", clOrdID))
+		return
+	}
+
+	if !containsAccount(accounts, o.account) {
+		// 99 - other.
+		reject(99, fmt.Errorf("account: %s isn't allowed for %s", o.account, s.compID))
+		return
+	}
+
+	var (
+		ok  bool
+		err error
+	)
+	o.ob, ok = s.acceptor.exchange.Book(m.Value(tagSymbol))
+	if !ok {
+		// 1 - unknown symbol.
+		reject(1, fmt.Errorf("unknown symbol: %q", m.Value(tagSymbol)))
+		return
+	}
+
+	o.side, err = parseFIXSide(m.Value(tagSide))
+	if err != nil {
+		reject(99, err)
+		return
+	}
+
+	o.qty, err = parsePositiveDecimal("OrderQty", m.Value(tagOrderQty))
+	if err != nil {
+		reject(99, err)
+		return
+	}
+
+	// market orders need the price as well, it's the worst price to execute.
+	o.price, err = parsePositiveDecimal("Price", m.Value(tagPrice))
+	if err != nil {
+		reject(99, err)
+		return
+	}
+
+	ct := CommandPlaceLimit
+	switch o.ordType {
+	case fixOrdTypeLimit:
+	case fixOrdTypeMarket:
+		ct = CommandPlaceMarket
+	default:
+		reject(99, fmt.Errorf("unsupported OrdType: %q", o.ordType))
+		return
+	}
+
+	s.clOrdIDs[clOrdID] = o
+
+	res, err := o.ob.Apply(ctx, Command{
+		Type:      ct,
+		OrderID:   o.orderID,
+		AccountID: o.account,
+		Side:      o.side,
+		Price:     o.price,
+		Amount:    apd.New(0, 0).Set(o.qty),
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		// 3 - order exceeds limit.
+		reason := 99
+		var re *RiskError
+		if errors.As(err, &re) || errors.Is(err, ErrInsufficientFunds) {
+			reason = 3
+		}
+
+		reject(reason, err)
+		return
+	}
+
+	o.open = ct == CommandPlaceLimit
+	s.orders[o.orderID] = o
+	s.sendLocked(s.executionReport(o, fixExecNew, fixStatusNew, nil))
+
+	s.reportExecutions(o, trades(res.Executions))
+
+	if ct == CommandPlaceMarket && o.cum.Cmp(o.qty) < 0 {
+		// the market order isn't resting in the order book - the rest is canceled.
+		er := s.executionReport(o, fixExecCanceled, fixStatusCanceled, nil)
+		er.Set(tagText, "no more liquidity")
+		s.sendLocked(er)
+	}
+}
+
+// reportExecutions sends ExecutionReport Trade for the executions which aren't reported yet.
+func (s *fixSession) reportExecutions(o *fixOrder, executions []Trade) {
+	if len(executions) < o.reported {
+		// the order was placed again by the rollback of the counterparty.
+		o.reported = len(executions)
+	}
+
+	for _, e := range executions[o.reported:] {
+		_, _ = apd.BaseContext.Add(o.cum, o.cum, e.Amount)
+
+		notional := apd.New(0, 0)
+		_, _ = apd.BaseContext.Mul(notional, e.Price, e.Amount)
+		_, _ = apd.BaseContext.Add(o.notional, o.notional, notional)
+
+		status := fixStatusPartiallyFilled
+		if o.cum.Cmp(o.qty) >= 0 {
+			status = fixStatusFilled
+			o.open = false
+		}
+
+		s.sendLocked(s.executionReport(o, fixExecTrade, status, &e))
+	}
+	o.reported = len(executions)
+}
+
+// reportFills reports the executions of the resting order.
+func (s *fixSession) reportFills(ctx context.Context, orderID OrderID) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	o, ok := s.orders[orderID]
+	if !ok {
+		return
+	}
+
+	s.reportOrderFills(ctx, o)
+}
+
+// reportAllFills reports the executions of all open orders.
+func (s *fixSession) reportAllFills(ctx context.Context) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for _, o := range s.orders {
+		if o.open {
+			s.reportOrderFills(ctx, o)
+		}
+	}
+}
+
+// reportOrderFills reports the executions of the order by its status in the order book.
+func (s *fixSession) reportOrderFills(ctx context.Context, o *fixOrder) {
+	status, err := o.ob.OrderStatus(ctx, o.orderID)
+	if err != nil {
+		return
+	}
+
+	s.reportExecutions(o, status.Trades)
+}
+
+// originalOrder returns the open order by OrigClOrdID of the cancel or replace request,
+// if it isn't found - OrderCancelReject is sent.
+func (s *fixSession) originalOrder(ctx context.Context, m *FIXMessage, responseTo string) (*fixOrder, bool) {
+	clOrdID, origClOrdID := m.Value(tagClOrdID), m.Value(tagOrigClOrdID)
+	if clOrdID == "" {
+		s.rejectMissingTag(m, tagClOrdID)
+		return nil, false
+	}
+	if origClOrdID == "" {
+		s.rejectMissingTag(m, tagOrigClOrdID)
+		return nil, false
+	}
+
+	cancelReject := func(reason int, status, text string) {
+		s.sendLocked(newFIXMessage(fixOrderCancelReject,
+			tagOrderID, "NONE", tagClOrdID, clOrdID, tagOrigClOrdID, origClOrdID, tagOrdStatus, status,
+			tagCxlRejResponseTo, responseTo, tagCxlRejReason, reason, tagText, text,
+		))
+	}
+
+	if _, ok := s.clOrdIDs[clOrdID]; ok {
+		// 6 - duplicate ClOrdID.
+		cancelReject(6, fixStatusRejected, fmt.Sprintf("ClOrdID: %s is used already", clOrdID))
+		return nil, false
+	}
+
+	o, ok := s.clOrdIDs[origClOrdID]
+	if ok && o.open {
+		// the fills before the cancel are reported before it.
+		s.reportOrderFills(ctx, o)
+	}
+	if !ok || !o.open {
+		// 1 - unknown order.
+		status := fixStatusRejected
+		if ok {
+			status = o.status()
+		}
+		cancelReject(1, status, fmt.Sprintf("order: %s is not open", origClOrdID))
+
+		return nil, false
+	}
+
+	return o, true
+}
+
+// cancelOrder cancels the order, ExecutionReport Canceled is sent with the ClOrdID of the request.
+func (s *fixSession) cancelOrder(ctx context.Context, m *FIXMessage) {
+	o, ok := s.originalOrder(ctx, m, "1")
+	if !ok {
+		return
+	}
+
+	_, err := o.ob.Apply(ctx, Command{Type: CommandCancel, OrderID: o.orderID})
+	if err != nil {
+		// 0 - too late to cancel.
+		s.sendLocked(newFIXMessage(fixOrderCancelReject,
+			tagOrderID, o.orderID, tagClOrdID, m.Value(tagClOrdID), tagOrigClOrdID, o.clOrdID,
+			tagOrdStatus, o.status(), tagCxlRejResponseTo, "1", tagCxlRejReason, 0, tagText, err.Error(),
+		))
+		return
+	}
+
+	o.open = false
+	o.origClOrdID, o.clOrdID = o.clOrdID, m.Value(tagClOrdID)
+	s.clOrdIDs[o.clOrdID] = o
+
+	s.sendLocked(s.executionReport(o, fixExecCanceled, fixStatusCanceled, nil))
+}
+
+// replaceOrder replaces the price and the quantity of the limit order by one command of the order book: the order
+// is canceled and the new one is placed with the ClOrdID of the request, so it loses its place in the queue.
+// OrderQty is the total quantity, the executed quantity of the original order is counted.
+func (s *fixSession) replaceOrder(ctx context.Context, m *FIXMessage) {
+	o, ok := s.originalOrder(ctx, m, "2")
+	if !ok {
+		return
+	}
+
+	clOrdID := m.Value(tagClOrdID)
+	cancelReject := func(err error) {
+		// 99 - other.
+		s.sendLocked(newFIXMessage(fixOrderCancelReject,
+			tagOrderID, o.orderID, tagClOrdID, clOrdID, tagOrigClOrdID, o.clOrdID,
+			tagOrdStatus, o.status(), tagCxlRejResponseTo, "2", tagCxlRejReason, 99, tagText, err.Error(),
+		))
+	}
+
+	if o.ordType != fixOrdTypeLimit || (m.Value(tagOrdType) != "" && m.Value(tagOrdType) != fixOrdTypeLimit) {
+		cancelReject(errors.New("only limit orders can be replaced"))
+		return
+	}
+	if side, err := parseFIXSide(m.Value(tagSide)); err != nil || side != o.side {
+		cancelReject(errors.New("side can't be changed"))
+		return
+	}
+
+	qty, err := parsePositiveDecimal("OrderQty", m.Value(tagOrderQty))
+	if err != nil {
+		cancelReject(err)
+		return
+	}
+	price, err := parsePositiveDecimal("Price", m.Value(tagPrice))
+	if err != nil {
+		cancelReject(err)
+		return
+	}
+
+	leaves := apd.New(0, 0)
+	_, _ = apd.BaseContext.Sub(leaves, qty, o.cum)
+	if leaves.Sign() <= 0 {
+		cancelReject(fmt.Errorf("OrderQty: %s must be greater than executed: %s", qty, o.cum))
+		return
+	}
+
+	// the order is replaced by one command of the order book, so the original order stays open
+	// if the new one is rejected by the funds, the risk limits or the tick.
+	res, err := o.ob.Apply(ctx, Command{
+		Type:            CommandReplace,
+		OrderID:         OrderID(s.compID + "/" + clOrdID),
+		ReplacedOrderID: o.orderID,
+		AccountID:       o.account,
+		Side:            o.side,
+		Price:           price,
+		Amount:          leaves,
+		CreatedAt:       time.Now(),
+	})
+	if err != nil {
+		cancelReject(err)
+		return
+	}
+	o.open = false
+
+	replaced := &fixOrder{
+		orderID:     OrderID(s.compID + "/" + clOrdID),
+		clOrdID:     clOrdID,
+		origClOrdID: o.clOrdID,
+		ob:          o.ob,
+		account:     o.account,
+		side:        o.side,
+		ordType:     o.ordType,
+		price:       price,
+		qty:         qty,
+		cum:         apd.New(0, 0).Set(o.cum),
+		notional:    apd.New(0, 0).Set(o.notional),
+		open:        true,
+	}
+	s.clOrdIDs[clOrdID] = replaced
+	s.orders[replaced.orderID] = replaced
+	s.sendLocked(s.executionReport(replaced, fixExecReplaced, replaced.status(), nil))
+	s.reportExecutions(replaced, trades(res.Executions))
+}
+
+// status returns OrdStatus of the order.
+func (o *fixOrder) status() string {
+	switch {
+	case o.cum.Cmp(o.qty) >= 0 && o.qty.Sign() > 0:
+		return fixStatusFilled
+	case !o.open:
+		return fixStatusCanceled
+	case o.cum.Sign() > 0:
+		return fixStatusPartiallyFilled
+	default:
+		return fixStatusNew
+	}
+}
+
+// executionReport builds ExecutionReport of the order, the trade is set for ExecType Trade.
+func (s *fixSession) executionReport(o *fixOrder, execType, ordStatus string, trade *Trade) *FIXMessage {
+	o.execs++
+
+	leaves := apd.New(0, 0)
+	if o.open {
+		_, _ = apd.BaseContext.Sub(leaves, o.qty, o.cum)
+	}
+
+	avgPx := apd.New(0, 0)
+	if o.cum.Sign() > 0 {
+		_, _ = apd.BaseContext.WithPrecision(34).Quo(avgPx, o.notional, o.cum)
+		avgPx.Reduce(avgPx)
+	}
+
+	side := fixSide(o.side)
+	if o.side != Ask && o.side != Bid {
+		side = ""
+	}
+
+	m := newFIXMessage(fixExecutionReport,
+		tagOrderID, o.orderID,
+		tagClOrdID, o.clOrdID,
+		tagOrigClOrdID, o.origClOrdID,
+		tagExecID, fmt.Sprintf("%s-%d", o.orderID, o.execs),
+		tagExecType, execType,
+		tagOrdStatus, ordStatus,
+		tagAccount, o.account,
+		tagSymbol, bookInstrument(o.ob),
+		tagSide, side,
+		tagOrdType, o.ordType,
+		tagOrderQty, o.qty,
+		tagPrice, o.price,
+	)
+	if trade != nil {
+		m.Set(tagLastQty, trade.Amount.String())
+		m.Set(tagLastPx, trade.Price.String())
+	}
+	m.Set(tagLeavesQty, leaves.String())
+	m.Set(tagCumQty, o.cum.String())
+	m.Set(tagAvgPx, avgPx.Text('f'))
+	m.Set(tagTransactTime, fixTime(time.Now()))
+
+	return m
+}
+
+// bookInstrument returns the instrument of the order book, the empty string for nil.
+func bookInstrument(ob *OrderBook) string {
+	if ob == nil {
+		return ""
+	}
+
+	return ob.Instrument()
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type testFIXClient struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *bufio.Reader
+	compID string
+	seq    uint64
+}
+
+func testFIXAcceptor(t *testing.T, exchange *Exchange, dir string) (string, func()) {
+	t.Helper()
+
+	acceptor, err := NewFIXAcceptor(exchange, FIXConfig{CompID: "ME", StoreDir: dir, Clients: map[string][]AccountID{
+		"A": nil,
+		"B": {"B", "b-account"},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := acceptor.Serve(ctx, l)
+		if err != nil {
+			t.Errorf("unexpected err: %v", err)
+		}
+	}()
+
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+
+	return l.Addr().String(), stop
+}
+
+func dialFIX(t *testing.T, addr, compID string, seq uint64) *testFIXClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return &testFIXClient{t: t, conn: conn, r: bufio.NewReader(conn), compID: compID, seq: seq}
+}
+
+func (c *testFIXClient) send(msgType string, tagValues ...interface{}) {
+	c.t.Helper()
+
+	c.seq++
+	c.sendSeq(c.seq, msgType, tagValues...)
+}
+
+func (c *testFIXClient) sendSeq(seq uint64, msgType string, tagValues ...interface{}) {
+	c.t.Helper()
+
+	m := newFIXMessage(msgType, tagValues...)
+	m.Set(tagSenderCompID, c.compID)
+	m.Set(tagTargetCompID, "ME")
+	m.Set(tagMsgSeqNum, strconv.FormatUint(seq, 10))
+	m.Set(tagSendingTime, fixTime(time.Now()))
+
+	_, err := c.conn.Write(m.Encode())
+	if err != nil {
+		c.t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+// expect reads the next message and checks its type and the fields.
+func (c *testFIXClient) expect(msgType string, fields map[int]string) *FIXMessage {
+	c.t.Helper()
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	m, err := readFIXMessage(c.r)
+	if err != nil {
+		c.t.Fatalf("unexpected err: %v", err)
+	}
+
+	if m.MsgType != msgType {
+		c.t.Fatalf("expected MsgType %s, but got: %s", msgType, m)
+	}
+	for tag, v := range fields {
+		if m.Value(tag) != v {
+			c.t.Fatalf("expected %d=%s, but got: %s", tag, v, m)
+		}
+	}
+
+	return m
+}
+
+func (c *testFIXClient) logon(fields map[int]string) {
+	c.t.Helper()
+
+	c.send(fixLogon, tagEncryptMethod, 0, tagHeartBtInt, 30)
+	c.expect(fixLogon, fields)
+}
+
+func testFIXExchange(t *testing.T) *Exchange {
+	t.Helper()
+
+	ob := NewOrderBook("BTC", "USDT")
+	ob.MarketData = NewMarketDataFeed()
+
+	exchange := NewExchange()
+	err := exchange.AddBook(ob)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	return exchange
+}
+
+func Test_FIXAcceptorOrders(t *testing.T) {
+	addr, _ := testFIXAcceptor(t, testFIXExchange(t), t.TempDir())
+
+	a := dialFIX(t, addr, "A", 0)
+	a.logon(map[int]string{tagMsgSeqNum: "1", tagTargetCompID: "A"})
+	b := dialFIX(t, addr, "B", 0)
+	b.logon(nil)
+
+	a.send(fixNewOrderSingle, tagClOrdID, "a1", tagSymbol, "BTC-USDT", tagSide, fixSideSell,
+		tagOrderQty, "2", tagOrdType, fixOrdTypeLimit, tagPrice, "100")
+	a.expect(fixExecutionReport, map[int]string{
+		tagOrderID: "A/a1", tagClOrdID: "a1", tagExecType: fixExecNew, tagOrdStatus: fixStatusNew,
+		tagAccount: "A", tagLeavesQty: "2", tagCumQty: "0",
+	})
+
+	b.send(fixNewOrderSingle, tagClOrdID, "b1", tagAccount, "b-account", tagSymbol, "BTC-USDT",
+		tagSide, fixSideBuy, tagOrderQty, "1", tagOrdType, fixOrdTypeLimit, tagPrice, "110")
+	b.expect(fixExecutionReport, map[int]string{tagExecType: fixExecNew, tagAccount: "b-account"})
+	b.expect(fixExecutionReport, map[int]string{
+		tagExecType: fixExecTrade, tagOrdStatus: fixStatusFilled, tagLastQty: "1", tagLastPx: "100",
+		tagLeavesQty: "0", tagCumQty: "1", tagAvgPx: "100",
+	})
+
+	// the fill of the resting order is reported to its session.
+	a.expect(fixExecutionReport, map[int]string{
+		tagClOrdID: "a1", tagExecType: fixExecTrade, tagOrdStatus: fixStatusPartiallyFilled,
+		tagLastQty: "1", tagLeavesQty: "1", tagCumQty: "1",
+	})
+
+	a.send(fixOrderCancelRequest, tagClOrdID, "a2", tagOrigClOrdID, "a1", tagSymbol, "BTC-USDT", tagSide, fixSideSell)
+	a.expect(fixExecutionReport, map[int]string{
+		tagOrderID: "A/a1", tagClOrdID: "a2", tagOrigClOrdID: "a1", tagExecType: fixExecCanceled,
+		tagOrdStatus: fixStatusCanceled, tagLeavesQty: "0", tagCumQty: "1",
+	})
+
+	a.send(fixOrderCancelRequest, tagClOrdID, "a3", tagOrigClOrdID, "a1", tagSymbol, "BTC-USDT", tagSide, fixSideSell)
+	a.expect(fixOrderCancelReject, map[int]string{
+		tagClOrdID: "a3", tagOrigClOrdID: "a1", tagCxlRejResponseTo: "1", tagCxlRejReason: "1",
+	})
+
+	a.send(fixNewOrderSingle, tagClOrdID, "a4", tagSymbol, "BTC-USDT", tagSide, fixSideBuy,
+		tagOrderQty, "3", tagOrdType, fixOrdTypeLimit, tagPrice, "90")
+	a.expect(fixExecutionReport, map[int]string{tagExecType: fixExecNew})
+
+	a.send(fixOrderCancelReplaceRequest, tagClOrdID, "a5", tagOrigClOrdID, "a4", tagSymbol, "BTC-USDT",
+		tagSide, fixSideBuy, tagOrderQty, "5", tagOrdType, fixOrdTypeLimit, tagPrice, "95")
+	a.expect(fixExecutionReport, map[int]string{
+		tagOrderID: "A/a5", tagClOrdID: "a5", tagOrigClOrdID: "a4", tagExecType: fixExecReplaced,
+		tagOrdStatus: fixStatusNew, tagPrice: "95", tagLeavesQty: "5",
+	})
+
+	// the market order takes the liquidity by 95 and the rest is canceled.
+	b.send(fixNewOrderSingle, tagClOrdID, "b2", tagSymbol, "BTC-USDT", tagSide, fixSideSell,
+		tagOrderQty, "7", tagOrdType, fixOrdTypeMarket, tagPrice, "1")
+	b.expect(fixExecutionReport, map[int]string{tagExecType: fixExecNew})
+	b.expect(fixExecutionReport, map[int]string{tagExecType: fixExecTrade, tagLastQty: "5", tagLastPx: "95"})
+	b.expect(fixExecutionReport, map[int]string{
+		tagExecType: fixExecCanceled, tagOrdStatus: fixStatusCanceled, tagCumQty: "5",
+	})
+
+	a.expect(fixExecutionReport, map[int]string{
+		tagClOrdID: "a5", tagExecType: fixExecTrade, tagOrdStatus: fixStatusFilled, tagCumQty: "5",
+	})
+
+	b.send(fixNewOrderSingle, tagClOrdID, "b1", tagSymbol, "BTC-USDT", tagSide, fixSideBuy,
+		tagOrderQty, "1", tagOrdType, fixOrdTypeLimit, tagPrice, "100")
+	b.expect(fixExecutionReport, map[int]string{tagExecType: fixExecRejected, tagOrdRejReason: "6"})
+
+	b.send(fixNewOrderSingle, tagClOrdID, "b3", tagSymbol, "ETH-USDT", tagSide, fixSideBuy,
+		tagOrderQty, "1", tagOrdType, fixOrdTypeLimit, tagPrice, "100")
+	b.expect(fixExecutionReport, map[int]string{tagExecType: fixExecRejected, tagOrdRejReason: "1"})
+
+	b.send(fixNewOrderSingle, tagClOrdID, "b4", tagSymbol, "BTC-USDT", tagSide, fixSideBuy,
+		tagOrderQty, "-1", tagOrdType, fixOrdTypeLimit, tagPrice, "100")
+	b.expect(fixExecutionReport, map[int]string{tagExecType: fixExecRejected, tagOrdStatus: fixStatusRejected})
+}
+
+func Test_FIXAcceptorReplaceRejected(t *testing.T) {
+	exchange := testFIXExchange(t)
+	ob, _ := exchange.Book("BTC-USDT")
+	ob.Risk = NewRiskManager(RiskLimits{MaxOrderAmount: decimal(t, "10"), MaxOpenOrders: 1})
+	addr, _ := testFIXAcceptor(t, exchange, t.TempDir())
+
+	a := dialFIX(t, addr, "A", 0)
+	a.logon(nil)
+
+	a.send(fixNewOrderSingle, tagClOrdID, "a1", tagSymbol, "BTC-USDT", tagSide, fixSideBuy,
+		tagOrderQty, "3", tagOrdType, fixOrdTypeLimit, tagPrice, "90")
+	a.expect(fixExecutionReport, map[int]string{tagExecType: fixExecNew})
+
+	// the replaced order isn't counted by the limit of the open orders.
+	a.send(fixOrderCancelReplaceRequest, tagClOrdID, "a2", tagOrigClOrdID, "a1", tagSymbol, "BTC-USDT",
+		tagSide, fixSideBuy, tagOrderQty, "5", tagOrdType, fixOrdTypeLimit, tagPrice, "95")
+	a.expect(fixExecutionReport, map[int]string{tagClOrdID: "a2", tagExecType: fixExecReplaced})
+
+	// the new order is rejected by the risk limits, the original one is still open.
+	a.send(fixOrderCancelReplaceRequest, tagClOrdID, "a3", tagOrigClOrdID, "a2", tagSymbol, "BTC-USDT",
+		tagSide, fixSideBuy, tagOrderQty, "20", tagOrdType, fixOrdTypeLimit, tagPrice, "95")
+	a.expect(fixOrderCancelReject, map[int]string{
+		tagClOrdID: "a3", tagOrigClOrdID: "a2", tagOrdStatus: fixStatusNew, tagCxlRejResponseTo: "2",
+	})
+
+	a.send(fixOrderCancelRequest, tagClOrdID, "a4", tagOrigClOrdID, "a2", tagSymbol, "BTC-USDT", tagSide, fixSideBuy)
+	a.expect(fixExecutionReport, map[int]string{
+		tagOrderID: "A/a2", tagClOrdID: "a4", tagExecType: fixExecCanceled, tagLeavesQty: "0",
+	})
+}
+
+func Test_FIXAcceptorSession(t *testing.T) {
+	exchange := testFIXExchange(t)
+	dir := t.TempDir()
+	addr, stop := testFIXAcceptor(t, exchange, dir)
+
+	a := dialFIX(t, addr, "A", 0)
+	a.logon(nil)
+
+	a.send(fixNewOrderSingle, tagClOrdID, "a1", tagSymbol, "BTC-USDT", tagSide, fixSideSell,
+		tagOrderQty, "1", tagOrdType, fixOrdTypeLimit, tagPrice, "100")
+	a.expect(fixExecutionReport, map[int]string{tagMsgSeqNum: "2", tagExecType: fixExecNew})
+
+	a.send(fixTestRequest, tagTestReqID, "ping")
+	a.expect(fixHeartbeat, map[int]string{tagMsgSeqNum: "3", tagTestReqID: "ping"})
+
+	// the session messages are replaced by the gap fills, the application ones are resent.
+	a.send(fixResendRequest, tagBeginSeqNo, 1, tagEndSeqNo, 0)
+	a.expect(fixSequenceReset, map[int]string{
+		tagMsgSeqNum: "1", tagGapFillFlag: "Y", tagNewSeqNo: "2", tagPossDupFlag: "Y",
+	})
+	m := a.expect(fixExecutionReport, map[int]string{tagMsgSeqNum: "2", tagClOrdID: "a1", tagPossDupFlag: "Y"})
+	if m.Value(tagOrigSendingTime) == "" {
+		t.Fatalf("OrigSendingTime is required: %s", m)
+	}
+	a.expect(fixSequenceReset, map[int]string{tagMsgSeqNum: "3", tagGapFillFlag: "Y", tagNewSeqNo: "4"})
+
+	// the gap is requested to resend and the client fills it.
+	a.sendSeq(a.seq+3, fixHeartbeat)
+	a.expect(fixResendRequest, map[int]string{tagMsgSeqNum: "4", tagBeginSeqNo: strconv.FormatUint(a.seq+1, 10)})
+
+	a.sendSeq(a.seq+1, fixSequenceReset, tagGapFillFlag, "Y", tagNewSeqNo, a.seq+4)
+	a.seq += 3
+	a.send(fixTestRequest, tagTestReqID, "after gap")
+	a.expect(fixHeartbeat, map[int]string{tagMsgSeqNum: "5", tagTestReqID: "after gap"})
+
+	// the duplicate is ignored.
+	a.sendSeq(2, fixTestRequest, tagTestReqID, "dup", tagPossDupFlag, "Y")
+	a.send(fixLogout)
+	a.expect(fixLogout, map[int]string{tagMsgSeqNum: "6"})
+
+	// the sequence numbers continue after the reconnect and the restart.
+	a = dialFIX(t, addr, "A", a.seq)
+	a.logon(map[int]string{tagMsgSeqNum: "7"})
+	a.send(fixLogout)
+	a.expect(fixLogout, map[int]string{tagMsgSeqNum: "8"})
+
+	stop()
+	addr, _ = testFIXAcceptor(t, exchange, dir)
+
+	a = dialFIX(t, addr, "A", a.seq)
+	a.logon(map[int]string{tagMsgSeqNum: "9"})
+
+	a.sendSeq(1, fixTestRequest, tagTestReqID, "too low")
+	a.expect(fixLogout, map[int]string{tagMsgSeqNum: "10"})
+
+	// the reset starts the session from 1.
+	a = dialFIX(t, addr, "A", 0)
+	a.send(fixLogon, tagEncryptMethod, 0, tagHeartBtInt, 30, tagResetSeqNumFlag, "Y")
+	a.expect(fixLogon, map[int]string{tagMsgSeqNum: "1", tagResetSeqNumFlag: "Y"})
+
+	// unknown clients can't log on, the CompID which isn't a name of the dir is rejected as well.
+	for _, compID := range []string{"C", "..", "."} {
+		c := dialFIX(t, addr, compID, 0)
+		c.send(fixLogon, tagEncryptMethod, 0, tagHeartBtInt, 30)
+		_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err := readFIXMessage(c.r)
+		if err == nil {
+			t.Fatalf("expected err of %q, but got nil", compID)
+		}
+	}
+}
+
+func Test_FIXAcceptorAccounts(t *testing.T) {
+	addr, _ := testFIXAcceptor(t, testFIXExchange(t), t.TempDir())
+
+	b := dialFIX(t, addr, "B", 0)
+	b.logon(nil)
+
+	b.send(fixNewOrderSingle, tagClOrdID, "b1", tagSymbol, "BTC-USDT", tagSide, fixSideBuy,
+		tagOrderQty, "1", tagOrdType, fixOrdTypeLimit, tagPrice, "100")
+	b.expect(fixExecutionReport, map[int]string{tagExecType: fixExecNew, tagAccount: "B"})
+
+	// the account which isn't bound to the client is rejected.
+	b.send(fixNewOrderSingle, tagClOrdID, "b2", tagAccount, "A", tagSymbol, "BTC-USDT", tagSide, fixSideBuy,
+		tagOrderQty, "1", tagOrdType, fixOrdTypeLimit, tagPrice, "100")
+	b.expect(fixExecutionReport, map[int]string{tagClOrdID: "b2", tagExecType: fixExecRejected, tagOrdRejReason: "99"})
+
+	for _, cfg := range []FIXConfig{
+		{CompID: "ME"},
+		{CompID: "ME", Clients: map[string][]AccountID{"..": nil}},
+		{CompID: "ME", Clients: map[string][]AccountID{"A/B": nil}},
+	} {
+		_, err := NewFIXAcceptor(testFIXExchange(t), cfg)
+		if err == nil {
+			t.Fatalf("expected err of %+v, but got nil", cfg)
+		}
+	}
+
+	clients, err := ParseFIXClients("A:a1:a2,B")
+	if err != nil || len(clients) != 2 || len(clients["A"]) != 2 || clients["A"][1] != "a2" || len(clients["B"]) != 0 {
+		t.Fatalf("unexpected clients: %v, %v", clients, err)
+	}
+	for _, wrong := range []string{"", "..:a1", "A:"} {
+		_, err = ParseFIXClients(wrong)
+		if err == nil {
+			t.Fatalf("expected err of %q, but got nil", wrong)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/cockroachdb/apd"
+)
+
+// maxFixedDecimals is a max number of the decimals of the fixed-point order book, 10^18 fits int64.
+const maxFixedDecimals = 18
+
+// FixedPoint is a fixed-point mode of the order book: the prices and the amounts are int64 scaled
+// by the decimals of the instrument, so the matching compares and sums the integers instead of the decimals.
+// The decimals are converted exactly at the boundary: the order with more decimals is rejected.
+type FixedPoint struct {
+	PriceDecimals  int32
+	AmountDecimals int32
+}
+
+// priceKey is a key of the price level: the decimal price or the fixed-point price.
+type priceKey struct {
+	d *apd.Decimal
+	n int64
+}
+
+// comparePrices compares the keys of the decimal prices.
+func comparePrices(a, b priceKey) int {
+	return a.d.Cmp(b.d)
+}
+
+// compareFixedPrices compares the keys of the fixed-point prices.
+func compareFixedPrices(a, b priceKey) int {
+	switch {
+	case a.n < b.n:
+		return -1
+	case a.n > b.n:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// key returns the key of the price level by the price.
+func (os *OrderSide) key(price *apd.Decimal, fixedPrice int64) priceKey {
+	if os.fixed != nil {
+		return priceKey{n: fixedPrice}
+	}
+
+	return priceKey{d: price}
+}
+
+// addAmount adds the amount to the price level.
+func (os *OrderSide) addAmount(level *OrdersBySpecificPrice, amount *apd.Decimal, fixedAmount int64) error {
+	if os.fixed == nil {
+		return level.AddAmount(amount)
+	}
+
+	total, err := addFixed(level.fixedTotal, fixedAmount)
+	if err != nil {
+		return err
+	}
+	level.fixedTotal = total
+
+	return nil
+}
+
+// remaining returns a copy of the amount left of the order. The fixed-point matching doesn't update
+// the decimal amount of the order, so it's built from the fixed-point one.
+func (os *OrderSide) remaining(o *Order) *apd.Decimal {
+	if os.fixed == nil {
+		return apd.New(0, 0).Set(o.amount)
+	}
+
+	return os.fixed.amountDecimal(o.fixedAmount)
+}
+
+// total returns a copy of the total amount of the price level, it's built from the fixed-point one
+// in the fixed-point mode as well.
+func (os *OrderSide) total(level *OrdersBySpecificPrice) *apd.Decimal {
+	if os.fixed == nil {
+		return apd.New(0, 0).Set(level.totalAmount)
+	}
+
+	return os.fixed.amountDecimal(level.fixedTotal)
+}
+
+// filled returns true if nothing is left of the order.
+func (os *OrderSide) filled(o *Order) bool {
+	if os.fixed == nil {
+		return o.amount.IsZero()
+	}
+
+	return o.fixedAmount == 0
+}
+
+// remaining returns a copy of the amount left of the order.
+func (ob *OrderBook) remaining(o *Order) *apd.Decimal {
+	return ob.side(o.operationType).remaining(o)
+}
+
+// addFixed returns a+b of the non-negative values or the error if it overflows int64.
+func addFixed(a, b int64) (int64, error) {
+	if a > math.MaxInt64-b {
+		return 0, fmt.Errorf("fixed-point amount overflows: %d + %d", a, b)
+	}
+
+	return a + b, nil
+}
+
+// pow10 are the powers of 10 which fit int64.
+var pow10 = [maxFixedDecimals + 1]int64{
+	1, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10, 1e11, 1e12, 1e13, 1e14, 1e15, 1e16, 1e17, 1e18,
+}
+
+// toFixed converts the decimal to int64 scaled by the decimals, it fails if there are more decimals
+// or the value doesn't fit int64. The prices and the amounts can't be negative. It's exact and doesn't allocate.
+func toFixed(d *apd.Decimal, decimals int32) (int64, error) {
+	if d.Form != apd.Finite || !d.Coeff.IsInt64() {
+		return 0, newOrderError(ErrInvalidPrecision, "%s doesn't fit int64 with %d decimals", d, decimals)
+	}
+	if d.Negative && d.Coeff.Sign() != 0 {
+		return 0, newOrderError(ErrInvalidPrecision, "%s is negative", d)
+	}
+
+	v := d.Coeff.Int64()
+	scale := d.Exponent + decimals
+	switch {
+	case v == 0:
+	case scale >= 0:
+		if scale > maxFixedDecimals || v > math.MaxInt64/pow10[scale] {
+			return 0, newOrderError(ErrInvalidPrecision, "%s doesn't fit int64 with %d decimals", d, decimals)
+		}
+		v *= pow10[scale]
+	default:
+		if -scale > maxFixedDecimals || v%pow10[-scale] != 0 {
+			return 0, newOrderError(ErrInvalidPrecision, "%s has more than %d decimals", d, decimals)
+		}
+		v /= pow10[-scale]
+	}
+
+	return v, nil
+}
+
+// amount converts the amount to the fixed-point one.
+func (fp *FixedPoint) amount(d *apd.Decimal) (int64, error) {
+	return toFixed(d, fp.AmountDecimals)
+}
+
+// price converts the price to the fixed-point one.
+func (fp *FixedPoint) price(d *apd.Decimal) (int64, error) {
+	return toFixed(d, fp.PriceDecimals)
+}
+
+// amountDecimal returns the decimal of the fixed-point amount.
+func (fp *FixedPoint) amountDecimal(v int64) *apd.Decimal {
+	d := &apd.Decimal{}
+	fp.setAmount(d, v)
+
+	return d
+}
+
+// setAmount sets the fixed-point amount to the decimal without the allocation of a new one,
+// the trailing zeros are dropped, so the amounts look the same as in the decimal order book.
+func (fp *FixedPoint) setAmount(d *apd.Decimal, v int64) {
+	v, exp := fp.trim(v)
+	d.SetInt64(v)
+	d.Exponent = exp
+}
+
+// setAmountWord sets the fixed-point amount to the new decimal, its coefficient is kept by the word,
+// so nothing is allocated.
+func (fp *FixedPoint) setAmountWord(d *apd.Decimal, word *[1]big.Word, v int64) {
+	v, exp := fp.trim(v)
+	if v < 0 || uint64(v) > uint64(^big.Word(0)) {
+		d.SetInt64(v)
+		d.Exponent = exp
+
+		return
+	}
+
+	word[0] = big.Word(v)
+	d.Coeff.SetBits(word[:])
+	d.Form, d.Negative, d.Exponent = apd.Finite, false, exp
+}
+
+// trim drops the trailing zeros of the fixed-point amount, it returns the coefficient and the exponent.
+func (fp *FixedPoint) trim(v int64) (int64, int32) {
+	exp := -fp.AmountDecimals
+	for exp < 0 && v%10 == 0 {
+		v /= 10
+		exp++
+	}
+
+	return v, exp
+}
+
+// fixOrder sets the fixed-point price and amount of the order if the order book is fixed-point.
+func (ob *OrderBook) fixOrder(o *Order) error {
+	fp := ob.Asks.fixed
+	if fp == nil {
+		return nil
+	}
+
+	price, err := fp.price(o.price)
+	if err != nil {
+		return fmt.Errorf("wrong price of order: %s: %w", o.orderID, err)
+	}
+
+	amount, err := fp.amount(o.amount)
+	if err != nil {
+		return fmt.Errorf("wrong amount of order: %s: %w", o.orderID, err)
+	}
+
+	o.fixedPrice, o.fixedAmount = price, amount
+
+	return nil
+}
+
+// SetFixedPoint switches the order book to the fixed-point mode. The mode is set before the orders are placed,
+// so it fails if there are resting orders.
+func (ob *OrderBook) SetFixedPoint(ctx context.Context, fp FixedPoint) error {
+	if fp.PriceDecimals < 0 || fp.PriceDecimals > maxFixedDecimals ||
+		fp.AmountDecimals < 0 || fp.AmountDecimals > maxFixedDecimals {
+		return fmt.Errorf("decimals must be from 0 to %d: %+v", maxFixedDecimals, fp)
+	}
+
+	err := ob.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer ob.unlock()
+
+	if len(ob.Orders) > 0 {
+		return fmt.Errorf("can't set fixed point: there are %d orders in order book", len(ob.Orders))
+	}
+
+	var indexes [2]priceLevels
+	for i, side := range []*OrderSide{ob.Asks, ob.Bids} {
+		indexes[i], err = newPriceLevels(&fp, side.ladder)
+		if err != nil {
+			return fmt.Errorf("can't set fixed point: %w", err)
+		}
+	}
+
+	for i, side := range []*OrderSide{ob.Asks, ob.Bids} {
+		side.fixed = &fp
+		side.index = indexes[i]
+		side.dirty = true
+	}
+
+	return nil
+}
+
+// executeFixed executes the order by the fixed-point prices and amounts, it's ExecuteOrder of the fixed-point order book.
+func (os *OrderSide) executeFixed(o *Order) (amountLeft *apd.Decimal, ordersExecuted int, err error) {
+	left := o.fixedAmount
+	defer func() {
+		amountLeft = os.fixed.amountDecimal(left)
+	}()
+
+	for orders := os.best(); left > 0 && orders != nil; orders = os.worse(orders) {
+		// the price is worse than the price of the order.
+		if (o.operationType == Bid && orders.fixedPrice > o.fixedPrice) ||
+			(o.operationType == Ask && orders.fixedPrice < o.fixedPrice) {
+			break
+		}
+
+		for el := orders.orders.Front(); el != nil && left > 0; {
+			reqOrder := el.Value.(*Order)
+
+			found := reqOrder.fixedAmount
+			if found > left {
+				found = left
+			}
+
+			left -= found
+			reqOrder.fixedAmount -= found
+			orders.fixedTotal -= found
+
+			oe := os.newReport()
+			oe.initiatorOrderID = o.orderID
+			oe.initiatorAccountID = o.accountID
+			oe.executorOrderID = reqOrder.orderID
+			oe.executorAccountID = reqOrder.accountID
+			oe.price = reqOrder.price
+			os.fixed.setAmountWord(oe.amount, &oe.amountWord, found)
+			o.addExecution(oe)
+			reqOrder.addExecution(oe)
+
+			ordersExecuted++
+			if os.ob != nil && os.ob.listening() {
+				os.emitFills(o, reqOrder, oe, os.fixed.amountDecimal(left))
+			}
+
+			current := el
+			el = el.Next()
+			if reqOrder.fixedAmount == 0 {
+				orders.orders.Remove(current)
+			}
+		}
+
+		// the removed level keeps its price, so the iteration goes on from it.
+		if orders.orders.Len() == 0 {
+			os.index.Remove(os.key(orders.price, orders.fixedPrice))
+		}
+		if os.ob != nil {
+			os.ob.emitLevel(os, orders.price, orders.fixedPrice)
+		}
+		if orders.orders.Len() == 0 {
+			os.freeLevel(orders)
+		}
+	}
+
+	return amountLeft, ordersExecuted, nil
+}
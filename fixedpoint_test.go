@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func Test_FixedPointConversion(t *testing.T) {
+	fp := &FixedPoint{PriceDecimals: 2, AmountDecimals: 8}
+
+	for _, tc := range []struct {
+		amount string
+		fixed  int64
+	}{
+		{amount: "1", fixed: 100000000},
+		{amount: "0.00000001", fixed: 1},
+		{amount: "12.3456789", fixed: 1234567890},
+		{amount: "1.000000000", fixed: 100000000},
+	} {
+		v, err := fp.amount(decimal(t, tc.amount))
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if v != tc.fixed {
+			t.Fatalf("unexpected fixed amount of %s: %d", tc.amount, v)
+		}
+		if fp.amountDecimal(v).Cmp(decimal(t, tc.amount)) != 0 {
+			t.Fatalf("unexpected decimal of %d: %s", v, fp.amountDecimal(v))
+		}
+	}
+
+	if fp.amountDecimal(120000000).String() != "1.2" || fp.amountDecimal(0).String() != "0" {
+		t.Fatalf("unexpected decimals: %s, %s", fp.amountDecimal(120000000), fp.amountDecimal(0))
+	}
+
+	for _, d := range []string{"0.000000001", "100.001", "100000000000000000000", "-1"} {
+		_, err := fp.price(decimal(t, d))
+		if !errors.Is(err, ErrInvalidPrecision) {
+			t.Fatalf("unexpected err of %s: %v", d, err)
+		}
+	}
+}
+
+func Test_FixedPointOrderBook(t *testing.T) {
+	run := func(fixed bool) *OrderBook {
+		ob := NewOrderBook("BTC", "USDT")
+		if fixed {
+			err := ob.SetFixedPoint(context.Background(), FixedPoint{PriceDecimals: 2, AmountDecimals: 4})
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+		}
+
+		for _, o := range []*Order{
+			{orderID: "1", operationType: Ask, amount: decimal(t, "0.5"), price: decimal(t, "100.5")},
+			{orderID: "2", operationType: Ask, amount: decimal(t, "0.25"), price: decimal(t, "100.5")},
+			{orderID: "3", operationType: Ask, amount: decimal(t, "1"), price: decimal(t, "101")},
+			{orderID: "4", operationType: Bid, amount: decimal(t, "0.3"), price: decimal(t, "99.99")},
+			{orderID: "5", operationType: Bid, amount: decimal(t, "2"), price: decimal(t, "101")},
+		} {
+			_, err := ob.PlaceLimitOrder(context.Background(), o)
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+		}
+
+		_, amountLeft, err := ob.PlaceMarketOrder(context.Background(), &Order{
+			orderID: "6", operationType: Ask, amount: decimal(t, "0.4"), price: decimal(t, "0"),
+		})
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if !amountLeft.IsZero() {
+			t.Fatalf("unexpected amount left: %s", amountLeft)
+		}
+
+		err = ob.Cancel(context.Background(), "4")
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		err = ob.Rollback(context.Background(), "6")
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		return ob
+	}
+
+	dob, fob := run(false), run(true)
+
+	dAsks, dBids := dob.Depth(context.Background(), 0)
+	fAsks, fBids := fob.Depth(context.Background(), 0)
+	for _, levels := range [][2][]PriceLevel{{dAsks, fAsks}, {dBids, fBids}} {
+		if len(levels[0]) != len(levels[1]) {
+			t.Fatalf("unexpected levels: %+v, %+v", levels[0], levels[1])
+		}
+		for i := range levels[0] {
+			d, f := levels[0][i], levels[1][i]
+			if d.Price.Cmp(f.Price) != 0 || d.Amount.Cmp(f.Amount) != 0 || d.Orders != f.Orders {
+				t.Fatalf("unexpected level: %+v, %+v", d, f)
+			}
+		}
+	}
+
+	// 5 took 1.75 and rests by 0.25, 6 sold 0.25 to 5 and 0.15 to 4, the rollback refills 5,
+	// 4 is canceled already, so it gets only the funds back.
+	if len(fAsks) != 0 || len(fBids) != 1 || fBids[0].Amount.Cmp(decimal(t, "0.25")) != 0 {
+		t.Fatalf("unexpected depth: %+v, %+v", fAsks, fBids)
+	}
+
+	if _, ok := fob.OrdersDone["6"]; ok {
+		t.Fatalf("order isn't rolled back")
+	}
+	if level, _ := fob.Bids.index.Get(priceKey{n: 10100}); level.fixedTotal != 2500 {
+		t.Fatalf("unexpected price level: %+v", fob.Bids.index.Values())
+	}
+
+	_, err := fob.PlaceLimitOrder(context.Background(), &Order{
+		orderID: "7", operationType: Bid, amount: decimal(t, "0.00001"), price: decimal(t, "1"),
+	})
+	if !errors.Is(err, ErrInvalidPrecision) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if _, ok := fob.Orders["7"]; ok {
+		t.Fatalf("order is added")
+	}
+}
+
+func Test_SetFixedPoint(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	_, err := ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID: "1", operationType: Ask, amount: decimal(t, "0.5"), price: decimal(t, "100.5"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// the mode can't be changed when there are orders in the order book.
+	err = ob.SetFixedPoint(context.Background(), FixedPoint{PriceDecimals: 1, AmountDecimals: 3})
+	if err == nil {
+		t.Fatalf("expected err")
+	}
+	if ob.Asks.fixed != nil || ob.Bids.fixed != nil {
+		t.Fatalf("order book is changed")
+	}
+
+	err = ob.Cancel(context.Background(), "1")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	err = ob.SetFixedPoint(context.Background(), FixedPoint{PriceDecimals: 1, AmountDecimals: 3})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	for _, o := range []*Order{
+		{orderID: "2", operationType: Ask, amount: decimal(t, "0.5"), price: decimal(t, "100.5")},
+		{orderID: "3", operationType: Ask, amount: decimal(t, "0.001"), price: decimal(t, "100.5")},
+		{orderID: "4", operationType: Bid, amount: decimal(t, "1"), price: decimal(t, "99")},
+		{orderID: "5", operationType: Bid, amount: decimal(t, "0.6"), price: decimal(t, "100.5")},
+	} {
+		_, err = ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	for _, o := range []*Order{
+		{orderID: "6", operationType: Bid, amount: decimal(t, "-1"), price: decimal(t, "99")},
+		{orderID: "7", operationType: Bid, amount: decimal(t, "1"), price: decimal(t, "-99")},
+	} {
+		_, err = ob.PlaceLimitOrder(context.Background(), o)
+		if err == nil {
+			t.Fatalf("expected err of order: %s", o.orderID)
+		}
+	}
+
+	asks, bids := ob.Depth(context.Background(), 0)
+	if len(asks) != 0 || len(bids) != 2 || bids[0].Price.Cmp(decimal(t, "100.5")) != 0 ||
+		bids[0].Amount.Cmp(decimal(t, "0.099")) != 0 || bids[1].Amount.Cmp(decimal(t, "1")) != 0 {
+		t.Fatalf("unexpected depth: %+v, %+v", asks, bids)
+	}
+	if level, ok := ob.Bids.index.Get(priceKey{n: 1005}); !ok || level.fixedTotal != 99 {
+		t.Fatalf("unexpected price level: %+v", ob.Bids.index.Values())
+	}
+
+	status, err := ob.OrderStatus(context.Background(), "5")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if status.Remaining.Cmp(decimal(t, "0.099")) != 0 || status.Executed.Cmp(decimal(t, "0.501")) != 0 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
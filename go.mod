@@ -4,10 +4,16 @@ go 1.19
 
 require (
 	github.com/cockroachdb/apd v1.1.0
-	github.com/emirpasic/gods v1.18.1
+	github.com/gorilla/websocket v1.5.3
+	google.golang.org/grpc v1.64.0
+	google.golang.org/protobuf v1.33.0
 )
 
 require (
 	github.com/lib/pq v1.10.7 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
+	golang.org/x/net v0.22.0 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
 )
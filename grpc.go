@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/cockroachdb/apd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative matchingengine.proto
+
+// GRPCServer is a gRPC API of the order books of the exchange, see matchingengine.proto.
+type GRPCServer struct {
+	UnimplementedMatchingEngineServer
+
+	exchange *Exchange
+	// streamBuffer is a number of the market data messages buffered for the subscriber.
+	streamBuffer int
+}
+
+// NewGRPCServer creates a new instance of GRPCServer.
+func NewGRPCServer(exchange *Exchange) *GRPCServer {
+	return &GRPCServer{
+		exchange: exchange,
+	}
+}
+
+// Server creates the gRPC server with the registered service.
+func (s *GRPCServer) Server(opts ...grpc.ServerOption) *grpc.Server {
+	server := grpc.NewServer(opts...)
+	RegisterMatchingEngineServer(server, s)
+
+	return server
+}
+
+// grpcError maps the error of the order book to the gRPC status, by the same codes as the HTTP API.
+func grpcError(err error) error {
+	ae := toAPIError(err)
+
+	code := codes.Internal
+	switch ae.Status {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.AlreadyExists
+	case http.StatusUnprocessableEntity:
+		code = codes.FailedPrecondition
+	}
+
+	return status.Errorf(code, "%s: %s", ae.Code, ae.Message)
+}
+
+// book returns the order book of the instrument.
+func (s *GRPCServer) book(instrument string) (*OrderBook, error) {
+	ob, ok := s.exchange.Book(instrument)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s: order book: %s not found", CodeBookNotFound, instrument)
+	}
+
+	return ob, nil
+}
+
+// PlaceLimitOrder places the limit order.
+func (s *GRPCServer) PlaceLimitOrder(ctx context.Context, req *PlaceOrderRequest) (*PlaceOrderResponse, error) {
+	return s.placeOrder(ctx, CommandPlaceLimit, req)
+}
+
+// PlaceMarketOrder places the market order.
+func (s *GRPCServer) PlaceMarketOrder(ctx context.Context, req *PlaceOrderRequest) (*PlaceOrderResponse, error) {
+	return s.placeOrder(ctx, CommandPlaceMarket, req)
+}
+
+// placeOrder places the limit or the market order.
+func (s *GRPCServer) placeOrder(ctx context.Context, ct CommandType, req *PlaceOrderRequest) (*PlaceOrderResponse, error) {
+	ob, err := s.book(req.Instrument)
+	if err != nil {
+		return nil, err
+	}
+
+	side := ""
+	switch req.Side {
+	case Side_SIDE_ASK:
+		side = Ask.String()
+	case Side_SIDE_BID:
+		side = Bid.String()
+	}
+
+	c, err := orderRequest{
+		OrderID: req.OrderId,
+		Account: req.Account,
+		Side:    side,
+		Price:   req.Price,
+		Amount:  req.Amount,
+	}.command(ct)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	res, err := ob.Apply(ctx, c)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &PlaceOrderResponse{
+		OrderId:        req.OrderId,
+		OrdersExecuted: int32(res.OrdersExecuted),
+		AmountLeft:     decimalString(res.AmountLeft),
+	}
+	for _, e := range res.Executions {
+		resp.Trades = append(resp.Trades, tradeEvent(ob.Instrument(), c.Side, e.Trade()))
+	}
+
+	return resp, nil
+}
+
+// Cancel cancels the order.
+func (s *GRPCServer) Cancel(ctx context.Context, req *OrderRequest) (*Empty, error) {
+	return s.orderCommand(ctx, CommandCancel, req)
+}
+
+// Rollback rollbacks the order.
+func (s *GRPCServer) Rollback(ctx context.Context, req *OrderRequest) (*Empty, error) {
+	return s.orderCommand(ctx, CommandRollback, req)
+}
+
+// orderCommand applies the cancel or the rollback command.
+func (s *GRPCServer) orderCommand(ctx context.Context, ct CommandType, req *OrderRequest) (*Empty, error) {
+	ob, err := s.book(req.Instrument)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ob.Apply(ctx, Command{Type: ct, OrderID: OrderID(req.OrderId)})
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	return &Empty{}, nil
+}
+
+// GetOrder returns the state of the order.
+func (s *GRPCServer) GetOrder(ctx context.Context, req *OrderRequest) (*OrderState, error) {
+	ob, err := s.book(req.Instrument)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := ob.OrderStatus(ctx, OrderID(req.OrderId))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &OrderState{
+		OrderId:   string(st.OrderID),
+		Account:   string(st.AccountID),
+		Side:      grpcSide(st.Side),
+		Price:     decimalString(st.Price),
+		Open:      st.Open,
+		Remaining: decimalString(st.Remaining),
+		Executed:  decimalString(st.Executed),
+	}
+	for _, t := range st.Trades {
+		takerSide := st.Side
+		if t.ExecutorOrderID == st.OrderID {
+			takerSide = opposite(st.Side)
+		}
+		resp.Trades = append(resp.Trades, tradeEvent(ob.Instrument(), takerSide, t))
+	}
+
+	return resp, nil
+}
+
+// GetDepth returns the depth of the order book.
+func (s *GRPCServer) GetDepth(ctx context.Context, req *DepthRequest) (*BookDepth, error) {
+	ob, err := s.book(req.Instrument)
+	if err != nil {
+		return nil, err
+	}
+	if req.Limit < 0 {
+		return nil, grpcError(invalidRequest("wrong limit: %d", req.Limit))
+	}
+
+	asks, bids := ob.Depth(ctx, int(req.Limit))
+
+	return &BookDepth{
+		Instrument: ob.Instrument(),
+		Asks:       bookLevels(depthLevels(asks)),
+		Bids:       bookLevels(depthLevels(bids)),
+	}, nil
+}
+
+// SubscribeTrades streams the trades of the order book.
+func (s *GRPCServer) SubscribeTrades(req *SubscribeRequest, stream MatchingEngine_SubscribeTradesServer) error {
+	return s.subscribe(stream.Context(), req, func(update *BookUpdate) error {
+		trade := update.GetTrade()
+		if trade == nil {
+			return nil
+		}
+
+		return stream.Send(trade)
+	})
+}
+
+// SubscribeBook streams the depth snapshot, the level updates and the trades of the order book.
+func (s *GRPCServer) SubscribeBook(req *SubscribeRequest, stream MatchingEngine_SubscribeBookServer) error {
+	return s.subscribe(stream.Context(), req, stream.Send)
+}
+
+// subscribe sends the market data of the order book until the context is done.
+// When the subscriber lags and the updates are dropped, the stream continues from a new snapshot.
+func (s *GRPCServer) subscribe(ctx context.Context, req *SubscribeRequest, send func(update *BookUpdate) error) error {
+	ob, err := s.book(req.Instrument)
+	if err != nil {
+		return err
+	}
+
+	sub, err := ob.SubscribeMarketData(s.streamBuffer)
+	if err != nil {
+		return status.Errorf(codes.NotFound, "%s: %v", CodeNotFound, err)
+	}
+	defer sub.Close()
+
+	for {
+		var (
+			m  MarketDataMessage
+			ok bool
+		)
+		select {
+		case m, ok = <-sub.Messages():
+			if !ok {
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+
+		var update *BookUpdate
+		switch m := m.(type) {
+		case *DepthSnapshot:
+			update = &BookUpdate{Update: &BookUpdate_Snapshot{Snapshot: &BookDepth{
+				Instrument: m.Instrument, Seq: m.Seq, Asks: bookLevels(m.Asks), Bids: bookLevels(m.Bids),
+			}}}
+		case *LevelUpdate:
+			update = &BookUpdate{Update: &BookUpdate_Level{Level: &BookLevelUpdate{
+				Instrument: m.Instrument,
+				Seq:        m.Seq,
+				Side:       grpcSideString(m.Side),
+				Price:      decimalString(m.Price),
+				Amount:     decimalString(m.Amount),
+				Orders:     int32(m.Orders),
+			}}}
+		case *TradeUpdate:
+			update = &BookUpdate{Update: &BookUpdate_Trade{Trade: &TradeEvent{
+				Instrument:       m.Instrument,
+				Seq:              m.Seq,
+				InitiatorOrderId: string(m.InitiatorOrderID),
+				ExecutorOrderId:  string(m.ExecutorOrderID),
+				Price:            decimalString(m.Price),
+				Amount:           decimalString(m.Amount),
+				TakerSide:        grpcSideString(m.TakerSide),
+			}}}
+		}
+
+		// SequenceGap has no update, the stream is resynced below.
+		if update != nil {
+			err = send(update)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(sub.Messages()) == 0 && sub.Lagging() {
+			err = sub.Resync()
+			if errors.Is(err, ErrSubscriptionClosed) {
+				return nil
+			}
+		}
+	}
+}
+
+// decimalString returns the string of the decimal, nil is the empty string.
+func decimalString(d *apd.Decimal) string {
+	if d == nil {
+		return ""
+	}
+
+	return d.String()
+}
+
+// opposite returns the opposite side.
+func opposite(side OperationType) OperationType {
+	if side == Ask {
+		return Bid
+	}
+
+	return Ask
+}
+
+// grpcSide converts the side to the side of the gRPC API.
+func grpcSide(side OperationType) Side {
+	switch side {
+	case Ask:
+		return Side_SIDE_ASK
+	case Bid:
+		return Side_SIDE_BID
+	default:
+		return Side_SIDE_UNSPECIFIED
+	}
+}
+
+// grpcSideString converts the side like ask to the side of the gRPC API.
+func grpcSideString(side string) Side {
+	ot, err := ParseOperationType(side)
+	if err != nil {
+		return Side_SIDE_UNSPECIFIED
+	}
+
+	return grpcSide(ot)
+}
+
+// tradeEvent converts the trade to the gRPC message.
+func tradeEvent(instrument string, takerSide OperationType, t Trade) *TradeEvent {
+	return &TradeEvent{
+		Instrument:       instrument,
+		InitiatorOrderId: string(t.InitiatorOrderID),
+		ExecutorOrderId:  string(t.ExecutorOrderID),
+		Price:            decimalString(t.Price),
+		Amount:           decimalString(t.Amount),
+		TakerSide:        grpcSide(takerSide),
+	}
+}
+
+// bookLevels converts the depth levels to the gRPC messages.
+func bookLevels(levels []depthLevel) []*BookLevel {
+	res := make([]*BookLevel, 0, len(levels))
+	for _, l := range levels {
+		res = append(res, &BookLevel{Price: decimalString(l.Price), Amount: decimalString(l.Amount), Orders: int32(l.Orders)})
+	}
+
+	return res
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func testGRPCClient(t *testing.T) (MatchingEngineClient, *OrderBook) {
+	t.Helper()
+
+	ob, _ := testAccountsOrderBook(t)
+	ob.Risk = NewRiskManager(RiskLimits{MaxOrderAmount: decimal(t, "5")})
+	ob.MarketData = NewMarketDataFeed()
+
+	exchange := NewExchange()
+	err := exchange.AddBook(ob)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	l := bufconn.Listen(1024 * 1024)
+	server := NewGRPCServer(exchange).Server()
+	go func() {
+		_ = server.Serve(l)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return NewMatchingEngineClient(conn), ob
+}
+
+func checkGRPCCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+
+	if status.Code(err) != code {
+		t.Fatalf("expected %s, but got: %v", code, err)
+	}
+}
+
+func Test_GRPCServer(t *testing.T) {
+	client, _ := testGRPCClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	placed, err := client.PlaceLimitOrder(ctx, &PlaceOrderRequest{
+		Instrument: "BTC-USDT", OrderId: "1", Account: "seller", Side: Side_SIDE_ASK, Price: "20000.10", Amount: "1.5",
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if placed.OrderId != "1" || placed.OrdersExecuted != 0 || len(placed.Trades) != 0 {
+		t.Fatalf("wrong response: %+v", placed)
+	}
+
+	placed, err = client.PlaceMarketOrder(ctx, &PlaceOrderRequest{
+		Instrument: "BTC-USDT", OrderId: "2", Account: "buyer", Side: Side_SIDE_BID, Price: "20100", Amount: "0.25",
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if placed.OrdersExecuted != 1 || len(placed.Trades) != 1 || placed.Trades[0].Price != "20000.10" ||
+		placed.Trades[0].Amount != "0.25" || placed.Trades[0].TakerSide != Side_SIDE_BID || placed.AmountLeft != "0.00" {
+		t.Fatalf("wrong response: %+v", placed)
+	}
+
+	order, err := client.GetOrder(ctx, &OrderRequest{Instrument: "BTC-USDT", OrderId: "1"})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !order.Open || order.Side != Side_SIDE_ASK || order.Remaining != "1.25" || order.Executed != "0.25" ||
+		order.Account != "seller" || len(order.Trades) != 1 || order.Trades[0].TakerSide != Side_SIDE_BID {
+		t.Fatalf("wrong order: %+v", order)
+	}
+
+	depth, err := client.GetDepth(ctx, &DepthRequest{Instrument: "BTC-USDT"})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(depth.Asks) != 1 || len(depth.Bids) != 0 || depth.Asks[0].Amount != "1.25" || depth.Asks[0].Orders != 1 {
+		t.Fatalf("wrong depth: %+v", depth)
+	}
+
+	_, err = client.Rollback(ctx, &OrderRequest{Instrument: "BTC-USDT", OrderId: "2"})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	_, err = client.Cancel(ctx, &OrderRequest{Instrument: "BTC-USDT", OrderId: "1"})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	order, err = client.GetOrder(ctx, &OrderRequest{Instrument: "BTC-USDT", OrderId: "1"})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if order.Open || order.Remaining != "0" {
+		t.Fatalf("wrong order: %+v", order)
+	}
+
+	_, err = client.GetOrder(ctx, &OrderRequest{Instrument: "ETH-USDT", OrderId: "1"})
+	checkGRPCCode(t, err, codes.NotFound)
+
+	_, err = client.Cancel(ctx, &OrderRequest{Instrument: "BTC-USDT", OrderId: "1"})
+	checkGRPCCode(t, err, codes.NotFound)
+
+	_, err = client.PlaceLimitOrder(ctx, &PlaceOrderRequest{
+		Instrument: "BTC-USDT", OrderId: "3", Account: "seller", Side: Side_SIDE_UNSPECIFIED, Price: "1", Amount: "1",
+	})
+	checkGRPCCode(t, err, codes.InvalidArgument)
+
+	_, err = client.PlaceLimitOrder(ctx, &PlaceOrderRequest{
+		Instrument: "BTC-USDT", OrderId: "3", Account: "seller", Side: Side_SIDE_ASK, Price: "1", Amount: "6",
+	})
+	checkGRPCCode(t, err, codes.FailedPrecondition)
+
+	for _, code := range []codes.Code{codes.OK, codes.AlreadyExists} {
+		_, err = client.PlaceLimitOrder(ctx, &PlaceOrderRequest{
+			Instrument: "BTC-USDT", OrderId: "3", Account: "seller", Side: Side_SIDE_ASK, Price: "30000", Amount: "1",
+		})
+		checkGRPCCode(t, err, code)
+	}
+}
+
+func Test_GRPCSubscriptions(t *testing.T) {
+	client, ob := testGRPCClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := client.PlaceLimitOrder(ctx, &PlaceOrderRequest{
+		Instrument: "BTC-USDT", OrderId: "1", Account: "seller", Side: Side_SIDE_ASK, Price: "20000", Amount: "1",
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	book, err := client.SubscribeBook(ctx, &SubscribeRequest{Instrument: "BTC-USDT"})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	trades, err := client.SubscribeTrades(ctx, &SubscribeRequest{Instrument: "BTC-USDT"})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	update, err := book.Recv()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if update.GetSnapshot() == nil || update.GetSnapshot().Seq != 1 || len(update.GetSnapshot().Asks) != 1 {
+		t.Fatalf("wrong snapshot: %+v", update)
+	}
+
+	// the trades stream is subscribed, when the first update is received.
+	for ob.MarketData.subscribersCount() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err = client.PlaceMarketOrder(ctx, &PlaceOrderRequest{
+		Instrument: "BTC-USDT", OrderId: "2", Account: "buyer", Side: Side_SIDE_BID, Price: "20000", Amount: "0.4",
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	update, err = book.Recv()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if update.GetTrade() == nil || update.GetTrade().Seq != 2 || update.GetTrade().Amount != "0.4" ||
+		update.GetTrade().ExecutorOrderId != "1" {
+		t.Fatalf("wrong trade: %+v", update)
+	}
+
+	update, err = book.Recv()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if update.GetLevel() == nil || update.GetLevel().Seq != 3 || update.GetLevel().Side != Side_SIDE_ASK ||
+		update.GetLevel().Amount != "0.6" {
+		t.Fatalf("wrong level: %+v", update)
+	}
+
+	trade, err := trades.Recv()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if trade.Seq != 2 || trade.Price != "20000" || trade.TakerSide != Side_SIDE_BID || trade.InitiatorOrderId != "2" {
+		t.Fatalf("wrong trade: %+v", trade)
+	}
+
+	unknown, err := client.SubscribeBook(ctx, &SubscribeRequest{Instrument: "ETH-USDT"})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	_, err = unknown.Recv()
+	checkGRPCCode(t, err, codes.NotFound)
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cockroachdb/apd"
+)
+
+// maxRequestBody is a max size of the request body of the HTTP API.
+const maxRequestBody = 64 * 1024
+
+// Error codes of the HTTP API.
+const (
+	CodeInvalidRequest    = "invalid_request"
+	CodeNotFound          = "not_found"
+	CodeBookNotFound      = "book_not_found"
+	CodeOrderNotFound     = "order_not_found"
+	CodeOrderExists       = "order_exists"
+	CodeInsufficientFunds = "insufficient_funds"
+	CodeRiskRejected      = "risk_rejected"
+	CodeInternal          = "internal"
+)
+
+// apiError is an error of the HTTP API: {"error":{"code":"order_not_found","message":"..."}}.
+type apiError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Reason is a reason of the risk rejection.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Error returns the string.
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// invalidRequest returns the error of the wrong request.
+func invalidRequest(format string, args ...interface{}) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+// toAPIError maps the error of the order book to the error of the HTTP API.
+func toAPIError(err error) *apiError {
+	var (
+		ae *apiError
+		re *RiskError
+	)
+	switch {
+	case errors.As(err, &ae):
+		return ae
+	case errors.As(err, &re):
+		return &apiError{
+			Status: http.StatusUnprocessableEntity, Code: CodeRiskRejected, Message: err.Error(), Reason: re.Reason.String(),
+		}
+	case errors.Is(err, ErrOrderNotFound):
+		return &apiError{Status: http.StatusNotFound, Code: CodeOrderNotFound, Message: err.Error()}
+	case errors.Is(err, ErrOrderExists):
+		return &apiError{Status: http.StatusConflict, Code: CodeOrderExists, Message: err.Error()}
+	case errors.Is(err, ErrInvalidPrecision), errors.Is(err, ErrInvalidTick):
+		return invalidRequest("%s", err)
+	case errors.Is(err, ErrInsufficientFunds):
+		return &apiError{Status: http.StatusUnprocessableEntity, Code: CodeInsufficientFunds, Message: err.Error()}
+	default:
+		return &apiError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: err.Error()}
+	}
+}
+
+// orderRequest is a body of the place order requests, decimals are strings, so they keep the exact precision:
+//
+//	{"order_id":"1","account":"alice","side":"ask","price":"20050","amount":"0.3"}
+type orderRequest struct {
+	OrderID string `json:"order_id"`
+	Account string `json:"account"`
+	Side    string `json:"side"`
+	Price   string `json:"price"`
+	Amount  string `json:"amount"`
+}
+
+// command converts the request to the command of the order book.
+func (r orderRequest) command(ct CommandType) (Command, error) {
+	if r.OrderID == "" {
+		return Command{}, invalidRequest("order_id is required")
+	}
+
+	side, err := ParseOperationType(r.Side)
+	if err != nil {
+		return Command{}, invalidRequest("%v", err)
+	}
+
+	price, err := parsePositiveDecimal("price", r.Price)
+	if err != nil {
+		return Command{}, invalidRequest("%v", err)
+	}
+
+	amount, err := parsePositiveDecimal("amount", r.Amount)
+	if err != nil {
+		return Command{}, invalidRequest("%v", err)
+	}
+
+	return Command{
+		Type:      ct,
+		OrderID:   OrderID(r.OrderID),
+		AccountID: AccountID(r.Account),
+		Side:      side,
+		Price:     price,
+		Amount:    amount,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// orderResponse is a response of the place order requests.
+type orderResponse struct {
+	OrderID        OrderID      `json:"order_id"`
+	OrdersExecuted int          `json:"orders_executed"`
+	AmountLeft     *apd.Decimal `json:"amount_left,omitempty"`
+	Trades         []Trade      `json:"trades"`
+}
+
+// orderStatusResponse is a response of the order status request.
+type orderStatusResponse struct {
+	OrderID   OrderID      `json:"order_id"`
+	Account   AccountID    `json:"account,omitempty"`
+	Side      string       `json:"side"`
+	Price     *apd.Decimal `json:"price"`
+	Open      bool         `json:"open"`
+	Remaining *apd.Decimal `json:"remaining"`
+	Executed  *apd.Decimal `json:"executed"`
+	Trades    []Trade      `json:"trades"`
+}
+
+// depthResponse is a response of the depth request, levels are from the best price.
+type depthResponse struct {
+	Instrument string       `json:"instrument"`
+	Asks       []depthLevel `json:"asks"`
+	Bids       []depthLevel `json:"bids"`
+}
+
+// depthLevel is a price level of the depth response.
+type depthLevel struct {
+	Price  *apd.Decimal `json:"price"`
+	Amount *apd.Decimal `json:"amount"`
+	Orders int          `json:"orders"`
+}
+
+// depthLevels converts the price levels to the depth response.
+func depthLevels(levels []PriceLevel) []depthLevel {
+	res := make([]depthLevel, 0, len(levels))
+	for _, l := range levels {
+		res = append(res, depthLevel{Price: l.Price, Amount: l.Amount, Orders: l.Orders})
+	}
+
+	return res
+}
+
+// HTTPServer is an HTTP/JSON API of the order books of the exchange:
+//
+//	GET    /v1/books                                   instruments
+//	GET    /v1/books/{instrument}/depth?limit=N        depth
+//	GET    /v1/books/{instrument}/stream               websocket of the depth updates and the trades
+//	POST   /v1/books/{instrument}/orders/limit         place limit order
+//	POST   /v1/books/{instrument}/orders/market        place market order
+//	GET    /v1/books/{instrument}/orders/{id}          order status
+//	DELETE /v1/books/{instrument}/orders/{id}          cancel order
+//	POST   /v1/books/{instrument}/orders/{id}/rollback rollback order
+//
+// Errors are returned as {"error":{"code":"...","message":"..."}}.
+type HTTPServer struct {
+	exchange *Exchange
+	// streamBuffer is a number of the market data messages buffered for the websocket client.
+	streamBuffer int
+}
+
+// written is a result of the handler which has written the response itself.
+type written struct{}
+
+// NewHTTPServer creates a new instance of HTTPServer.
+func NewHTTPServer(exchange *Exchange) *HTTPServer {
+	return &HTTPServer{
+		exchange: exchange,
+	}
+}
+
+// ServeHTTP routes the request.
+func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	res, err := s.route(w, r)
+	if err != nil {
+		ae := toAPIError(err)
+		writeJSON(w, ae.Status, map[string]*apiError{"error": ae})
+		return
+	}
+	if _, ok := res.(written); ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// route calls the handler by the path and the method.
+func (s *HTTPServer) route(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if r.URL.Path != "/v1/books" && !strings.HasPrefix(r.URL.Path, "/v1/books/") {
+		return nil, &apiError{Status: http.StatusNotFound, Code: CodeNotFound, Message: "unknown path"}
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/books"), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			return nil, methodNotAllowed(w, http.MethodGet)
+		}
+
+		return map[string][]string{"instruments": s.exchange.Instruments()}, nil
+	}
+
+	parts := strings.Split(path, "/")
+	ob, ok := s.exchange.Book(parts[0])
+	if !ok {
+		return nil, &apiError{
+			Status: http.StatusNotFound, Code: CodeBookNotFound, Message: fmt.Sprintf("order book: %s not found", parts[0]),
+		}
+	}
+
+	ctx := r.Context()
+	switch {
+	case len(parts) == 2 && parts[1] == "depth":
+		if r.Method != http.MethodGet {
+			return nil, methodNotAllowed(w, http.MethodGet)
+		}
+
+		return s.depth(ctx, ob, r)
+
+	case len(parts) == 2 && parts[1] == "stream":
+		if r.Method != http.MethodGet {
+			return nil, methodNotAllowed(w, http.MethodGet)
+		}
+
+		return written{}, s.stream(ob, w, r)
+
+	case len(parts) == 3 && parts[1] == "orders" && (parts[2] == "limit" || parts[2] == "market"):
+		if r.Method != http.MethodPost {
+			return nil, methodNotAllowed(w, http.MethodPost)
+		}
+
+		ct := CommandPlaceLimit
+		if parts[2] == "market" {
+			ct = CommandPlaceMarket
+		}
+
+		return s.placeOrder(ctx, ob, ct, w, r)
+
+	case len(parts) == 3 && parts[1] == "orders":
+		orderID := OrderID(parts[2])
+
+		switch r.Method {
+		case http.MethodGet:
+			return s.orderStatus(ctx, ob, orderID)
+		case http.MethodDelete:
+			_, err := ob.Apply(ctx, Command{Type: CommandCancel, OrderID: orderID})
+			if err != nil {
+				return nil, err
+			}
+
+			return map[string]OrderID{"canceled": orderID}, nil
+		default:
+			return nil, methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+		}
+
+	case len(parts) == 4 && parts[1] == "orders" && parts[3] == "rollback":
+		if r.Method != http.MethodPost {
+			return nil, methodNotAllowed(w, http.MethodPost)
+		}
+
+		orderID := OrderID(parts[2])
+		_, err := ob.Apply(ctx, Command{Type: CommandRollback, OrderID: orderID})
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]OrderID{"rolled_back": orderID}, nil
+
+	default:
+		return nil, &apiError{Status: http.StatusNotFound, Code: CodeNotFound, Message: "unknown path"}
+	}
+}
+
+// methodNotAllowed sets the allowed methods and returns the error.
+func methodNotAllowed(w http.ResponseWriter, methods ...string) error {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+
+	return &apiError{Status: http.StatusMethodNotAllowed, Code: CodeInvalidRequest, Message: "method not allowed"}
+}
+
+// placeOrder places the limit or the market order.
+func (s *HTTPServer) placeOrder(
+	ctx context.Context, ob *OrderBook, ct CommandType, w http.ResponseWriter, r *http.Request,
+) (interface{}, error) {
+	var req orderRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&req)
+	if err != nil {
+		return nil, invalidRequest("wrong body: %v", err)
+	}
+	if dec.More() {
+		return nil, invalidRequest("wrong body: only one object is expected")
+	}
+
+	c, err := req.command(ct)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := ob.Apply(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return orderResponse{
+		OrderID:        c.OrderID,
+		OrdersExecuted: res.OrdersExecuted,
+		AmountLeft:     res.AmountLeft,
+		Trades:         trades(res.Executions),
+	}, nil
+}
+
+// orderStatus returns the state of the order.
+func (s *HTTPServer) orderStatus(ctx context.Context, ob *OrderBook, orderID OrderID) (interface{}, error) {
+	status, err := ob.OrderStatus(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	return orderStatusResponse{
+		OrderID:   status.OrderID,
+		Account:   status.AccountID,
+		Side:      status.Side.String(),
+		Price:     status.Price,
+		Open:      status.Open,
+		Remaining: status.Remaining,
+		Executed:  status.Executed,
+		Trades:    status.Trades,
+	}, nil
+}
+
+// depth returns the depth of the order book, limit 0 or no limit means all levels.
+func (s *HTTPServer) depth(ctx context.Context, ob *OrderBook, r *http.Request) (interface{}, error) {
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			return nil, invalidRequest("wrong limit: %q", l)
+		}
+	}
+
+	asks, bids := ob.Depth(ctx, limit)
+
+	return depthResponse{
+		Instrument: ob.Instrument(),
+		Asks:       depthLevels(asks),
+		Bids:       depthLevels(bids),
+	}, nil
+}
+
+// writeJSON writes the response as JSON.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// runServe runs the serve command:
+// matching-engine serve [-addr :8080] [-grpc :9090] [-fix :9876 -fix-clients A:account,B -fix-comp-id ME -fix-store dir]
+// [-feed 239.0.0.1:5000 -feed-retransmit :5001] [-books BTC-USDT,ETH-USDT] [-data dir]
+// With the data dir every order book is recovered from dir/<instrument> and journals its commands there.
+// The gRPC API, the FIX gateway and the order feed are served only if their addresses are set.
+func runServe(ctx context.Context, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", ":8080", "address to listen")
+	grpcAddr := fs.String("grpc", "", "address to listen by the gRPC API")
+	fixAddr := fs.String("fix", "", "address to listen by the FIX gateway")
+	fixCompID := fs.String("fix-comp-id", "MATCHING-ENGINE", "SenderCompID of the FIX gateway")
+	fixStore := fs.String("fix-store", "fix", "dir of the FIX session stores")
+	fixClients := fs.String("fix-clients", "", "FIX clients which can log on with their accounts, "+
+		"like A:account1:account2,B - B trades only on the account B, it's required for the FIX gateway")
+	feedAddr := fs.String("feed", "", "UDP address to publish the binary order feed, for example the multicast group")
+	retransmitAddr := fs.String("feed-retransmit", "", "address to listen by the order feed retransmission")
+	books := fs.String("books", "BTC-USDT", "comma separated instruments")
+	data := fs.String("data", "", "data dir of the snapshots and the journals")
+	sequencerSize := fs.Int("sequencer", 0, "size of the ring buffer of the commands of every order book, "+
+		"they are applied by one goroutine per book if it's set")
+	fixedPoint := fs.String("fixed-point", "", "decimals of the prices and the amounts of the fixed-point order books, "+
+		"for example 2,8")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	var fp *FixedPoint
+	if *fixedPoint != "" {
+		fp = &FixedPoint{}
+		_, err = fmt.Sscanf(*fixedPoint, "%d,%d", &fp.PriceDecimals, &fp.AmountDecimals)
+		if err != nil {
+			return fmt.Errorf("wrong fixed-point decimals: %s: %w", *fixedPoint, err)
+		}
+	}
+
+	exchange := NewExchange()
+	for _, instrument := range strings.Split(*books, ",") {
+		baseAsset, quoteAsset, err := ParseInstrument(strings.TrimSpace(instrument))
+		if err != nil {
+			return err
+		}
+
+		ob := NewOrderBook(baseAsset, quoteAsset)
+		if *data != "" {
+			ob, err = RecoverOrderBook(
+				ctx, filepath.Join(*data, ob.Instrument()), baseAsset, quoteAsset, OrderBookMode{FixedPoint: fp}, OrderBookParts{}, JournalConfig{},
+			)
+			if err != nil {
+				return fmt.Errorf("can't recover %s: %w", instrument, err)
+			}
+			defer ob.Journal.Close()
+		} else if fp != nil {
+			err = ob.SetFixedPoint(ctx, *fp)
+			if err != nil {
+				return fmt.Errorf("can't set fixed point of %s: %w", instrument, err)
+			}
+		}
+		ob.MarketData = NewMarketDataFeed()
+		if *feedAddr != "" {
+			err = ob.SetOrderFeed(NewOrderFeed(0))
+			if err != nil {
+				return fmt.Errorf("can't set order feed of %s: %w", instrument, err)
+			}
+		}
+		if *sequencerSize > 0 {
+			ob.Sequencer = NewSequencer(ob, *sequencerSize)
+
+			sequencerCtx, sequencerCancel := context.WithCancel(ctx)
+			go ob.Sequencer.Run(sequencerCtx)
+			defer func(s *Sequencer) {
+				sequencerCancel()
+				<-s.Stopped()
+			}(ob.Sequencer)
+		}
+
+		err = exchange.AddBook(ob)
+		if err != nil {
+			return err
+		}
+	}
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           NewHTTPServer(exchange),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if *grpcAddr != "" {
+		l, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			return fmt.Errorf("can't listen gRPC: %w", err)
+		}
+
+		grpcServer := NewGRPCServer(exchange).Server()
+		defer grpcServer.Stop()
+
+		go func() {
+			_ = grpcServer.Serve(l)
+		}()
+
+		fmt.Fprintf(stdout, "gRPC listening on %s\n", *grpcAddr)
+	}
+
+	if *fixAddr != "" {
+		if *fixClients == "" {
+			return errors.New("-fix-clients is required for the FIX gateway")
+		}
+
+		clients, err := ParseFIXClients(*fixClients)
+		if err != nil {
+			return err
+		}
+
+		acceptor, err := NewFIXAcceptor(exchange, FIXConfig{CompID: *fixCompID, StoreDir: *fixStore, Clients: clients})
+		if err != nil {
+			return err
+		}
+
+		l, err := net.Listen("tcp", *fixAddr)
+		if err != nil {
+			return fmt.Errorf("can't listen FIX: %w", err)
+		}
+
+		fixCtx, fixCancel := context.WithCancel(ctx)
+		fixDone := make(chan struct{})
+		defer func() {
+			fixCancel()
+			<-fixDone
+		}()
+
+		go func() {
+			defer close(fixDone)
+			_ = acceptor.Serve(fixCtx, l)
+		}()
+
+		fmt.Fprintf(stdout, "FIX listening on %s\n", *fixAddr)
+	}
+
+	if *feedAddr != "" {
+		stop, err := serveOrderFeeds(ctx, exchange, *feedAddr, *retransmitAddr)
+		if err != nil {
+			return err
+		}
+		defer stop()
+
+		fmt.Fprintf(stdout, "order feed is published to %s\n", *feedAddr)
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(stdout, "listening on %s: %s\n", *addr, strings.Join(exchange.Instruments(), ", "))
+
+	err = server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testHTTPServer(t *testing.T) (*httptest.Server, *Accounts) {
+	t.Helper()
+
+	ob, accounts := testAccountsOrderBook(t)
+	ob.Risk = NewRiskManager(RiskLimits{MaxOrderAmount: decimal(t, "5")})
+
+	exchange := NewExchange()
+	err := exchange.AddBook(ob)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	server := httptest.NewServer(NewHTTPServer(exchange))
+	t.Cleanup(server.Close)
+
+	return server, accounts
+}
+
+func doRequest(t *testing.T, method, url, body string, res interface{}) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("expected json, but got: %s", resp.Header.Get("Content-Type"))
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(res)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	return resp.StatusCode
+}
+
+func Test_HTTPServer(t *testing.T) {
+	server, accounts := testHTTPServer(t)
+	books := server.URL + "/v1/books/BTC-USDT"
+
+	var instruments map[string][]string
+	status := doRequest(t, http.MethodGet, server.URL+"/v1/books", "", &instruments)
+	if status != http.StatusOK || len(instruments["instruments"]) != 1 || instruments["instruments"][0] != "BTC-USDT" {
+		t.Fatalf("wrong instruments: %d %v", status, instruments)
+	}
+
+	var placed orderResponse
+	status = doRequest(t, http.MethodPost, books+"/orders/limit",
+		`{"order_id":"1","account":"seller","side":"ask","price":"20000.10","amount":"1.5"}`, &placed)
+	if status != http.StatusOK || placed.OrdersExecuted != 0 {
+		t.Fatalf("wrong response: %d %+v", status, placed)
+	}
+
+	var raw map[string]interface{}
+	status = doRequest(t, http.MethodPost, books+"/orders/market",
+		`{"order_id":"2","account":"buyer","side":"bid","price":"20100","amount":"0.25"}`, &raw)
+	if status != http.StatusOK {
+		t.Fatalf("wrong response: %d %v", status, raw)
+	}
+	// decimals are strings with the exact precision.
+	trade := raw["trades"].([]interface{})[0].(map[string]interface{})
+	if trade["price"] != "20000.10" || trade["amount"] != "0.25" || trade["executor_order_id"] != "1" {
+		t.Fatalf("wrong trade: %v", trade)
+	}
+	checkBalance(t, accounts, "buyer", "BTC", "0.25", "0")
+
+	var orderStatus orderStatusResponse
+	status = doRequest(t, http.MethodGet, books+"/orders/1", "", &orderStatus)
+	if status != http.StatusOK || !orderStatus.Open || orderStatus.Remaining.String() != "1.25" ||
+		orderStatus.Executed.String() != "0.25" || orderStatus.Side != "ask" || len(orderStatus.Trades) != 1 {
+		t.Fatalf("wrong order status: %d %+v", status, orderStatus)
+	}
+
+	var depth depthResponse
+	status = doRequest(t, http.MethodGet, books+"/depth?limit=1", "", &depth)
+	if status != http.StatusOK || len(depth.Asks) != 1 || len(depth.Bids) != 0 ||
+		depth.Asks[0].Amount.String() != "1.25" || depth.Asks[0].Orders != 1 {
+		t.Fatalf("wrong depth: %d %+v", status, depth)
+	}
+
+	status = doRequest(t, http.MethodPost, books+"/orders/2/rollback", "", &raw)
+	if status != http.StatusOK {
+		t.Fatalf("wrong response: %d %v", status, raw)
+	}
+	checkBalance(t, accounts, "buyer", "BTC", "0", "0")
+
+	status = doRequest(t, http.MethodDelete, books+"/orders/1", "", &raw)
+	if status != http.StatusOK {
+		t.Fatalf("wrong response: %d %v", status, raw)
+	}
+	checkBalance(t, accounts, "seller", "BTC", "10", "0")
+
+	// the canceled order is closed.
+	status = doRequest(t, http.MethodGet, books+"/orders/1", "", &orderStatus)
+	if status != http.StatusOK || orderStatus.Open || !orderStatus.Remaining.IsZero() {
+		t.Fatalf("wrong order status: %d %+v", status, orderStatus)
+	}
+
+	status = doRequest(t, http.MethodGet, books+"/orders/100", "", &raw)
+	if status != http.StatusNotFound {
+		t.Fatalf("expected %d, but got: %d", http.StatusNotFound, status)
+	}
+}
+
+func Test_HTTPServerErrors(t *testing.T) {
+	server, _ := testHTTPServer(t)
+	books := server.URL + "/v1/books/BTC-USDT"
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		status int
+		code   string
+		reason string
+	}{
+		{"unknown book", http.MethodGet, server.URL + "/v1/books/ETH-USDT/depth", "", 404, CodeBookNotFound, ""},
+		{"unknown path", http.MethodGet, server.URL + "/v2/books", "", 404, CodeNotFound, ""},
+		{"wrong method", http.MethodPut, books + "/orders/limit", "", 405, CodeInvalidRequest, ""},
+		{"wrong limit", http.MethodGet, books + "/depth?limit=-1", "", 400, CodeInvalidRequest, ""},
+		{"wrong json", http.MethodPost, books + "/orders/limit", `{"order_id":`, 400, CodeInvalidRequest, ""},
+		{
+			"unknown field", http.MethodPost, books + "/orders/limit",
+			`{"order_id":"1","account":"seller","side":"ask","price":"1","amount":"1","tif":"gtc"}`,
+			400, CodeInvalidRequest, "",
+		},
+		{
+			"wrong price", http.MethodPost, books + "/orders/limit",
+			`{"order_id":"1","account":"seller","side":"ask","price":"-1","amount":"1"}`,
+			400, CodeInvalidRequest, "",
+		},
+		{
+			"wrong side", http.MethodPost, books + "/orders/limit",
+			`{"order_id":"1","account":"seller","side":"up","price":"1","amount":"1"}`,
+			400, CodeInvalidRequest, "",
+		},
+		{
+			"insufficient funds", http.MethodPost, books + "/orders/limit",
+			`{"order_id":"1","account":"buyer","side":"bid","price":"30000","amount":"4"}`,
+			422, CodeInsufficientFunds, "",
+		},
+		{
+			"risk rejected", http.MethodPost, books + "/orders/limit",
+			`{"order_id":"1","account":"seller","side":"ask","price":"20000","amount":"6"}`,
+			422, CodeRiskRejected, RejectMaxOrderAmount.String(),
+		},
+		{
+			"placed", http.MethodPost, books + "/orders/limit",
+			`{"order_id":"1","account":"seller","side":"ask","price":"20000","amount":"1"}`,
+			200, "", "",
+		},
+		{
+			"order exists", http.MethodPost, books + "/orders/limit",
+			`{"order_id":"1","account":"seller","side":"ask","price":"20000","amount":"1"}`,
+			409, CodeOrderExists, "",
+		},
+		{"cancel unknown order", http.MethodDelete, books + "/orders/100", "", 404, CodeOrderNotFound, ""},
+		{"rollback unknown order", http.MethodPost, books + "/orders/100/rollback", "", 404, CodeOrderNotFound, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var res struct {
+				Error *apiError `json:"error"`
+			}
+			status := doRequest(t, tc.method, tc.url, tc.body, &res)
+			if status != tc.status {
+				t.Fatalf("expected %d, but got: %d", tc.status, status)
+			}
+			if tc.code == "" {
+				return
+			}
+			if res.Error == nil || res.Error.Code != tc.code || res.Error.Reason != tc.reason || res.Error.Message == "" {
+				t.Fatalf("wrong error: %+v", res.Error)
+			}
+		})
+	}
+}
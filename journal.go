@@ -0,0 +1,636 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/apd"
+)
+
+const (
+	// journalSegmentPrefix and journalSegmentExt make the name of the segment file: journal-<first seq>.log.
+	journalSegmentPrefix = "journal-"
+	journalSegmentExt    = ".log"
+	// defaultSegmentSize is a default max size of the segment file.
+	defaultSegmentSize = 64 << 20
+	// recordHeaderSize is a size of the record header: payload length and checksum.
+	recordHeaderSize = 8
+	// maxRecordSize is a max size of the record payload, the commands are much smaller,
+	// so the greater length is a corrupted header and nothing is allocated for it.
+	maxRecordSize = 64 << 10
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorruptedRecord is returned when the journal record is incomplete or has a wrong checksum.
+var ErrCorruptedRecord = errors.New("corrupted journal record")
+
+// CommandType is a type of the order book command.
+type CommandType uint8
+
+const (
+	CommandPlaceLimit CommandType = iota + 1
+	CommandPlaceMarket
+	CommandRollback
+	CommandCancel
+	CommandCancelAll
+	CommandReplace
+	CommandDeposit
+	CommandWithdraw
+)
+
+// String returns the string.
+func (ct CommandType) String() string {
+	switch ct {
+	case CommandPlaceLimit:
+		return "place_limit"
+	case CommandPlaceMarket:
+		return "place_market"
+	case CommandRollback:
+		return "rollback"
+	case CommandCancel:
+		return "cancel"
+	case CommandCancelAll:
+		return "cancel_all"
+	case CommandReplace:
+		return "replace"
+	case CommandDeposit:
+		return "deposit"
+	case CommandWithdraw:
+		return "withdraw"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(ct))
+	}
+}
+
+// Command is a command which changes the order book.
+type Command struct {
+	// Seq is a sequence number of the command in the journal.
+	Seq     uint64
+	Type    CommandType
+	OrderID OrderID
+	// ReplacedOrderID is the open order which is replaced by the order of CommandReplace.
+	ReplacedOrderID OrderID
+	AccountID       AccountID
+	// Asset is the asset of CommandDeposit and CommandWithdraw.
+	Asset Asset
+	Side  OperationType
+	// AnySide is set for CancelAll of both sides.
+	AnySide   bool
+	Price     *apd.Decimal
+	Amount    *apd.Decimal
+	CreatedAt time.Time
+	// Rejected is set when the order book rejected the command, the replay skips it.
+	Rejected bool
+
+	// placed is the order of the direct call which goes through the sequencer, it gets the executions.
+	// It isn't journaled.
+	placed *Order
+}
+
+// orderCommand creates the command which places the order.
+func orderCommand(commandType CommandType, o *Order) Command {
+	return Command{
+		Type:      commandType,
+		OrderID:   o.orderID,
+		AccountID: o.accountID,
+		Side:      o.operationType,
+		Price:     o.price,
+		Amount:    o.amount,
+		CreatedAt: o.createdAt,
+	}
+}
+
+// order creates the order of the command.
+func (c Command) order() *Order {
+	return &Order{
+		orderID:       c.OrderID,
+		accountID:     c.AccountID,
+		operationType: c.Side,
+		amount:        apd.New(0, 0).Set(c.Amount),
+		price:         apd.New(0, 0).Set(c.Price),
+		createdAt:     c.CreatedAt,
+	}
+}
+
+// SyncPolicy defines when the journal segment is synced to the disk.
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs the segment after every record.
+	SyncAlways SyncPolicy = iota
+	// SyncEveryN syncs the segment after every JournalConfig.SyncEvery records.
+	SyncEveryN
+	// SyncInterval syncs the segment every JournalConfig.SyncInterval in the background.
+	SyncInterval
+	// SyncNever leaves it to the OS.
+	SyncNever
+)
+
+// JournalConfig is a config of the Journal.
+type JournalConfig struct {
+	// SegmentSize is a max size of the segment file, the next one is created when it's reached.
+	SegmentSize int64
+	SyncPolicy  SyncPolicy
+	// SyncEvery is a number of records between syncs for SyncEveryN.
+	SyncEvery int
+	// SyncInterval is a period between syncs for SyncInterval.
+	SyncInterval time.Duration
+}
+
+// Journal is an append-only write-ahead journal of the order book commands.
+// Every record is: payload length (4 bytes), crc32 of the payload (4 bytes), payload.
+type Journal struct {
+	dir string
+	cfg JournalConfig
+
+	segment     *os.File
+	segmentSize int64
+	lastSeq     uint64
+	// unsynced is a number of records written since the last sync.
+	unsynced int
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+	mx        sync.Mutex
+}
+
+// OpenJournal opens the journal in the dir, new records are appended after the last one.
+func OpenJournal(dir string, cfg JournalConfig) (*Journal, error) {
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = defaultSegmentSize
+	}
+	if cfg.SyncPolicy == SyncEveryN && cfg.SyncEvery <= 0 {
+		return nil, fmt.Errorf("sync every must be positive: %d", cfg.SyncEvery)
+	}
+	if cfg.SyncPolicy == SyncInterval && cfg.SyncInterval <= 0 {
+		return nil, fmt.Errorf("sync interval must be positive: %s", cfg.SyncInterval)
+	}
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("can't create journal dir: %w", err)
+	}
+
+	j := &Journal{
+		dir:  dir,
+		cfg:  cfg,
+		done: make(chan struct{}),
+	}
+
+	segments, err := journalSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(segments) > 0 {
+		last := segments[len(segments)-1]
+
+		err = readSegment(last.path, func(c Command) error {
+			j.lastSeq = c.Seq
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if j.lastSeq == 0 {
+			// the last segment is empty - the previous one has the last record.
+			j.lastSeq = last.firstSeq - 1
+		}
+
+		j.segment, err = os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("can't open journal segment: %w", err)
+		}
+
+		info, err := j.segment.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("can't stat journal segment: %w", err)
+		}
+		j.segmentSize = info.Size()
+	}
+
+	if cfg.SyncPolicy == SyncInterval {
+		j.wg.Add(1)
+		go j.syncLoop()
+	}
+
+	return j, nil
+}
+
+// LastSeq returns the sequence number of the last record.
+func (j *Journal) LastSeq() uint64 {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	return j.lastSeq
+}
+
+// Append appends the command to the journal and returns its sequence number.
+func (j *Journal) Append(c Command) (uint64, error) {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	c.Seq = j.lastSeq + 1
+
+	payload, err := encodeCommand(c)
+	if err != nil {
+		return 0, err
+	}
+	if len(payload) > maxRecordSize {
+		return 0, fmt.Errorf("journal record is too large: %d bytes, max is %d", len(payload), maxRecordSize)
+	}
+
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	copy(record[recordHeaderSize:], payload)
+
+	if j.segment == nil || j.segmentSize+int64(len(record)) > j.cfg.SegmentSize {
+		err = j.rotate(c.Seq)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = j.segment.Write(record)
+	if err != nil {
+		return 0, fmt.Errorf("can't write journal record: %w", err)
+	}
+	j.segmentSize += int64(len(record))
+	j.lastSeq = c.Seq
+	j.unsynced++
+
+	if j.cfg.SyncPolicy == SyncAlways || (j.cfg.SyncPolicy == SyncEveryN && j.unsynced >= j.cfg.SyncEvery) {
+		err = j.sync()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return c.Seq, nil
+}
+
+// Sync syncs the current segment to the disk.
+func (j *Journal) Sync() error {
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	return j.sync()
+}
+
+func (j *Journal) sync() error {
+	if j.segment == nil || j.unsynced == 0 {
+		return nil
+	}
+
+	err := j.segment.Sync()
+	if err != nil {
+		return fmt.Errorf("can't sync journal segment: %w", err)
+	}
+	j.unsynced = 0
+
+	return nil
+}
+
+// Close syncs and closes the journal, the next calls do nothing.
+func (j *Journal) Close() error {
+	j.closeOnce.Do(func() { close(j.done) })
+	j.wg.Wait()
+
+	j.mx.Lock()
+	defer j.mx.Unlock()
+
+	if j.segment == nil {
+		return nil
+	}
+
+	err := j.sync()
+	if err != nil {
+		return err
+	}
+
+	err = j.segment.Close()
+	j.segment = nil
+
+	return err
+}
+
+// rotate closes the current segment and creates the next one which starts from the seq.
+func (j *Journal) rotate(seq uint64) error {
+	if j.segment != nil {
+		err := j.sync()
+		if err != nil {
+			return err
+		}
+
+		err = j.segment.Close()
+		if err != nil {
+			return fmt.Errorf("can't close journal segment: %w", err)
+		}
+	}
+
+	f, err := os.OpenFile(
+		filepath.Join(j.dir, segmentName(seq)),
+		os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND,
+		0o644,
+	)
+	if err != nil {
+		return fmt.Errorf("can't create journal segment: %w", err)
+	}
+
+	j.segment = f
+	j.segmentSize = 0
+
+	return nil
+}
+
+func (j *Journal) syncLoop() {
+	defer j.wg.Done()
+
+	ticker := time.NewTicker(j.cfg.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-ticker.C:
+			// there is nobody to report the error to, the next sync will try again.
+			_ = j.Sync()
+		}
+	}
+}
+
+// ReadJournal reads the commands with sequence number greater than fromSeq from the journal in the dir.
+func ReadJournal(dir string, fromSeq uint64, fn func(c Command) error) error {
+	segments, err := journalSegments(dir)
+	if err != nil {
+		return err
+	}
+
+	for i, s := range segments {
+		// the next segment starts before fromSeq - there is nothing needed in this one.
+		if i+1 < len(segments) && segments[i+1].firstSeq <= fromSeq+1 {
+			continue
+		}
+
+		err = readSegment(s.path, func(c Command) error {
+			if c.Seq <= fromSeq {
+				return nil
+			}
+
+			return fn(c)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// journalSegment is a segment file of the journal.
+type journalSegment struct {
+	path     string
+	firstSeq uint64
+}
+
+func segmentName(firstSeq uint64) string {
+	return fmt.Sprintf("%s%020d%s", journalSegmentPrefix, firstSeq, journalSegmentExt)
+}
+
+// journalSegments returns the segments in the dir sorted by sequence numbers.
+func journalSegments(dir string) ([]journalSegment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("can't read journal dir: %w", err)
+	}
+
+	segments := make([]journalSegment, 0)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, journalSegmentPrefix) || !strings.HasSuffix(name, journalSegmentExt) {
+			continue
+		}
+
+		var firstSeq uint64
+		_, err = fmt.Sscanf(strings.TrimPrefix(name, journalSegmentPrefix), "%020d", &firstSeq)
+		if err != nil {
+			return nil, fmt.Errorf("wrong journal segment name: %s", name)
+		}
+
+		segments = append(segments, journalSegment{
+			path:     filepath.Join(dir, name),
+			firstSeq: firstSeq,
+		})
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].firstSeq < segments[j].firstSeq
+	})
+
+	return segments, nil
+}
+
+// readSegment reads all records of the segment file.
+func readSegment(path string, fn func(c Command) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("can't open journal segment: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		c, _, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("can't read %s: %w", filepath.Base(path), err)
+		}
+
+		err = fn(c)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readRecord reads one record, it returns io.EOF if there are no more records and the size of the record.
+func readRecord(r io.Reader) (Command, int, error) {
+	header := make([]byte, recordHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err == io.EOF {
+		return Command{}, 0, io.EOF
+	}
+	if err != nil {
+		return Command{}, n, ErrCorruptedRecord
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size > maxRecordSize {
+		return Command{}, n, ErrCorruptedRecord
+	}
+
+	payload := make([]byte, size)
+	m, err := io.ReadFull(r, payload)
+	if err != nil {
+		return Command{}, n + m, ErrCorruptedRecord
+	}
+
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return Command{}, n + m, ErrCorruptedRecord
+	}
+
+	c, err := decodeCommand(payload)
+	if err != nil {
+		return Command{}, n + m, fmt.Errorf("%w: %v", ErrCorruptedRecord, err)
+	}
+
+	return c, n + m, nil
+}
+
+func encodeCommand(c Command) ([]byte, error) {
+	e := &encoder{}
+	e.uint64(c.Seq)
+	e.uint8(uint8(c.Type))
+	e.string(string(c.OrderID))
+	e.string(string(c.ReplacedOrderID))
+	e.string(string(c.AccountID))
+	e.string(string(c.Asset))
+	e.uint8(uint8(c.Side))
+	e.bool(c.AnySide)
+	e.decimal(c.Price)
+	e.decimal(c.Amount)
+	e.time(c.CreatedAt)
+	e.bool(c.Rejected)
+
+	return e.buf, nil
+}
+
+func decodeCommand(payload []byte) (Command, error) {
+	d := &decoder{buf: payload}
+
+	c := Command{
+		Seq:             d.uint64(),
+		Type:            CommandType(d.uint8()),
+		OrderID:         OrderID(d.string()),
+		ReplacedOrderID: OrderID(d.string()),
+		AccountID:       AccountID(d.string()),
+		Asset:           Asset(d.string()),
+		Side:            OperationType(d.uint8()),
+		AnySide:         d.bool(),
+		Price:           d.decimal(),
+		Amount:          d.decimal(),
+		CreatedAt:       d.time(),
+		Rejected:        d.bool(),
+	}
+
+	return c, d.finish()
+}
+
+// journal writes the command to the journal before the order book applies it.
+// It's called when the command passed all checks, so the replay applies it the same way.
+func (ob *OrderBook) journal(c Command) error {
+	if ob.Journal == nil {
+		return nil
+	}
+
+	seq, err := ob.Journal.Append(c)
+	if err != nil {
+		return fmt.Errorf("can't write %s to journal: %w", c.Type, err)
+	}
+	ob.seq = seq
+
+	return nil
+}
+
+// commit journals the command and applies the moves of the balances at once: the command is journaled
+// only if all moves can be applied and they are applied only if it's journaled.
+func (ob *OrderBook) commit(c Command, moves []balanceMove) error {
+	if ob.Accounts == nil || len(moves) == 0 {
+		return ob.journal(c)
+	}
+
+	return ob.Accounts.apply(moves, func() error {
+		return ob.journal(c)
+	})
+}
+
+// reject journals the command which is rejected by the order book and returns the error of the command.
+// The replay skips the rejected commands, so the recovered order book doesn't depend on the checks
+// which need Risk.
+func (ob *OrderBook) reject(c Command, err error) error {
+	c.Rejected = true
+
+	journalErr := ob.journal(c)
+	if journalErr != nil {
+		return fmt.Errorf("%w (%v)", err, journalErr)
+	}
+
+	return err
+}
+
+// repairJournal truncates the torn record at the end of the last segment, which is left by a crash in the middle of write.
+// It returns the number of the truncated bytes.
+func repairJournal(dir string) (int64, error) {
+	segments, err := journalSegments(dir)
+	if err != nil || len(segments) == 0 {
+		return 0, err
+	}
+
+	last := segments[len(segments)-1].path
+	f, err := os.Open(last)
+	if err != nil {
+		return 0, fmt.Errorf("can't open journal segment: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, fmt.Errorf("can't stat journal segment: %w", err)
+	}
+
+	var valid int64
+	r := bufio.NewReader(f)
+	for {
+		_, n, err := readRecord(r)
+		if err != nil {
+			// io.EOF or the torn record - everything after the last valid record is garbage.
+			break
+		}
+
+		valid += int64(n)
+	}
+	f.Close()
+
+	torn := info.Size() - valid
+	if torn == 0 {
+		return 0, nil
+	}
+
+	err = os.Truncate(last, valid)
+	if err != nil {
+		return 0, fmt.Errorf("can't truncate journal segment: %w", err)
+	}
+
+	return torn, nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/apd"
+)
+
+func Test_JournalCommands(t *testing.T) {
+	dir := t.TempDir()
+
+	j, err := OpenJournal(dir, JournalConfig{SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	ob := NewOrderBook("BTC", "USDT")
+	ob.Journal = j
+
+	for _, o := range testOrders() {
+		_, err = ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	_, _, err = ob.PlaceMarketOrder(context.Background(), &Order{
+		orderID:       "100500",
+		operationType: Bid,
+		amount:        apd.New(1, -1),
+		price:         apd.New(20050, 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	err = ob.Rollback(context.Background(), "100500")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	err = ob.Cancel(context.Background(), "3")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// the order doesn't exist - it's written as rejected.
+	err = ob.Cancel(context.Background(), "3")
+	if err == nil {
+		t.Fatalf("expected err, but got nil")
+	}
+
+	side := Bid
+	_, err = ob.CancelAll(context.Background(), "", &side)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	err = j.Close()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// the second close does nothing.
+	err = j.Close()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	commands := make([]Command, 0)
+	err = ReadJournal(dir, 0, func(c Command) error {
+		commands = append(commands, c)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if len(commands) != 19 {
+		t.Fatalf("expected 19 commands, but got: %d", len(commands))
+	}
+
+	for i, c := range commands {
+		if c.Seq != uint64(i+1) {
+			t.Fatalf("expected %d seq, but got: %d", i+1, c.Seq)
+		}
+	}
+
+	market := commands[14]
+	if market.Type != CommandPlaceMarket || market.OrderID != "100500" || market.Side != Bid ||
+		market.Price.String() != "20050" || market.Amount.String() != "0.1" || market.CreatedAt.IsZero() {
+		t.Fatalf("unexpected command: %+v", market)
+	}
+
+	for i, ct := range []CommandType{CommandRollback, CommandCancel, CommandCancel, CommandCancelAll} {
+		if commands[15+i].Type != ct || commands[15+i].Rejected != (i == 2) {
+			t.Fatalf("unexpected command: %+v", commands[15+i])
+		}
+	}
+
+	if commands[18].AnySide || commands[18].Side != Bid {
+		t.Fatalf("unexpected cancel all command: %+v", commands[18])
+	}
+
+	if ob.seq != 19 {
+		t.Fatalf("expected 19 seq in the order book, but got: %d", ob.seq)
+	}
+}
+
+func Test_JournalSegments(t *testing.T) {
+	dir := t.TempDir()
+
+	j, err := OpenJournal(dir, JournalConfig{SegmentSize: 256, SyncPolicy: SyncEveryN, SyncEvery: 3})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		_, err = j.Append(Command{
+			Type:      CommandPlaceLimit,
+			OrderID:   OrderID("order"),
+			Side:      Ask,
+			Price:     apd.New(20000, 0),
+			Amount:    apd.New(1, -1),
+			CreatedAt: time.Unix(1665000000, 0),
+		})
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	err = j.Close()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	segments, err := journalSegments(dir)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(segments) < 2 {
+		t.Fatalf("expected several segments, but got: %d", len(segments))
+	}
+
+	// the journal continues from the last record after reopening.
+	j, err = OpenJournal(dir, JournalConfig{SyncPolicy: SyncInterval, SyncInterval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	seq, err := j.Append(Command{Type: CommandCancel, OrderID: "order"})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if seq != 11 {
+		t.Fatalf("expected 11 seq, but got: %d", seq)
+	}
+
+	err = j.Close()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	seqs := make([]uint64, 0)
+	err = ReadJournal(dir, 7, func(c Command) error {
+		seqs = append(seqs, c.Seq)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(seqs) != 4 || seqs[0] != 8 || seqs[3] != 11 {
+		t.Fatalf("unexpected seqs: %v", seqs)
+	}
+
+	// let's break the checksum of the last record.
+	last := segments[len(segments)-1].path
+	data, err := os.ReadFile(last)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	data[len(data)-1] ^= 0xff
+	err = os.WriteFile(last, data, 0o644)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	err = ReadJournal(dir, 0, func(c Command) error { return nil })
+	if !errors.Is(err, ErrCorruptedRecord) {
+		t.Fatalf("expected corrupted record err, but got: %v", err)
+	}
+
+	_, err = OpenJournal(filepath.Join(dir, "new"), JournalConfig{SyncPolicy: SyncEveryN})
+	if err == nil {
+		t.Fatalf("expected err, but got nil")
+	}
+}
+
+func Test_RepairJournalLargeRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	j, err := OpenJournal(dir, JournalConfig{SyncPolicy: SyncNever})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		_, err = j.Append(Command{Type: CommandCancel, OrderID: "order"})
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+	err = j.Close()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// the torn header has a length of 4 GiB, it's found by the length before the payload is read.
+	segments, err := journalSegments(dir)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	last := segments[len(segments)-1].path
+	f, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	_, err = f.Write([]byte{0xff, 0xff, 0xff, 0xff, 1, 2, 3, 4, 5, 6})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	f.Close()
+
+	err = ReadJournal(dir, 0, func(c Command) error { return nil })
+	if !errors.Is(err, ErrCorruptedRecord) {
+		t.Fatalf("expected corrupted record err, but got: %v", err)
+	}
+
+	torn, err := repairJournal(dir)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if torn != 10 {
+		t.Fatalf("expected 10 truncated bytes, but got: %d", torn)
+	}
+
+	commands := 0
+	err = ReadJournal(dir, 0, func(c Command) error {
+		commands++
+		return nil
+	})
+	if err != nil || commands != 3 {
+		t.Fatalf("expected 3 commands, but got: %d, %v", commands, err)
+	}
+}
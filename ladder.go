@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+
+	"github.com/cockroachdb/apd"
+)
+
+// maxLadderTicks is a max number of the ticks of the price ladder, the ladder keeps a pointer of every tick.
+const maxLadderTicks = 1 << 20
+
+// PriceLadder is a bounded price range of the instrument with the known tick size.
+// The order book with the ladder keeps the price levels by a dense array of the ticks,
+// so the levels are found and added in O(1), and the best price is found by the bitmap of the ticks.
+// The limit orders with the prices off the ladder are rejected.
+type PriceLadder struct {
+	MinPrice *apd.Decimal
+	MaxPrice *apd.Decimal
+	TickSize *apd.Decimal
+}
+
+// String returns the string.
+func (pl PriceLadder) String() string {
+	return fmt.Sprintf("%s..%s by %s", pl.MinPrice, pl.MaxPrice, pl.TickSize)
+}
+
+// decimals returns the number of the decimals of the ladder.
+func (pl PriceLadder) decimals() int32 {
+	var decimals int32
+	for _, d := range []*apd.Decimal{pl.MinPrice, pl.MaxPrice, pl.TickSize} {
+		if -d.Exponent > decimals {
+			decimals = -d.Exponent
+		}
+	}
+
+	return decimals
+}
+
+// tickLadder is the storage of the price levels by the ticks of the ladder.
+type tickLadder struct {
+	ladder PriceLadder
+	// decimals are the decimals of the prices of the ticks: the ones of the fixed-point order book or of the ladder.
+	decimals int32
+	min      int64
+	tick     int64
+
+	levels []*OrdersBySpecificPrice
+	// bits has a bit of every tick with the level, words has a bit of every non-empty word of bits.
+	bits  []uint64
+	words []uint64
+	size  int
+}
+
+// newPriceLevels returns the empty storage of the price levels of the side by the mode of the order book.
+func newPriceLevels(fixed *FixedPoint, ladder *PriceLadder) (priceLevels, error) {
+	switch {
+	case ladder != nil:
+		return newTickLadder(*ladder, fixed)
+	case fixed != nil:
+		return newIndexLevels(compareFixedPrices), nil
+	default:
+		return newIndexLevels(comparePrices), nil
+	}
+}
+
+// newTickLadder creates a new instance of tickLadder, the ticks are scaled by the decimals of the fixed-point order book.
+func newTickLadder(pl PriceLadder, fixed *FixedPoint) (*tickLadder, error) {
+	if pl.MinPrice == nil || pl.MaxPrice == nil || pl.TickSize == nil {
+		return nil, fmt.Errorf("min price, max price and tick size of price ladder must be set")
+	}
+
+	l := &tickLadder{ladder: pl, decimals: pl.decimals()}
+	if fixed != nil {
+		l.decimals = fixed.PriceDecimals
+	}
+
+	var max int64
+	var err error
+	for _, p := range []struct {
+		v *int64
+		d *apd.Decimal
+	}{{&l.min, pl.MinPrice}, {&max, pl.MaxPrice}, {&l.tick, pl.TickSize}} {
+		*p.v, err = toFixed(p.d, l.decimals)
+		if err != nil {
+			return nil, fmt.Errorf("wrong price ladder: %s: %w", pl, err)
+		}
+	}
+
+	if l.tick <= 0 || max < l.min {
+		return nil, fmt.Errorf("wrong price ladder: %s: tick size must be positive and max price must be >= min price", pl)
+	}
+	if l.offset(max)/uint64(l.tick) >= maxLadderTicks {
+		return nil, fmt.Errorf("wrong price ladder: %s: more than %d ticks", pl, maxLadderTicks)
+	}
+
+	n := int(l.offset(max)/uint64(l.tick)) + 1
+	l.levels = make([]*OrdersBySpecificPrice, n)
+	l.bits = make([]uint64, (n+63)/64)
+	l.words = make([]uint64, (len(l.bits)+63)/64)
+
+	return l, nil
+}
+
+// value returns the price of the key scaled by the decimals of the ladder, ok is false if it doesn't fit them.
+func (l *tickLadder) value(key priceKey) (int64, bool) {
+	if key.d == nil {
+		return key.n, true
+	}
+
+	v, err := toFixed(key.d, l.decimals)
+
+	return v, err == nil
+}
+
+// offset returns the offset of the price from the min price, the price must not be less than it.
+func (l *tickLadder) offset(v int64) uint64 {
+	return uint64(v) - uint64(l.min)
+}
+
+// index returns the tick of the key, ok is false if the key isn't on the ladder.
+func (l *tickLadder) index(key priceKey) (int, bool) {
+	v, ok := l.value(key)
+	if !ok || v < l.min {
+		return 0, false
+	}
+
+	offset := l.offset(v)
+	if offset%uint64(l.tick) != 0 || offset/uint64(l.tick) >= uint64(len(l.levels)) {
+		return 0, false
+	}
+
+	return int(offset / uint64(l.tick)), true
+}
+
+// Len returns the number of the levels.
+func (l *tickLadder) Len() int {
+	return l.size
+}
+
+// Get returns the level of the key.
+func (l *tickLadder) Get(key priceKey) (*OrdersBySpecificPrice, bool) {
+	i, ok := l.index(key)
+	if !ok || l.levels[i] == nil {
+		return nil, false
+	}
+
+	return l.levels[i], true
+}
+
+// Put sets the level of the key, it fails if the key isn't on the ladder.
+func (l *tickLadder) Put(key priceKey, level *OrdersBySpecificPrice) error {
+	i, ok := l.index(key)
+	if !ok {
+		return newOrderError(ErrInvalidTick, "price %s isn't on the ladder %s", level.price, l.ladder)
+	}
+
+	if l.levels[i] == nil {
+		l.size++
+		w := i >> 6
+		l.bits[w] |= 1 << uint(i&63)
+		l.words[w>>6] |= 1 << uint(w&63)
+	}
+	l.levels[i] = level
+
+	return nil
+}
+
+// Remove removes the key, it returns false if there is no such key.
+func (l *tickLadder) Remove(key priceKey) bool {
+	i, ok := l.index(key)
+	if !ok || l.levels[i] == nil {
+		return false
+	}
+
+	l.size--
+	l.levels[i] = nil
+	w := i >> 6
+	l.bits[w] &^= 1 << uint(i&63)
+	if l.bits[w] == 0 {
+		l.words[w>>6] &^= 1 << uint(w&63)
+	}
+
+	return true
+}
+
+// Min returns the level of the min price.
+func (l *tickLadder) Min() *OrdersBySpecificPrice {
+	return l.level(l.next(0))
+}
+
+// Max returns the level of the max price.
+func (l *tickLadder) Max() *OrdersBySpecificPrice {
+	return l.level(l.prev(len(l.levels) - 1))
+}
+
+// Above returns the nearest level above the key, the price of the key must fit the decimals of the ladder.
+func (l *tickLadder) Above(key priceKey) *OrdersBySpecificPrice {
+	v, ok := l.value(key)
+	if !ok {
+		return nil
+	}
+	if v < l.min {
+		return l.Min()
+	}
+
+	i := l.offset(v) / uint64(l.tick)
+	if i >= uint64(len(l.levels)) {
+		return nil
+	}
+
+	return l.level(l.next(int(i) + 1))
+}
+
+// Below returns the nearest level below the key, the price of the key must fit the decimals of the ladder.
+func (l *tickLadder) Below(key priceKey) *OrdersBySpecificPrice {
+	v, ok := l.value(key)
+	if !ok || v < l.min {
+		return nil
+	}
+
+	offset := l.offset(v)
+	i := offset / uint64(l.tick)
+	if offset%uint64(l.tick) == 0 {
+		if i == 0 {
+			return nil
+		}
+		i--
+	}
+	if i >= uint64(len(l.levels)) {
+		i = uint64(len(l.levels) - 1)
+	}
+
+	return l.level(l.prev(int(i)))
+}
+
+// Values returns the levels from the min price.
+func (l *tickLadder) Values() []*OrdersBySpecificPrice {
+	values := make([]*OrdersBySpecificPrice, 0, l.size)
+	for i := l.next(0); i >= 0; i = l.next(i + 1) {
+		values = append(values, l.levels[i])
+	}
+
+	return values
+}
+
+// level returns the level of the tick or nil if the tick is -1.
+func (l *tickLadder) level(i int) *OrdersBySpecificPrice {
+	if i < 0 {
+		return nil
+	}
+
+	return l.levels[i]
+}
+
+// next returns the first tick from i with the level or -1.
+func (l *tickLadder) next(i int) int {
+	if i >= len(l.levels) {
+		return -1
+	}
+
+	w := i >> 6
+	if m := l.bits[w] >> uint(i&63); m != 0 {
+		return i + bits.TrailingZeros64(m)
+	}
+
+	w = l.nextWord(w + 1)
+	if w < 0 {
+		return -1
+	}
+
+	return w<<6 + bits.TrailingZeros64(l.bits[w])
+}
+
+// nextWord returns the first non-empty word of bits from w or -1.
+func (l *tickLadder) nextWord(w int) int {
+	if w >= len(l.bits) {
+		return -1
+	}
+
+	s := w >> 6
+	if m := l.words[s] >> uint(w&63); m != 0 {
+		return w + bits.TrailingZeros64(m)
+	}
+	for s++; s < len(l.words); s++ {
+		if l.words[s] != 0 {
+			return s<<6 + bits.TrailingZeros64(l.words[s])
+		}
+	}
+
+	return -1
+}
+
+// prev returns the last tick up to i with the level or -1.
+func (l *tickLadder) prev(i int) int {
+	if i < 0 {
+		return -1
+	}
+
+	w := i >> 6
+	if m := l.bits[w] << uint(63-i&63); m != 0 {
+		return i - bits.LeadingZeros64(m)
+	}
+
+	w = l.prevWord(w - 1)
+	if w < 0 {
+		return -1
+	}
+
+	return w<<6 + 63 - bits.LeadingZeros64(l.bits[w])
+}
+
+// prevWord returns the last non-empty word of bits up to w or -1.
+func (l *tickLadder) prevWord(w int) int {
+	if w < 0 {
+		return -1
+	}
+
+	s := w >> 6
+	if m := l.words[s] << uint(63-w&63); m != 0 {
+		return w - bits.LeadingZeros64(m)
+	}
+	for s--; s >= 0; s-- {
+		if l.words[s] != 0 {
+			return s<<6 + 63 - bits.LeadingZeros64(l.words[s])
+		}
+	}
+
+	return -1
+}
+
+// SetPriceLadder switches the order book to the tick ladder of the bounded instrument, the resting orders are moved to it.
+// Nothing is changed if the price of some order isn't on the ladder.
+func (ob *OrderBook) SetPriceLadder(ctx context.Context, ladder PriceLadder) error {
+	err := ob.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer ob.unlock()
+
+	var indexes [2]priceLevels
+	for i, side := range []*OrderSide{ob.Asks, ob.Bids} {
+		indexes[i], err = newPriceLevels(side.fixed, &ladder)
+		if err != nil {
+			return fmt.Errorf("can't set price ladder: %w", err)
+		}
+
+		for _, level := range side.levels() {
+			err = indexes[i].Put(side.key(level.price, level.fixedPrice), level)
+			if err != nil {
+				return fmt.Errorf("can't set price ladder: %w", err)
+			}
+		}
+	}
+
+	for i, side := range []*OrderSide{ob.Asks, ob.Bids} {
+		side.ladder = &ladder
+		side.index = indexes[i]
+	}
+
+	return nil
+}
+
+// checkTick checks that the price of the order is on the price ladder of the order book.
+func (ob *OrderBook) checkTick(o *Order) error {
+	l, ok := ob.Asks.index.(*tickLadder)
+	if !ok {
+		return nil
+	}
+
+	if _, ok := l.index(ob.Asks.key(o.price, o.fixedPrice)); !ok {
+		return newOrderError(ErrInvalidTick, "wrong price of order: %s: %s isn't on the ladder %s", o.orderID, o.price, l.ladder)
+	}
+
+	return nil
+}
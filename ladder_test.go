@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/cockroachdb/apd"
+)
+
+func Test_TickLadder(t *testing.T) {
+	l, err := newTickLadder(PriceLadder{
+		MinPrice: decimal(t, "100"), MaxPrice: decimal(t, "5099.5"), TickSize: decimal(t, "0.5"),
+	}, nil)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(l.levels) != 10000 || l.Min() != nil || l.Max() != nil {
+		t.Fatalf("unexpected ladder: %d", len(l.levels))
+	}
+
+	price := func(tick int) *apd.Decimal {
+		return apd.New(1000+int64(tick)*5, -1)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	ticks := map[int]*OrdersBySpecificPrice{}
+	for i := 0; i < 20000; i++ {
+		// the most of the ticks are near the min price, so the words of the bitmap become empty.
+		tick := rnd.Intn(300)
+		if rnd.Intn(10) == 0 {
+			tick = rnd.Intn(10000)
+		}
+
+		key := priceKey{d: price(tick)}
+		if rnd.Intn(2) == 0 {
+			if l.Remove(key) != (ticks[tick] != nil) {
+				t.Fatalf("unexpected remove of %d", tick)
+			}
+			delete(ticks, tick)
+
+			continue
+		}
+
+		level := NewOrdersBySpecificPrice(price(tick), decimal(t, "1"))
+		err = l.Put(key, level)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		ticks[tick] = level
+	}
+
+	sorted := make([]int, 0, len(ticks))
+	for tick := range ticks {
+		sorted = append(sorted, tick)
+	}
+	sort.Ints(sorted)
+
+	values := l.Values()
+	if l.Len() != len(sorted) || len(values) != len(sorted) {
+		t.Fatalf("expected %d levels, but got: %d, %d", len(sorted), l.Len(), len(values))
+	}
+	for i, tick := range sorted {
+		if values[i] != ticks[tick] {
+			t.Fatalf("unexpected level of %d: %s", tick, values[i].price)
+		}
+	}
+	if l.Min() != ticks[sorted[0]] || l.Max() != ticks[sorted[len(sorted)-1]] {
+		t.Fatalf("unexpected min or max: %s, %s", l.Min().price, l.Max().price)
+	}
+
+	for _, p := range []string{"50", "99.5", "100", "100.2", "150", "150.5", "2000", "5099.5", "5100", "9999"} {
+		d := decimal(t, p)
+		// the levels are sorted by price, so the nearest ones are found by the search.
+		i := sort.Search(len(values), func(i int) bool { return values[i].price.Cmp(d) > 0 })
+		var above, below *OrdersBySpecificPrice
+		if i < len(values) {
+			above = values[i]
+		}
+		j := sort.Search(len(values), func(i int) bool { return values[i].price.Cmp(d) >= 0 })
+		if j > 0 {
+			below = values[j-1]
+		}
+
+		if l.Above(priceKey{d: d}) != above || l.Below(priceKey{d: d}) != below {
+			t.Fatalf("unexpected levels near %s", p)
+		}
+	}
+
+	for _, p := range []string{"99.5", "100.2", "5100", "100.25"} {
+		err = l.Put(priceKey{d: decimal(t, p)}, NewOrdersBySpecificPrice(decimal(t, p), decimal(t, "1")))
+		if !errors.Is(err, ErrInvalidTick) {
+			t.Fatalf("unexpected err of %s: %v", p, err)
+		}
+	}
+
+	for _, tick := range sorted {
+		l.Remove(priceKey{d: price(tick)})
+	}
+	if l.Len() != 0 || l.Min() != nil || l.Max() != nil || len(l.Values()) != 0 {
+		t.Fatalf("expected empty ladder")
+	}
+}
+
+func Test_PriceLadderOrderBook(t *testing.T) {
+	ladder := PriceLadder{MinPrice: decimal(t, "90"), MaxPrice: decimal(t, "110"), TickSize: decimal(t, "0.05")}
+
+	for _, fixed := range []bool{false, true} {
+		iob, lob := NewOrderBook("BTC", "USDT"), NewOrderBook("BTC", "USDT")
+		if fixed {
+			for _, ob := range []*OrderBook{iob, lob} {
+				err := ob.SetFixedPoint(context.Background(), FixedPoint{PriceDecimals: 2, AmountDecimals: 2})
+				if err != nil {
+					t.Fatalf("unexpected err: %v", err)
+				}
+			}
+		}
+		err := lob.SetPriceLadder(context.Background(), ladder)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		rnd := rand.New(rand.NewSource(1))
+		var placed []OrderID
+		for i := 0; i < 2000; i++ {
+			if len(placed) > 0 && rnd.Intn(4) == 0 {
+				id := placed[rnd.Intn(len(placed))]
+				for _, ob := range []*OrderBook{iob, lob} {
+					err = ob.Cancel(context.Background(), id)
+					if err != nil && !errors.Is(err, ErrOrderNotFound) {
+						t.Fatalf("unexpected err: %v", err)
+					}
+				}
+
+				continue
+			}
+
+			// the prices are in the ticks of 0.05 around 100.
+			side, price := Ask, 2000+rnd.Intn(200)
+			if rnd.Intn(2) == 0 {
+				side, price = Bid, 2000-rnd.Intn(200)
+			}
+			id, amount := OrderID(strconv.Itoa(i)), int64(rnd.Intn(100)+1)
+			placed = append(placed, id)
+
+			var executed [2]int
+			for j, ob := range []*OrderBook{iob, lob} {
+				executed[j], err = ob.PlaceLimitOrder(context.Background(), &Order{
+					orderID:       id,
+					operationType: side,
+					amount:        apd.New(amount, -1),
+					price:         apd.New(int64(price)*5, -2),
+				})
+				if err != nil {
+					t.Fatalf("unexpected err: %v", err)
+				}
+			}
+			if executed[0] != executed[1] {
+				t.Fatalf("unexpected executions: %d, %d", executed[0], executed[1])
+			}
+		}
+
+		iAsks, iBids := iob.Asks.Depth(0), iob.Bids.Depth(0)
+		lAsks, lBids := lob.Asks.Depth(0), lob.Bids.Depth(0)
+		for _, levels := range [][2][]PriceLevel{{iAsks, lAsks}, {iBids, lBids}} {
+			if len(levels[0]) != len(levels[1]) || len(levels[0]) == 0 {
+				t.Fatalf("unexpected levels: %d, %d", len(levels[0]), len(levels[1]))
+			}
+			for i := range levels[0] {
+				a, b := levels[0][i], levels[1][i]
+				if a.Price.Cmp(b.Price) != 0 || a.Amount.Cmp(b.Amount) != 0 || a.Orders != b.Orders {
+					t.Fatalf("unexpected level: %+v, %+v", a, b)
+				}
+			}
+		}
+
+		for _, p := range []string{"89.95", "110.05", "100.01"} {
+			_, err = lob.PlaceLimitOrder(context.Background(), &Order{
+				orderID: OrderID(p), operationType: Bid, amount: decimal(t, "1"), price: decimal(t, p),
+			})
+			if !errors.Is(err, ErrInvalidTick) {
+				t.Fatalf("unexpected err of %s: %v", p, err)
+			}
+			if _, ok := lob.Orders[OrderID(p)]; ok {
+				t.Fatalf("order is added: %s", p)
+			}
+		}
+	}
+}
+
+func Test_SetPriceLadder(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	for _, o := range []*Order{
+		{orderID: "1", operationType: Ask, amount: decimal(t, "1"), price: decimal(t, "101")},
+		{orderID: "2", operationType: Bid, amount: decimal(t, "1"), price: decimal(t, "99.5")},
+	} {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	for _, ladder := range []PriceLadder{
+		{MinPrice: decimal(t, "90"), MaxPrice: decimal(t, "110"), TickSize: decimal(t, "1")},
+		{MinPrice: decimal(t, "100"), MaxPrice: decimal(t, "110"), TickSize: decimal(t, "0.5")},
+		{MinPrice: decimal(t, "110"), MaxPrice: decimal(t, "90"), TickSize: decimal(t, "0.5")},
+		{MinPrice: decimal(t, "90"), MaxPrice: decimal(t, "110"), TickSize: decimal(t, "0")},
+		{MinPrice: decimal(t, "0"), MaxPrice: decimal(t, "1000000"), TickSize: decimal(t, "0.5")},
+		{MinPrice: decimal(t, "90"), MaxPrice: decimal(t, "110")},
+	} {
+		err := ob.SetPriceLadder(context.Background(), ladder)
+		if err == nil {
+			t.Fatalf("expected err of %s", ladder)
+		}
+		if _, ok := ob.Asks.index.(indexLevels); !ok || ob.Asks.ladder != nil {
+			t.Fatalf("order book is changed")
+		}
+	}
+
+	err := ob.SetPriceLadder(context.Background(), PriceLadder{
+		MinPrice: decimal(t, "90"), MaxPrice: decimal(t, "110"), TickSize: decimal(t, "0.5"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// the fixed-point mode is set on the empty order book only.
+	err = ob.SetFixedPoint(context.Background(), FixedPoint{PriceDecimals: 2, AmountDecimals: 2})
+	if err == nil || ob.Bids.fixed != nil {
+		t.Fatalf("expected err")
+	}
+	for _, id := range []OrderID{"1", "2"} {
+		err = ob.Cancel(context.Background(), id)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	// the ladder is kept by the fixed-point order book, it can't be finer than the decimals.
+	err = ob.SetFixedPoint(context.Background(), FixedPoint{PriceDecimals: 0, AmountDecimals: 2})
+	if !errors.Is(err, ErrInvalidPrecision) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	err = ob.SetFixedPoint(context.Background(), FixedPoint{PriceDecimals: 2, AmountDecimals: 2})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if l, ok := ob.Bids.index.(*tickLadder); !ok || l.decimals != 2 || l.Len() != 0 {
+		t.Fatalf("unexpected index: %+v", ob.Bids.index)
+	}
+
+	for _, o := range []*Order{
+		{orderID: "1", operationType: Ask, amount: decimal(t, "1"), price: decimal(t, "101")},
+		{orderID: "2", operationType: Bid, amount: decimal(t, "1"), price: decimal(t, "99.5")},
+	} {
+		_, err = ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	_, err = ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID: "3", operationType: Bid, amount: decimal(t, "0.5"), price: decimal(t, "101.5"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	ask, bid, askOk, bidOk := ob.TopOfBook()
+	if !askOk || !bidOk || ask.Amount.Cmp(decimal(t, "0.5")) != 0 || bid.Price.Cmp(decimal(t, "99.5")) != 0 {
+		t.Fatalf("unexpected top of book: %+v, %+v", ask, bid)
+	}
+	if o := ob.OrdersDone["3"]; o == nil || len(o.executions) != 1 || o.executions[0].amount.Cmp(decimal(t, "0.5")) != 0 {
+		t.Fatalf("unexpected executions: %+v", o)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/apd"
+)
+
+// Posting is one leg of the ledger entry.
+// A positive amount is a debit (the account receives the Asset), a negative amount is a credit.
+type Posting struct {
+	Account AccountID
+	Asset   Asset
+	Amount  *apd.Decimal
+}
+
+// LedgerEntry is a balanced set of postings of one trade.
+type LedgerEntry struct {
+	// Seq is a sequence number of the entry in the ledger.
+	Seq uint64
+	// Instrument is an instrument of the trade, like BTC-USDT.
+	Instrument       string
+	InitiatorOrderID OrderID
+	ExecutorOrderID  OrderID
+	// Reversal is true when the entry cancels the trade by rollback.
+	Reversal  bool
+	Postings  []Posting
+	CreatedAt time.Time
+}
+
+// Ledger is an append-only double-entry ledger of the trades.
+// It can be shared between several order books.
+type Ledger struct {
+	entries []LedgerEntry
+	// balances are the sums of the postings by accounts.
+	balances map[AccountID]map[Asset]*apd.Decimal
+
+	mx sync.Mutex
+}
+
+// NewLedger creates a new instance of Ledger.
+func NewLedger() *Ledger {
+	return &Ledger{
+		entries:  make([]LedgerEntry, 0),
+		balances: map[AccountID]map[Asset]*apd.Decimal{},
+		mx:       sync.Mutex{},
+	}
+}
+
+// Append appends the entry to the ledger, the postings of every Asset must net to zero.
+func (l *Ledger) Append(entry LedgerEntry) (uint64, error) {
+	err := checkPostings(entry.Postings)
+	if err != nil {
+		return 0, err
+	}
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	return l.add(entry), nil
+}
+
+// appendEntries appends all entries to the ledger or nothing if the postings of some of them don't net to zero.
+func (l *Ledger) appendEntries(entries []LedgerEntry) error {
+	for _, entry := range entries {
+		err := checkPostings(entry.Postings)
+		if err != nil {
+			return err
+		}
+	}
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	for _, entry := range entries {
+		l.add(entry)
+	}
+
+	return nil
+}
+
+// add adds the checked entry to the ledger and returns its sequence number.
+func (l *Ledger) add(entry LedgerEntry) uint64 {
+	for _, p := range entry.Postings {
+		assets, ok := l.balances[p.Account]
+		if !ok {
+			assets = map[Asset]*apd.Decimal{}
+			l.balances[p.Account] = assets
+		}
+
+		b, ok := assets[p.Asset]
+		if !ok {
+			b = apd.New(0, 0)
+			assets[p.Asset] = b
+		}
+
+		// it can't fail - there is no rounding with BaseContext.
+		_, _ = apd.BaseContext.Add(b, b, p.Amount)
+	}
+
+	entry.Seq = uint64(len(l.entries)) + 1
+	l.entries = append(l.entries, entry)
+
+	return entry.Seq
+}
+
+// Entries returns the entries with sequence number greater than fromSeq.
+func (l *Ledger) Entries(fromSeq uint64) []LedgerEntry {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if fromSeq >= uint64(len(l.entries)) {
+		return nil
+	}
+
+	entries := make([]LedgerEntry, len(l.entries)-int(fromSeq))
+	copy(entries, l.entries[fromSeq:])
+
+	return entries
+}
+
+// Balance returns the sum of the postings of the account.
+func (l *Ledger) Balance(account AccountID, asset Asset) *apd.Decimal {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	b := apd.New(0, 0)
+	if v, ok := l.balances[account][asset]; ok {
+		b.Set(v)
+	}
+
+	return b
+}
+
+// Check checks that the totals of every Asset in the ledger net to zero.
+func (l *Ledger) Check() error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	totals := map[Asset]*apd.Decimal{}
+	for _, assets := range l.balances {
+		for asset, b := range assets {
+			total, ok := totals[asset]
+			if !ok {
+				total = apd.New(0, 0)
+				totals[asset] = total
+			}
+
+			_, err := apd.BaseContext.Add(total, total, b)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for asset, total := range totals {
+		if !total.IsZero() {
+			return fmt.Errorf("ledger is inconsistent: %s total is %s", asset, total)
+		}
+	}
+
+	return nil
+}
+
+// checkPostings checks that the postings of every Asset net to zero.
+func checkPostings(postings []Posting) error {
+	totals := map[Asset]*apd.Decimal{}
+	for _, p := range postings {
+		total, ok := totals[p.Asset]
+		if !ok {
+			total = apd.New(0, 0)
+			totals[p.Asset] = total
+		}
+
+		_, err := apd.BaseContext.Add(total, total, p.Amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	for asset, total := range totals {
+		if !total.IsZero() {
+			return fmt.Errorf("postings of %s aren't balanced: %s", asset, total)
+		}
+	}
+
+	return nil
+}
+
+// tradePostings creates the postings of the execution of the order:
+// BaseAsset goes from the seller to the buyer, QuoteAsset goes from the buyer to the seller and fees go to the fee account.
+func (ob *OrderBook) tradePostings(o *Order, e *ExecutionReport) ([]Posting, error) {
+	buyer, seller := e.initiatorAccountID, e.executorAccountID
+	if o.operationType == Ask {
+		buyer, seller = seller, buyer
+	}
+
+	quoteAmount := apd.New(0, 0)
+	_, err := apd.BaseContext.Mul(quoteAmount, e.price, e.amount)
+	if err != nil {
+		return nil, err
+	}
+
+	postings := []Posting{
+		{Account: buyer, Asset: ob.BaseAsset, Amount: apd.New(0, 0).Set(e.amount)},
+		{Account: seller, Asset: ob.BaseAsset, Amount: apd.New(0, 0).Neg(e.amount)},
+		{Account: seller, Asset: ob.QuoteAsset, Amount: apd.New(0, 0).Set(quoteAmount)},
+		{Account: buyer, Asset: ob.QuoteAsset, Amount: apd.New(0, 0).Neg(quoteAmount)},
+	}
+
+	if ob.Fees == nil {
+		return postings, nil
+	}
+
+	for _, fee := range []struct {
+		account AccountID
+		amount  *apd.Decimal
+	}{
+		{e.initiatorAccountID, e.takerFee},
+		{e.executorAccountID, e.makerFee},
+	} {
+		if fee.amount == nil || fee.amount.IsZero() {
+			continue
+		}
+
+		postings = append(postings,
+			Posting{Account: ob.Fees.FeeAccount, Asset: e.feeAsset, Amount: apd.New(0, 0).Set(fee.amount)},
+			Posting{Account: fee.account, Asset: e.feeAsset, Amount: apd.New(0, 0).Neg(fee.amount)},
+		)
+	}
+
+	return postings, nil
+}
+
+// reversalEntry returns the entry which cancels the trade of the execution with its fees at the time,
+// it's checked, so it can be appended.
+func (ob *OrderBook) reversalEntry(o *Order, e *ExecutionReport, at time.Time) (LedgerEntry, error) {
+	postings, err := ob.tradePostings(o, e)
+	if err != nil {
+		return LedgerEntry{}, err
+	}
+
+	for i := range postings {
+		postings[i].Amount.Neg(postings[i].Amount)
+	}
+
+	err = checkPostings(postings)
+	if err != nil {
+		return LedgerEntry{}, err
+	}
+
+	return LedgerEntry{
+		Instrument:       ob.Instrument(),
+		InitiatorOrderID: e.initiatorOrderID,
+		ExecutorOrderID:  e.executorOrderID,
+		Reversal:         true,
+		Postings:         postings,
+		CreatedAt:        at,
+	}, nil
+}
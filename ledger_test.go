@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/apd"
+)
+
+func Test_LedgerTrades(t *testing.T) {
+	ob, _ := testAccountsOrderBook(t)
+	ob.Fees = testFeeSchedule(t)
+	ob.Ledger = NewLedger()
+
+	for _, o := range []*Order{
+		{
+			orderID:       "1",
+			accountID:     "seller",
+			operationType: Ask,
+			amount:        apd.New(1, 0),
+			price:         apd.New(20000, 0),
+		},
+		{
+			orderID:       "2",
+			accountID:     "seller",
+			operationType: Ask,
+			amount:        apd.New(1, 0),
+			price:         apd.New(20100, 0),
+		},
+	} {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	_, err := ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID:       "3",
+		accountID:     "buyer",
+		operationType: Bid,
+		amount:        apd.New(15, -1),
+		price:         apd.New(20100, 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	entries := ob.Ledger.Entries(0)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, but got: %d", len(entries))
+	}
+
+	if entries[1].Seq != 2 || entries[1].ExecutorOrderID != "2" || len(entries[1].Postings) != 8 {
+		t.Fatalf("unexpected entry: %+v", entries[1])
+	}
+
+	for _, tc := range []struct {
+		account AccountID
+		asset   Asset
+		amount  string
+	}{
+		{"buyer", "BTC", "1.5"},
+		{"seller", "BTC", "-1.5"},
+		// 20000 + 10050 - fees of the seller.
+		{"seller", "USDT", "30019.95"},
+		// 20000 + 10050 + fees of the buyer.
+		{"buyer", "USDT", "-30110.10"},
+		{"fees", "USDT", "90.15"},
+	} {
+		b := ob.Ledger.Balance(tc.account, tc.asset)
+		if b.Cmp(decimal(t, tc.amount)) != 0 {
+			t.Fatalf("expected %s %s for %s, but got: %s", tc.amount, tc.asset, tc.account, b)
+		}
+	}
+
+	err = ob.Ledger.Check()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// the fees are reverted by rollback too.
+	err = ob.Rollback(context.Background(), "3")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	entries = ob.Ledger.Entries(2)
+	if len(entries) != 2 || !entries[0].Reversal || len(entries[0].Postings) != 8 {
+		t.Fatalf("unexpected reversal entries: %+v", entries)
+	}
+
+	for _, account := range []AccountID{"buyer", "seller", "fees"} {
+		for _, asset := range []Asset{"BTC", "USDT"} {
+			if b := ob.Ledger.Balance(account, asset); !b.IsZero() {
+				t.Fatalf("expected 0 %s for %s, but got: %s", asset, account, b)
+			}
+		}
+	}
+	checkBalance(t, ob.Accounts, "fees", "USDT", "0", "0")
+
+	err = ob.Ledger.Check()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+func Test_LedgerUnbalancedEntry(t *testing.T) {
+	l := NewLedger()
+
+	_, err := l.Append(LedgerEntry{
+		Postings: []Posting{
+			{Account: "buyer", Asset: "BTC", Amount: apd.New(1, 0)},
+			{Account: "seller", Asset: "BTC", Amount: apd.New(-1, 0)},
+			{Account: "seller", Asset: "USDT", Amount: apd.New(20000, 0)},
+			{Account: "buyer", Asset: "USDT", Amount: apd.New(-19999, 0)},
+		},
+	})
+	if err == nil || err.Error() != "postings of USDT aren't balanced: 1" {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if len(l.Entries(0)) != 0 {
+		t.Fatalf("expected no entries")
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+// levelIndexMaxHeight is a max number of the levels of the skip list, it's enough for 2^24 prices.
+const levelIndexMaxHeight = 24
+
+// levelIndexMaxFree is a max number of the removed nodes which are kept for the reuse.
+const levelIndexMaxFree = 256
+
+// levelIndex is an ordered index of the price levels: a skip list by the keys which are compared by cmp.
+// The first and the last nodes are the min and the max keys, so the best price is found in O(1),
+// the lookups, the inserts and the removes take O(log n). The nodes are linked back by the bottom level,
+// so the index is iterated from the both ends.
+type levelIndex[K, V any] struct {
+	cmp    func(a, b K) int
+	head   levelNode[K, V]
+	tail   *levelNode[K, V]
+	height int
+	size   int
+	// free are the removed nodes for the reuse.
+	free []*levelNode[K, V]
+	// rnd is a state of xorshift, the heights of the nodes don't depend on the global random source.
+	rnd uint64
+}
+
+// levelNode is a node of the skip list.
+type levelNode[K, V any] struct {
+	key   K
+	value V
+	prev  *levelNode[K, V]
+	next  []*levelNode[K, V]
+}
+
+// newLevelIndex creates a new instance of levelIndex ordered by cmp.
+func newLevelIndex[K, V any](cmp func(a, b K) int) *levelIndex[K, V] {
+	return &levelIndex[K, V]{
+		cmp:    cmp,
+		head:   levelNode[K, V]{next: make([]*levelNode[K, V], levelIndexMaxHeight)},
+		height: 1,
+		rnd:    0x9e3779b97f4a7c15,
+	}
+}
+
+// Len returns the number of the keys.
+func (idx *levelIndex[K, V]) Len() int {
+	return idx.size
+}
+
+// First returns the node of the min key or nil if the index is empty.
+func (idx *levelIndex[K, V]) First() *levelNode[K, V] {
+	return idx.head.next[0]
+}
+
+// Last returns the node of the max key or nil if the index is empty.
+func (idx *levelIndex[K, V]) Last() *levelNode[K, V] {
+	return idx.tail
+}
+
+// Next returns the node of the next key or nil.
+func (n *levelNode[K, V]) Next() *levelNode[K, V] {
+	return n.next[0]
+}
+
+// Prev returns the node of the previous key or nil.
+func (n *levelNode[K, V]) Prev() *levelNode[K, V] {
+	return n.prev
+}
+
+// Get returns the value of the key.
+func (idx *levelIndex[K, V]) Get(key K) (V, bool) {
+	x := &idx.head
+	for i := idx.height - 1; i >= 0; i-- {
+		for x.next[i] != nil && idx.cmp(x.next[i].key, key) < 0 {
+			x = x.next[i]
+		}
+	}
+
+	x = x.next[0]
+	if x != nil && idx.cmp(x.key, key) == 0 {
+		return x.value, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+// Put sets the value of the key.
+func (idx *levelIndex[K, V]) Put(key K, value V) {
+	var update [levelIndexMaxHeight]*levelNode[K, V]
+
+	x := &idx.head
+	for i := idx.height - 1; i >= 0; i-- {
+		for x.next[i] != nil && idx.cmp(x.next[i].key, key) < 0 {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+
+	if n := x.next[0]; n != nil && idx.cmp(n.key, key) == 0 {
+		n.value = value
+		return
+	}
+
+	height := idx.randomHeight()
+	for i := idx.height; i < height; i++ {
+		update[i] = &idx.head
+	}
+	if height > idx.height {
+		idx.height = height
+	}
+
+	n := idx.newNode(height)
+	n.key, n.value = key, value
+	for i := 0; i < height; i++ {
+		n.next[i] = update[i].next[i]
+		update[i].next[i] = n
+	}
+
+	if update[0] != &idx.head {
+		n.prev = update[0]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n
+	} else {
+		idx.tail = n
+	}
+	idx.size++
+}
+
+// Remove removes the key, it returns false if there is no such key.
+// The removed node keeps its links till the next Put, so the iteration can go on from it.
+func (idx *levelIndex[K, V]) Remove(key K) bool {
+	var update [levelIndexMaxHeight]*levelNode[K, V]
+
+	x := &idx.head
+	for i := idx.height - 1; i >= 0; i-- {
+		for x.next[i] != nil && idx.cmp(x.next[i].key, key) < 0 {
+			x = x.next[i]
+		}
+		update[i] = x
+	}
+
+	n := x.next[0]
+	if n == nil || idx.cmp(n.key, key) != 0 {
+		return false
+	}
+
+	for i := 0; i < len(n.next); i++ {
+		update[i].next[i] = n.next[i]
+	}
+	if n.next[0] != nil {
+		n.next[0].prev = n.prev
+	} else {
+		idx.tail = n.prev
+	}
+	for idx.height > 1 && idx.head.next[idx.height-1] == nil {
+		idx.height--
+	}
+	idx.size--
+
+	if len(idx.free) < levelIndexMaxFree {
+		idx.free = append(idx.free, n)
+	}
+
+	return true
+}
+
+// newNode returns the node of the height, the removed nodes are reused.
+func (idx *levelIndex[K, V]) newNode(height int) *levelNode[K, V] {
+	n := len(idx.free)
+	if n == 0 || cap(idx.free[n-1].next) < height {
+		return &levelNode[K, V]{next: make([]*levelNode[K, V], height)}
+	}
+
+	node := idx.free[n-1]
+	idx.free[n-1] = nil
+	idx.free = idx.free[:n-1]
+
+	node.next = node.next[:height]
+	for i := range node.next {
+		node.next[i] = nil
+	}
+	node.prev = nil
+
+	return node
+}
+
+// Above returns the node of the min key which is greater than the key or nil, the key can be absent.
+func (idx *levelIndex[K, V]) Above(key K) *levelNode[K, V] {
+	x := &idx.head
+	for i := idx.height - 1; i >= 0; i-- {
+		for x.next[i] != nil && idx.cmp(x.next[i].key, key) <= 0 {
+			x = x.next[i]
+		}
+	}
+
+	return x.next[0]
+}
+
+// Below returns the node of the max key which is less than the key or nil, the key can be absent.
+func (idx *levelIndex[K, V]) Below(key K) *levelNode[K, V] {
+	x := &idx.head
+	for i := idx.height - 1; i >= 0; i-- {
+		for x.next[i] != nil && idx.cmp(x.next[i].key, key) < 0 {
+			x = x.next[i]
+		}
+	}
+
+	if x == &idx.head {
+		return nil
+	}
+
+	return x
+}
+
+// Values returns the values from the min key.
+func (idx *levelIndex[K, V]) Values() []V {
+	values := make([]V, 0, idx.size)
+	for n := idx.First(); n != nil; n = n.Next() {
+		values = append(values, n.value)
+	}
+
+	return values
+}
+
+// randomHeight returns the height of a new node, every next level has the half of the nodes.
+func (idx *levelIndex[K, V]) randomHeight() int {
+	idx.rnd ^= idx.rnd << 13
+	idx.rnd ^= idx.rnd >> 7
+	idx.rnd ^= idx.rnd << 17
+
+	height := 1
+	for r := idx.rnd; height < levelIndexMaxHeight && r&1 == 1; r >>= 1 {
+		height++
+	}
+
+	return height
+}
+
+// priceLevels is a storage of the price levels of the side by the price keys.
+// It's the skip list by default or the tick ladder of the bounded instrument.
+type priceLevels interface {
+	Len() int
+	Get(key priceKey) (*OrdersBySpecificPrice, bool)
+	// Put fails if the storage can't keep the key.
+	Put(key priceKey, level *OrdersBySpecificPrice) error
+	Remove(key priceKey) bool
+	// Min and Max return the level of the min and the max price or nil.
+	Min() *OrdersBySpecificPrice
+	Max() *OrdersBySpecificPrice
+	// Above and Below return the nearest level above and below the key or nil, the key can be absent.
+	Above(key priceKey) *OrdersBySpecificPrice
+	Below(key priceKey) *OrdersBySpecificPrice
+	// Values returns the levels from the min price.
+	Values() []*OrdersBySpecificPrice
+}
+
+// indexLevels is the storage of the price levels by levelIndex.
+type indexLevels struct {
+	*levelIndex[priceKey, *OrdersBySpecificPrice]
+}
+
+// newIndexLevels creates a new instance of indexLevels ordered by cmp.
+func newIndexLevels(cmp func(a, b priceKey) int) indexLevels {
+	return indexLevels{newLevelIndex[priceKey, *OrdersBySpecificPrice](cmp)}
+}
+
+// Put sets the level of the key.
+func (l indexLevels) Put(key priceKey, level *OrdersBySpecificPrice) error {
+	l.levelIndex.Put(key, level)
+
+	return nil
+}
+
+// Min returns the level of the min price.
+func (l indexLevels) Min() *OrdersBySpecificPrice {
+	return nodeValue(l.First())
+}
+
+// Max returns the level of the max price.
+func (l indexLevels) Max() *OrdersBySpecificPrice {
+	return nodeValue(l.Last())
+}
+
+// Above returns the nearest level above the key.
+func (l indexLevels) Above(key priceKey) *OrdersBySpecificPrice {
+	return nodeValue(l.levelIndex.Above(key))
+}
+
+// Below returns the nearest level below the key.
+func (l indexLevels) Below(key priceKey) *OrdersBySpecificPrice {
+	return nodeValue(l.levelIndex.Below(key))
+}
+
+// nodeValue returns the level of the node or nil.
+func nodeValue(n *levelNode[priceKey, *OrdersBySpecificPrice]) *OrdersBySpecificPrice {
+	if n == nil {
+		return nil
+	}
+
+	return n.value
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func Test_LevelIndex(t *testing.T) {
+	cmp := func(a, b int) int { return a - b }
+	idx := newLevelIndex[int, string](cmp)
+	if idx.First() != nil || idx.Last() != nil || idx.Len() != 0 {
+		t.Fatalf("expected empty index")
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	keys := map[int]bool{}
+	for i := 0; i < 5000; i++ {
+		k := rnd.Intn(1000)
+		if rnd.Intn(3) == 0 {
+			if idx.Remove(k) != keys[k] {
+				t.Fatalf("unexpected remove of %d", k)
+			}
+			delete(keys, k)
+
+			continue
+		}
+
+		idx.Put(k, "v")
+		keys[k] = true
+	}
+
+	sorted := make([]int, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Ints(sorted)
+
+	if idx.Len() != len(sorted) {
+		t.Fatalf("expected %d keys, but got: %d", len(sorted), idx.Len())
+	}
+
+	i := 0
+	for n := idx.First(); n != nil; n = n.Next() {
+		if n.key != sorted[i] {
+			t.Fatalf("expected key %d, but got: %d", sorted[i], n.key)
+		}
+		i++
+	}
+
+	i = len(sorted) - 1
+	for n := idx.Last(); n != nil; n = n.Prev() {
+		if n.key != sorted[i] {
+			t.Fatalf("expected key %d, but got: %d", sorted[i], n.key)
+		}
+		i--
+	}
+	if i != -1 {
+		t.Fatalf("unexpected backward iteration: %d", i)
+	}
+
+	for k := 0; k < 1000; k++ {
+		if _, ok := idx.Get(k); ok != keys[k] {
+			t.Fatalf("unexpected get of %d", k)
+		}
+	}
+
+	for k := -1; k <= 1000; k++ {
+		i := sort.SearchInts(sorted, k+1)
+		if n := idx.Above(k); (i == len(sorted)) != (n == nil) || n != nil && n.key != sorted[i] {
+			t.Fatalf("unexpected key above %d", k)
+		}
+
+		i = sort.SearchInts(sorted, k) - 1
+		if n := idx.Below(k); (i < 0) != (n == nil) || n != nil && n.key != sorted[i] {
+			t.Fatalf("unexpected key below %d", k)
+		}
+	}
+
+	idx.Put(sorted[0], "w")
+	v, ok := idx.Get(sorted[0])
+	if !ok || v != "w" || idx.Len() != len(sorted) {
+		t.Fatalf("unexpected value: %s", v)
+	}
+
+	// the iteration goes on from the removed node.
+	first := idx.First()
+	idx.Remove(first.key)
+	if first.Next() != idx.First() || idx.First().Prev() != nil {
+		t.Fatalf("unexpected links after remove")
+	}
+
+	for _, k := range sorted {
+		idx.Remove(k)
+	}
+	if idx.First() != nil || idx.Last() != nil || idx.Len() != 0 || idx.height != 1 {
+		t.Fatalf("expected empty index")
+	}
+}
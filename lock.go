@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+)
+
+// ctxMutex is a mutex which can be waited till the context is done. It's a one-slot semaphore:
+// the mutex is locked while the slot is taken. It must be created by newCtxMutex.
+type ctxMutex chan struct{}
+
+// newCtxMutex creates an unlocked ctxMutex.
+func newCtxMutex() ctxMutex {
+	return make(ctxMutex, 1)
+}
+
+// Lock locks the mutex, it waits as long as it takes.
+func (m ctxMutex) Lock() {
+	m <- struct{}{}
+}
+
+// LockContext locks the mutex or returns ctx.Err() if the context is done before the mutex is locked.
+func (m ctxMutex) LockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case m <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Unlock unlocks the mutex.
+func (m ctxMutex) Unlock() {
+	select {
+	case <-m:
+	default:
+		panic("unlock of unlocked mutex")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/apd"
+)
+
+func Test_CtxMutex(t *testing.T) {
+	m := newCtxMutex()
+	m.Lock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := m.LockContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline err, but got: %v", err)
+	}
+
+	// it's unlocked - the waiter gets it.
+	locked := make(chan error)
+	go func() {
+		locked <- m.LockContext(context.Background())
+	}()
+	m.Unlock()
+	err = <-locked
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	m.Unlock()
+
+	err = m.LockContext(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	m.Unlock()
+}
+
+func Test_OrderBookContext(t *testing.T) {
+	ob, accounts := testAccountsOrderBook(t)
+	o := func(id OrderID) *Order {
+		return &Order{orderID: id, accountID: "buyer", operationType: Bid, amount: apd.New(1, 0), price: apd.New(100, 0)}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := ob.PlaceLimitOrder(ctx, o("1"))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled err, but got: %v", err)
+	}
+
+	_, err = ob.OpenOrders(ctx, "buyer", OrderFilter{})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled err, but got: %v", err)
+	}
+
+	// the order book is busy - the requests fail by the deadline.
+	ob.mx.Lock()
+	deadlineCtx, deadlineCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer deadlineCancel()
+	_, err = ob.Apply(deadlineCtx, Command{
+		Type: CommandPlaceLimit, OrderID: "2", AccountID: "buyer", Side: Bid, Price: apd.New(100, 0), Amount: apd.New(1, 0),
+	})
+	_, statusErr := ob.OrderStatus(deadlineCtx, "2")
+	ob.mx.Unlock()
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(statusErr, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline errs, but got: %v, %v", err, statusErr)
+	}
+
+	if len(ob.Orders) != 0 || len(ob.OrdersDone) != 0 {
+		t.Fatalf("expected no orders, but got: %d, %d", len(ob.Orders), len(ob.OrdersDone))
+	}
+	if reserved := accounts.Balance("buyer", "USDT").Reserved; !reserved.IsZero() {
+		t.Fatalf("expected nothing reserved, but got: %s", reserved)
+	}
+
+	_, err = ob.PlaceLimitOrder(context.Background(), o("3"))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	_, err = ob.Bids.AddOrder(ctx, o("4"))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected canceled err, but got: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const usage = `usage: matching-engine <command> [arguments]
+
+commands:
+  replay    feeds a JSONL command file through the order book
+  repl      runs the interactive shell of the in-memory order books, the script is read from stdin or the file
+  serve     runs the HTTP/JSON, WebSocket, gRPC, FIX APIs and the binary feed of the order books
+`
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		stop()
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "replay":
+		return runReplay(ctx, args[1:], stdin, stdout)
+	case "repl":
+		return runREPL(ctx, args[1:], stdin, stdout)
+	case "serve":
+		return runServe(ctx, args[1:], stdout)
+	default:
+		return fmt.Errorf("unknown command: %s\n%s", args[0], usage)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/cockroachdb/apd"
+)
+
+// defaultMarketDataBuffer is a default number of the messages which are buffered for the subscriber.
+const defaultMarketDataBuffer = 1024
+
+// ErrSubscriptionClosed is returned when the market data subscription is closed.
+var ErrSubscriptionClosed = errors.New("subscription is closed")
+
+// MarketDataMessage is a message of the market data feed: *DepthSnapshot, *LevelUpdate, *TradeUpdate or *SequenceGap.
+type MarketDataMessage interface {
+	// Sequence returns the sequence number of the message in the order book feed.
+	Sequence() uint64
+}
+
+// DepthSnapshot is a full depth of the order book, Seq is a sequence number of the last update in it,
+// so the next update has Seq+1. Levels are from the best price.
+type DepthSnapshot struct {
+	Type       string       `json:"type"`
+	Instrument string       `json:"instrument"`
+	Seq        uint64       `json:"seq"`
+	Asks       []depthLevel `json:"asks"`
+	Bids       []depthLevel `json:"bids"`
+}
+
+// Sequence returns the sequence number.
+func (m *DepthSnapshot) Sequence() uint64 {
+	return m.Seq
+}
+
+// LevelUpdate is a new state of the price level, zero amount means that the level is removed.
+type LevelUpdate struct {
+	Type       string       `json:"type"`
+	Instrument string       `json:"instrument"`
+	Seq        uint64       `json:"seq"`
+	Side       string       `json:"side"`
+	Price      *apd.Decimal `json:"price"`
+	Amount     *apd.Decimal `json:"amount"`
+	Orders     int          `json:"orders"`
+}
+
+// Sequence returns the sequence number.
+func (m *LevelUpdate) Sequence() uint64 {
+	return m.Seq
+}
+
+// TradeUpdate is a trade in the order book, TakerSide is a side of the initiator order.
+type TradeUpdate struct {
+	Type             string       `json:"type"`
+	Instrument       string       `json:"instrument"`
+	Seq              uint64       `json:"seq"`
+	TakerSide        string       `json:"taker_side"`
+	Price            *apd.Decimal `json:"price"`
+	Amount           *apd.Decimal `json:"amount"`
+	InitiatorOrderID OrderID      `json:"initiator_order_id"`
+	ExecutorOrderID  OrderID      `json:"executor_order_id"`
+}
+
+// Sequence returns the sequence number.
+func (m *TradeUpdate) Sequence() uint64 {
+	return m.Seq
+}
+
+// SequenceGap is sent to the slow subscriber instead of the dropped updates, Seq is a sequence number
+// of the first dropped one. The updates after it aren't sent, the subscriber should request a new snapshot by Resync.
+type SequenceGap struct {
+	Type       string `json:"type"`
+	Instrument string `json:"instrument"`
+	Seq        uint64 `json:"seq"`
+}
+
+// Sequence returns the sequence number.
+func (m *SequenceGap) Sequence() uint64 {
+	return m.Seq
+}
+
+// MarketDataFeed is a feed of the order book: every level change and trade gets the next sequence number
+// of the order book. A subscriber starts with the snapshot and applies the updates after it,
+// if it gets SequenceGap - it should request a new snapshot by Resync.
+type MarketDataFeed struct {
+	seq         uint64
+	subscribers map[*MarketDataSubscription]struct{}
+
+	mx sync.Mutex
+}
+
+// NewMarketDataFeed creates a new instance of MarketDataFeed.
+func NewMarketDataFeed() *MarketDataFeed {
+	return &MarketDataFeed{
+		subscribers: map[*MarketDataSubscription]struct{}{},
+		mx:          sync.Mutex{},
+	}
+}
+
+// MarketDataSubscription is a subscription to the feed of the order book.
+type MarketDataSubscription struct {
+	ob *OrderBook
+	ch chan MarketDataMessage
+	// lagging is true when the messages were dropped, SequenceGap is sent and the updates are dropped until Resync.
+	lagging bool
+	closed  bool
+}
+
+// Messages returns the channel of the messages, it's closed by Close.
+func (s *MarketDataSubscription) Messages() <-chan MarketDataMessage {
+	return s.ch
+}
+
+// Lagging returns true if the updates were dropped and the subscriber should Resync.
+// The last message of the lagging subscriber is SequenceGap, so the subscriber can check it when it reads all messages.
+func (s *MarketDataSubscription) Lagging() bool {
+	feed := s.ob.MarketData
+	feed.mx.Lock()
+	defer feed.mx.Unlock()
+
+	return s.lagging
+}
+
+// Resync drops the buffered messages and sends a new snapshot, the updates continue after it.
+func (s *MarketDataSubscription) Resync() error {
+	_ = s.ob.lock(context.Background())
+	defer s.ob.release()
+
+	feed := s.ob.MarketData
+	feed.mx.Lock()
+	defer feed.mx.Unlock()
+
+	if s.closed {
+		return ErrSubscriptionClosed
+	}
+
+	for len(s.ch) > 0 {
+		select {
+		case <-s.ch:
+		default:
+		}
+	}
+
+	s.ch <- s.ob.depthSnapshot()
+	s.lagging = false
+
+	return nil
+}
+
+// Close stops the subscription and closes the channel of the messages.
+func (s *MarketDataSubscription) Close() {
+	feed := s.ob.MarketData
+	feed.mx.Lock()
+	defer feed.mx.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	delete(feed.subscribers, s)
+	close(s.ch)
+}
+
+// SubscribeMarketData subscribes to the market data feed of the order book, the first message is the snapshot.
+// If the subscriber doesn't read the messages and buffer messages are waiting,
+// it gets SequenceGap instead of the next update and the updates are dropped until Resync.
+func (ob *OrderBook) SubscribeMarketData(buffer int) (*MarketDataSubscription, error) {
+	_ = ob.lock(context.Background())
+	defer ob.release()
+
+	feed := ob.MarketData
+	if feed == nil {
+		return nil, errors.New("order book has no market data feed")
+	}
+	if buffer <= 0 {
+		buffer = defaultMarketDataBuffer
+	}
+
+	s := &MarketDataSubscription{
+		ob: ob,
+		// one more for the snapshot and one for the gap.
+		ch: make(chan MarketDataMessage, buffer+2),
+	}
+	s.ch <- ob.depthSnapshot()
+
+	feed.mx.Lock()
+	feed.subscribers[s] = struct{}{}
+	feed.mx.Unlock()
+
+	return s, nil
+}
+
+// depthSnapshot returns the snapshot of the depth, it must be called under the lock of the order book.
+func (ob *OrderBook) depthSnapshot() *DepthSnapshot {
+	return &DepthSnapshot{
+		Type:       "snapshot",
+		Instrument: ob.Instrument(),
+		Seq:        ob.MarketData.seq,
+		Asks:       depthLevels(ob.Asks.Depth(0)),
+		Bids:       depthLevels(ob.Bids.Depth(0)),
+	}
+}
+
+// subscribersCount returns the number of the subscribers.
+func (f *MarketDataFeed) subscribersCount() int {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	return len(f.subscribers)
+}
+
+// publish sends the message with the next sequence number to the subscribers.
+// The messages are sent under the lock of the feed, so the last place in the channel is kept for the gap.
+func (f *MarketDataFeed) publish(instrument string, m MarketDataMessage) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	for s := range f.subscribers {
+		if s.lagging {
+			continue
+		}
+
+		if len(s.ch) < cap(s.ch)-1 {
+			s.ch <- m
+			continue
+		}
+
+		// the subscriber is too slow - it gets the gap and resyncs.
+		s.ch <- &SequenceGap{Type: "gap", Instrument: instrument, Seq: m.Sequence()}
+		s.lagging = true
+	}
+}
+
+// marketDataListener publishes the level changes and the trades of the order book to its market data feed.
+type marketDataListener struct {
+	NopEventListener
+
+	ob *OrderBook
+}
+
+// LevelChanged publishes the change of the price level.
+func (l marketDataListener) LevelChanged(e LevelChanged) {
+	feed := l.ob.MarketData
+	feed.seq++
+	feed.publish(l.ob.Instrument(), &LevelUpdate{
+		Type:       "level",
+		Instrument: l.ob.Instrument(),
+		Seq:        feed.seq,
+		Side:       e.Side.String(),
+		Price:      e.Level.Price,
+		Amount:     e.Level.Amount,
+		Orders:     e.Level.Orders,
+	})
+}
+
+// OrderPartiallyFilled publishes the trade by the fill of the maker.
+func (l marketDataListener) OrderPartiallyFilled(e OrderFill) {
+	l.publishTrade(e)
+}
+
+// OrderFilled publishes the trade by the fill of the maker.
+func (l marketDataListener) OrderFilled(e OrderFill) {
+	l.publishTrade(e)
+}
+
+// publishTrade publishes the trade, every execution has the fills of the maker and the taker,
+// the trade is published by the maker one.
+func (l marketDataListener) publishTrade(e OrderFill) {
+	if !e.Maker {
+		return
+	}
+
+	feed := l.ob.MarketData
+	feed.seq++
+	feed.publish(l.ob.Instrument(), &TradeUpdate{
+		Type:             "trade",
+		Instrument:       l.ob.Instrument(),
+		Seq:              feed.seq,
+		TakerSide:        opposite(e.Side).String(),
+		Price:            e.Price,
+		Amount:           e.Amount,
+		InitiatorOrderID: e.CounterOrderID,
+		ExecutorOrderID:  e.OrderID,
+	})
+}
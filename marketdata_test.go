@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// applyMarketData applies the messages to the depth by prices and checks the sequence numbers.
+func applyMarketData(t *testing.T, depth map[string]string, seq uint64, messages ...MarketDataMessage) uint64 {
+	t.Helper()
+
+	for _, m := range messages {
+		switch m := m.(type) {
+		case *DepthSnapshot:
+			for k := range depth {
+				delete(depth, k)
+			}
+			for _, l := range m.Asks {
+				depth["ask "+l.Price.String()] = l.Amount.String()
+			}
+			for _, l := range m.Bids {
+				depth["bid "+l.Price.String()] = l.Amount.String()
+			}
+
+			seq = m.Seq
+			continue
+
+		case *LevelUpdate:
+			key := m.Side + " " + m.Price.String()
+			if m.Amount.IsZero() {
+				delete(depth, key)
+			} else {
+				depth[key] = m.Amount.String()
+			}
+		}
+
+		if m.Sequence() != seq+1 {
+			t.Fatalf("expected %d seq, but got: %d", seq+1, m.Sequence())
+		}
+		seq = m.Sequence()
+	}
+
+	return seq
+}
+
+func receiveMarketData(t *testing.T, sub *MarketDataSubscription) []MarketDataMessage {
+	t.Helper()
+
+	messages := make([]MarketDataMessage, 0)
+	for {
+		select {
+		case m := <-sub.Messages():
+			messages = append(messages, m)
+		default:
+			return messages
+		}
+	}
+}
+
+func checkMarketDataDepth(t *testing.T, ob *OrderBook, depth map[string]string) {
+	t.Helper()
+
+	asks, bids := ob.Depth(context.Background(), 0)
+	if len(asks)+len(bids) != len(depth) {
+		t.Fatalf("expected %d levels, but got: %d", len(asks)+len(bids), len(depth))
+	}
+	for side, levels := range map[string][]PriceLevel{"ask": asks, "bid": bids} {
+		for _, l := range levels {
+			if depth[side+" "+l.Price.String()] != l.Amount.String() {
+				t.Fatalf("expected %s for %s %s, but got: %s", l.Amount, side, l.Price, depth[side+" "+l.Price.String()])
+			}
+		}
+	}
+}
+
+func Test_MarketDataFeed(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	ob.MarketData = NewMarketDataFeed()
+	for _, o := range testOrders()[:5] {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	sub, err := ob.SubscribeMarketData(0)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer sub.Close()
+
+	depth := map[string]string{}
+	seq := applyMarketData(t, depth, 0, receiveMarketData(t, sub)...)
+	if seq != 5 {
+		t.Fatalf("expected %d seq, but got: %d", 5, seq)
+	}
+	checkMarketDataDepth(t, ob, depth)
+
+	for _, o := range testOrders()[5:] {
+		_, err = ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	o := &Order{orderID: "100500", operationType: Bid, price: decimal(t, "20100"), amount: decimal(t, "2.5")}
+	_, _, err = ob.PlaceMarketOrder(context.Background(), o)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	err = ob.Cancel(context.Background(), "44")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	err = ob.Rollback(context.Background(), "100500")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	messages := receiveMarketData(t, sub)
+	trades := 0
+	for _, m := range messages {
+		if trade, ok := m.(*TradeUpdate); ok {
+			trades++
+			if trade.TakerSide != "bid" || trade.InitiatorOrderID != "100500" {
+				t.Fatalf("wrong trade: %+v", trade)
+			}
+		}
+	}
+	if trades != len(o.executions) {
+		t.Fatalf("expected %d trades, but got: %d", len(o.executions), trades)
+	}
+
+	applyMarketData(t, depth, seq, messages...)
+	checkMarketDataDepth(t, ob, depth)
+}
+
+func Test_MarketDataResync(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	ob.MarketData = NewMarketDataFeed()
+
+	sub, err := ob.SubscribeMarketData(2)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// the subscriber doesn't read - it gets the gap after the buffer and the next updates are dropped.
+	for _, o := range testOrders() {
+		_, err = ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	messages := receiveMarketData(t, sub)
+	if len(messages) != 4 || messages[2].Sequence() != 2 {
+		t.Fatalf("expected snapshot, 2 updates and gap, but got: %d", len(messages))
+	}
+	if gap, ok := messages[3].(*SequenceGap); !ok || gap.Seq != 3 || gap.Type != "gap" || gap.Instrument != "BTC-USDT" {
+		t.Fatalf("expected gap, but got: %+v", messages[3])
+	}
+	if !sub.Lagging() {
+		t.Fatalf("expected lagging subscriber")
+	}
+
+	_, err = ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID: "100500", operationType: Ask, price: decimal(t, "30000"), amount: decimal(t, "1"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(receiveMarketData(t, sub)) != 0 {
+		t.Fatalf("expected no updates until resync")
+	}
+
+	err = sub.Resync()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	err = ob.Cancel(context.Background(), "100500")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	depth := map[string]string{}
+	messages = receiveMarketData(t, sub)
+	seq := applyMarketData(t, depth, 0, messages...)
+	if len(messages) != 2 || seq != ob.MarketData.seq {
+		t.Fatalf("expected snapshot and update, but got: %d", len(messages))
+	}
+	checkMarketDataDepth(t, ob, depth)
+
+	sub.Close()
+	if _, ok := <-sub.Messages(); ok {
+		t.Fatalf("expected closed channel")
+	}
+	err = sub.Resync()
+	if err != ErrSubscriptionClosed {
+		t.Fatalf("expected %v, but got: %v", ErrSubscriptionClosed, err)
+	}
+
+	_, err = NewOrderBook("BTC", "USDT").SubscribeMarketData(0)
+	if err == nil {
+		t.Fatalf("expected err, but got nil")
+	}
+}
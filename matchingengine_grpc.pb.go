@@ -0,0 +1,433 @@
+// The gRPC API of the matching engine.
+// The Go code is generated by protoc-gen-go and protoc-gen-go-grpc, see go:generate in grpc.go.
+// Decimals are strings, so they keep the exact precision.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.5.1-go
+// source: matchingengine.proto
+
+package main
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	MatchingEngine_PlaceLimitOrder_FullMethodName  = "/matchingengine.v1.MatchingEngine/PlaceLimitOrder"
+	MatchingEngine_PlaceMarketOrder_FullMethodName = "/matchingengine.v1.MatchingEngine/PlaceMarketOrder"
+	MatchingEngine_Cancel_FullMethodName           = "/matchingengine.v1.MatchingEngine/Cancel"
+	MatchingEngine_Rollback_FullMethodName         = "/matchingengine.v1.MatchingEngine/Rollback"
+	MatchingEngine_GetOrder_FullMethodName         = "/matchingengine.v1.MatchingEngine/GetOrder"
+	MatchingEngine_GetDepth_FullMethodName         = "/matchingengine.v1.MatchingEngine/GetDepth"
+	MatchingEngine_SubscribeTrades_FullMethodName  = "/matchingengine.v1.MatchingEngine/SubscribeTrades"
+	MatchingEngine_SubscribeBook_FullMethodName    = "/matchingengine.v1.MatchingEngine/SubscribeBook"
+)
+
+// MatchingEngineClient is the client API for MatchingEngine service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MatchingEngineClient interface {
+	PlaceLimitOrder(ctx context.Context, in *PlaceOrderRequest, opts ...grpc.CallOption) (*PlaceOrderResponse, error)
+	PlaceMarketOrder(ctx context.Context, in *PlaceOrderRequest, opts ...grpc.CallOption) (*PlaceOrderResponse, error)
+	Cancel(ctx context.Context, in *OrderRequest, opts ...grpc.CallOption) (*Empty, error)
+	Rollback(ctx context.Context, in *OrderRequest, opts ...grpc.CallOption) (*Empty, error)
+	GetOrder(ctx context.Context, in *OrderRequest, opts ...grpc.CallOption) (*OrderState, error)
+	GetDepth(ctx context.Context, in *DepthRequest, opts ...grpc.CallOption) (*BookDepth, error)
+	// SubscribeTrades streams the trades of the order book.
+	SubscribeTrades(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (MatchingEngine_SubscribeTradesClient, error)
+	// SubscribeBook streams the depth snapshot and then the level updates and the trades.
+	// The sequence numbers are contiguous, after a gap the server sends a new snapshot.
+	SubscribeBook(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (MatchingEngine_SubscribeBookClient, error)
+}
+
+type matchingEngineClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMatchingEngineClient(cc grpc.ClientConnInterface) MatchingEngineClient {
+	return &matchingEngineClient{cc}
+}
+
+func (c *matchingEngineClient) PlaceLimitOrder(ctx context.Context, in *PlaceOrderRequest, opts ...grpc.CallOption) (*PlaceOrderResponse, error) {
+	out := new(PlaceOrderResponse)
+	err := c.cc.Invoke(ctx, MatchingEngine_PlaceLimitOrder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchingEngineClient) PlaceMarketOrder(ctx context.Context, in *PlaceOrderRequest, opts ...grpc.CallOption) (*PlaceOrderResponse, error) {
+	out := new(PlaceOrderResponse)
+	err := c.cc.Invoke(ctx, MatchingEngine_PlaceMarketOrder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchingEngineClient) Cancel(ctx context.Context, in *OrderRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, MatchingEngine_Cancel_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchingEngineClient) Rollback(ctx context.Context, in *OrderRequest, opts ...grpc.CallOption) (*Empty, error) {
+	out := new(Empty)
+	err := c.cc.Invoke(ctx, MatchingEngine_Rollback_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchingEngineClient) GetOrder(ctx context.Context, in *OrderRequest, opts ...grpc.CallOption) (*OrderState, error) {
+	out := new(OrderState)
+	err := c.cc.Invoke(ctx, MatchingEngine_GetOrder_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchingEngineClient) GetDepth(ctx context.Context, in *DepthRequest, opts ...grpc.CallOption) (*BookDepth, error) {
+	out := new(BookDepth)
+	err := c.cc.Invoke(ctx, MatchingEngine_GetDepth_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *matchingEngineClient) SubscribeTrades(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (MatchingEngine_SubscribeTradesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MatchingEngine_ServiceDesc.Streams[0], MatchingEngine_SubscribeTrades_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &matchingEngineSubscribeTradesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MatchingEngine_SubscribeTradesClient interface {
+	Recv() (*TradeEvent, error)
+	grpc.ClientStream
+}
+
+type matchingEngineSubscribeTradesClient struct {
+	grpc.ClientStream
+}
+
+func (x *matchingEngineSubscribeTradesClient) Recv() (*TradeEvent, error) {
+	m := new(TradeEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *matchingEngineClient) SubscribeBook(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (MatchingEngine_SubscribeBookClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MatchingEngine_ServiceDesc.Streams[1], MatchingEngine_SubscribeBook_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &matchingEngineSubscribeBookClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MatchingEngine_SubscribeBookClient interface {
+	Recv() (*BookUpdate, error)
+	grpc.ClientStream
+}
+
+type matchingEngineSubscribeBookClient struct {
+	grpc.ClientStream
+}
+
+func (x *matchingEngineSubscribeBookClient) Recv() (*BookUpdate, error) {
+	m := new(BookUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// MatchingEngineServer is the server API for MatchingEngine service.
+// All implementations must embed UnimplementedMatchingEngineServer
+// for forward compatibility
+type MatchingEngineServer interface {
+	PlaceLimitOrder(context.Context, *PlaceOrderRequest) (*PlaceOrderResponse, error)
+	PlaceMarketOrder(context.Context, *PlaceOrderRequest) (*PlaceOrderResponse, error)
+	Cancel(context.Context, *OrderRequest) (*Empty, error)
+	Rollback(context.Context, *OrderRequest) (*Empty, error)
+	GetOrder(context.Context, *OrderRequest) (*OrderState, error)
+	GetDepth(context.Context, *DepthRequest) (*BookDepth, error)
+	// SubscribeTrades streams the trades of the order book.
+	SubscribeTrades(*SubscribeRequest, MatchingEngine_SubscribeTradesServer) error
+	// SubscribeBook streams the depth snapshot and then the level updates and the trades.
+	// The sequence numbers are contiguous, after a gap the server sends a new snapshot.
+	SubscribeBook(*SubscribeRequest, MatchingEngine_SubscribeBookServer) error
+	mustEmbedUnimplementedMatchingEngineServer()
+}
+
+// UnimplementedMatchingEngineServer must be embedded to have forward compatible implementations.
+type UnimplementedMatchingEngineServer struct {
+}
+
+func (UnimplementedMatchingEngineServer) PlaceLimitOrder(context.Context, *PlaceOrderRequest) (*PlaceOrderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PlaceLimitOrder not implemented")
+}
+func (UnimplementedMatchingEngineServer) PlaceMarketOrder(context.Context, *PlaceOrderRequest) (*PlaceOrderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PlaceMarketOrder not implemented")
+}
+func (UnimplementedMatchingEngineServer) Cancel(context.Context, *OrderRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Cancel not implemented")
+}
+func (UnimplementedMatchingEngineServer) Rollback(context.Context, *OrderRequest) (*Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Rollback not implemented")
+}
+func (UnimplementedMatchingEngineServer) GetOrder(context.Context, *OrderRequest) (*OrderState, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetOrder not implemented")
+}
+func (UnimplementedMatchingEngineServer) GetDepth(context.Context, *DepthRequest) (*BookDepth, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDepth not implemented")
+}
+func (UnimplementedMatchingEngineServer) SubscribeTrades(*SubscribeRequest, MatchingEngine_SubscribeTradesServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeTrades not implemented")
+}
+func (UnimplementedMatchingEngineServer) SubscribeBook(*SubscribeRequest, MatchingEngine_SubscribeBookServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeBook not implemented")
+}
+func (UnimplementedMatchingEngineServer) mustEmbedUnimplementedMatchingEngineServer() {}
+
+// UnsafeMatchingEngineServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MatchingEngineServer will
+// result in compilation errors.
+type UnsafeMatchingEngineServer interface {
+	mustEmbedUnimplementedMatchingEngineServer()
+}
+
+func RegisterMatchingEngineServer(s grpc.ServiceRegistrar, srv MatchingEngineServer) {
+	s.RegisterService(&MatchingEngine_ServiceDesc, srv)
+}
+
+func _MatchingEngine_PlaceLimitOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PlaceOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchingEngineServer).PlaceLimitOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MatchingEngine_PlaceLimitOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchingEngineServer).PlaceLimitOrder(ctx, req.(*PlaceOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MatchingEngine_PlaceMarketOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PlaceOrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchingEngineServer).PlaceMarketOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MatchingEngine_PlaceMarketOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchingEngineServer).PlaceMarketOrder(ctx, req.(*PlaceOrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MatchingEngine_Cancel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(OrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchingEngineServer).Cancel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MatchingEngine_Cancel_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchingEngineServer).Cancel(ctx, req.(*OrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MatchingEngine_Rollback_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(OrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchingEngineServer).Rollback(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MatchingEngine_Rollback_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchingEngineServer).Rollback(ctx, req.(*OrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MatchingEngine_GetOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(OrderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchingEngineServer).GetOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MatchingEngine_GetOrder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchingEngineServer).GetOrder(ctx, req.(*OrderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MatchingEngine_GetDepth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DepthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MatchingEngineServer).GetDepth(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MatchingEngine_GetDepth_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MatchingEngineServer).GetDepth(ctx, req.(*DepthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MatchingEngine_SubscribeTrades_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MatchingEngineServer).SubscribeTrades(m, &matchingEngineSubscribeTradesServer{stream})
+}
+
+type MatchingEngine_SubscribeTradesServer interface {
+	Send(*TradeEvent) error
+	grpc.ServerStream
+}
+
+type matchingEngineSubscribeTradesServer struct {
+	grpc.ServerStream
+}
+
+func (x *matchingEngineSubscribeTradesServer) Send(m *TradeEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MatchingEngine_SubscribeBook_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MatchingEngineServer).SubscribeBook(m, &matchingEngineSubscribeBookServer{stream})
+}
+
+type MatchingEngine_SubscribeBookServer interface {
+	Send(*BookUpdate) error
+	grpc.ServerStream
+}
+
+type matchingEngineSubscribeBookServer struct {
+	grpc.ServerStream
+}
+
+func (x *matchingEngineSubscribeBookServer) Send(m *BookUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// MatchingEngine_ServiceDesc is the grpc.ServiceDesc for MatchingEngine service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MatchingEngine_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "matchingengine.v1.MatchingEngine",
+	HandlerType: (*MatchingEngineServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PlaceLimitOrder",
+			Handler:    _MatchingEngine_PlaceLimitOrder_Handler,
+		},
+		{
+			MethodName: "PlaceMarketOrder",
+			Handler:    _MatchingEngine_PlaceMarketOrder_Handler,
+		},
+		{
+			MethodName: "Cancel",
+			Handler:    _MatchingEngine_Cancel_Handler,
+		},
+		{
+			MethodName: "Rollback",
+			Handler:    _MatchingEngine_Rollback_Handler,
+		},
+		{
+			MethodName: "GetOrder",
+			Handler:    _MatchingEngine_GetOrder_Handler,
+		},
+		{
+			MethodName: "GetDepth",
+			Handler:    _MatchingEngine_GetDepth_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeTrades",
+			Handler:       _MatchingEngine_SubscribeTrades_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SubscribeBook",
+			Handler:       _MatchingEngine_SubscribeBook_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "matchingengine.proto",
+}
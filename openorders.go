@@ -0,0 +1,167 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/cockroachdb/apd"
+)
+
+// OrderFilter filters the open orders of the account. Empty fields match every order.
+type OrderFilter struct {
+	// Side is a side of the orders.
+	Side *OperationType
+	// MinPrice is a min price of the orders, inclusive.
+	MinPrice *apd.Decimal
+	// MaxPrice is a max price of the orders, inclusive.
+	MaxPrice *apd.Decimal
+}
+
+// match checks that the order fits the filter.
+func (f OrderFilter) match(o *Order) bool {
+	if f.Side != nil && *f.Side != o.operationType {
+		return false
+	}
+	if f.MinPrice != nil && o.price.Cmp(f.MinPrice) < 0 {
+		return false
+	}
+	if f.MaxPrice != nil && o.price.Cmp(f.MaxPrice) > 0 {
+		return false
+	}
+
+	return true
+}
+
+// addOrder adds the order which was placed to the order book to the storages.
+func (ob *OrderBook) addOrder(el *list.Element) {
+	o := el.Value.(*Order)
+	ob.Orders[o.orderID] = el
+
+	orders, ok := ob.OrdersByAccount[o.accountID]
+	if !ok {
+		orders = map[OrderID]*list.Element{}
+		ob.OrdersByAccount[o.accountID] = orders
+	}
+	orders[o.orderID] = el
+}
+
+// deleteOrder deletes the order which left the order book from the storages.
+func (ob *OrderBook) deleteOrder(o *Order) {
+	delete(ob.Orders, o.orderID)
+
+	orders := ob.OrdersByAccount[o.accountID]
+	delete(orders, o.orderID)
+	if len(orders) == 0 {
+		delete(ob.OrdersByAccount, o.accountID)
+	}
+}
+
+// deleteExecuted deletes the executor orders of the order which were executed completely.
+func (ob *OrderBook) deleteExecuted(o *Order) {
+	for _, e := range o.executions {
+		el, ok := ob.Orders[e.executorOrderID]
+		if !ok {
+			continue
+		}
+
+		executor := el.Value.(*Order)
+		if ob.side(executor.operationType).filled(executor) {
+			ob.deleteOrder(executor)
+		}
+	}
+}
+
+// OpenOrders returns the open orders of the account sorted by time of creation.
+func (ob *OrderBook) OpenOrders(ctx context.Context, account AccountID, filter OrderFilter) ([]*Order, error) {
+	err := ob.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer ob.release()
+
+	return ob.openOrders(account, filter), nil
+}
+
+func (ob *OrderBook) openOrders(account AccountID, filter OrderFilter) []*Order {
+	orders := make([]*Order, 0, len(ob.OrdersByAccount[account]))
+	for _, el := range ob.OrdersByAccount[account] {
+		o := el.Value.(*Order)
+		if filter.match(o) {
+			orders = append(orders, o)
+		}
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].createdAt.Equal(orders[j].createdAt) {
+			return orders[i].orderID < orders[j].orderID
+		}
+
+		return orders[i].createdAt.Before(orders[j].createdAt)
+	})
+
+	return orders
+}
+
+// CancelAll cancels all open orders of the account, or only orders of the side if it's set.
+// It returns ids of the canceled orders.
+func (ob *OrderBook) CancelAll(ctx context.Context, account AccountID, side *OperationType) ([]OrderID, error) {
+	if ob.Sequencer != nil {
+		c := Command{Type: CommandCancelAll, AccountID: account, AnySide: side == nil}
+		if side != nil {
+			c.Side = *side
+		}
+
+		res, err := ob.Sequencer.Apply(ctx, c)
+		return res.Canceled, err
+	}
+
+	err := ob.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer ob.unlock()
+
+	return ob.cancelAllOrders(ctx, account, side)
+}
+
+// cancelAllOrders journals the cancel of all orders with the release of their funds and cancels them.
+func (ob *OrderBook) cancelAllOrders(ctx context.Context, account AccountID, side *OperationType) ([]OrderID, error) {
+	c := Command{Type: CommandCancelAll, AccountID: account, AnySide: side == nil}
+	if side != nil {
+		c.Side = *side
+	}
+
+	var moves []balanceMove
+	for _, o := range ob.openOrders(account, OrderFilter{Side: side}) {
+		release, err := ob.releaseMoves(o)
+		if err != nil {
+			return nil, ob.reject(c, err)
+		}
+		moves = append(moves, release...)
+	}
+
+	err := ob.commit(c, moves)
+	if err != nil {
+		return nil, ob.reject(c, fmt.Errorf("can't release funds of orders of: %s: %w", account, err))
+	}
+
+	return ob.cancelAll(ctx, account, side)
+}
+
+func (ob *OrderBook) cancelAll(ctx context.Context, account AccountID, side *OperationType) ([]OrderID, error) {
+	orders := ob.openOrders(account, OrderFilter{Side: side})
+
+	canceled := make([]OrderID, 0, len(orders))
+	for _, o := range orders {
+		err := ob.cancel(ctx, o.orderID)
+		if err != nil {
+			return canceled, fmt.Errorf("can't cancel all orders of: %s: %w", account, err)
+		}
+
+		canceled = append(canceled, o.orderID)
+	}
+
+	return canceled, nil
+}
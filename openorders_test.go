@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cockroachdb/apd"
+)
+
+func Test_OpenOrders(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	for _, o := range testOrders() {
+		if o.operationType == Ask {
+			o.accountID = "seller"
+		} else {
+			o.accountID = "buyer"
+		}
+
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	// the order "1" is executed completely, the order "11" is executed partially.
+	_, err := ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID:       "100500",
+		accountID:     "buyer",
+		operationType: Bid,
+		amount:        apd.New(4, -1),
+		price:         apd.New(20050, 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	orders, err := ob.OpenOrders(context.Background(), "seller", OrderFilter{})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(orders) != 6 {
+		t.Fatalf("expected 6 orders, but got: %d", len(orders))
+	}
+	if orders[0].orderID != "11" || orders[0].amount.String() != "0.4" {
+		t.Fatalf("unexpected first order: %s with amount: %s", orders[0].orderID, orders[0].amount)
+	}
+
+	side := Bid
+	orders, err = ob.OpenOrders(context.Background(), "buyer", OrderFilter{
+		Side:     &side,
+		MinPrice: apd.New(19900, 0),
+		MaxPrice: apd.New(20000, 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(orders) != 6 {
+		t.Fatalf("expected 6 orders, but got: %d", len(orders))
+	}
+
+	orders, err = ob.OpenOrders(context.Background(), "nobody", OrderFilter{})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(orders) != 0 {
+		t.Fatalf("expected 0 orders, but got: %d", len(orders))
+	}
+}
+
+func Test_CancelAll(t *testing.T) {
+	ob, accounts := testAccountsOrderBook(t)
+	for _, o := range testOrders() {
+		if o.operationType == Ask {
+			o.accountID = "seller"
+		} else {
+			o.accountID = "buyer"
+		}
+
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	side := Ask
+	canceled, err := ob.CancelAll(context.Background(), "seller", &side)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(canceled) != 7 {
+		t.Fatalf("expected 7 canceled orders, but got: %d", len(canceled))
+	}
+
+	if ob.Asks.String() != "prices:" {
+		t.Fatalf("expected empty asks, but got: %s", ob.Asks.String())
+	}
+	checkBalance(t, accounts, "seller", "BTC", "10", "0")
+
+	canceled, err = ob.CancelAll(context.Background(), "buyer", nil)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(canceled) != 7 {
+		t.Fatalf("expected 7 canceled orders, but got: %d", len(canceled))
+	}
+
+	if ob.Bids.String() != "prices:" {
+		t.Fatalf("expected empty bids, but got: %s", ob.Bids.String())
+	}
+	checkBalance(t, accounts, "buyer", "USDT", "100000", "0")
+
+	if len(ob.Orders) != 0 || len(ob.OrdersByAccount) != 0 {
+		t.Fatalf("expected no orders, but got: %d", len(ob.Orders))
+	}
+}
+
+func Test_RollbackPartiallyExecutedOrder(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	for _, o := range testOrders() {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	logBeforeOrder := ob.Asks.String()
+
+	_, _, err := ob.PlaceMarketOrder(context.Background(), &Order{
+		orderID:       "100500",
+		operationType: Bid,
+		amount:        apd.New(4, -1),
+		price:         apd.New(20050, 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if _, ok := ob.Orders["11"]; !ok {
+		t.Fatalf("partially executed order should stay in the order book")
+	}
+
+	err = ob.Rollback(context.Background(), "100500")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if !strings.Contains(ob.Asks.String(), "`3` orders with price: `20050` with amount: `1.0`") {
+		t.Fatalf("unexpected asks: %s, before: %s", ob.Asks.String(), logBeforeOrder)
+	}
+}
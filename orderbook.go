@@ -4,13 +4,13 @@ import (
 	"bytes"
 	"container/list"
 	"context"
+	"errors"
 	"fmt"
-	"sync"
+	"math/big"
+	"sync/atomic"
 	"time"
 
 	"github.com/cockroachdb/apd"
-	rbtEx "github.com/emirpasic/gods/examples/redblacktreeextended"
-	rbt "github.com/emirpasic/gods/trees/redblacktree"
 )
 
 // OperationType is a type of operation.
@@ -27,25 +27,86 @@ type Asset string
 // OrderID is a unique order id.
 type OrderID string
 
+var (
+	// ErrOrderNotFound is returned when there is no order with such id.
+	ErrOrderNotFound = errors.New("order not found")
+	// ErrOrderExists is returned when the order with such id is in the order book already.
+	ErrOrderExists = errors.New("order already exists")
+	// ErrInvalidPrecision is returned when the price or the amount has more decimals than the fixed-point order book,
+	// or it doesn't fit the decimal of the order feed.
+	ErrInvalidPrecision = errors.New("invalid precision")
+	// ErrInvalidTick is returned when the price isn't on the price ladder of the order book.
+	ErrInvalidTick = errors.New("invalid tick")
+)
+
+// orderError is an error of the order, it can be checked by errors.Is with its kind.
+type orderError struct {
+	kind error
+	msg  string
+}
+
+// Error returns the string.
+func (e *orderError) Error() string {
+	return e.msg
+}
+
+// Is checks the kind of the error.
+func (e *orderError) Is(target error) bool {
+	return target == e.kind
+}
+
+func newOrderError(kind error, format string, args ...interface{}) error {
+	return &orderError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
+
 // Order is an order in the order book.
 type Order struct {
 	orderID       OrderID
+	accountID     AccountID
 	operationType OperationType
 	amount        *apd.Decimal
 	price         *apd.Decimal
+	// fixedAmount and fixedPrice are the amount and the price scaled by the decimals of the fixed-point order book.
+	// The fixed-point matching updates fixedAmount only, the amount left is built by OrderSide.remaining.
+	fixedAmount int64
+	fixedPrice  int64
+	// priority is a place of the order in the queue of its price level, it's given when the order rests.
+	priority uint64
 
 	// we store data that this order was executed or partially executed.
 	executions []*ExecutionReport
+	// executionsBuf keeps the first execution, most orders don't have more.
+	executionsBuf [1]*ExecutionReport
 
 	createdAt time.Time
 }
 
+// addExecution adds the execution of the order.
+func (o *Order) addExecution(e *ExecutionReport) {
+	if o.executions == nil {
+		o.executions = o.executionsBuf[:0]
+	}
+
+	o.executions = append(o.executions, e)
+}
+
 // ExecutionReport is a log data of executed orders.
 type ExecutionReport struct {
-	initiatorOrderID OrderID
-	executorOrderID  OrderID
-	amount           *apd.Decimal
-	price            *apd.Decimal
+	initiatorOrderID   OrderID
+	initiatorAccountID AccountID
+	executorOrderID    OrderID
+	executorAccountID  AccountID
+	amount             *apd.Decimal
+	price              *apd.Decimal
+
+	// fees of the execution, they are set only if the order book has FeeSchedule.
+	feeAsset Asset
+	takerFee *apd.Decimal
+	makerFee *apd.Decimal
+
+	// amountValue keeps the amount of the report which is made by the matching, amountWord keeps its coefficient.
+	amountValue apd.Decimal
+	amountWord  [1]big.Word
 }
 
 // OrderBook is a main domain for order book in matching engine.
@@ -61,26 +122,69 @@ type OrderBook struct {
 	Orders map[OrderID]*list.Element
 	// Storage of executed orders.
 	OrdersDone map[OrderID]*Order
+	// Storage of orders by accounts.
+	OrdersByAccount map[AccountID]map[OrderID]*list.Element
 
 	// Asks are sells in the order book.
 	Asks *OrderSide
 	// Bid are buys in the order book.
 	Bids *OrderSide
 
-	mx sync.Mutex
+	// Accounts is an optional ledger of balances.
+	// If it's set, orders reserve funds on placement and fills move them between counterparties.
+	Accounts *Accounts
+	// Fees is an optional schedule of maker/taker fees of the executions.
+	Fees *FeeSchedule
+	// Ledger is an optional double-entry ledger of the trades.
+	Ledger *Ledger
+	// Risk is an optional set of pre-trade risk limits of the accounts.
+	Risk *RiskManager
+	// Journal is an optional write-ahead journal of the commands.
+	Journal *Journal
+	// MarketData is an optional feed of the sequenced depth updates and trades.
+	MarketData *MarketDataFeed
+	// OrderFeed is an optional binary feed of the order-level changes.
+	OrderFeed *OrderFeed
+	// DropCopy is an optional copy of the executions and the order state changes by the accounts.
+	DropCopy *DropCopy
+	// Sequencer is an optional single goroutine which owns the order book: it applies the commands of Apply
+	// and the calls which change the order book by the ring buffer without the lock,
+	// the other calls wait for their turn in it.
+	Sequencer *Sequencer
+	// Listeners get the events of the order book.
+	Listeners []EventListener
+
+	// seq is a sequence number of the last command written to the journal.
+	seq uint64
+
+	// view is the last published view of the price levels.
+	view atomic.Pointer[BookView]
+
+	// mx is the lock of the order book, the order entry waits for it till the context is done.
+	// The running sequencer holds it and gives the order book by the barrier, see lock.
+	mx ctxMutex
+	// barrier is the barrier of the sequencer the order book is taken by, it's nil if it's taken by mx.
+	barrier *sequencerBarrier
 }
 
 // NewOrderBook creates a new instance of OrderBook.
 func NewOrderBook(baseAsset, quoteAsset Asset) *OrderBook {
-	return &OrderBook{
-		BaseAsset:  baseAsset,
-		QuoteAsset: quoteAsset,
-		Orders:     map[OrderID]*list.Element{},
-		OrdersDone: map[OrderID]*Order{},
-		Asks:       NewOrderSide(Ask),
-		Bids:       NewOrderSide(Bid),
-		mx:         sync.Mutex{},
+	ob := &OrderBook{
+		BaseAsset:       baseAsset,
+		QuoteAsset:      quoteAsset,
+		Orders:          map[OrderID]*list.Element{},
+		OrdersDone:      map[OrderID]*Order{},
+		OrdersByAccount: map[AccountID]map[OrderID]*list.Element{},
+		Asks:            NewOrderSide(Ask),
+		Bids:            NewOrderSide(Bid),
+		mx:              newCtxMutex(),
 	}
+
+	ob.Asks.ob = ob
+	ob.Bids.ob = ob
+	ob.publishView()
+
+	return ob
 }
 
 // OrdersBySpecificPrice we store all orders by specific price as linked list.
@@ -91,6 +195,10 @@ type OrdersBySpecificPrice struct {
 	price *apd.Decimal
 	// totalAmount is a total amount of volume on this price level
 	totalAmount *apd.Decimal
+	// fixedPrice and fixedTotal are the price and the total amount of the fixed-point order book,
+	// totalAmount isn't updated there, the total is built by OrderSide.total.
+	fixedPrice int64
+	fixedTotal int64
 	// linked listed of the orders on this price level.
 	orders *list.List
 }
@@ -98,42 +206,50 @@ type OrdersBySpecificPrice struct {
 // NewOrdersBySpecificPrice creates a new instance of OrdersBySpecificPrice.
 func NewOrdersBySpecificPrice(price, amount *apd.Decimal) *OrdersBySpecificPrice {
 	return &OrdersBySpecificPrice{
-		price:       price,
-		totalAmount: amount,
+		price: price,
+		// copy the amount, otherwise it's shared with the first order on this level.
+		totalAmount: apd.New(0, 0).Set(amount),
 		orders:      list.New(),
 	}
 }
 
 // AddAmount adds amount.
 func (op *OrdersBySpecificPrice) AddAmount(amount *apd.Decimal) error {
-	d := apd.New(0, 0)
-
-	_, err := apd.BaseContext.Add(d, op.totalAmount, amount)
-	op.totalAmount = d
+	_, err := apd.BaseContext.Add(op.totalAmount, op.totalAmount, amount)
 
 	return err
 }
 
 // OrderSide is a part of order book, there are 2 sides: asks (sells in the order book) and bids (buys in the order book).
 type OrderSide struct {
-	priceTree *rbtEx.RedBlackTreeExtended
-	// cache of the prices, where string is a price
-	prices map[string]*OrdersBySpecificPrice
+	// index is the price levels ordered by price.
+	index priceLevels
 
 	sideType OperationType
+	// fixed is set for the fixed-point order book, the levels are ordered by int64 prices then.
+	fixed *FixedPoint
+	// ladder is set for the bounded instrument, the levels are kept by the tick ladder then.
+	ladder *PriceLadder
+
+	// ob is the order book of the side, it raises the events of the executions.
+	ob *OrderBook
+	// dirty is true when all levels must be published by the next view,
+	// changed are the levels which are changed after the last view.
+	dirty   bool
+	changed []levelChange
+
+	// reports are preallocated execution reports, freeLevels are removed price levels for the reuse.
+	reports    []ExecutionReport
+	freeLevels []*OrdersBySpecificPrice
+
+	// priority is the last priority given to the order of the side.
+	priority uint64
 }
 
 // NewOrderSide creates a new instance of the OrderSide.
 func NewOrderSide(sideType OperationType) *OrderSide {
-	f := func(a, b interface{}) int {
-		return a.(*apd.Decimal).Cmp(b.(*apd.Decimal))
-	}
-
 	return &OrderSide{
-		priceTree: &rbtEx.RedBlackTreeExtended{
-			Tree: rbt.NewWith(f),
-		},
-		prices:   map[string]*OrdersBySpecificPrice{},
+		index:    newIndexLevels(comparePrices),
 		sideType: sideType,
 	}
 }
@@ -143,64 +259,51 @@ func (os *OrderSide) String() string {
 	var buffer bytes.Buffer
 
 	buffer.WriteString("prices:")
-	for _, o := range os.prices {
+	for _, o := range os.index.Values() {
 		buffer.WriteString(
 			fmt.Sprintf(
 				"`%d` orders with price: `%s` with amount: `%s`.",
 				o.orders.Len(),
 				o.price.String(),
-				o.totalAmount.String(),
+				os.total(o).String(),
 			),
 		)
 	}
 
-	buffer.WriteString("priceTree:")
-	buffer.WriteString(os.priceTree.String())
-
 	return buffer.String()
 }
 
 // ExecuteOrder executes order.
 func (os *OrderSide) ExecuteOrder(o *Order) (amountLeft *apd.Decimal, ordersExecuted int, err error) {
+	if os.fixed != nil {
+		return os.executeFixed(o)
+	}
+
 	// how much amount we should find
 	amountLeft = apd.New(0, 0)
 	amountLeft.Set(o.amount)
 
-	var found bool
-	iter := os.priceTree.Iterator()
-
-	if os.sideType == Ask { // there are sells here, let's find the min one
-		found = iter.First()
-	} else { // there are buys here, let's find the max one
-		found = iter.Last()
-	}
-
-	if !found {
+	// the min price for asks and the max one for bids.
+	orders := os.best()
+	if orders == nil {
 		return
 	}
 
 	i := 0
-	deleteTreeNodes := make([]interface{}, 0)
 	for {
 		// we found required amount - it's done
 		if amountLeft.IsZero() {
 			break
 		}
 
-		// let's iterate by the tree to find required orders.
+		// let's iterate by the index to find required orders.
 		if i != 0 {
-			if os.sideType == Ask {
-				found = iter.Next()
-			} else {
-				found = iter.Prev()
-			}
-			if !found { // we checked all nodes - there are no more.
+			orders = os.worse(orders)
+			if orders == nil { // we checked all levels - there are no more.
 				break
 			}
 		}
 
-		orders := iter.Value().(*OrdersBySpecificPrice)
-
 		// let's check if our price fits with the price from order book.
 		res := o.price.Cmp(orders.price)
 		if os.sideType == Ask {
@@ -213,21 +316,19 @@ func (os *OrderSide) ExecuteOrder(o *Order) (amountLeft *apd.Decimal, ordersExec
 			}
 		}
 
-		var treeNodeIsEmpty bool
 		// we have some orders with amount, let's match it
 		el := orders.orders.Front()
-		deleteEls := make([]*list.Element, 0)
 		for {
 			var listNodeEmpty bool
-			amountExecuted := apd.New(0, 0)
-			amountFound := apd.New(0, 0)
+			var amountFound *apd.Decimal
 
 			reqOrder := el.Value.(*Order)
-			oe := ExecutionReport{
-				initiatorOrderID: o.orderID,
-				executorOrderID:  reqOrder.orderID,
-				price:            reqOrder.price,
-			}
+			oe := os.newReport()
+			oe.initiatorOrderID = o.orderID
+			oe.initiatorAccountID = o.accountID
+			oe.executorOrderID = reqOrder.orderID
+			oe.executorAccountID = reqOrder.accountID
+			oe.price = reqOrder.price
 
 			switch reqOrder.amount.Cmp(amountLeft) {
 			case -1: // reqOrder.amount < amountLeft - we found only the small part, let's take it and look more
@@ -242,8 +343,8 @@ func (os *OrderSide) ExecuteOrder(o *Order) (amountLeft *apd.Decimal, ordersExec
 				amountFound = amountLeft
 			}
 
-			oe.amount = amountFound
-			amountExecuted = amountExecuted.Set(amountFound)
+			// amountFound can point to amountLeft, which is changed below - keep a copy in the report.
+			setDecimal(oe.amount, &oe.amountWord, amountFound)
 
 			if !listNodeEmpty {
 				_, err = apd.BaseContext.Sub(reqOrder.amount, reqOrder.amount, amountFound)
@@ -257,24 +358,25 @@ func (os *OrderSide) ExecuteOrder(o *Order) (amountLeft *apd.Decimal, ordersExec
 				return nil, ordersExecuted, err
 			}
 
-			if len(o.executions) == 0 {
-				o.executions = make([]*ExecutionReport, 0)
+			if listNodeEmpty {
+				// the order was executed completely - nothing left.
+				reqOrder.amount.SetInt64(0)
 			}
-			o.executions = append(o.executions, &oe)
 
-			if len(reqOrder.executions) == 0 {
-				reqOrder.executions = make([]*ExecutionReport, 0)
-			}
-			reqOrder.executions = append(reqOrder.executions, &oe)
+			o.addExecution(oe)
+			reqOrder.addExecution(oe)
 
 			ordersExecuted++
+			os.emitFills(o, reqOrder, oe, amountLeft)
 
+			current := el
+			el = el.Next()
 			if listNodeEmpty {
-				deleteEls = append(deleteEls, el)
+				orders.orders.Remove(current)
 			}
 
 			// recalc the total amount for that price
-			_, err = apd.BaseContext.Sub(orders.totalAmount, orders.totalAmount, amountExecuted)
+			_, err = apd.BaseContext.Sub(orders.totalAmount, orders.totalAmount, oe.amount)
 			if err != nil {
 				return nil, ordersExecuted, err
 			}
@@ -284,56 +386,211 @@ func (os *OrderSide) ExecuteOrder(o *Order) (amountLeft *apd.Decimal, ordersExec
 				break
 			}
 
-			el = el.Next()
 			if el == nil {
-				// we checked all data in linked list - so the node of the tree is empty
-				treeNodeIsEmpty = true
+				// we checked all data in linked list - so the price level is empty
 				break
 			}
 		}
 
-		for _, delEl := range deleteEls {
-			orders.orders.Remove(delEl)
-			if orders.orders.Len() == 0 {
-				delete(os.prices, orders.price.String())
-			}
+		// the removed level keeps its price, so the iteration goes on from it.
+		if orders.orders.Len() == 0 {
+			os.index.Remove(os.key(orders.price, 0))
 		}
-
-		if treeNodeIsEmpty {
-			deleteTreeNodes = append(deleteTreeNodes, iter.Key())
+		if os.ob != nil {
+			os.ob.emitLevel(os, orders.price, 0)
+		}
+		if orders.orders.Len() == 0 {
+			os.freeLevel(orders)
 		}
 
 		i++
 	}
 
-	for _, node := range deleteTreeNodes {
-		os.priceTree.Remove(node)
+	return
+}
+
+// best returns the level of the best price: the min one for asks and the max one for bids.
+func (os *OrderSide) best() *OrdersBySpecificPrice {
+	if os.sideType == Bid {
+		return os.index.Max()
+	}
+
+	return os.index.Min()
+}
+
+// worse returns the level of the next worse price after the level, the level can be removed already.
+func (os *OrderSide) worse(level *OrdersBySpecificPrice) *OrdersBySpecificPrice {
+	key := os.key(level.price, level.fixedPrice)
+	if os.sideType == Bid {
+		return os.index.Below(key)
 	}
 
-	return
+	return os.index.Above(key)
 }
 
-// AddOrder adds order to the list side.
+// BestPrice returns the best price of the side: the min one for asks and the max one for bids.
+func (os *OrderSide) BestPrice() (*apd.Decimal, bool) {
+	level := os.best()
+	if level == nil {
+		return nil, false
+	}
+
+	return level.price, true
+}
+
+// AddOrder adds order to the list side, nothing is added if the context is done.
 func (os *OrderSide) AddOrder(ctx context.Context, o *Order) (*list.Element, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ordersByPrice, err := os.addToLevel(o)
+	if err != nil {
+		return nil, err
+	}
+
+	// let's add order to the list.
+	os.priority++
+	o.priority = os.priority
+	el := ordersByPrice.orders.PushBack(o)
+
+	return el, nil
+}
+
+// RestoreOrder adds the order back to its place in the queue by its priority, the order keeps its priority.
+func (os *OrderSide) RestoreOrder(o *Order) (*list.Element, error) {
+	ordersByPrice, err := os.addToLevel(o)
+	if err != nil {
+		return nil, err
+	}
+
+	for el := ordersByPrice.orders.Front(); el != nil; el = el.Next() {
+		if el.Value.(*Order).priority > o.priority {
+			return ordersByPrice.orders.InsertBefore(o, el), nil
+		}
+	}
+
+	return ordersByPrice.orders.PushBack(o), nil
+}
+
+// addToLevel adds the amount of the order to its price level and returns it, the level is created if there is no one.
+func (os *OrderSide) addToLevel(o *Order) (*OrdersBySpecificPrice, error) {
 	// check that we have some orders on this price level
-	priceS := o.price.String()
-	ordersByPrice, ok := os.prices[priceS]
+	key := os.key(o.price, o.fixedPrice)
+	ordersByPrice, ok := os.index.Get(key)
 	if ok {
 		// let's add more volume.
-		err := ordersByPrice.AddAmount(o.amount)
+		err := os.addAmount(ordersByPrice, o.amount, o.fixedAmount)
 		if err != nil {
 			return nil, err
 		}
 	}
 	if !ok {
 		// there are no orders on this price level - let's create them.
-		ordersByPrice = NewOrdersBySpecificPrice(o.price, o.amount)
-		os.prices[priceS] = ordersByPrice
-		os.priceTree.Put(o.price, ordersByPrice)
+		ordersByPrice = os.newLevel(o)
+		err := os.index.Put(key, ordersByPrice)
+		if err != nil {
+			os.freeLevel(ordersByPrice)
+			return nil, err
+		}
 	}
 
-	// let's add order to the list.
-	return ordersByPrice.orders.PushBack(o), nil
+	return ordersByPrice, nil
+}
+
+// RefillOrder adds amount to the order which is in the list side.
+func (os *OrderSide) RefillOrder(el *list.Element, amount *apd.Decimal) error {
+	o := el.Value.(*Order)
+
+	ordersByPrice, ok := os.index.Get(os.key(o.price, o.fixedPrice))
+	if !ok {
+		return fmt.Errorf("price level: %s not found", o.price)
+	}
+
+	if os.fixed != nil {
+		fixedAmount, err := os.fixed.amount(amount)
+		if err != nil {
+			return err
+		}
+
+		err = os.addAmount(ordersByPrice, amount, fixedAmount)
+		if err != nil {
+			return err
+		}
+
+		o.fixedAmount += fixedAmount
+
+		return nil
+	}
+
+	err := ordersByPrice.AddAmount(amount)
+	if err != nil {
+		return err
+	}
+
+	total := apd.New(0, 0)
+	_, err = apd.BaseContext.Add(total, o.amount, amount)
+	o.amount = total
+
+	return err
+}
+
+// RemoveOrder removes order from the list side.
+// If the price level becomes empty - it's removed from the tree as well.
+func (os *OrderSide) RemoveOrder(el *list.Element) error {
+	o := el.Value.(*Order)
+
+	key := os.key(o.price, o.fixedPrice)
+	ordersByPrice, ok := os.index.Get(key)
+	if !ok {
+		return fmt.Errorf("price level: %s not found", o.price)
+	}
+
+	ordersByPrice.orders.Remove(el)
+	if os.fixed != nil {
+		ordersByPrice.fixedTotal -= o.fixedAmount
+	} else {
+		_, err := apd.BaseContext.Sub(ordersByPrice.totalAmount, ordersByPrice.totalAmount, o.amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	if ordersByPrice.orders.Len() == 0 {
+		os.index.Remove(key)
+		os.freeLevel(ordersByPrice)
+	}
+
+	return nil
+}
+
+// emitFills raises the fill events of the execution: the maker one, then the taker one.
+func (os *OrderSide) emitFills(taker, maker *Order, e *ExecutionReport, amountLeft *apd.Decimal) {
+	if os.ob == nil || !os.ob.listening() {
+		return
+	}
+
+	os.ob.emitFill(OrderFill{
+		OrderID:          maker.orderID,
+		AccountID:        maker.accountID,
+		Side:             maker.operationType,
+		Maker:            true,
+		CounterOrderID:   taker.orderID,
+		CounterAccountID: taker.accountID,
+		Price:            apd.New(0, 0).Set(e.price),
+		Amount:           apd.New(0, 0).Set(e.amount),
+		Remaining:        os.remaining(maker),
+	})
+	os.ob.emitFill(OrderFill{
+		OrderID:          taker.orderID,
+		AccountID:        taker.accountID,
+		Side:             taker.operationType,
+		CounterOrderID:   maker.orderID,
+		CounterAccountID: maker.accountID,
+		Price:            apd.New(0, 0).Set(e.price),
+		Amount:           apd.New(0, 0).Set(e.amount),
+		Remaining:        apd.New(0, 0).Set(amountLeft),
+	})
 }
 
 // PlaceMarketOrder places a market order in OrderBook.
@@ -341,24 +598,56 @@ func (ob *OrderBook) PlaceMarketOrder(
 	ctx context.Context, o *Order,
 ) (ordersExecuted int, amountLeft *apd.Decimal, err error) {
 	// TODO: check that amount is valid.
+	if ob.Sequencer != nil {
+		c := orderCommand(CommandPlaceMarket, o)
+		c.placed = o
+
+		res, err := ob.Sequencer.Apply(ctx, c)
+		return res.OrdersExecuted, res.AmountLeft, err
+	}
+
+	err = ob.lock(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer ob.unlock()
+
+	return ob.placeMarketOrder(ctx, o)
+}
+
+func (ob *OrderBook) placeMarketOrder(
+	ctx context.Context, o *Order,
+) (ordersExecuted int, amountLeft *apd.Decimal, err error) {
+	sideToCheck := ob.Asks
+	if o.operationType == Ask {
+		sideToCheck = ob.Bids
+	}
+
+	if o.createdAt.IsZero() {
+		o.createdAt = time.Now()
+	}
+
+	// the command is journaled after all checks, with the moves of the balances, so nothing is changed
+	// if the order can't be settled. Market order doesn't stay in the order book - the funds of the amount
+	// which isn't found are released.
+	c := orderCommand(CommandPlaceMarket, o)
+	s, err := ob.checkOrder(o, sideToCheck, true, nil)
+	if err != nil {
+		return 0, nil, ob.reject(c, err)
+	}
+
+	err = ob.commit(c, s.moves)
+	if err != nil {
+		return 0, nil, ob.reject(c, fmt.Errorf("can't place market order: %w", err))
+	}
 
-	ob.mx.Lock()
 	defer func() {
 		if err == nil {
 			ob.OrdersDone[o.orderID] = o
 		}
-
-		ob.mx.Unlock()
 	}()
 
-	var (
-		sideToCheck *OrderSide
-	)
-	if o.operationType == Ask {
-		sideToCheck = ob.Bids
-	} else {
-		sideToCheck = ob.Asks
-	}
+	ob.emitAccepted(o, true)
 
 	amountLeft, ordersExecuted, err = sideToCheck.ExecuteOrder(o)
 	if err != nil {
@@ -366,10 +655,16 @@ func (ob *OrderBook) PlaceMarketOrder(
 	}
 
 	// these orders were executed - delete them
-	if o.executions != nil {
-		for _, execution := range o.executions {
-			delete(ob.Orders, execution.executorOrderID)
-		}
+	ob.deleteExecuted(o)
+
+	err = ob.commitSettlement(o, s)
+	if err != nil {
+		return ordersExecuted, nil, fmt.Errorf("can't post market order to ledger: %w", err)
+	}
+	ob.dropCopyExecutions(o)
+
+	if !amountLeft.IsZero() {
+		ob.emitCanceled(o, amountLeft)
 	}
 
 	return ordersExecuted, amountLeft, nil
@@ -378,94 +673,223 @@ func (ob *OrderBook) PlaceMarketOrder(
 // PlaceLimitOrder places a limit order in OrderBook.
 func (ob *OrderBook) PlaceLimitOrder(ctx context.Context, o *Order) (ordersExecuted int, err error) {
 	// TODO: check that amount and price are valid.
-	ob.mx.Lock()
-	defer ob.mx.Unlock()
+	if ob.Sequencer != nil {
+		c := orderCommand(CommandPlaceLimit, o)
+		c.placed = o
+
+		res, err := ob.Sequencer.Apply(ctx, c)
+		return res.OrdersExecuted, err
+	}
+
+	err = ob.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer ob.unlock()
+
+	return ob.placeLimitOrder(ctx, o)
+}
+
+func (ob *OrderBook) placeLimitOrder(ctx context.Context, o *Order) (ordersExecuted int, err error) {
+	sideToCheck := ob.Asks
+	if o.operationType == Ask {
+		sideToCheck = ob.Bids
+	}
+
+	if o.createdAt.IsZero() {
+		o.createdAt = time.Now()
+	}
+
+	// the command is journaled after all checks, with the moves of the balances, so nothing is changed
+	// if the order can't be settled.
+	c := orderCommand(CommandPlaceLimit, o)
+	s, err := ob.checkOrder(o, sideToCheck, false, nil)
+	if err != nil {
+		return 0, ob.reject(c, err)
+	}
+
+	err = ob.commit(c, s.moves)
+	if err != nil {
+		return 0, ob.reject(c, fmt.Errorf("can't place limit order: %w", err))
+	}
+
+	return ob.limitOrder(o, s)
+}
+
+// checkOrder checks the order and plans its settlement by the side, it doesn't change anything.
+// The open order which is replaced by the order isn't counted by the risk limits, it's nil for the new orders.
+func (ob *OrderBook) checkOrder(o *Order, sideToCheck *OrderSide, market bool, replaced *Order) (*settlement, error) {
+	err := ob.fixOrder(o)
+	if err != nil {
+		return nil, err
+	}
+
+	if !market {
+		err = ob.checkTick(o)
+		if err != nil {
+			return nil, err
+		}
+
+		_, ok := ob.Orders[o.orderID]
+		if ok {
+			return nil, newOrderError(ErrOrderExists, "order: %s already exists", o.orderID)
+		}
+	}
+
+	err = ob.checkRisk(o, sideToCheck, market, replaced)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := ob.planSettlement(o, sideToCheck, market)
+	if err != nil {
+		return nil, fmt.Errorf("can't settle order: %w", err)
+	}
+
+	err = ob.checkFeed(o, s.fills, market)
+	if err != nil {
+		return nil, err
+	}
 
-	return ob.limitOrder(ctx, o)
+	return s, nil
 }
 
-func (ob *OrderBook) limitOrder(ctx context.Context, o *Order) (ordersExecuted int, err error) {
+// limitOrder matches the checked order and adds the rest of it to the order book.
+func (ob *OrderBook) limitOrder(o *Order, s *settlement) (ordersExecuted int, err error) {
 	defer func() {
 		if err == nil {
 			ob.OrdersDone[o.orderID] = o
 		}
 	}()
 
-	_, ok := ob.Orders[o.orderID]
-	if ok {
-		return 0, fmt.Errorf("order: %s already exists", o.orderID)
-	}
-
-	var (
-		sideToAdd, sideToCheck *OrderSide
-	)
+	sideToAdd, sideToCheck := ob.Bids, ob.Asks
 	if o.operationType == Ask {
-		sideToAdd = ob.Asks
-		sideToCheck = ob.Bids
-	} else {
-		sideToAdd = ob.Bids
-		sideToCheck = ob.Asks
+		sideToAdd, sideToCheck = ob.Asks, ob.Bids
 	}
 
+	ob.emitAccepted(o, false)
+
 	amountLeft, ordersExecuted, err := sideToCheck.ExecuteOrder(o)
 	if err != nil {
 		return ordersExecuted, fmt.Errorf("can't find about for order: %w", err)
 	}
 
 	// these orders were executed - delete them
-	if o.executions != nil {
-		for _, execution := range o.executions {
-			delete(ob.Orders, execution.executorOrderID)
-		}
+	ob.deleteExecuted(o)
+
+	err = ob.commitSettlement(o, s)
+	if err != nil {
+		return ordersExecuted, fmt.Errorf("can't post limit order to ledger: %w", err)
 	}
+	ob.dropCopyExecutions(o)
 
 	if amountLeft.IsZero() {
 		return ordersExecuted, nil
 	}
 	if ordersExecuted > 0 {
 		o.amount = amountLeft
+		err = ob.fixOrder(o)
+		if err != nil {
+			return ordersExecuted, fmt.Errorf("can't place limit order: %w", err)
+		}
 	}
 
-	orderInList, err := sideToAdd.AddOrder(ctx, o)
+	// the order is executed already - the rest must be added even if the context is done.
+	orderInList, err := sideToAdd.AddOrder(context.Background(), o)
 	if err != nil {
 		return ordersExecuted, fmt.Errorf("can't place limit order: %w", err)
 	}
-	ob.Orders[o.orderID] = orderInList
+	ob.addOrder(orderInList)
+	ob.emitRested(o)
+	ob.emitLevel(sideToAdd, o.price, o.fixedPrice)
 
 	return ordersExecuted, nil
 }
 
-// Rollback rollbacks the order by id.
-// NOTE: This operation isn't atomic!!!!
-func (ob *OrderBook) Rollback(ctx context.Context, orderID OrderID) error {
-	ob.mx.Lock()
-	defer ob.mx.Unlock()
+// Cancel cancels the open order by id and releases its reserved funds.
+func (ob *OrderBook) Cancel(ctx context.Context, orderID OrderID) error {
+	if ob.Sequencer != nil {
+		_, err := ob.Sequencer.Apply(ctx, Command{Type: CommandCancel, OrderID: orderID})
+		return err
+	}
+
+	err := ob.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer ob.unlock()
+
+	return ob.cancelOrder(ctx, orderID)
+}
 
-	order, ok := ob.OrdersDone[orderID]
+// cancelOrder journals the cancel with the release of the funds of the order and cancels the order.
+func (ob *OrderBook) cancelOrder(ctx context.Context, orderID OrderID) error {
+	c := Command{Type: CommandCancel, OrderID: orderID}
+	el, ok := ob.Orders[orderID]
 	if !ok {
-		return fmt.Errorf("order: %s not found - nothing to rollback", orderID)
+		return ob.reject(c, newOrderError(ErrOrderNotFound, "order: %s not found - nothing to cancel", orderID))
 	}
 
-	var operationTypeWas OperationType
-	if order.operationType == Ask {
-		operationTypeWas = Bid
-	} else {
-		operationTypeWas = Ask
+	moves, err := ob.releaseMoves(el.Value.(*Order))
+	if err != nil {
+		return ob.reject(c, err)
 	}
 
-	for _, oe := range order.executions {
-		_, err := ob.limitOrder(ctx, &Order{
-			orderID:       oe.executorOrderID,
-			operationType: operationTypeWas,
-			amount:        oe.amount,
-			price:         oe.price,
-		})
-		if err != nil {
-			return fmt.Errorf("error in rollback: %w", err)
-		}
+	err = ob.commit(c, moves)
+	if err != nil {
+		return ob.reject(c, fmt.Errorf("can't release funds of order: %w", err))
+	}
+
+	return ob.cancel(ctx, orderID)
+}
+
+func (ob *OrderBook) cancel(ctx context.Context, orderID OrderID) error {
+	el, ok := ob.Orders[orderID]
+	if !ok {
+		return newOrderError(ErrOrderNotFound, "order: %s not found - nothing to cancel", orderID)
 	}
 
-	delete(ob.OrdersDone, orderID)
+	o := el.Value.(*Order)
+	sideToRemove := ob.Bids
+	if o.operationType == Ask {
+		sideToRemove = ob.Asks
+	}
+
+	err := sideToRemove.RemoveOrder(el)
+	if err != nil {
+		return fmt.Errorf("can't cancel order: %w", err)
+	}
+	ob.deleteOrder(o)
+	ob.emitCanceled(o, sideToRemove.remaining(o))
+	ob.emitLevel(sideToRemove, o.price, o.fixedPrice)
 
 	return nil
 }
+
+// reservation calculates how much funds the order needs for the amount:
+// a bid needs price * amount of QuoteAsset, an ask needs amount of BaseAsset.
+func (ob *OrderBook) reservation(o *Order, amount *apd.Decimal) (Asset, *apd.Decimal, error) {
+	if o.operationType == Ask {
+		return ob.BaseAsset, amount, nil
+	}
+
+	total := apd.New(0, 0)
+	_, err := apd.BaseContext.Mul(total, o.price, amount)
+
+	return ob.QuoteAsset, total, err
+}
+
+// releaseMoves returns the move which releases the reserved funds of the amount left of the order.
+func (ob *OrderBook) releaseMoves(o *Order) ([]balanceMove, error) {
+	amount := ob.remaining(o)
+	if ob.Accounts == nil || amount.IsZero() {
+		return nil, nil
+	}
+
+	asset, funds, err := ob.reservation(o, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	return []balanceMove{{op: opRelease, from: o.accountID, asset: asset, amount: funds}}, nil
+}
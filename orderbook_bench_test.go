@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/cockroachdb/apd"
+)
+
+// benchBatch is a number of the orders which are made at once out of the timer.
+const benchBatch = 4096
+
+// benchOrders makes the orders of the benchmark by batches out of the timer,
+// so only the allocations of the order book are reported.
+type benchOrders struct {
+	b      *testing.B
+	make   func(i int) *Order
+	orders []*Order
+	n      int
+}
+
+// next returns the next order.
+func (bo *benchOrders) next() *Order {
+	if bo.n%benchBatch == 0 {
+		bo.b.StopTimer()
+		bo.orders = bo.orders[:0]
+		for i := 0; i < benchBatch; i++ {
+			bo.orders = append(bo.orders, bo.make(bo.n+i))
+		}
+		bo.b.StartTimer()
+	}
+
+	o := bo.orders[bo.n%benchBatch]
+	bo.n++
+
+	return o
+}
+
+// benchOrderBook runs the benchmark by the decimal, the fixed-point and the fixed-point order book with the tick ladder.
+func benchOrderBook(b *testing.B, prepare func(b *testing.B, ob *OrderBook), run func(b *testing.B, ob *OrderBook)) {
+	for _, name := range []string{"decimal", "fixed", "ladder"} {
+		name := name
+
+		b.Run(name, func(b *testing.B) {
+			ob := NewOrderBook("BTC", "USDT")
+			if name != "decimal" {
+				err := ob.SetFixedPoint(context.Background(), FixedPoint{PriceDecimals: 2, AmountDecimals: 8})
+				if err != nil {
+					b.Fatalf("unexpected err: %v", err)
+				}
+			}
+			if name == "ladder" {
+				err := ob.SetPriceLadder(context.Background(), PriceLadder{
+					MinPrice: apd.New(1, 0), MaxPrice: apd.New(40000, 0), TickSize: apd.New(1, 0),
+				})
+				if err != nil {
+					b.Fatalf("unexpected err: %v", err)
+				}
+			}
+			if prepare != nil {
+				prepare(b, ob)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			run(b, ob)
+		})
+	}
+}
+
+func benchOrder(id string, side OperationType, amount, price int64) *Order {
+	return &Order{
+		orderID:       OrderID(id),
+		accountID:     "bench",
+		operationType: side,
+		amount:        apd.New(amount, 0),
+		price:         apd.New(price, 0),
+	}
+}
+
+func placeBench(b *testing.B, ob *OrderBook, o *Order) {
+	_, err := ob.PlaceLimitOrder(context.Background(), o)
+	if err != nil {
+		b.Fatalf("unexpected err: %v", err)
+	}
+}
+
+// BenchmarkRestingOrder places the orders which rest in the order book and cancels them later,
+// so there are about 1000 orders on 200 levels.
+func BenchmarkRestingOrder(b *testing.B) {
+	benchOrderBook(b, nil, func(b *testing.B, ob *OrderBook) {
+		orders := &benchOrders{b: b, make: func(i int) *Order {
+			if i%2 == 0 {
+				return benchOrder(strconv.Itoa(i), Ask, 1, int64(10000+i%100))
+			}
+
+			return benchOrder(strconv.Itoa(i), Bid, 1, int64(9000-i%100))
+		}}
+
+		placed := make([]OrderID, 1000)
+		for i := 0; i < b.N; i++ {
+			o := orders.next()
+			placeBench(b, ob, o)
+
+			old := placed[i%len(placed)]
+			placed[i%len(placed)] = o.orderID
+			if old == "" {
+				continue
+			}
+
+			err := ob.Cancel(context.Background(), old)
+			if err != nil {
+				b.Fatalf("unexpected err: %v", err)
+			}
+		}
+	})
+}
+
+// BenchmarkSweep places 10 asks on 10 levels and the bid which executes all of them.
+func BenchmarkSweep(b *testing.B) {
+	benchOrderBook(b, nil, func(b *testing.B, ob *OrderBook) {
+		orders := &benchOrders{b: b, make: func(i int) *Order {
+			if i%11 == 10 {
+				return benchOrder(strconv.Itoa(i), Bid, 10, 109)
+			}
+
+			return benchOrder(strconv.Itoa(i), Ask, 1, int64(100+i%11))
+		}}
+
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 11; j++ {
+				placeBench(b, ob, orders.next())
+			}
+		}
+	})
+}
+
+// BenchmarkDeepBook executes the best level of the order book with 10000 levels on every side and replenishes it.
+func BenchmarkDeepBook(b *testing.B) {
+	prepare := func(b *testing.B, ob *OrderBook) {
+		for i := 0; i < 10000; i++ {
+			placeBench(b, ob, benchOrder("ask-"+strconv.Itoa(i), Ask, 1, int64(20000+i)))
+			placeBench(b, ob, benchOrder("bid-"+strconv.Itoa(i), Bid, 1, int64(19999-i)))
+		}
+	}
+
+	benchOrderBook(b, prepare, func(b *testing.B, ob *OrderBook) {
+		orders := &benchOrders{b: b, make: func(i int) *Order {
+			switch i % 4 {
+			case 0: // takes the best ask.
+				return benchOrder(strconv.Itoa(i), Bid, 1, 20000)
+			case 1: // replenishes it.
+				return benchOrder(strconv.Itoa(i), Ask, 1, 20000)
+			case 2: // takes the best bid.
+				return benchOrder(strconv.Itoa(i), Ask, 1, 19999)
+			default: // replenishes it.
+				return benchOrder(strconv.Itoa(i), Bid, 1, 19999)
+			}
+		}}
+
+		for i := 0; i < b.N; i++ {
+			placeBench(b, ob, orders.next())
+		}
+	})
+}
@@ -351,7 +351,7 @@ func Test_LimitOrderExecution(t *testing.T) {
 			},
 			ordersExecutedExpected: 7,
 			expectedAskData: []string{
-				"`1` orders with price: `20150` with amount: `1.0`",
+				"`1` orders with price: `20150` with amount: `1.5`",
 			},
 		},
 		{
@@ -480,7 +480,7 @@ func Test_LimitOrderExecution(t *testing.T) {
 			},
 			ordersExecutedExpected: 7,
 			expectedBidData: []string{
-				"`1` orders with price: `19850` with amount: `1.0`",
+				"`1` orders with price: `19850` with amount: `1.5`",
 			},
 		},
 		{
@@ -648,7 +648,7 @@ func Test_MarketOrderExecution(t *testing.T) {
 			},
 			ordersExecutedExpected: 7,
 			expectedAskData: []string{
-				"`1` orders with price: `20150` with amount: `1.0`",
+				"`1` orders with price: `20150` with amount: `1.5`",
 			},
 			amountLeftExpected: "0.0",
 		},
@@ -763,7 +763,7 @@ func Test_MarketOrderExecution(t *testing.T) {
 			},
 			ordersExecutedExpected: 7,
 			expectedBidData: []string{
-				"`1` orders with price: `19850` with amount: `1.0`",
+				"`1` orders with price: `19850` with amount: `1.5`",
 			},
 			amountLeftExpected: "0.0",
 		},
@@ -874,3 +874,24 @@ func Test_OrderBookRollback(t *testing.T) {
 		t.Fatalf("unexpected err")
 	}
 }
+
+func Test_LimitOrderExecutionSamePrice(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+
+	// the level is executed exactly by the amount of the order, then the same price is used again.
+	for _, o := range []*Order{
+		{orderID: "1", operationType: Ask, amount: apd.New(1, 0), price: apd.New(100, 0)},
+		{orderID: "2", operationType: Bid, amount: apd.New(1, 0), price: apd.New(100, 0)},
+		{orderID: "3", operationType: Bid, amount: apd.New(1, 0), price: apd.New(100, 0)},
+		{orderID: "4", operationType: Ask, amount: apd.New(1, 0), price: apd.New(100, 0)},
+	} {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	if ob.Asks.index.Len() != 0 || ob.Bids.index.Len() != 0 {
+		t.Fatalf("expected empty order book, but got: %s, %s", ob.Asks, ob.Bids)
+	}
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/apd"
+)
+
+// defaultOrderFeedRetention is a default number of the messages which are kept for the retransmission.
+const defaultOrderFeedRetention = 100000
+
+// Types of the order feed messages.
+const (
+	// FeedAddOrder is an order which is added to the order book.
+	FeedAddOrder byte = 'A'
+	// FeedOrderExecuted is an execution of the resting order, Amount is executed.
+	FeedOrderExecuted byte = 'E'
+	// FeedOrderCancel is a cancel of the resting order, Amount is canceled and the order is removed.
+	FeedOrderCancel byte = 'X'
+	// FeedOrderRefill is an amount which is given back to the resting order by the rollback,
+	// the order keeps its place in the queue. The filled order which is restored by the rollback is added again.
+	FeedOrderRefill byte = 'R'
+	// FeedTrade is an execution of the aggressor order, it has the same MatchNumber as FeedOrderExecuted
+	// of the resting order. It doesn't change the order book.
+	FeedTrade byte = 'P'
+)
+
+// Sizes of the binary encoding.
+const (
+	// feedInstrumentSize is a size of the instrument in the packet header, it's padded by spaces.
+	feedInstrumentSize = 16
+	// feedPacketHeaderSize is instrument, sequence number of the first message and count of the messages.
+	feedPacketHeaderSize = feedInstrumentSize + 8 + 2
+	// feedDecimalSize is int64 mantissa and int8 exponent.
+	feedDecimalSize = 9
+	// feedMessageHeaderSize is type, timestamp and order reference.
+	feedMessageHeaderSize = 1 + 8 + 8
+)
+
+// ErrFeedFormat is returned for the packet or the message which can't be decoded.
+var ErrFeedFormat = errors.New("wrong order feed format")
+
+// FeedMessage is a message of the order feed. Orders are identified by the references of the feed,
+// not by OrderID. Fields which aren't used by the type are zero:
+//
+//	A  AddOrder       OrderRef, Side, Amount, Price
+//	E  OrderExecuted  OrderRef, Amount, Price, MatchNumber
+//	X  OrderCancel    OrderRef, Amount
+//	R  OrderRefill    OrderRef, Amount
+//	P  Trade          OrderRef, Side, Amount, Price, MatchNumber
+//
+// Every field has the fixed width, integers are big-endian, decimals are int64 mantissa and int8 exponent.
+type FeedMessage struct {
+	Type byte
+	// Timestamp is nanoseconds since the Unix epoch.
+	Timestamp   uint64
+	OrderRef    uint64
+	Side        OperationType
+	Amount      *apd.Decimal
+	Price       *apd.Decimal
+	MatchNumber uint64
+}
+
+// feedMessageSize returns the size of the message by its type.
+func feedMessageSize(t byte) (int, bool) {
+	switch t {
+	case FeedAddOrder:
+		return feedMessageHeaderSize + 1 + 2*feedDecimalSize, true
+	case FeedOrderExecuted:
+		return feedMessageHeaderSize + 2*feedDecimalSize + 8, true
+	case FeedOrderCancel, FeedOrderRefill:
+		return feedMessageHeaderSize + feedDecimalSize, true
+	case FeedTrade:
+		return feedMessageHeaderSize + 1 + 2*feedDecimalSize + 8, true
+	default:
+		return 0, false
+	}
+}
+
+// Encode encodes the message.
+func (m *FeedMessage) Encode() ([]byte, error) {
+	size, ok := feedMessageSize(m.Type)
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown message type: %q", ErrFeedFormat, m.Type)
+	}
+
+	b := make([]byte, 0, size)
+	b = append(b, m.Type)
+	b = binary.BigEndian.AppendUint64(b, m.Timestamp)
+	b = binary.BigEndian.AppendUint64(b, m.OrderRef)
+
+	if m.Type == FeedAddOrder || m.Type == FeedTrade {
+		b = append(b, feedSide(m.Side))
+	}
+
+	b, err := appendFeedDecimal(b, m.Amount)
+	if err != nil {
+		return nil, err
+	}
+	if m.Type != FeedOrderCancel && m.Type != FeedOrderRefill {
+		b, err = appendFeedDecimal(b, m.Price)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if m.Type == FeedOrderExecuted || m.Type == FeedTrade {
+		b = binary.BigEndian.AppendUint64(b, m.MatchNumber)
+	}
+
+	return b, nil
+}
+
+// DecodeFeedMessage decodes the message.
+func DecodeFeedMessage(b []byte) (FeedMessage, error) {
+	if len(b) == 0 {
+		return FeedMessage{}, fmt.Errorf("%w: empty message", ErrFeedFormat)
+	}
+
+	m := FeedMessage{Type: b[0]}
+	size, ok := feedMessageSize(m.Type)
+	if !ok {
+		return FeedMessage{}, fmt.Errorf("%w: unknown message type: %q", ErrFeedFormat, m.Type)
+	}
+	if len(b) != size {
+		return FeedMessage{}, fmt.Errorf("%w: message %q has %d bytes, expected: %d", ErrFeedFormat, m.Type, len(b), size)
+	}
+
+	m.Timestamp = binary.BigEndian.Uint64(b[1:])
+	m.OrderRef = binary.BigEndian.Uint64(b[9:])
+	b = b[feedMessageHeaderSize:]
+
+	var err error
+	if m.Type == FeedAddOrder || m.Type == FeedTrade {
+		m.Side, err = parseFeedSide(b[0])
+		if err != nil {
+			return FeedMessage{}, err
+		}
+		b = b[1:]
+	}
+
+	m.Amount = readFeedDecimal(b)
+	b = b[feedDecimalSize:]
+
+	if m.Type != FeedOrderCancel && m.Type != FeedOrderRefill {
+		m.Price = readFeedDecimal(b)
+		b = b[feedDecimalSize:]
+	}
+
+	if m.Type == FeedOrderExecuted || m.Type == FeedTrade {
+		m.MatchNumber = binary.BigEndian.Uint64(b)
+	}
+
+	return m, nil
+}
+
+// feedSide encodes the side: B - buy, S - sell.
+func feedSide(side OperationType) byte {
+	if side == Bid {
+		return 'B'
+	}
+
+	return 'S'
+}
+
+// parseFeedSide decodes the side.
+func parseFeedSide(b byte) (OperationType, error) {
+	switch b {
+	case 'B':
+		return Bid, nil
+	case 'S':
+		return Ask, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown side: %q", ErrFeedFormat, b)
+	}
+}
+
+// appendFeedDecimal appends the decimal as int64 mantissa and int8 exponent.
+func appendFeedDecimal(b []byte, d *apd.Decimal) ([]byte, error) {
+	mantissa, exponent, err := feedDecimal(d)
+	if err != nil {
+		return nil, err
+	}
+
+	b = binary.BigEndian.AppendUint64(b, uint64(mantissa))
+
+	return append(b, byte(exponent)), nil
+}
+
+// feedDecimal returns the decimal as int64 mantissa and int8 exponent. The decimal isn't rounded,
+// the error is returned if it doesn't fit them.
+func feedDecimal(d *apd.Decimal) (int64, int8, error) {
+	v := apd.New(0, 0)
+	if d != nil {
+		v.Reduce(d)
+	}
+
+	// the big exponent is moved to the mantissa, like 1E+130 is 1000E+127.
+	if v.Exponent > math.MaxInt8 && v.Coeff.BitLen() <= 63 {
+		scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(v.Exponent-math.MaxInt8)), nil)
+		v.Coeff.Mul(&v.Coeff, scale)
+		v.Exponent = math.MaxInt8
+	}
+
+	if v.Coeff.BitLen() > 63 || v.Exponent < math.MinInt8 || v.Exponent > math.MaxInt8 {
+		return 0, 0, fmt.Errorf("%w: %s doesn't fit int64 mantissa and int8 exponent", ErrFeedFormat, d)
+	}
+
+	mantissa := v.Coeff.Int64()
+	if v.Negative {
+		mantissa = -mantissa
+	}
+
+	return mantissa, int8(v.Exponent), nil
+}
+
+// readFeedDecimal reads the decimal which is encoded by appendFeedDecimal.
+func readFeedDecimal(b []byte) *apd.Decimal {
+	return apd.New(int64(binary.BigEndian.Uint64(b)), int32(int8(b[8])))
+}
+
+// FeedPacket is a packet of the order feed: the messages of the order book starting from Sequence.
+// The packet without messages is a heartbeat, its Sequence is the next one.
+type FeedPacket struct {
+	Instrument string
+	Sequence   uint64
+	Messages   []FeedMessage
+}
+
+// encodeFeedPacket encodes the packet: instrument, sequence number, count and the messages prefixed by the length.
+func encodeFeedPacket(instrument string, seq uint64, messages [][]byte) []byte {
+	size := feedPacketHeaderSize
+	for _, m := range messages {
+		size += 2 + len(m)
+	}
+
+	b := make([]byte, 0, size)
+	b = append(b, fmt.Sprintf("%-*s", feedInstrumentSize, instrument)[:feedInstrumentSize]...)
+	b = binary.BigEndian.AppendUint64(b, seq)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(messages)))
+	for _, m := range messages {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(m)))
+		b = append(b, m...)
+	}
+
+	return b
+}
+
+// DecodeFeedPacket decodes the packet, for example the UDP datagram.
+func DecodeFeedPacket(b []byte) (FeedPacket, error) {
+	if len(b) < feedPacketHeaderSize {
+		return FeedPacket{}, fmt.Errorf("%w: packet is too short", ErrFeedFormat)
+	}
+
+	p := FeedPacket{
+		Instrument: strings.TrimRight(string(b[:feedInstrumentSize]), " "),
+		Sequence:   binary.BigEndian.Uint64(b[feedInstrumentSize:]),
+	}
+	count := int(binary.BigEndian.Uint16(b[feedInstrumentSize+8:]))
+	b = b[feedPacketHeaderSize:]
+
+	for i := 0; i < count; i++ {
+		if len(b) < 2 {
+			return FeedPacket{}, fmt.Errorf("%w: packet is too short", ErrFeedFormat)
+		}
+
+		size := int(binary.BigEndian.Uint16(b))
+		if len(b) < 2+size {
+			return FeedPacket{}, fmt.Errorf("%w: packet is too short", ErrFeedFormat)
+		}
+
+		m, err := DecodeFeedMessage(b[2 : 2+size])
+		if err != nil {
+			return FeedPacket{}, err
+		}
+		p.Messages = append(p.Messages, m)
+		b = b[2+size:]
+	}
+
+	if len(b) != 0 {
+		return FeedPacket{}, fmt.Errorf("%w: %d extra bytes in packet", ErrFeedFormat, len(b))
+	}
+
+	return p, nil
+}
+
+// OrderFeed is a binary feed of the order-level changes of the order book (L3): every order which is added,
+// executed, canceled or refilled gets the message with the next sequence number of the order book.
+// It's built by the events of the order book.
+// The last messages are kept for the retransmission, the older ones are dropped.
+type OrderFeed struct {
+	NopEventListener
+
+	seq     uint64
+	history [][]byte
+
+	// refs are the references of the resting orders in the feed.
+	refs    map[OrderID]uint64
+	lastRef uint64
+	// aggressor is the order which executes the resting ones now, it gets the reference on the first execution
+	// and keeps it if it's added to the order book after.
+	aggressor    OrderID
+	aggressorRef uint64
+	match        uint64
+
+	// updated is closed and replaced on every message.
+	updated chan struct{}
+
+	mx sync.Mutex
+}
+
+// NewOrderFeed creates a new instance of OrderFeed which keeps retention last messages for the retransmission.
+func NewOrderFeed(retention int) *OrderFeed {
+	if retention <= 0 {
+		retention = defaultOrderFeedRetention
+	}
+
+	return &OrderFeed{
+		history: make([][]byte, retention),
+		refs:    map[OrderID]uint64{},
+		updated: make(chan struct{}),
+		mx:      sync.Mutex{},
+	}
+}
+
+// SetOrderFeed attaches the order feed to the order book, the resting orders are published as added ones
+// in the order of their priority, so the consumers can build the order book from the first message.
+// The order feed isn't attached if some resting order doesn't fit it.
+func (ob *OrderBook) SetOrderFeed(feed *OrderFeed) error {
+	_ = ob.lock(context.Background())
+	defer ob.release()
+
+	for _, side := range []*OrderSide{ob.Asks, ob.Bids} {
+		for _, o := range side.orders() {
+			err := checkFeedDecimals(o.price, side.remaining(o))
+			if err != nil {
+				return fmt.Errorf("can't publish order: %s: %w", o.orderID, err)
+			}
+		}
+	}
+
+	ob.OrderFeed = feed
+	for _, side := range []*OrderSide{ob.Asks, ob.Bids} {
+		for _, o := range side.orders() {
+			feed.OrderRested(OrderRested{
+				OrderID:   o.orderID,
+				AccountID: o.accountID,
+				Side:      o.operationType,
+				Price:     o.price,
+				Amount:    side.remaining(o),
+			})
+		}
+	}
+
+	return nil
+}
+
+// checkFeed checks that the order feed can publish the order without rounding: the amounts of the fills,
+// the amounts which are left to the makers, and the price and the rest of the limit order.
+func (ob *OrderBook) checkFeed(o *Order, fills []*ExecutionReport, market bool) error {
+	if ob.OrderFeed == nil {
+		return nil
+	}
+
+	left := apd.New(0, 0).Set(o.amount)
+	var values []*apd.Decimal
+	for _, e := range fills {
+		makerLeft := apd.New(0, 0)
+		if el, ok := ob.Orders[e.executorOrderID]; ok {
+			_, err := apd.BaseContext.Sub(makerLeft, ob.remaining(el.Value.(*Order)), e.amount)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := apd.BaseContext.Sub(left, left, e.amount)
+		if err != nil {
+			return err
+		}
+
+		values = append(values, e.amount, makerLeft)
+	}
+	if !market {
+		values = append(values, o.price, left)
+	}
+
+	return checkFeedDecimals(values...)
+}
+
+// checkFeedDecimals checks that the decimals fit the order feed.
+func checkFeedDecimals(values ...*apd.Decimal) error {
+	for _, v := range values {
+		_, _, err := feedDecimal(v)
+		if err != nil {
+			return newOrderError(ErrInvalidPrecision, "%s doesn't fit the order feed", v)
+		}
+	}
+
+	return nil
+}
+
+// orders returns the orders of the side from the best price in the order of the queue.
+func (os *OrderSide) orders() []*Order {
+	var orders []*Order
+
+	for level := os.best(); level != nil; level = os.worse(level) {
+		for el := level.orders.Front(); el != nil; el = el.Next() {
+			orders = append(orders, el.Value.(*Order))
+		}
+	}
+
+	return orders
+}
+
+// publish encodes the message and adds it to the history with the next sequence number.
+func (f *OrderFeed) publish(m FeedMessage) {
+	m.Timestamp = uint64(time.Now().UnixNano())
+	data, err := m.Encode()
+	if err != nil {
+		// the feed publishes only the known types, the decimals are checked before the order book is changed.
+		panic(err)
+	}
+
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	f.seq++
+	f.history[(f.seq-1)%uint64(len(f.history))] = data
+
+	close(f.updated)
+	f.updated = make(chan struct{})
+}
+
+// messages returns the messages from the sequence number, which fit maxBytes of the packet,
+// the first one is the sequence number of the first message. If the messages are dropped already
+// the first available message is returned. The channel is closed when there are new messages.
+func (f *OrderFeed) messages(from uint64, maxBytes int) (first uint64, messages [][]byte, updated <-chan struct{}) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	first = from
+	if oldest := f.oldest(); first < oldest {
+		first = oldest
+	}
+	if first > f.seq+1 {
+		first = f.seq + 1
+	}
+
+	size := feedPacketHeaderSize
+	for seq := first; seq <= f.seq; seq++ {
+		m := f.history[(seq-1)%uint64(len(f.history))]
+		if size+2+len(m) > maxBytes {
+			break
+		}
+
+		size += 2 + len(m)
+		messages = append(messages, m)
+	}
+
+	return first, messages, f.updated
+}
+
+// oldest returns the sequence number of the oldest message in the history, it must be called under the lock.
+func (f *OrderFeed) oldest() uint64 {
+	if f.seq < uint64(len(f.history)) {
+		return 1
+	}
+
+	return f.seq - uint64(len(f.history)) + 1
+}
+
+// OrderRested publishes the order which is added to the order book.
+func (f *OrderFeed) OrderRested(e OrderRested) {
+	ref := f.aggressorRef
+	if e.OrderID != f.aggressor {
+		f.lastRef++
+		ref = f.lastRef
+	}
+	f.aggressor, f.aggressorRef = "", 0
+	f.refs[e.OrderID] = ref
+
+	f.publish(FeedMessage{
+		Type:     FeedAddOrder,
+		OrderRef: ref,
+		Side:     e.Side,
+		Amount:   e.Amount,
+		Price:    e.Price,
+	})
+}
+
+// OrderCanceled publishes the cancel of the resting order, the rest of the market order isn't published.
+func (f *OrderFeed) OrderCanceled(e OrderCanceled) {
+	ref, ok := f.refs[e.OrderID]
+	if !ok {
+		return
+	}
+	delete(f.refs, e.OrderID)
+
+	f.publish(FeedMessage{Type: FeedOrderCancel, OrderRef: ref, Amount: e.Amount})
+}
+
+// OrderRolledBack publishes the amount which is given back to the resting order or the restored order.
+func (f *OrderFeed) OrderRolledBack(e OrderRolledBack) {
+	if e.Restored {
+		f.lastRef++
+		f.refs[e.ExecutorOrderID] = f.lastRef
+
+		f.publish(FeedMessage{
+			Type:     FeedAddOrder,
+			OrderRef: f.lastRef,
+			Side:     opposite(e.Side),
+			Amount:   e.Amount,
+			Price:    e.Price,
+		})
+
+		return
+	}
+
+	ref, ok := f.refs[e.ExecutorOrderID]
+	if !ok || !e.Refilled {
+		return
+	}
+
+	f.publish(FeedMessage{Type: FeedOrderRefill, OrderRef: ref, Amount: e.Amount})
+}
+
+// OrderPartiallyFilled publishes the execution of the order.
+func (f *OrderFeed) OrderPartiallyFilled(e OrderFill) {
+	f.executed(e)
+}
+
+// OrderFilled publishes the execution of the order.
+func (f *OrderFeed) OrderFilled(e OrderFill) {
+	f.executed(e)
+}
+
+// executed publishes the execution of the resting order by the fill of the maker,
+// then the trade of the aggressor by the fill of the taker with the same match number.
+func (f *OrderFeed) executed(e OrderFill) {
+	if !e.Maker {
+		if f.aggressor != e.OrderID {
+			f.lastRef++
+			f.aggressor, f.aggressorRef = e.OrderID, f.lastRef
+		}
+
+		f.publish(FeedMessage{
+			Type:        FeedTrade,
+			OrderRef:    f.aggressorRef,
+			Side:        e.Side,
+			Amount:      e.Amount,
+			Price:       e.Price,
+			MatchNumber: f.match,
+		})
+
+		return
+	}
+
+	ref, ok := f.refs[e.OrderID]
+	if !ok {
+		return
+	}
+	if e.Remaining.IsZero() {
+		delete(f.refs, e.OrderID)
+	}
+	f.match++
+
+	f.publish(FeedMessage{
+		Type:        FeedOrderExecuted,
+		OrderRef:    ref,
+		Amount:      e.Amount,
+		Price:       e.Price,
+		MatchNumber: f.match,
+	})
+}
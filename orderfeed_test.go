@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cockroachdb/apd"
+)
+
+type testFeedOrder struct {
+	side   OperationType
+	price  *apd.Decimal
+	amount *apd.Decimal
+}
+
+// feedMessages returns the decoded messages of the feed from the sequence number.
+func feedMessages(t *testing.T, feed *OrderFeed, from uint64) []FeedMessage {
+	t.Helper()
+
+	first, data, _ := feed.messages(from, maxFeedRetransmission)
+	if first != from {
+		t.Fatalf("expected %d seq, but got: %d", from, first)
+	}
+
+	messages := make([]FeedMessage, 0, len(data))
+	for _, b := range data {
+		m, err := DecodeFeedMessage(b)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		messages = append(messages, m)
+	}
+
+	return messages
+}
+
+// applyFeedMessages applies the messages to the orders by the references.
+func applyFeedMessages(t *testing.T, orders map[uint64]*testFeedOrder, messages ...FeedMessage) {
+	t.Helper()
+
+	for _, m := range messages {
+		if m.Type == FeedAddOrder {
+			if _, ok := orders[m.OrderRef]; ok {
+				t.Fatalf("order ref: %d is added twice", m.OrderRef)
+			}
+
+			orders[m.OrderRef] = &testFeedOrder{side: m.Side, price: m.Price, amount: m.Amount}
+			continue
+		}
+		if m.Type == FeedTrade {
+			continue
+		}
+
+		o, ok := orders[m.OrderRef]
+		if !ok {
+			t.Fatalf("unknown order ref: %d of %q", m.OrderRef, m.Type)
+		}
+
+		switch m.Type {
+		case FeedOrderExecuted:
+			_, _ = apd.BaseContext.Sub(o.amount, o.amount, m.Amount)
+		case FeedOrderRefill:
+			_, _ = apd.BaseContext.Add(o.amount, o.amount, m.Amount)
+		case FeedOrderCancel:
+			if o.amount.Cmp(m.Amount) != 0 {
+				t.Fatalf("expected %s canceled, but got: %s", o.amount, m.Amount)
+			}
+			o.amount = apd.New(0, 0)
+		}
+
+		if o.amount.IsZero() {
+			delete(orders, m.OrderRef)
+		}
+	}
+}
+
+// checkFeedOrders checks that the orders built by the feed are the same as in the order book.
+func checkFeedOrders(t *testing.T, ob *OrderBook, orders map[uint64]*testFeedOrder) {
+	t.Helper()
+
+	total := 0
+	for _, side := range []*OrderSide{ob.Asks, ob.Bids} {
+		for _, o := range side.orders() {
+			total++
+
+			ref, ok := ob.OrderFeed.refs[o.orderID]
+			if !ok {
+				t.Fatalf("order: %s has no ref", o.orderID)
+			}
+
+			fo, ok := orders[ref]
+			if !ok || fo.side != o.operationType || fo.price.Cmp(o.price) != 0 || fo.amount.Cmp(o.amount) != 0 {
+				t.Fatalf("order: %s doesn't match the feed: %+v", o.orderID, fo)
+			}
+		}
+	}
+
+	if total != len(orders) {
+		t.Fatalf("expected %d orders, but got: %d", total, len(orders))
+	}
+}
+
+func Test_FeedMessageEncode(t *testing.T) {
+	messages := []FeedMessage{
+		{Type: FeedAddOrder, Timestamp: 1, OrderRef: 2, Side: Bid, Amount: decimal(t, "1.5"), Price: decimal(t, "20000")},
+		{Type: FeedOrderExecuted, Timestamp: 3, OrderRef: 2, Amount: decimal(t, "0.00000001"), Price: decimal(t, "20000"), MatchNumber: 7},
+		{Type: FeedOrderCancel, Timestamp: 4, OrderRef: 2, Amount: decimal(t, "1.49999999")},
+		{Type: FeedOrderRefill, Timestamp: 5, OrderRef: 2, Amount: decimal(t, "1")},
+		{Type: FeedTrade, Timestamp: 6, OrderRef: 8, Side: Ask, Amount: decimal(t, "3"), Price: decimal(t, "0.1"), MatchNumber: 9},
+	}
+
+	data := make([][]byte, 0, len(messages))
+	for _, m := range messages {
+		b, err := m.Encode()
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		size, _ := feedMessageSize(m.Type)
+		if len(b) != size {
+			t.Fatalf("expected %d bytes, but got: %d", size, len(b))
+		}
+		data = append(data, b)
+	}
+
+	p, err := DecodeFeedPacket(encodeFeedPacket("BTC-USDT", 10, data))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if p.Instrument != "BTC-USDT" || p.Sequence != 10 || len(p.Messages) != len(messages) {
+		t.Fatalf("unexpected packet: %+v", p)
+	}
+
+	for i, m := range p.Messages {
+		exp := messages[i]
+		if m.Type != exp.Type || m.Timestamp != exp.Timestamp || m.OrderRef != exp.OrderRef ||
+			m.Side != exp.Side || m.MatchNumber != exp.MatchNumber || m.Amount.Cmp(exp.Amount) != 0 ||
+			(exp.Price != nil && m.Price.Cmp(exp.Price) != 0) {
+			t.Fatalf("expected %+v, but got: %+v", exp, m)
+		}
+	}
+
+	_, err = DecodeFeedPacket(encodeFeedPacket("BTC-USDT", 10, [][]byte{data[0][:10]}))
+	if !errors.Is(err, ErrFeedFormat) {
+		t.Fatalf("expected format err, but got: %v", err)
+	}
+
+	// the big exponent is moved to the mantissa.
+	m := FeedMessage{Type: FeedOrderCancel, Amount: decimal(t, "1E+130")}
+	b, err := m.Encode()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	m, err = DecodeFeedMessage(b)
+	if err != nil || m.Amount.Cmp(decimal(t, "1E+130")) != 0 {
+		t.Fatalf("unexpected amount: %s, %v", m.Amount, err)
+	}
+
+	// the decimals which don't fit aren't rounded.
+	for _, amount := range []string{"1.0000000000000000001", "1E-129", "1E+200"} {
+		m := FeedMessage{Type: FeedOrderCancel, Amount: decimal(t, amount)}
+		_, err = m.Encode()
+		if !errors.Is(err, ErrFeedFormat) {
+			t.Fatalf("expected format err of %s, but got: %v", amount, err)
+		}
+	}
+}
+
+func Test_OrderFeedPrecision(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	err := ob.SetOrderFeed(NewOrderFeed(0))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	_, err = ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID: "a1", operationType: Ask, price: decimal(t, "100"), amount: decimal(t, "1"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	for _, o := range []*Order{
+		{orderID: "b1", operationType: Bid, price: decimal(t, "99.00000000000000000001"), amount: decimal(t, "1")},
+		{orderID: "b2", operationType: Bid, price: decimal(t, "99"), amount: decimal(t, "1.0000000000000000001")},
+		// the amount which is left to the maker doesn't fit.
+		{orderID: "b3", operationType: Bid, price: decimal(t, "100"), amount: decimal(t, "0.0000000000000000001")},
+	} {
+		_, err = ob.PlaceLimitOrder(context.Background(), o)
+		if !errors.Is(err, ErrInvalidPrecision) {
+			t.Fatalf("expected invalid precision err of %s, but got: %v", o.orderID, err)
+		}
+	}
+
+	if ob.OrderFeed.seq != 1 || len(ob.Orders) != 1 || len(ob.OrdersDone) != 1 {
+		t.Fatalf("order book is changed")
+	}
+
+	// the resting order which doesn't fit isn't published.
+	ob = NewOrderBook("BTC", "USDT")
+	_, err = ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID: "a1", operationType: Ask, price: decimal(t, "100"), amount: decimal(t, "1.0000000000000000001"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	err = ob.SetOrderFeed(NewOrderFeed(0))
+	if !errors.Is(err, ErrInvalidPrecision) || ob.OrderFeed != nil {
+		t.Fatalf("expected invalid precision err, but got: %v", err)
+	}
+}
+
+func Test_OrderFeed(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	for _, o := range testOrders()[:5] {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	// the resting orders are added when the feed is attached.
+	err := ob.SetOrderFeed(NewOrderFeed(0))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	orders := map[uint64]*testFeedOrder{}
+	messages := feedMessages(t, ob.OrderFeed, 1)
+	if len(messages) != 5 {
+		t.Fatalf("expected %d messages, but got: %d", 5, len(messages))
+	}
+	applyFeedMessages(t, orders, messages...)
+	checkFeedOrders(t, ob, orders)
+
+	for _, o := range testOrders()[5:] {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	o := &Order{orderID: "100500", operationType: Bid, price: decimal(t, "20100"), amount: decimal(t, "2.5")}
+	_, _, err = ob.PlaceMarketOrder(context.Background(), o)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	err = ob.Cancel(context.Background(), "44")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	seq := uint64(len(messages)) + 1
+	messages = feedMessages(t, ob.OrderFeed, seq)
+	applyFeedMessages(t, orders, messages...)
+	checkFeedOrders(t, ob, orders)
+
+	trades := 0
+	for _, m := range messages {
+		if m.Type == FeedTrade {
+			trades++
+			if m.Side != Bid {
+				t.Fatalf("wrong trade: %+v", m)
+			}
+		}
+	}
+	if trades != len(o.executions) {
+		t.Fatalf("expected %d trades, but got: %d", len(o.executions), trades)
+	}
+
+	err = ob.Rollback(context.Background(), "100500")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	seq += uint64(len(messages))
+	applyFeedMessages(t, orders, feedMessages(t, ob.OrderFeed, seq)...)
+	checkFeedOrders(t, ob, orders)
+}
+
+func Test_OrderFeedSequence(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	err := ob.SetOrderFeed(NewOrderFeed(0))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	place := func(id OrderID, side OperationType, price, amount string) {
+		_, err := ob.PlaceLimitOrder(context.Background(), &Order{
+			orderID: id, operationType: side, price: decimal(t, price), amount: decimal(t, amount),
+		})
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	place("a1", Ask, "100", "1")
+	place("a2", Ask, "101", "2")
+	// executes a1 and a2, the rest is added with the ref of the trades.
+	place("b1", Bid, "101", "4")
+
+	err = ob.Cancel(context.Background(), "b1")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	expected := []FeedMessage{
+		{Type: FeedAddOrder, OrderRef: 1, Side: Ask, Amount: decimal(t, "1"), Price: decimal(t, "100")},
+		{Type: FeedAddOrder, OrderRef: 2, Side: Ask, Amount: decimal(t, "2"), Price: decimal(t, "101")},
+		{Type: FeedOrderExecuted, OrderRef: 1, Amount: decimal(t, "1"), Price: decimal(t, "100"), MatchNumber: 1},
+		{Type: FeedTrade, OrderRef: 3, Side: Bid, Amount: decimal(t, "1"), Price: decimal(t, "100"), MatchNumber: 1},
+		{Type: FeedOrderExecuted, OrderRef: 2, Amount: decimal(t, "2"), Price: decimal(t, "101"), MatchNumber: 2},
+		{Type: FeedTrade, OrderRef: 3, Side: Bid, Amount: decimal(t, "2"), Price: decimal(t, "101"), MatchNumber: 2},
+		{Type: FeedAddOrder, OrderRef: 3, Side: Bid, Amount: decimal(t, "1"), Price: decimal(t, "101")},
+		{Type: FeedOrderCancel, OrderRef: 3, Amount: decimal(t, "1")},
+	}
+
+	messages := feedMessages(t, ob.OrderFeed, 1)
+	if len(messages) != len(expected) {
+		t.Fatalf("expected %d messages, but got: %d", len(expected), len(messages))
+	}
+	for i, m := range messages {
+		exp := expected[i]
+		if m.Type != exp.Type || m.OrderRef != exp.OrderRef || m.Side != exp.Side ||
+			m.MatchNumber != exp.MatchNumber || m.Amount.Cmp(exp.Amount) != 0 ||
+			(exp.Price != nil && m.Price.Cmp(exp.Price) != 0) {
+			t.Fatalf("expected %+v, but got: %+v", exp, m)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// snapshotFilePrefix and snapshotFileExt make the name of the snapshot file: snapshot-<seq>.bin.
+	snapshotFilePrefix = "snapshot-"
+	snapshotFileExt    = ".bin"
+)
+
+// SaveSnapshot writes the snapshot of the order book to the dir, the file name has the sequence number
+// of the last command in the snapshot. The file is written to a temporary file and renamed,
+// so a crash doesn't leave a partial snapshot with the final name.
+func (ob *OrderBook) SaveSnapshot(dir string) (string, error) {
+	_ = ob.lock(context.Background())
+	data, seq := ob.snapshot(), ob.seq
+	ob.release()
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return "", fmt.Errorf("can't create snapshot dir: %w", err)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s%020d%s", snapshotFilePrefix, seq, snapshotFileExt))
+
+	f, err := os.CreateTemp(dir, snapshotFilePrefix+"*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("can't create snapshot: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("can't write snapshot: %w", err)
+	}
+
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return "", fmt.Errorf("can't rename snapshot: %w", err)
+	}
+
+	return path, nil
+}
+
+// snapshotFiles returns the snapshot files in the dir sorted from the latest one.
+func snapshotFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("can't read snapshot dir: %w", err)
+	}
+
+	files := make([]string, 0)
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasPrefix(name, snapshotFilePrefix) && strings.HasSuffix(name, snapshotFileExt) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+
+	// the sequence number has a fixed width, so the names are sorted as the numbers.
+	sort.Sort(sort.Reverse(sort.StringSlice(files)))
+
+	return files, nil
+}
+
+// loadLatestSnapshot restores the order book with the parts from the latest valid snapshot in the dir.
+// It returns nil if there are no valid snapshots.
+func loadLatestSnapshot(dir string, parts OrderBookParts) (*OrderBook, error) {
+	files, err := snapshotFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("can't read snapshot: %w", err)
+		}
+
+		ob, err := restoreOrderBook(data, parts)
+		if errors.Is(err, ErrCorruptedSnapshot) {
+			// the snapshot is broken - let's try the previous one.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return ob, nil
+	}
+
+	return nil, nil
+}
+
+// RecoverOrderBook restores the order book after restart or crash: it loads the latest valid snapshot from the dir
+// and replays the journal commands after the sequence number of the snapshot. The torn record at the end of the journal
+// is truncated. The recovered order book has the journal attached, so the new commands continue the sequence.
+// The new order book is created in the mode, the snapshot must have the same one.
+//
+// The empty parts are attached to the order book before the replay, so the balances, the fee volumes and the ledger
+// are rebuilt with the orders. The snapshot must have the state of the same parts. Risk isn't a part of the state,
+// it should be attached after the recovery.
+func RecoverOrderBook(
+	ctx context.Context, dir string, baseAsset, quoteAsset Asset, mode OrderBookMode, parts OrderBookParts, cfg JournalConfig,
+) (*OrderBook, error) {
+	err := parts.checkEmpty()
+	if err != nil {
+		return nil, err
+	}
+
+	ob, err := loadLatestSnapshot(dir, parts)
+	if err != nil {
+		return nil, err
+	}
+	if ob == nil {
+		ob = NewOrderBook(baseAsset, quoteAsset)
+		err = ob.setMode(ctx, mode)
+		if err != nil {
+			return nil, fmt.Errorf("can't set mode of order book: %w", err)
+		}
+		ob.attach(parts)
+	}
+	if ob.BaseAsset != baseAsset || ob.QuoteAsset != quoteAsset {
+		return nil, fmt.Errorf("snapshot is for %s, but %s-%s is recovered", ob.Instrument(), baseAsset, quoteAsset)
+	}
+	if !ob.Mode().equal(mode) {
+		return nil, fmt.Errorf("snapshot of %s has another mode of order book", ob.Instrument())
+	}
+
+	_, err = repairJournal(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ReadJournal(dir, ob.seq, func(c Command) error {
+		if c.Seq != ob.seq+1 {
+			return fmt.Errorf("journal has a gap: %d is after %d", c.Seq, ob.seq)
+		}
+
+		// the rejected command didn't change the order book. The accepted one passed all checks before the crash,
+		// so it's applied the same way now.
+		if !c.Rejected {
+			_, err := ob.Apply(ctx, c)
+			if err != nil {
+				return fmt.Errorf("command %d can't be applied: %w", c.Seq, err)
+			}
+		}
+		ob.seq = c.Seq
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't replay journal: %w", err)
+	}
+
+	j, err := OpenJournal(dir, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if j.lastSeq < ob.seq {
+		// the journal before the snapshot was removed - new commands go after the snapshot.
+		j.lastSeq = ob.seq
+	}
+	ob.Journal = j
+
+	return ob, nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/cockroachdb/apd"
+)
+
+func testRecoveryOrderBook(t *testing.T, dir string) (*OrderBook, []byte) {
+	t.Helper()
+
+	j, err := OpenJournal(dir, JournalConfig{SegmentSize: 1024, SyncPolicy: SyncNever})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	ob := NewOrderBook("BTC", "USDT")
+	ob.Journal = j
+
+	for _, o := range testOrders() {
+		_, err = ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	_, err = ob.SaveSnapshot(dir)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	commands := []Command{
+		{Type: CommandPlaceLimit, OrderID: "100500", Side: Bid, Price: apd.New(20100, 0), Amount: apd.New(15, -1)},
+		{Type: CommandPlaceMarket, OrderID: "100501", Side: Ask, Price: apd.New(19900, 0), Amount: apd.New(12, -1)},
+		{Type: CommandCancel, OrderID: "55"},
+		{Type: CommandRollback, OrderID: "100501"},
+		// it fails before the crash and after it.
+		{Type: CommandCancel, OrderID: "100501"},
+		{Type: CommandPlaceLimit, OrderID: "100502", Side: Ask, Price: apd.New(20000, 0), Amount: apd.New(3, -1)},
+	}
+	for _, c := range commands {
+		_, _ = ob.Apply(context.Background(), c)
+	}
+
+	err = j.Close()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = ob.Snapshot(&buf)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	return ob, buf.Bytes()
+}
+
+func Test_RecoverOrderBook(t *testing.T) {
+	dir := t.TempDir()
+	ob, stateBeforeCrash := testRecoveryOrderBook(t, dir)
+
+	// the crash in the middle of the write leaves a part of the record.
+	segments, err := journalSegments(dir)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	last := segments[len(segments)-1].path
+	f, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	_, err = f.Write([]byte{0, 0, 0, 40, 1, 2, 3, 4, 5})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	f.Close()
+
+	recovered, err := RecoverOrderBook(context.Background(), dir, "BTC", "USDT", OrderBookMode{}, OrderBookParts{}, JournalConfig{SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer recovered.Journal.Close()
+
+	var buf bytes.Buffer
+	err = recovered.Snapshot(&buf)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !bytes.Equal(stateBeforeCrash, buf.Bytes()) {
+		t.Fatalf("recovered order book is different")
+	}
+
+	// the new commands continue the journal.
+	err = recovered.Cancel(context.Background(), "44")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if recovered.seq != ob.seq+1 {
+		t.Fatalf("expected %d seq, but got: %d", ob.seq+1, recovered.seq)
+	}
+
+	commands := 0
+	err = ReadJournal(dir, 0, func(c Command) error {
+		commands++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if uint64(commands) != recovered.seq {
+		t.Fatalf("expected %d commands, but got: %d", recovered.seq, commands)
+	}
+}
+
+func Test_RecoverOrderBookBrokenSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	ob, stateBeforeCrash := testRecoveryOrderBook(t, dir)
+
+	// the latest snapshot is broken - the previous one is used.
+	path, err := ob.SaveSnapshot(dir)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	err = os.WriteFile(path, []byte("MEOB broken"), 0o644)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	recovered, err := RecoverOrderBook(context.Background(), dir, "BTC", "USDT", OrderBookMode{}, OrderBookParts{}, JournalConfig{SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer recovered.Journal.Close()
+
+	if recovered.StateHash() != ob.StateHash() {
+		t.Fatalf("recovered order book is different")
+	}
+
+	var buf bytes.Buffer
+	err = recovered.Snapshot(&buf)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !bytes.Equal(stateBeforeCrash, buf.Bytes()) {
+		t.Fatalf("recovered order book is different")
+	}
+
+	_, err = RecoverOrderBook(context.Background(), dir, "ETH", "USDT", OrderBookMode{}, OrderBookParts{}, JournalConfig{SyncPolicy: SyncAlways})
+	if err == nil {
+		t.Fatalf("expected err, but got nil")
+	}
+
+	// the snapshot is of the decimal order book.
+	fixed := OrderBookMode{FixedPoint: &FixedPoint{PriceDecimals: 0, AmountDecimals: 1}}
+	_, err = RecoverOrderBook(context.Background(), dir, "BTC", "USDT", fixed, OrderBookParts{}, JournalConfig{SyncPolicy: SyncAlways})
+	if err == nil {
+		t.Fatalf("expected err, but got nil")
+	}
+
+	// the new order book is created in the mode.
+	recovered, err = RecoverOrderBook(context.Background(), t.TempDir(), "BTC", "USDT", fixed, OrderBookParts{}, JournalConfig{})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer recovered.Journal.Close()
+	if !recovered.Mode().equal(fixed) {
+		t.Fatalf("unexpected mode: %+v", recovered.Mode())
+	}
+}
+
+func Test_RecoverOrderBookRejected(t *testing.T) {
+	dir := t.TempDir()
+	j, err := OpenJournal(dir, JournalConfig{SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	ob, _ := testJournaledAccountsOrderBook(t, j)
+
+	for _, o := range []*Order{
+		{orderID: "a1", accountID: "seller", operationType: Ask, amount: decimal(t, "1"), price: decimal(t, "100")},
+		// the buyer doesn't have the funds, the order is rejected by the live order book only.
+		{orderID: "b1", accountID: "buyer", operationType: Bid, amount: decimal(t, "2000"), price: decimal(t, "100")},
+		{orderID: "b2", accountID: "buyer", operationType: Bid, amount: decimal(t, "2"), price: decimal(t, "99")},
+	} {
+		_, err = ob.PlaceLimitOrder(context.Background(), o)
+		if (o.orderID == "b1") != (err != nil) {
+			t.Fatalf("unexpected err of %s: %v", o.orderID, err)
+		}
+	}
+	err = j.Close()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	recovered, err := RecoverOrderBook(context.Background(), dir, "BTC", "USDT", OrderBookMode{},
+		OrderBookParts{Accounts: NewAccounts()}, JournalConfig{})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer recovered.Journal.Close()
+
+	// 2 deposits and 3 placements.
+	if recovered.StateHash() != ob.StateHash() || recovered.seq != 5 {
+		t.Fatalf("recovered order book is different")
+	}
+	if _, ok := recovered.OrdersDone["b1"]; ok {
+		t.Fatalf("rejected order is placed")
+	}
+}
+
+func Test_RecoverOrderBookBalances(t *testing.T) {
+	dir := t.TempDir()
+	j, err := OpenJournal(dir, JournalConfig{SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	ob, _ := testJournaledAccountsOrderBook(t, j)
+	ob.Fees = testFeeSchedule(t)
+	ob.Ledger = NewLedger()
+
+	place := func(ob *OrderBook, id OrderID, account AccountID, side OperationType, amount, price string) {
+		t.Helper()
+
+		_, err := ob.PlaceLimitOrder(context.Background(), &Order{
+			orderID: id, accountID: account, operationType: side, amount: decimal(t, amount), price: decimal(t, price),
+		})
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	// b1 takes a half of a1, the rest of a1 and a2 stay in the order book.
+	place(ob, "a1", "seller", Ask, "1", "100")
+	place(ob, "b1", "buyer", Bid, "0.5", "100")
+	place(ob, "a2", "seller", Ask, "1", "101")
+
+	_, err = ob.SaveSnapshot(dir)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	place(ob, "b2", "buyer", Bid, "1", "99")
+	err = ob.Withdraw(context.Background(), "buyer", "USDT", decimal(t, "100"))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	err = j.Close()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// the snapshot has the balances, they can't be dropped.
+	_, err = RecoverOrderBook(context.Background(), dir, "BTC", "USDT", OrderBookMode{}, OrderBookParts{}, JournalConfig{})
+	if err == nil {
+		t.Fatalf("expected err")
+	}
+
+	accounts := NewAccounts()
+	err = accounts.Deposit("seller", "BTC", decimal(t, "1"))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	_, err = RecoverOrderBook(context.Background(), dir, "BTC", "USDT", OrderBookMode{},
+		OrderBookParts{Accounts: accounts}, JournalConfig{})
+	if err == nil {
+		t.Fatalf("expected err")
+	}
+
+	accounts = NewAccounts()
+	recovered, err := RecoverOrderBook(context.Background(), dir, "BTC", "USDT", OrderBookMode{},
+		OrderBookParts{Accounts: accounts, Fees: testFeeSchedule(t), Ledger: NewLedger()}, JournalConfig{})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer recovered.Journal.Close()
+
+	if recovered.StateHash() != ob.StateHash() {
+		t.Fatalf("recovered order book is different")
+	}
+	if len(recovered.Ledger.Entries(0)) != len(ob.Ledger.Entries(0)) {
+		t.Fatalf("expected %d ledger entries, but got: %d", len(ob.Ledger.Entries(0)), len(recovered.Ledger.Entries(0)))
+	}
+	if recovered.Fees.Volume("buyer").Cmp(ob.Fees.Volume("buyer")) != 0 {
+		t.Fatalf("expected %s volume, but got: %s", ob.Fees.Volume("buyer"), recovered.Fees.Volume("buyer"))
+	}
+	checkBalance(t, accounts, "seller", "BTC", "8", "1.5")
+	checkBalance(t, accounts, "buyer", "USDT", "99750.9", "99")
+
+	// the recovered orders have the reservations behind them, so they can be canceled and filled.
+	err = recovered.Cancel(context.Background(), "a2")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	place(recovered, "b3", "buyer", Bid, "0.5", "100")
+
+	checkBalance(t, accounts, "seller", "BTC", "9", "0")
+	checkBalance(t, accounts, "seller", "USDT", "99.9", "0")
+	checkBalance(t, accounts, "buyer", "BTC", "1", "0")
+
+	err = recovered.Ledger.Check()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const replHelp = `commands:
+  create BTC-USDT                               creates the order book and uses it
+  use BTC-USDT                                  uses the order book
+  books                                         prints the order books
+  limit <order_id> <buy|sell> <amount> <price> [account]
+  market <order_id> <buy|sell> <amount> <price> [account]
+                                                the price of the market order is the worst one to execute
+  cancel <order_id>
+  cancel_all <account> [buy|sell]
+  rollback <order_id>
+  order <order_id>                              prints the state of the order
+  depth [levels]                                prints the sorted depth: asks from the worst, then bids from the best
+  trades [count]                                prints the last trades of the order book
+  help
+  quit
+Lines starting with # are comments.
+`
+
+// errREPLQuit is returned by the quit command.
+var errREPLQuit = errors.New("quit")
+
+// REPL is an interactive shell of the in-memory order books for the operators and QA.
+type REPL struct {
+	exchange *Exchange
+	// book is the order book which is used by the order commands.
+	book *OrderBook
+	// trades are the trades of the order books by the instruments.
+	trades map[string][]Trade
+	out    io.Writer
+}
+
+// NewREPL creates a new instance of REPL which prints to out.
+func NewREPL(out io.Writer) *REPL {
+	return &REPL{
+		exchange: NewExchange(),
+		trades:   map[string][]Trade{},
+		out:      out,
+	}
+}
+
+// Run executes the lines from in till quit or the end of the input. Errors of the commands are printed,
+// they stop the execution only if stopOnError is set. The prompt is printed before every line if it's set.
+func (r *REPL) Run(ctx context.Context, in io.Reader, prompt, stopOnError bool) error {
+	scanner := bufio.NewScanner(in)
+
+	line := 0
+	for {
+		if prompt {
+			fmt.Fprint(r.out, r.prompt())
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line++
+
+		err := r.Exec(ctx, scanner.Text())
+		if errors.Is(err, errREPLQuit) {
+			return nil
+		}
+		if err != nil {
+			fmt.Fprintf(r.out, "error: %v\n", err)
+			if stopOnError {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// prompt returns the prompt with the instrument of the used order book.
+func (r *REPL) prompt() string {
+	if r.book == nil {
+		return "> "
+	}
+
+	return r.book.Instrument() + "> "
+}
+
+// Exec executes the line.
+func (r *REPL) Exec(ctx context.Context, line string) error {
+	args := strings.Fields(line)
+	if len(args) == 0 || strings.HasPrefix(args[0], "#") {
+		return nil
+	}
+
+	switch args[0] {
+	case "help":
+		fmt.Fprint(r.out, replHelp)
+		return nil
+	case "quit", "exit":
+		return errREPLQuit
+	case "create":
+		return r.create(args[1:])
+	case "use":
+		return r.use(args[1:])
+	case "books":
+		for _, instrument := range r.exchange.Instruments() {
+			fmt.Fprintln(r.out, instrument)
+		}
+		return nil
+	}
+
+	if r.book == nil {
+		return errors.New("there is no order book, create it: create BTC-USDT")
+	}
+
+	switch args[0] {
+	case "limit":
+		return r.place(ctx, CommandPlaceLimit, args[1:])
+	case "market":
+		return r.place(ctx, CommandPlaceMarket, args[1:])
+	case "cancel":
+		return r.cancel(ctx, args[1:])
+	case "cancel_all":
+		return r.cancelAll(ctx, args[1:])
+	case "rollback":
+		return r.rollback(ctx, args[1:])
+	case "order":
+		return r.order(ctx, args[1:])
+	case "depth":
+		return r.depth(ctx, args[1:])
+	case "trades":
+		return r.printTrades(args[1:])
+	default:
+		return fmt.Errorf("unknown command: %q, see help", args[0])
+	}
+}
+
+func (r *REPL) create(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: create BTC-USDT")
+	}
+
+	baseAsset, quoteAsset, err := ParseInstrument(args[0])
+	if err != nil {
+		return err
+	}
+
+	ob := NewOrderBook(baseAsset, quoteAsset)
+	err = r.exchange.AddBook(ob)
+	if err != nil {
+		return err
+	}
+	r.book = ob
+
+	fmt.Fprintf(r.out, "created %s\n", ob.Instrument())
+
+	return nil
+}
+
+func (r *REPL) use(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: use BTC-USDT")
+	}
+
+	ob, ok := r.exchange.Book(args[0])
+	if !ok {
+		return fmt.Errorf("order book: %s not found", args[0])
+	}
+	r.book = ob
+
+	return nil
+}
+
+func (r *REPL) place(ctx context.Context, ct CommandType, args []string) error {
+	if len(args) != 4 && len(args) != 5 {
+		return fmt.Errorf("usage: %s <order_id> <buy|sell> <amount> <price> [account]", replCommandName(ct))
+	}
+
+	side, err := ParseOperationType(args[1])
+	if err != nil {
+		return err
+	}
+
+	amount, err := parsePositiveDecimal("amount", args[2])
+	if err != nil {
+		return err
+	}
+
+	price, err := parsePositiveDecimal("price", args[3])
+	if err != nil {
+		return err
+	}
+
+	c := Command{
+		Type:      ct,
+		OrderID:   OrderID(args[0]),
+		Side:      side,
+		Price:     price,
+		Amount:    amount,
+		CreatedAt: time.Now(),
+	}
+	if len(args) == 5 {
+		c.AccountID = AccountID(args[4])
+	}
+
+	res, err := r.book.Apply(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(r.out, "%s: executed %d\n", c.OrderID, res.OrdersExecuted)
+	for _, e := range res.Executions {
+		fmt.Fprintf(r.out, "  trade: %s x %s: %s @ %s\n", e.initiatorOrderID, e.executorOrderID, e.amount, e.price)
+	}
+	if res.AmountLeft != nil && !res.AmountLeft.IsZero() {
+		fmt.Fprintf(r.out, "  amount left: %s\n", res.AmountLeft)
+	}
+
+	instrument := r.book.Instrument()
+	r.trades[instrument] = append(r.trades[instrument], trades(res.Executions)...)
+
+	return nil
+}
+
+// replCommandName returns the name of the REPL command of the place command.
+func replCommandName(ct CommandType) string {
+	if ct == CommandPlaceMarket {
+		return "market"
+	}
+
+	return "limit"
+}
+
+func (r *REPL) cancel(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: cancel <order_id>")
+	}
+
+	err := r.book.Cancel(ctx, OrderID(args[0]))
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(r.out, "%s: canceled\n", args[0])
+
+	return nil
+}
+
+func (r *REPL) cancelAll(ctx context.Context, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errors.New("usage: cancel_all <account> [buy|sell]")
+	}
+
+	c := Command{Type: CommandCancelAll, AccountID: AccountID(args[0]), AnySide: len(args) == 1}
+	if len(args) == 2 {
+		side, err := ParseOperationType(args[1])
+		if err != nil {
+			return err
+		}
+		c.Side = side
+	}
+
+	res, err := r.book.Apply(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(r.out, "canceled %d: %v\n", len(res.Canceled), res.Canceled)
+
+	return nil
+}
+
+func (r *REPL) rollback(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: rollback <order_id>")
+	}
+
+	err := r.book.Rollback(ctx, OrderID(args[0]))
+	if err != nil {
+		return err
+	}
+
+	// the trades of the order are canceled - they aren't in the trades anymore.
+	instrument := r.book.Instrument()
+	kept := r.trades[instrument][:0]
+	for _, t := range r.trades[instrument] {
+		if t.InitiatorOrderID != OrderID(args[0]) {
+			kept = append(kept, t)
+		}
+	}
+	r.trades[instrument] = kept
+
+	fmt.Fprintf(r.out, "%s: rolled back\n", args[0])
+
+	return nil
+}
+
+func (r *REPL) order(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: order <order_id>")
+	}
+
+	s, err := r.book.OrderStatus(ctx, OrderID(args[0]))
+	if err != nil {
+		return err
+	}
+
+	state := "closed"
+	if s.Open {
+		state = "open"
+	}
+	fmt.Fprintf(r.out, "%s: %s @ %s, account: %s, %s, remaining: %s, executed: %s\n",
+		s.OrderID, s.Side, s.Price, s.AccountID, state, s.Remaining, s.Executed)
+	for _, t := range s.Trades {
+		fmt.Fprintf(r.out, "  trade: %s x %s: %s @ %s\n", t.InitiatorOrderID, t.ExecutorOrderID, t.Amount, t.Price)
+	}
+
+	return nil
+}
+
+func (r *REPL) depth(ctx context.Context, args []string) error {
+	limit, err := replCount(args, "usage: depth [levels]")
+	if err != nil {
+		return err
+	}
+
+	asks, bids := r.book.Depth(ctx, limit)
+	writeDepth(r.out, asks, bids)
+
+	return nil
+}
+
+func (r *REPL) printTrades(args []string) error {
+	count, err := replCount(args, "usage: trades [count]")
+	if err != nil {
+		return err
+	}
+
+	trades := r.trades[r.book.Instrument()]
+	if count > 0 && len(trades) > count {
+		trades = trades[len(trades)-count:]
+	}
+
+	for _, t := range trades {
+		fmt.Fprintf(r.out, "%s x %s: %s @ %s\n", t.InitiatorOrderID, t.ExecutorOrderID, t.Amount, t.Price)
+	}
+
+	return nil
+}
+
+// replCount parses the optional count argument, 0 means all.
+func replCount(args []string, usage string) (int, error) {
+	if len(args) == 0 {
+		return 0, nil
+	}
+	if len(args) > 1 {
+		return 0, errors.New(usage)
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 {
+		return 0, errors.New(usage)
+	}
+
+	return n, nil
+}
+
+// runREPL runs the repl command: matching-engine repl [-e] [script]
+// Without the script the commands are read from stdin, the prompt is printed only for the terminal.
+func runREPL(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("repl", flag.ContinueOnError)
+	stopOnError := fs.Bool("e", false, "stop on the first error")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return fmt.Errorf("usage: repl [-e] [script]")
+	}
+
+	r, prompt := stdin, isTerminal(stdin)
+	if fs.NArg() == 1 && fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("can't open script: %w", err)
+		}
+		defer f.Close()
+
+		r, prompt = f, false
+	}
+
+	return NewREPL(stdout).Run(ctx, r, prompt, *stopOnError)
+}
+
+// isTerminal returns true if the reader is the terminal.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func Test_REPL(t *testing.T) {
+	script := `
+# the order book must be created first
+limit 1 sell 1 100
+create BTC-USDT
+limit 1 sell 1 100 alice
+limit 2 sell 2 101
+limit 3 buy 1 99
+market 4 buy 5 101 bob
+depth
+trades 1
+order 4
+rollback 4
+depth 1
+trades
+cancel 3
+cancel 3
+create ETH-USDT
+books
+use BTC-USDT
+quit
+limit 5 sell 1 100
+`
+
+	var out bytes.Buffer
+	err := run(context.Background(), []string{"repl"}, strings.NewReader(script), &out)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	expected := `error: there is no order book, create it: create BTC-USDT
+created BTC-USDT
+1: executed 0
+2: executed 0
+3: executed 0
+4: executed 2
+  trade: 4 x 1: 1 @ 100
+  trade: 4 x 2: 2 @ 101
+  amount left: 2
+asks:
+bids:
+  99: 1 (1)
+4 x 2: 2 @ 101
+4: bid @ 101, account: bob, closed, remaining: 0, executed: 3
+  trade: 4 x 1: 1 @ 100
+  trade: 4 x 2: 2 @ 101
+4: rolled back
+asks:
+  100: 1 (1)
+bids:
+  99: 1 (1)
+3: canceled
+error: order: 3 not found - nothing to cancel
+created ETH-USDT
+BTC-USDT
+ETH-USDT
+`
+	if out.String() != expected {
+		t.Fatalf("expected:\n%s\nbut got:\n%s", expected, out.String())
+	}
+}
+
+func Test_REPLStopOnError(t *testing.T) {
+	var out bytes.Buffer
+	err := run(context.Background(), []string{"repl", "-e"}, strings.NewReader("create BTC-USDT\nlimit 1 long 1 100\ndepth\n"), &out)
+	if err == nil || err.Error() != `line 2: unknown side: "long"` {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if out.String() != "created BTC-USDT\nerror: unknown side: \"long\"\n" {
+		t.Fatalf("unexpected output: %s", out.String())
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ReplaceOrder replaces the open order by the new limit order of the same account and side, all or nothing:
+// the new order is checked as if the open order is canceled, then the open order is canceled with the release
+// of its funds and the new one is placed by one journaled command. The new order loses the place in the queue.
+func (ob *OrderBook) ReplaceOrder(ctx context.Context, orderID OrderID, o *Order) (ordersExecuted int, err error) {
+	if ob.Sequencer != nil {
+		c := orderCommand(CommandReplace, o)
+		c.ReplacedOrderID, c.placed = orderID, o
+
+		res, err := ob.Sequencer.Apply(ctx, c)
+		return res.OrdersExecuted, err
+	}
+
+	err = ob.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer ob.unlock()
+
+	return ob.replaceOrder(ctx, orderID, o)
+}
+
+func (ob *OrderBook) replaceOrder(ctx context.Context, orderID OrderID, o *Order) (int, error) {
+	if o.createdAt.IsZero() {
+		o.createdAt = time.Now()
+	}
+
+	c := orderCommand(CommandReplace, o)
+	c.ReplacedOrderID = orderID
+
+	el, ok := ob.Orders[orderID]
+	if !ok {
+		return 0, ob.reject(c, newOrderError(ErrOrderNotFound, "order: %s not found - nothing to replace", orderID))
+	}
+
+	replaced := el.Value.(*Order)
+	if replaced.accountID != o.accountID || replaced.operationType != o.operationType {
+		return 0, ob.reject(c, fmt.Errorf("order: %s can't be replaced by the order of another account or side", orderID))
+	}
+
+	sideToCheck := ob.Asks
+	if o.operationType == Ask {
+		sideToCheck = ob.Bids
+	}
+
+	// the replaced order is on the same side as the new one, so it doesn't change the fills.
+	s, err := ob.checkOrder(o, sideToCheck, false, replaced)
+	if err != nil {
+		return 0, ob.reject(c, err)
+	}
+
+	moves, err := ob.releaseMoves(replaced)
+	if err != nil {
+		return 0, ob.reject(c, err)
+	}
+
+	err = ob.commit(c, append(moves, s.moves...))
+	if err != nil {
+		return 0, ob.reject(c, fmt.Errorf("can't replace order: %s: %w", orderID, err))
+	}
+
+	err = ob.cancel(ctx, orderID)
+	if err != nil {
+		return 0, err
+	}
+
+	return ob.limitOrder(o, s)
+}
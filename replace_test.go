@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func Test_ReplaceOrder(t *testing.T) {
+	dir := t.TempDir()
+	j, err := OpenJournal(dir, JournalConfig{SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	ob, accounts := testJournaledAccountsOrderBook(t, j)
+
+	for _, o := range []*Order{
+		{orderID: "a1", accountID: "seller", operationType: Ask, amount: decimal(t, "1"), price: decimal(t, "100")},
+		{orderID: "b1", accountID: "buyer", operationType: Bid, amount: decimal(t, "1"), price: decimal(t, "90")},
+	} {
+		_, err = ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	// the funds of the replaced order are counted, but they aren't enough - nothing is changed.
+	_, err = ob.ReplaceOrder(context.Background(), "b1", &Order{
+		orderID: "b2", accountID: "buyer", operationType: Bid, amount: decimal(t, "1001"), price: decimal(t, "99.91"),
+	})
+	if !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatalf("expected insufficient funds err, but got: %v", err)
+	}
+	if _, ok := ob.Orders["b1"]; !ok {
+		t.Fatalf("order is canceled: b1")
+	}
+	checkBalance(t, accounts, "buyer", "USDT", "99910", "90")
+
+	_, err = ob.ReplaceOrder(context.Background(), "b1", &Order{
+		orderID: "b2", accountID: "seller", operationType: Bid, amount: decimal(t, "1"), price: decimal(t, "95"),
+	})
+	if err == nil {
+		t.Fatalf("expected err")
+	}
+
+	// the new order takes a1 and the rest of it stays in the order book.
+	executed, err := ob.ReplaceOrder(context.Background(), "b1", &Order{
+		orderID: "b2", accountID: "buyer", operationType: Bid, amount: decimal(t, "3"), price: decimal(t, "100"),
+	})
+	if err != nil || executed != 1 {
+		t.Fatalf("unexpected result: %d, %v", executed, err)
+	}
+	if _, ok := ob.Orders["b1"]; ok {
+		t.Fatalf("order isn't canceled: b1")
+	}
+	if _, ok := ob.Orders["b2"]; !ok {
+		t.Fatalf("order isn't placed: b2")
+	}
+	checkBalance(t, accounts, "buyer", "USDT", "99700", "200")
+	checkBalance(t, accounts, "buyer", "BTC", "1", "0")
+
+	err = j.Close()
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	var replaces, rejected int
+	err = ReadJournal(dir, 0, func(c Command) error {
+		if c.Type == CommandReplace {
+			replaces++
+			if c.Rejected {
+				rejected++
+			}
+			if c.ReplacedOrderID != "b1" {
+				t.Fatalf("unexpected command: %+v", c)
+			}
+		}
+		return nil
+	})
+	if err != nil || replaces != 3 || rejected != 2 {
+		t.Fatalf("unexpected replace commands: %d, %d, %v", replaces, rejected, err)
+	}
+
+	recovered, err := RecoverOrderBook(context.Background(), dir, "BTC", "USDT", OrderBookMode{},
+		OrderBookParts{Accounts: NewAccounts()}, JournalConfig{})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer recovered.Journal.Close()
+
+	if recovered.StateHash() != ob.StateHash() {
+		t.Fatalf("recovered order book is different")
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cockroachdb/apd"
+)
+
+// jsonCommand is a command of the JSONL command files, one command per line:
+//
+//	{"type":"place_limit","order_id":"1","account":"alice","side":"ask","price":"20050","amount":"0.3"}
+//	{"type":"place_market","order_id":"2","account":"bob","side":"bid","price":"20100","amount":"0.5"}
+//	{"type":"cancel","order_id":"1"}
+//	{"type":"cancel_all","account":"alice","side":"ask"}
+//	{"type":"rollback","order_id":"2"}
+//	{"type":"replace","order_id":"3","replaced_order_id":"1","account":"alice","side":"ask","price":"20060","amount":"0.2"}
+//	{"type":"deposit","account":"alice","asset":"BTC","amount":"1.5"}
+//
+// Decimals are strings, so they keep the exact precision. created_at is optional,
+// without it the order gets the unix time of the line number in nanoseconds, so the replay is deterministic.
+type jsonCommand struct {
+	Type            string     `json:"type"`
+	OrderID         string     `json:"order_id,omitempty"`
+	ReplacedOrderID string     `json:"replaced_order_id,omitempty"`
+	Account         string     `json:"account,omitempty"`
+	Asset           string     `json:"asset,omitempty"`
+	Side            string     `json:"side,omitempty"`
+	Price           string     `json:"price,omitempty"`
+	Amount          string     `json:"amount,omitempty"`
+	CreatedAt       *time.Time `json:"created_at,omitempty"`
+}
+
+// command converts the json command to the Command.
+func (jc jsonCommand) command(line int) (Command, error) {
+	ct, err := ParseCommandType(jc.Type)
+	if err != nil {
+		return Command{}, err
+	}
+
+	c := Command{
+		Type:            ct,
+		OrderID:         OrderID(jc.OrderID),
+		ReplacedOrderID: OrderID(jc.ReplacedOrderID),
+		AccountID:       AccountID(jc.Account),
+		Asset:           Asset(jc.Asset),
+		AnySide:         jc.Side == "",
+		CreatedAt:       time.Unix(0, int64(line)),
+	}
+	if jc.CreatedAt != nil {
+		c.CreatedAt = *jc.CreatedAt
+	}
+
+	if jc.Side != "" {
+		c.Side, err = ParseOperationType(jc.Side)
+		if err != nil {
+			return Command{}, err
+		}
+	}
+
+	switch ct {
+	case CommandPlaceLimit, CommandPlaceMarket, CommandReplace:
+		if jc.OrderID == "" || jc.Side == "" {
+			return Command{}, fmt.Errorf("%s needs order_id and side", ct)
+		}
+		if ct == CommandReplace && jc.ReplacedOrderID == "" {
+			return Command{}, fmt.Errorf("%s needs replaced_order_id", ct)
+		}
+
+		c.Price, err = parsePositiveDecimal("price", jc.Price)
+		if err != nil {
+			return Command{}, err
+		}
+
+		c.Amount, err = parsePositiveDecimal("amount", jc.Amount)
+		if err != nil {
+			return Command{}, err
+		}
+
+	case CommandCancel, CommandRollback:
+		if jc.OrderID == "" {
+			return Command{}, fmt.Errorf("%s needs order_id", ct)
+		}
+
+	case CommandDeposit, CommandWithdraw:
+		if jc.Account == "" || jc.Asset == "" {
+			return Command{}, fmt.Errorf("%s needs account and asset", ct)
+		}
+
+		c.Amount, err = parsePositiveDecimal("amount", jc.Amount)
+		if err != nil {
+			return Command{}, err
+		}
+	}
+
+	return c, nil
+}
+
+// parsePositiveDecimal parses the decimal which must be greater than zero.
+func parsePositiveDecimal(name, s string) (*apd.Decimal, error) {
+	d, _, err := apd.NewFromString(s)
+	if err != nil {
+		return nil, fmt.Errorf("wrong %s: %q", name, s)
+	}
+	if d.Sign() <= 0 || d.Form != apd.Finite {
+		return nil, fmt.Errorf("%s must be positive: %s", name, s)
+	}
+
+	return d, nil
+}
+
+// ReadCommands reads the JSONL command file, empty lines are skipped.
+func ReadCommands(r io.Reader, fn func(line int, c Command) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var jc jsonCommand
+		err := json.Unmarshal(scanner.Bytes(), &jc)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		c, err := jc.command(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		err = fn(line, c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Replay feeds the commands from r through the order book and prints the results, the trades,
+// the final depth and the state hash to w. Errors of the commands are printed, they don't stop the replay.
+func Replay(ctx context.Context, ob *OrderBook, r io.Reader, w io.Writer) error {
+	err := ReadCommands(r, func(line int, c Command) error {
+		res, err := ob.Apply(ctx, c)
+		if err != nil {
+			fmt.Fprintf(w, "%d: %s %s: error: %v\n", line, c.Type, c.OrderID, err)
+			return nil
+		}
+
+		switch c.Type {
+		case CommandPlaceLimit, CommandPlaceMarket, CommandReplace:
+			fmt.Fprintf(w, "%d: %s %s: executed %d\n", line, c.Type, c.OrderID, res.OrdersExecuted)
+			for _, e := range res.Executions {
+				fmt.Fprintf(w, "  trade: %s x %s: %s @ %s\n", e.initiatorOrderID, e.executorOrderID, e.amount, e.price)
+			}
+			if res.AmountLeft != nil && !res.AmountLeft.IsZero() {
+				fmt.Fprintf(w, "  amount left: %s\n", res.AmountLeft)
+			}
+
+		case CommandCancel, CommandCancelAll:
+			fmt.Fprintf(w, "%d: %s %s: canceled %v\n", line, c.Type, c.OrderID, res.Canceled)
+
+		default:
+			fmt.Fprintf(w, "%d: %s %s: ok\n", line, c.Type, c.OrderID)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	asks, bids := ob.Depth(ctx, 0)
+	writeDepth(w, asks, bids)
+	fmt.Fprintf(w, "state hash: %s\n", ob.StateHash())
+
+	return nil
+}
+
+// writeDepth prints the depth: asks from the worst to the best, then bids from the best to the worst.
+func writeDepth(w io.Writer, asks, bids []PriceLevel) {
+	fmt.Fprintln(w, "asks:")
+	for i := len(asks) - 1; i >= 0; i-- {
+		fmt.Fprintf(w, "  %s: %s (%d)\n", asks[i].Price, asks[i].Amount, asks[i].Orders)
+	}
+
+	fmt.Fprintln(w, "bids:")
+	for _, l := range bids {
+		fmt.Fprintf(w, "  %s: %s (%d)\n", l.Price, l.Amount, l.Orders)
+	}
+}
+
+// runReplay runs the replay command: matching-engine replay [-base BTC] [-quote USDT] file.jsonl
+// If the file is "-" - the commands are read from stdin.
+func runReplay(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	base := fs.String("base", "BTC", "base asset")
+	quote := fs.String("quote", "USDT", "quote asset")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: replay [-base BTC] [-quote USDT] file.jsonl")
+	}
+
+	r := stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("can't open commands: %w", err)
+		}
+		defer f.Close()
+
+		r = f
+	}
+
+	return Replay(ctx, NewOrderBook(Asset(*base), Asset(*quote)), r, stdout)
+}
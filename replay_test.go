@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func Test_Replay(t *testing.T) {
+	var first, second bytes.Buffer
+	for _, buf := range []*bytes.Buffer{&first, &second} {
+		err := run(context.Background(), []string{"replay", "testdata/commands.jsonl"}, nil, buf)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	if first.String() != second.String() {
+		t.Fatalf("replay isn't deterministic: %s, %s", first.String(), second.String())
+	}
+
+	for _, s := range []string{
+		"7: place_limit 6: executed 2\n  trade: 6 x 1: 0.3 @ 20050\n  trade: 6 x 2: 0.1 @ 20050\n",
+		"8: place_market 7: executed 2\n  trade: 7 x 4: 1 @ 20000\n  trade: 7 x 5: 0.5 @ 19900\n",
+		"10: cancel 3: error: order: 3 not found - nothing to cancel\n",
+		"12: cancel_all : canceled [4 5]\n",
+		"asks:\n  20050: 0.4 (1)\nbids:\nstate hash: ",
+	} {
+		if !strings.Contains(first.String(), s) {
+			t.Fatalf("didn't get required strings in output: %s", s)
+		}
+	}
+}
+
+func Test_ReplayWrongCommands(t *testing.T) {
+	testcases := []struct {
+		testName    string
+		commands    string
+		errExpected string
+	}{
+		{
+			testName:    "wrong json",
+			commands:    `{"type":`,
+			errExpected: "line 1: unexpected end of JSON input",
+		},
+		{
+			testName:    "unknown type",
+			commands:    `{"type":"modify","order_id":"1"}`,
+			errExpected: `line 1: unknown command type: "modify"`,
+		},
+		{
+			testName:    "wrong side",
+			commands:    `{"type":"place_limit","order_id":"1","side":"long","price":"1","amount":"1"}`,
+			errExpected: `line 1: unknown side: "long"`,
+		},
+		{
+			testName:    "negative amount",
+			commands:    `{"type":"place_market","order_id":"1","side":"bid","price":"1","amount":"-1"}`,
+			errExpected: "line 1: amount must be positive: -1",
+		},
+		{
+			testName:    "no order id",
+			commands:    "\n" + `{"type":"rollback"}`,
+			errExpected: "line 2: rollback needs order_id",
+		},
+		{
+			testName:    "no asset",
+			commands:    `{"type":"deposit","account":"alice","amount":"1"}`,
+			errExpected: "line 1: deposit needs account and asset",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testName, func(t *testing.T) {
+			var out bytes.Buffer
+			err := run(context.Background(), []string{"replay", "-"}, strings.NewReader(tc.commands), &out)
+			if err == nil || err.Error() != tc.errExpected {
+				t.Fatalf("expected err: %s, but got: %v", tc.errExpected, err)
+			}
+		})
+	}
+}
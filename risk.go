@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cockroachdb/apd"
+)
+
+// RejectReason is a reason why the order was rejected by the risk checks.
+type RejectReason int
+
+const (
+	RejectMaxOrderAmount RejectReason = iota + 1
+	RejectMaxOrderNotional
+	RejectMaxOpenOrders
+	RejectMaxOpenExposure
+	RejectPriceDeviation
+)
+
+// String returns the string.
+func (r RejectReason) String() string {
+	switch r {
+	case RejectMaxOrderAmount:
+		return "max_order_amount"
+	case RejectMaxOrderNotional:
+		return "max_order_notional"
+	case RejectMaxOpenOrders:
+		return "max_open_orders"
+	case RejectMaxOpenExposure:
+		return "max_open_exposure"
+	case RejectPriceDeviation:
+		return "price_deviation"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(r))
+	}
+}
+
+// RiskError is an error of the order which breaches the risk limits.
+type RiskError struct {
+	Reason  RejectReason
+	OrderID OrderID
+	Message string
+}
+
+// Error returns the string.
+func (e *RiskError) Error() string {
+	return fmt.Sprintf("order: %s rejected by %s: %s", e.OrderID, e.Reason, e.Message)
+}
+
+// RiskLimits are pre-trade limits of the account. Empty fields aren't checked.
+type RiskLimits struct {
+	// MaxOrderAmount is a max amount of one order in BaseAsset.
+	MaxOrderAmount *apd.Decimal
+	// MaxOrderNotional is a max price * amount of one order in QuoteAsset.
+	MaxOrderNotional *apd.Decimal
+	// MaxOpenOrders is a max number of open orders in the order book.
+	MaxOpenOrders int
+	// MaxOpenExposure is a max price * amount of all open orders of one side in QuoteAsset.
+	MaxOpenExposure *apd.Decimal
+	// MaxPriceDeviation is a max deviation of the order price from the best price of the opposite side,
+	// like 0.05 for 5%. A bid can't be higher than the best ask by it, an ask can't be lower than the best bid by it.
+	MaxPriceDeviation *apd.Decimal
+}
+
+// RiskManager keeps the risk limits of the accounts, they can be changed at runtime.
+type RiskManager struct {
+	defaults RiskLimits
+	accounts map[AccountID]RiskLimits
+
+	mx sync.RWMutex
+}
+
+// NewRiskManager creates a new instance of RiskManager with the limits for all accounts.
+func NewRiskManager(defaults RiskLimits) *RiskManager {
+	return &RiskManager{
+		defaults: defaults,
+		accounts: map[AccountID]RiskLimits{},
+		mx:       sync.RWMutex{},
+	}
+}
+
+// SetDefaultLimits sets the limits of the accounts which don't have their own limits.
+func (rm *RiskManager) SetDefaultLimits(limits RiskLimits) {
+	rm.mx.Lock()
+	defer rm.mx.Unlock()
+
+	rm.defaults = limits
+}
+
+// SetLimits sets the limits of the account.
+func (rm *RiskManager) SetLimits(account AccountID, limits RiskLimits) {
+	rm.mx.Lock()
+	defer rm.mx.Unlock()
+
+	rm.accounts[account] = limits
+}
+
+// ResetLimits removes the limits of the account, so the default limits are used.
+func (rm *RiskManager) ResetLimits(account AccountID) {
+	rm.mx.Lock()
+	defer rm.mx.Unlock()
+
+	delete(rm.accounts, account)
+}
+
+// Limits returns the limits of the account.
+func (rm *RiskManager) Limits(account AccountID) RiskLimits {
+	rm.mx.RLock()
+	defer rm.mx.RUnlock()
+
+	limits, ok := rm.accounts[account]
+	if !ok {
+		return rm.defaults
+	}
+
+	return limits
+}
+
+// checkRisk checks the order against the risk limits of its account.
+// A market order doesn't stay in the order book, so the limits of the open orders aren't checked for it.
+// For a limit order they are checked only by the amount which rests after the fills planned by sideToCheck,
+// so an order which crosses and reduces the position isn't rejected by them.
+// The open order which is replaced by the order isn't counted, it's nil for the new orders.
+func (ob *OrderBook) checkRisk(o *Order, sideToCheck *OrderSide, market bool, replaced *Order) error {
+	if ob.Risk == nil {
+		return nil
+	}
+
+	limits := ob.Risk.Limits(o.accountID)
+
+	if limits.MaxOrderAmount != nil && o.amount.Cmp(limits.MaxOrderAmount) > 0 {
+		return &RiskError{
+			Reason:  RejectMaxOrderAmount,
+			OrderID: o.orderID,
+			Message: fmt.Sprintf("amount %s is greater than %s", o.amount, limits.MaxOrderAmount),
+		}
+	}
+
+	notional := apd.New(0, 0)
+	_, err := apd.BaseContext.Mul(notional, o.price, o.amount)
+	if err != nil {
+		return err
+	}
+
+	if limits.MaxOrderNotional != nil && notional.Cmp(limits.MaxOrderNotional) > 0 {
+		return &RiskError{
+			Reason:  RejectMaxOrderNotional,
+			OrderID: o.orderID,
+			Message: fmt.Sprintf("notional %s is greater than %s", notional, limits.MaxOrderNotional),
+		}
+	}
+
+	if limits.MaxPriceDeviation != nil {
+		err = ob.checkPriceDeviation(o, limits.MaxPriceDeviation)
+		if err != nil {
+			return err
+		}
+	}
+
+	if market || (limits.MaxOpenOrders == 0 && limits.MaxOpenExposure == nil) {
+		return nil
+	}
+
+	resting, err := restingAmount(o, sideToCheck)
+	if err != nil {
+		return err
+	}
+	if resting.IsZero() {
+		return nil
+	}
+
+	openOrders := len(ob.OrdersByAccount[o.accountID])
+	if replaced != nil {
+		openOrders--
+	}
+
+	if limits.MaxOpenOrders > 0 && openOrders >= limits.MaxOpenOrders {
+		return &RiskError{
+			Reason:  RejectMaxOpenOrders,
+			OrderID: o.orderID,
+			Message: fmt.Sprintf("there are %d open orders already", openOrders),
+		}
+	}
+
+	if limits.MaxOpenExposure != nil {
+		side := o.operationType
+		exposure := apd.New(0, 0)
+		_, err = apd.BaseContext.Mul(exposure, o.price, resting)
+		if err != nil {
+			return err
+		}
+		for _, open := range ob.openOrders(o.accountID, OrderFilter{Side: &side}) {
+			if open == replaced {
+				continue
+			}
+
+			openNotional := apd.New(0, 0)
+			_, err = apd.BaseContext.Mul(openNotional, open.price, ob.remaining(open))
+			if err != nil {
+				return err
+			}
+
+			_, err = apd.BaseContext.Add(exposure, exposure, openNotional)
+			if err != nil {
+				return err
+			}
+		}
+
+		if exposure.Cmp(limits.MaxOpenExposure) > 0 {
+			return &RiskError{
+				Reason:  RejectMaxOpenExposure,
+				OrderID: o.orderID,
+				Message: fmt.Sprintf("exposure %s is greater than %s", exposure, limits.MaxOpenExposure),
+			}
+		}
+	}
+
+	return nil
+}
+
+// restingAmount returns the amount of the order which rests in the order book after its fills by the side.
+func restingAmount(o *Order, side *OrderSide) (*apd.Decimal, error) {
+	fills, err := side.planFills(o)
+	if err != nil {
+		return nil, err
+	}
+
+	resting := apd.New(0, 0).Set(o.amount)
+	for _, e := range fills {
+		_, err = apd.BaseContext.Sub(resting, resting, e.amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resting, nil
+}
+
+// checkPriceDeviation checks that the order price isn't too far from the best price of the opposite side.
+// If the opposite side is empty - there is nothing to compare with.
+func (ob *OrderBook) checkPriceDeviation(o *Order, maxDeviation *apd.Decimal) error {
+	touchSide := ob.Bids
+	if o.operationType == Bid {
+		touchSide = ob.Asks
+	}
+
+	touch, ok := touchSide.BestPrice()
+	if !ok {
+		return nil
+	}
+
+	// bid: price <= touch * (1 + maxDeviation), ask: price >= touch * (1 - maxDeviation).
+	factor := apd.New(1, 0)
+	var err error
+	if o.operationType == Bid {
+		_, err = apd.BaseContext.Add(factor, factor, maxDeviation)
+	} else {
+		_, err = apd.BaseContext.Sub(factor, factor, maxDeviation)
+	}
+	if err != nil {
+		return err
+	}
+
+	bound := apd.New(0, 0)
+	_, err = apd.BaseContext.Mul(bound, touch, factor)
+	if err != nil {
+		return err
+	}
+
+	res := o.price.Cmp(bound)
+	if (o.operationType == Bid && res > 0) || (o.operationType == Ask && res < 0) {
+		return &RiskError{
+			Reason:  RejectPriceDeviation,
+			OrderID: o.orderID,
+			Message: fmt.Sprintf("price %s deviates from %s by more than %s", o.price, touch, maxDeviation),
+		}
+	}
+
+	return nil
+}
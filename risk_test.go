@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cockroachdb/apd"
+)
+
+func Test_RiskChecks(t *testing.T) {
+	testcases := []struct {
+		testName       string
+		limits         RiskLimits
+		order          *Order
+		market         bool
+		reasonExpected RejectReason
+	}{
+		{
+			testName: "max order amount",
+			limits:   RiskLimits{MaxOrderAmount: apd.New(1, 0)},
+			order: &Order{
+				orderID:       "100500",
+				accountID:     "trader",
+				operationType: Bid,
+				amount:        apd.New(11, -1),
+				price:         apd.New(20000, 0),
+			},
+			reasonExpected: RejectMaxOrderAmount,
+		},
+		{
+			testName: "max order notional by market order",
+			limits:   RiskLimits{MaxOrderNotional: apd.New(10000, 0)},
+			order: &Order{
+				orderID:       "100500",
+				accountID:     "trader",
+				operationType: Ask,
+				amount:        apd.New(1, 0),
+				price:         apd.New(19000, 0),
+			},
+			market:         true,
+			reasonExpected: RejectMaxOrderNotional,
+		},
+		{
+			testName: "max open orders",
+			limits:   RiskLimits{MaxOpenOrders: 2},
+			order: &Order{
+				orderID:       "100500",
+				accountID:     "trader",
+				operationType: Bid,
+				amount:        apd.New(1, -1),
+				price:         apd.New(19000, 0),
+			},
+			reasonExpected: RejectMaxOpenOrders,
+		},
+		{
+			testName: "max open exposure",
+			limits:   RiskLimits{MaxOpenExposure: apd.New(15000, 0)},
+			order: &Order{
+				orderID:       "100500",
+				accountID:     "trader",
+				operationType: Bid,
+				amount:        apd.New(1, -1),
+				price:         apd.New(19000, 0),
+			},
+			reasonExpected: RejectMaxOpenExposure,
+		},
+		{
+			testName: "max open orders by the crossing order which doesn't rest",
+			limits:   RiskLimits{MaxOpenOrders: 2},
+			order: &Order{
+				orderID:       "100500",
+				accountID:     "trader",
+				operationType: Bid,
+				amount:        apd.New(5, -1),
+				price:         apd.New(20050, 0),
+			},
+		},
+		{
+			testName: "max open orders by the rest of the crossing order",
+			limits:   RiskLimits{MaxOpenOrders: 2},
+			order: &Order{
+				orderID:       "100500",
+				accountID:     "trader",
+				operationType: Bid,
+				amount:        apd.New(11, -1),
+				price:         apd.New(20050, 0),
+			},
+			reasonExpected: RejectMaxOpenOrders,
+		},
+		{
+			testName: "max open exposure by the crossing order which doesn't rest",
+			limits:   RiskLimits{MaxOpenExposure: apd.New(15000, 0)},
+			order: &Order{
+				orderID:       "100500",
+				accountID:     "trader",
+				operationType: Bid,
+				amount:        apd.New(5, -1),
+				price:         apd.New(20050, 0),
+			},
+		},
+		{
+			// only 0.1 of the order rests: 16000 + 2005 of the exposure.
+			testName: "max open exposure by the rest of the crossing order",
+			limits:   RiskLimits{MaxOpenExposure: apd.New(19000, 0)},
+			order: &Order{
+				orderID:       "100500",
+				accountID:     "trader",
+				operationType: Bid,
+				amount:        apd.New(11, -1),
+				price:         apd.New(20050, 0),
+			},
+		},
+		{
+			testName: "exposure of the other side isn't counted",
+			limits:   RiskLimits{MaxOpenExposure: apd.New(15000, 0)},
+			order: &Order{
+				orderID:       "100500",
+				accountID:     "trader",
+				operationType: Ask,
+				amount:        apd.New(1, -1),
+				price:         apd.New(21000, 0),
+			},
+		},
+		{
+			testName: "bid price is too far from the best ask",
+			limits:   RiskLimits{MaxPriceDeviation: decimal(t, "0.01")},
+			order: &Order{
+				orderID:       "100500",
+				accountID:     "trader",
+				operationType: Bid,
+				amount:        apd.New(1, -1),
+				price:         apd.New(20300, 0),
+			},
+			reasonExpected: RejectPriceDeviation,
+		},
+		{
+			testName: "ask price is too far from the best bid",
+			limits:   RiskLimits{MaxPriceDeviation: decimal(t, "0.01")},
+			order: &Order{
+				orderID:       "100500",
+				accountID:     "trader",
+				operationType: Ask,
+				amount:        apd.New(1, -1),
+				price:         apd.New(19700, 0),
+			},
+			market:         true,
+			reasonExpected: RejectPriceDeviation,
+		},
+		{
+			testName: "price within deviation",
+			limits:   RiskLimits{MaxPriceDeviation: decimal(t, "0.01")},
+			order: &Order{
+				orderID:       "100500",
+				accountID:     "trader",
+				operationType: Bid,
+				amount:        apd.New(1, -1),
+				price:         apd.New(20250, 0),
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.testName, func(t *testing.T) {
+			ob := NewOrderBook("BTC", "USDT")
+			for _, o := range testOrders() {
+				_, err := ob.PlaceLimitOrder(context.Background(), o)
+				if err != nil {
+					t.Fatalf("unexpected err: %v", err)
+				}
+			}
+
+			// the trader has 2 bids with 16000 of the exposure.
+			for _, o := range []*Order{
+				{
+					orderID:       "1001",
+					accountID:     "trader",
+					operationType: Bid,
+					amount:        apd.New(5, -1),
+					price:         apd.New(19000, 0),
+				},
+				{
+					orderID:       "1002",
+					accountID:     "trader",
+					operationType: Bid,
+					amount:        apd.New(5, -1),
+					price:         apd.New(13000, 0),
+				},
+			} {
+				_, err := ob.PlaceLimitOrder(context.Background(), o)
+				if err != nil {
+					t.Fatalf("unexpected err: %v", err)
+				}
+			}
+
+			ob.Risk = NewRiskManager(RiskLimits{})
+			ob.Risk.SetLimits("trader", tc.limits)
+
+			var err error
+			if tc.market {
+				_, _, err = ob.PlaceMarketOrder(context.Background(), tc.order)
+			} else {
+				_, err = ob.PlaceLimitOrder(context.Background(), tc.order)
+			}
+
+			if tc.reasonExpected == 0 {
+				if err != nil {
+					t.Fatalf("unexpected err: %v", err)
+				}
+				return
+			}
+
+			var riskErr *RiskError
+			if !errors.As(err, &riskErr) {
+				t.Fatalf("expected risk err, but got: %v", err)
+			}
+			if riskErr.Reason != tc.reasonExpected {
+				t.Fatalf("expected %s reason, but got: %s", tc.reasonExpected, riskErr.Reason)
+			}
+
+			if _, ok := ob.OrdersDone[tc.order.orderID]; ok {
+				t.Fatalf("rejected order shouldn't be done")
+			}
+
+			// the limits are changed at runtime - the order passes.
+			ob.Risk.ResetLimits("trader")
+			if tc.market {
+				_, _, err = ob.PlaceMarketOrder(context.Background(), tc.order)
+			} else {
+				_, err = ob.PlaceLimitOrder(context.Background(), tc.order)
+			}
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/apd"
+)
+
+// rollbackStep is a planned rollback of one execution of the order.
+type rollbackStep struct {
+	e *ExecutionReport
+	// maker is the executor order, it's nil if the order book doesn't know it anymore.
+	maker *Order
+	// back is true if the maker gets the amount back to the order book: it's there or it was filled.
+	// The canceled maker gets only the funds back.
+	back bool
+	// entry is the reversal entry of the ledger.
+	entry LedgerEntry
+}
+
+// Rollback rollbacks the executions of the order by id, where it was the initiator, all or nothing:
+// the counterparties get their funds and fees back, the makers get the amounts back at their places in the queues,
+// the rest of the order is removed from the order book.
+func (ob *OrderBook) Rollback(ctx context.Context, orderID OrderID) error {
+	c := Command{Type: CommandRollback, OrderID: orderID, CreatedAt: time.Now()}
+	if ob.Sequencer != nil {
+		_, err := ob.Sequencer.Apply(ctx, c)
+		return err
+	}
+
+	err := ob.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer ob.unlock()
+
+	return ob.rollback(ctx, c)
+}
+
+// rollback rollbacks the order of the command, the reversal entries of the ledger have the time of the command.
+func (ob *OrderBook) rollback(ctx context.Context, c Command) error {
+	orderID := c.OrderID
+	order, ok := ob.OrdersDone[orderID]
+	if !ok {
+		return ob.reject(c, newOrderError(ErrOrderNotFound, "order: %s not found - nothing to rollback", orderID))
+	}
+
+	// everything which can fail is checked before the order book is changed.
+	steps, moves, err := ob.planRollback(order, c.CreatedAt)
+	if err != nil {
+		return ob.reject(c, fmt.Errorf("can't rollback order: %s: %w", orderID, err))
+	}
+
+	err = ob.commit(c, moves)
+	if err != nil {
+		return ob.reject(c, fmt.Errorf("can't rollback order: %s: %w", orderID, err))
+	}
+
+	if ob.Fees != nil {
+		for _, step := range steps {
+			if step.e.feeAsset != "" {
+				ob.Fees.removeVolume(step.e, order.createdAt)
+			}
+		}
+	}
+
+	if ob.Ledger != nil {
+		entries := make([]LedgerEntry, 0, len(steps))
+		for _, step := range steps {
+			entries = append(entries, step.entry)
+		}
+
+		err = ob.Ledger.appendEntries(entries)
+		if err != nil {
+			return fmt.Errorf("error in rollback: %w", err)
+		}
+	}
+
+	// the rest of the order leaves the order book before the makers are back, so the book isn't crossed.
+	if el, ok := ob.Orders[orderID]; ok {
+		side := ob.side(order.operationType)
+		err = side.RemoveOrder(el)
+		if err != nil {
+			return fmt.Errorf("error in rollback: %w", err)
+		}
+		ob.deleteOrder(order)
+		ob.emitCanceled(order, ob.remaining(order))
+		ob.emitLevel(side, order.price, order.fixedPrice)
+	}
+
+	for _, step := range steps {
+		err = ob.rollbackExecution(order, step)
+		if err != nil {
+			return fmt.Errorf("error in rollback: %w", err)
+		}
+	}
+
+	delete(ob.OrdersDone, orderID)
+
+	return nil
+}
+
+// planRollback returns the steps of the rollback of the order and the moves of the balances of the accounts.
+// It doesn't change anything.
+func (ob *OrderBook) planRollback(order *Order, at time.Time) ([]rollbackStep, []balanceMove, error) {
+	var moves []balanceMove
+	if _, ok := ob.Orders[order.orderID]; ok && ob.Accounts != nil {
+		asset, funds, err := ob.reservation(order, ob.remaining(order))
+		if err != nil {
+			return nil, nil, err
+		}
+		moves = append(moves, balanceMove{op: opRelease, from: order.accountID, asset: asset, amount: funds})
+	}
+
+	var steps []rollbackStep
+	for _, e := range order.executions {
+		// the order was the maker of the execution, it's rolled back with the initiator.
+		if e.initiatorOrderID != order.orderID {
+			continue
+		}
+
+		step := rollbackStep{e: e}
+		if el, ok := ob.Orders[e.executorOrderID]; ok {
+			step.maker, step.back = el.Value.(*Order), true
+
+			// the refilled amount of the maker is published by the order feed.
+			if ob.OrderFeed != nil {
+				refilled := apd.New(0, 0)
+				_, err := apd.BaseContext.Add(refilled, ob.remaining(step.maker), e.amount)
+				if err != nil {
+					return nil, nil, err
+				}
+
+				err = checkFeedDecimals(refilled)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+		} else if maker, ok := ob.OrdersDone[e.executorOrderID]; ok && ob.side(maker.operationType).filled(maker) {
+			// the filled maker is restored, its price must be on the price ladder still.
+			err := ob.checkTick(maker)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			err = ob.checkCross(order, maker)
+			if err != nil {
+				return nil, nil, err
+			}
+			step.maker, step.back = maker, true
+		} else if ok {
+			step.maker = maker
+		}
+
+		// the fees are reverted by the fee account of the order book.
+		charged := (e.takerFee != nil && !e.takerFee.IsZero()) || (e.makerFee != nil && !e.makerFee.IsZero())
+		if charged && ob.Fees == nil {
+			return nil, nil, fmt.Errorf("fees of execution by %s can't be reverted without fee schedule", e.executorOrderID)
+		}
+
+		if ob.Accounts != nil {
+			// the fees are given back first, they were paid from the funds of the trade.
+			moves = append(moves, ob.refundMoves(e)...)
+
+			unsettle, err := ob.unsettleMoves(order, e)
+			if err != nil {
+				return nil, nil, err
+			}
+			moves = append(moves, unsettle...)
+
+			if step.back {
+				asset, funds, err := ob.reservation(step.maker, e.amount)
+				if err != nil {
+					return nil, nil, err
+				}
+				moves = append(moves, balanceMove{op: opReserve, from: step.maker.accountID, asset: asset, amount: funds})
+			}
+		}
+
+		if ob.Ledger != nil {
+			var err error
+			step.entry, err = ob.reversalEntry(order, e, at)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		steps = append(steps, step)
+	}
+
+	return steps, moves, nil
+}
+
+// checkCross checks that the restored maker doesn't cross the best price of the opposite side.
+// The rest of the rolled back order leaves the order book before, so its price isn't counted.
+func (ob *OrderBook) checkCross(order, maker *Order) error {
+	side := ob.side(opposite(maker.operationType))
+	for level := side.best(); level != nil; level = side.worse(level) {
+		if level.orders.Len() == 1 && level.orders.Front().Value.(*Order) == order {
+			continue
+		}
+
+		res := maker.price.Cmp(level.price)
+		if (maker.operationType == Ask && res <= 0) || (maker.operationType == Bid && res >= 0) {
+			return fmt.Errorf("order: %s at %s crosses the best price: %s", maker.orderID, maker.price, level.price)
+		}
+
+		return nil
+	}
+
+	return nil
+}
+
+// unsettleMoves returns the moves of the funds of the execution back between counterparties.
+func (ob *OrderBook) unsettleMoves(o *Order, e *ExecutionReport) ([]balanceMove, error) {
+	buyer, seller := e.initiatorAccountID, e.executorAccountID
+	if o.operationType == Ask {
+		buyer, seller = seller, buyer
+	}
+
+	quoteAmount := apd.New(0, 0)
+	_, err := apd.BaseContext.Mul(quoteAmount, e.price, e.amount)
+	if err != nil {
+		return nil, err
+	}
+
+	return []balanceMove{
+		{op: opTransferAvailable, from: buyer, to: seller, asset: ob.BaseAsset, amount: e.amount},
+		{op: opTransferAvailable, from: seller, to: buyer, asset: ob.QuoteAsset, amount: quoteAmount},
+	}, nil
+}
+
+// rollbackExecution gives the amount of the execution back to the maker: the resting maker is refilled,
+// the filled one is restored at its place in the queue.
+func (ob *OrderBook) rollbackExecution(order *Order, step rollbackStep) error {
+	if step.maker != nil {
+		step.maker.executions = removeExecution(step.maker.executions, step.e)
+	}
+
+	el, resting := ob.Orders[step.e.executorOrderID]
+	ob.emitRolledBack(order, step.e, step.back, step.back && !resting)
+	if !step.back {
+		return nil
+	}
+
+	side := ob.side(step.maker.operationType)
+	if resting {
+		err := side.RefillOrder(el, step.e.amount)
+		if err != nil {
+			return err
+		}
+		ob.emitLevel(side, step.maker.price, step.maker.fixedPrice)
+
+		return nil
+	}
+
+	step.maker.amount = apd.New(0, 0).Set(step.e.amount)
+	err := ob.fixOrder(step.maker)
+	if err != nil {
+		return err
+	}
+
+	el, err = side.RestoreOrder(step.maker)
+	if err != nil {
+		return err
+	}
+	ob.addOrder(el)
+	ob.emitLevel(side, step.maker.price, step.maker.fixedPrice)
+
+	return nil
+}
+
+// side returns the side of the order book where the orders of the operation type rest.
+func (ob *OrderBook) side(operationType OperationType) *OrderSide {
+	if operationType == Ask {
+		return ob.Asks
+	}
+
+	return ob.Bids
+}
+
+// removeExecution removes the rolled back execution from the executions of the order.
+func removeExecution(executions []*ExecutionReport, e *ExecutionReport) []*ExecutionReport {
+	for i, x := range executions {
+		if x == e {
+			return append(executions[:i:i], executions[i+1:]...)
+		}
+	}
+
+	return executions
+}
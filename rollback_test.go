@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/cockroachdb/apd"
+)
+
+// testQueue returns the ids and the amounts of the orders of the price level from the head of the queue.
+func testQueue(t *testing.T, os *OrderSide, price string) []string {
+	t.Helper()
+
+	level, ok := os.index.Get(os.key(decimal(t, price), 0))
+	if !ok {
+		return nil
+	}
+
+	var queue []string
+	for el := level.orders.Front(); el != nil; el = el.Next() {
+		o := el.Value.(*Order)
+		amount, _ := apd.New(0, 0).Reduce(o.amount)
+		queue = append(queue, string(o.orderID)+" "+amount.String())
+	}
+
+	return queue
+}
+
+func Test_RollbackRestoresQueue(t *testing.T) {
+	ob, accounts := testAccountsOrderBook(t)
+
+	place := func(id OrderID, account AccountID, side OperationType, amount string) {
+		_, err := ob.PlaceLimitOrder(context.Background(), &Order{
+			orderID:       id,
+			accountID:     account,
+			operationType: side,
+			amount:        decimal(t, amount),
+			price:         apd.New(100, 0),
+		})
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	// b1 fills a1 and takes a half of a2, a3 rests after them.
+	place("a1", "seller", Ask, "1")
+	place("a2", "seller", Ask, "1")
+	place("b1", "buyer", Bid, "1.5")
+	place("a3", "seller", Ask, "1")
+
+	var buf bytes.Buffer
+	err := ob.Snapshot(&buf)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// the seller can't give the quote back, nothing is changed.
+	err = accounts.Withdraw("seller", "USDT", decimal(t, "100"))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	err = ob.Rollback(context.Background(), "b1")
+	if err == nil {
+		t.Fatalf("expected err")
+	}
+	if q := testQueue(t, ob.Asks, "100"); len(q) != 2 || q[0] != "a2 0.5" || q[1] != "a3 1" {
+		t.Fatalf("unexpected queue: %v", q)
+	}
+	if _, ok := ob.OrdersDone["b1"]; !ok {
+		t.Fatalf("order is rolled back")
+	}
+	checkBalance(t, accounts, "seller", "BTC", "7", "1.5")
+	checkBalance(t, accounts, "seller", "USDT", "50", "0")
+	checkBalance(t, accounts, "buyer", "BTC", "1.5", "0")
+	checkBalance(t, accounts, "buyer", "USDT", "99850", "0")
+
+	err = accounts.Deposit("seller", "USDT", decimal(t, "100"))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	err = ob.Rollback(context.Background(), "b1")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// a1 is back at the head of the queue, a3 is still the last one.
+	expected := []string{"a1 1", "a2 1", "a3 1"}
+	q := testQueue(t, ob.Asks, "100")
+	if len(q) != len(expected) || q[0] != expected[0] || q[1] != expected[1] || q[2] != expected[2] {
+		t.Fatalf("unexpected queue: %v", q)
+	}
+	if _, ok := ob.Orders["a1"]; !ok || len(ob.Orders["a1"].Value.(*Order).executions) != 0 {
+		t.Fatalf("order isn't restored: a1")
+	}
+	checkBalance(t, accounts, "seller", "BTC", "7", "3")
+	checkBalance(t, accounts, "seller", "USDT", "0", "0")
+	checkBalance(t, accounts, "buyer", "BTC", "0", "0")
+	checkBalance(t, accounts, "buyer", "USDT", "100000", "0")
+
+	// the places in the queue are kept by the snapshot.
+	restored, err := RestoreOrderBook(bytes.NewReader(buf.Bytes()), OrderBookParts{Accounts: NewAccounts()})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	err = restored.Rollback(context.Background(), "b1")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	q = testQueue(t, restored.Asks, "100")
+	if len(q) != len(expected) || q[0] != expected[0] || q[1] != expected[1] || q[2] != expected[2] {
+		t.Fatalf("unexpected queue of restored order book: %v", q)
+	}
+}
+
+func Test_RollbackRemovesRest(t *testing.T) {
+	ob, accounts := testAccountsOrderBook(t)
+
+	for _, o := range []*Order{
+		{orderID: "a1", accountID: "seller", operationType: Ask, amount: decimal(t, "1"), price: decimal(t, "100")},
+		{orderID: "b1", accountID: "buyer", operationType: Bid, amount: decimal(t, "2"), price: decimal(t, "100")},
+	} {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+	checkBalance(t, accounts, "buyer", "USDT", "99800", "100")
+
+	err := ob.Rollback(context.Background(), "b1")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if _, ok := ob.Orders["b1"]; ok {
+		t.Fatalf("rest of order is in order book")
+	}
+	asks, bids := ob.Asks.Depth(0), ob.Bids.Depth(0)
+	if len(bids) != 0 || len(asks) != 1 || asks[0].Amount.Cmp(decimal(t, "1")) != 0 {
+		t.Fatalf("unexpected depth: %+v, %+v", asks, bids)
+	}
+	checkBalance(t, accounts, "buyer", "USDT", "100000", "0")
+	checkBalance(t, accounts, "seller", "BTC", "9", "1")
+	checkBalance(t, accounts, "seller", "USDT", "0", "0")
+}
+
+func Test_RollbackCrossed(t *testing.T) {
+	dir := t.TempDir()
+	j, err := OpenJournal(dir, JournalConfig{SyncPolicy: SyncAlways})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer j.Close()
+
+	ob, accounts := testAccountsOrderBook(t)
+	ob.Journal = j
+
+	// b1 fills a1, then b2 rests at the price of a1.
+	for _, o := range []*Order{
+		{orderID: "a1", accountID: "seller", operationType: Ask, amount: decimal(t, "1"), price: decimal(t, "100")},
+		{orderID: "b1", accountID: "buyer", operationType: Bid, amount: decimal(t, "1"), price: decimal(t, "100")},
+		{orderID: "b2", accountID: "buyer", operationType: Bid, amount: decimal(t, "1"), price: decimal(t, "100")},
+	} {
+		_, err = ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	// a1 can't be restored, it crosses b2.
+	err = ob.Rollback(context.Background(), "b1")
+	if err == nil {
+		t.Fatalf("expected err")
+	}
+	if _, ok := ob.Orders["a1"]; ok {
+		t.Fatalf("order is restored: a1")
+	}
+	checkBalance(t, accounts, "buyer", "BTC", "1", "0")
+	checkBalance(t, accounts, "seller", "USDT", "100", "0")
+
+	var last Command
+	err = ReadJournal(dir, 0, func(c Command) error {
+		last = c
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if last.Type != CommandRollback || !last.Rejected {
+		t.Fatalf("unexpected command: %+v", last)
+	}
+
+	err = ob.Cancel(context.Background(), "b2")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	err = ob.Rollback(context.Background(), "b1")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if q := testQueue(t, ob.Asks, "100"); len(q) != 1 || q[0] != "a1 1" {
+		t.Fatalf("unexpected queue: %v", q)
+	}
+}
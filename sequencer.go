@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultSequencerSize is a default size of the ring buffer of the sequencer.
+const defaultSequencerSize = 4096
+
+// ErrSequencerStopped is returned for the commands which weren't applied because the sequencer is stopped.
+var ErrSequencerStopped = errors.New("sequencer is stopped")
+
+// ErrCommandCanceled is a result of the command which is canceled by Wait before it's applied.
+var ErrCommandCanceled = errors.New("command is canceled")
+
+// States of CommandFuture.
+const (
+	futurePending int32 = iota
+	futureApplying
+	futureCanceled
+)
+
+// Sequencer applies the commands of the order book by one goroutine: the callers put the commands
+// into the bounded ring buffer and get the results by the futures, the goroutine takes the commands
+// by batches and applies them. The goroutine owns the order book while it runs, so the commands are applied
+// without the lock and journaled in the order of the ring buffer. Depth and TopOfBook are served by the views,
+// the other calls, like OrderStatus or Snapshot, get the order book by a barrier in the ring buffer:
+// the goroutine applies the commands before it and waits till the caller releases the order book.
+type Sequencer struct {
+	ob *OrderBook
+
+	// slots is the ring buffer, its size is a power of two.
+	slots []sequencerSlot
+	mask  uint64
+	// tail is a position of the next command, it's claimed by the producers.
+	tail atomic.Uint64
+	// head is a position of the next command to apply, it's used only by the goroutine of the sequencer.
+	head uint64
+
+	// published gets a signal when there is a new command.
+	published chan struct{}
+	// freed is closed when the slots are freed and the producers wait for them.
+	freed   chan struct{}
+	waiting atomic.Int32
+	freedMx sync.Mutex
+
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+// sequencerSlot is a slot of the ring buffer. seq is a position of the slot when it's free
+// and the position + 1 when the command is published to it.
+type sequencerSlot struct {
+	seq atomic.Uint64
+	cmd sequencedCommand
+}
+
+// sequencedCommand is a command in the ring buffer.
+type sequencedCommand struct {
+	ctx    context.Context
+	c      Command
+	future *CommandFuture
+	// barrier isn't nil for the caller which takes the order book between the commands.
+	barrier *sequencerBarrier
+}
+
+// sequencerBarrier gives the order book to the caller: granted is closed when the commands before it are applied,
+// the sequencer doesn't apply the next ones till released is closed.
+type sequencerBarrier struct {
+	granted  chan struct{}
+	released chan struct{}
+}
+
+// CommandFuture is a result of the command which is submitted to the sequencer.
+type CommandFuture struct {
+	res   CommandResult
+	err   error
+	state atomic.Int32
+	done  chan struct{}
+}
+
+// Done returns the channel which is closed when the command is applied.
+func (f *CommandFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait waits for the result of the command till the context is done. If the context is done
+// before the command is applied, the command is canceled and ctx.Err() is returned,
+// otherwise Wait returns the result of the command which is being applied.
+func (f *CommandFuture) Wait(ctx context.Context) (CommandResult, error) {
+	select {
+	case <-f.done:
+		return f.res, f.err
+	case <-ctx.Done():
+	}
+
+	if f.state.CompareAndSwap(futurePending, futureCanceled) {
+		return CommandResult{}, ctx.Err()
+	}
+
+	<-f.done
+
+	return f.res, f.err
+}
+
+// NewSequencer creates a new instance of Sequencer of the order book with the ring buffer of size commands,
+// the size is rounded up to the power of two, the default one is used if it isn't positive.
+// The commands are applied by Run. It must be set to ob.Sequencer, so Apply and the other calls of the order book,
+// like PlaceLimitOrder or OrderStatus, go through the sequencer.
+func NewSequencer(ob *OrderBook, size int) *Sequencer {
+	if size <= 0 {
+		size = defaultSequencerSize
+	}
+
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+
+	s := &Sequencer{
+		ob:        ob,
+		slots:     make([]sequencerSlot, n),
+		mask:      uint64(n - 1),
+		published: make(chan struct{}, 1),
+		freed:     make(chan struct{}),
+		stopped:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for i := range s.slots {
+		s.slots[i].seq.Store(uint64(i))
+	}
+
+	return s
+}
+
+// Run applies the commands till the context is done, it must be called once.
+// The commands which are left in the ring buffer and the next ones get ErrSequencerStopped.
+// Run holds the lock of the order book till it's stopped, so nothing changes the order book past the sequencer.
+func (s *Sequencer) Run(ctx context.Context) {
+	s.ob.mx.Lock()
+	defer func() {
+		close(s.stopped)
+		s.failPending()
+		s.ob.mx.Unlock()
+		close(s.done)
+	}()
+
+	batch := make([]sequencedCommand, 0, len(s.slots))
+	for ctx.Err() == nil {
+		batch = s.take(batch[:0])
+		if len(batch) == 0 {
+			select {
+			case <-s.published:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		s.apply(batch)
+	}
+}
+
+// Stopped returns the channel which is closed when the sequencer is stopped and all commands got the results.
+func (s *Sequencer) Stopped() <-chan struct{} {
+	return s.done
+}
+
+// take takes the published commands from the ring buffer and frees their slots.
+func (s *Sequencer) take(batch []sequencedCommand) []sequencedCommand {
+	for len(batch) < len(s.slots) {
+		slot := &s.slots[s.head&s.mask]
+		if slot.seq.Load() != s.head+1 {
+			break
+		}
+
+		batch = append(batch, slot.cmd)
+		slot.cmd = sequencedCommand{}
+		slot.seq.Store(s.head + uint64(len(s.slots)))
+		s.head++
+	}
+
+	if len(batch) > 0 && s.waiting.Load() > 0 {
+		s.freedMx.Lock()
+		close(s.freed)
+		s.freed = make(chan struct{})
+		s.freedMx.Unlock()
+	}
+
+	return batch
+}
+
+// apply applies the batch and publishes the view of the order book, the futures are completed after it.
+func (s *Sequencer) apply(batch []sequencedCommand) {
+	for i := range batch {
+		cmd := &batch[i]
+		if !cmd.future.state.CompareAndSwap(futurePending, futureApplying) {
+			cmd.future.err = ErrCommandCanceled
+			continue
+		}
+		if err := cmd.ctx.Err(); err != nil {
+			cmd.future.err = err
+			continue
+		}
+
+		if cmd.barrier != nil {
+			// the caller has the order book till it's released.
+			close(cmd.barrier.granted)
+			<-cmd.barrier.released
+			continue
+		}
+
+		cmd.future.res, cmd.future.err = s.ob.apply(cmd.ctx, cmd.c)
+	}
+	s.ob.publishView()
+
+	for i := range batch {
+		close(batch[i].future.done)
+	}
+}
+
+// failPending completes the commands which are left in the ring buffer by ErrSequencerStopped.
+// The producers don't publish after stopped is closed, but the claimed slots can be published yet.
+func (s *Sequencer) failPending() {
+	for {
+		tail := s.tail.Load()
+		for s.head < tail {
+			slot := &s.slots[s.head&s.mask]
+			for slot.seq.Load() != s.head+1 {
+				// the producer has claimed the slot, but hasn't published it yet.
+				<-s.published
+			}
+
+			slot.cmd.future.err = ErrSequencerStopped
+			close(slot.cmd.future.done)
+			slot.cmd = sequencedCommand{}
+			slot.seq.Store(s.head + uint64(len(s.slots)))
+			s.head++
+		}
+
+		// nothing is claimed after the tail is closed by the position out of the ring buffer.
+		if s.tail.CompareAndSwap(tail, tail+uint64(len(s.slots))+1) {
+			break
+		}
+	}
+
+	s.freedMx.Lock()
+	close(s.freed)
+	s.freedMx.Unlock()
+}
+
+// Submit puts the command into the ring buffer, it waits for the free slot if the ring buffer is full.
+func (s *Sequencer) Submit(ctx context.Context, c Command) (*CommandFuture, error) {
+	f := &CommandFuture{done: make(chan struct{})}
+	err := s.publish(sequencedCommand{ctx: ctx, c: c, future: f})
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// lock takes the order book for the caller by the barrier, the order book is released by closing its released channel.
+// If the context is done before the order book is taken, the barrier is canceled and ctx.Err() is returned.
+func (s *Sequencer) lock(ctx context.Context) (*sequencerBarrier, error) {
+	b := &sequencerBarrier{granted: make(chan struct{}), released: make(chan struct{})}
+	f := &CommandFuture{done: make(chan struct{})}
+	err := s.publish(sequencedCommand{ctx: ctx, future: f, barrier: b})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-b.granted:
+		return b, nil
+	case <-f.done:
+		return nil, f.err
+	case <-ctx.Done():
+	}
+
+	if f.state.CompareAndSwap(futurePending, futureCanceled) {
+		return nil, ctx.Err()
+	}
+
+	// the sequencer has taken the barrier already - let's give the order book back.
+	select {
+	case <-b.granted:
+		close(b.released)
+	case <-f.done:
+	}
+
+	return nil, ctx.Err()
+}
+
+// publish puts the command into the ring buffer, it waits for the free slot if the ring buffer is full.
+func (s *Sequencer) publish(cmd sequencedCommand) error {
+	select {
+	case <-s.stopped:
+		return ErrSequencerStopped
+	default:
+	}
+
+	for {
+		tail := s.tail.Load()
+		slot := &s.slots[tail&s.mask]
+		seq := slot.seq.Load()
+
+		switch {
+		case seq == tail:
+			if !s.tail.CompareAndSwap(tail, tail+1) {
+				continue
+			}
+
+			slot.cmd = cmd
+			slot.seq.Store(tail + 1)
+
+			select {
+			case s.published <- struct{}{}:
+			default:
+			}
+
+			return nil
+
+		case seq < tail:
+			// the ring buffer is full or the sequencer is stopped.
+			err := s.waitFreed(cmd.ctx, tail)
+			if err != nil {
+				return err
+			}
+
+		default:
+			// the slot is taken by another producer already.
+		}
+	}
+}
+
+// waitFreed waits till the slot at the position is freed by the sequencer.
+func (s *Sequencer) waitFreed(ctx context.Context, tail uint64) error {
+	s.waiting.Add(1)
+	defer s.waiting.Add(-1)
+
+	s.freedMx.Lock()
+	freed := s.freed
+	s.freedMx.Unlock()
+
+	select {
+	case <-s.stopped:
+		return ErrSequencerStopped
+	default:
+	}
+
+	// the slot could be freed before the producer started to wait.
+	if s.slots[tail&s.mask].seq.Load() >= tail || s.tail.Load() != tail {
+		return nil
+	}
+
+	select {
+	case <-freed:
+		return nil
+	case <-s.stopped:
+		return ErrSequencerStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Apply submits the command and waits for its result.
+func (s *Sequencer) Apply(ctx context.Context, c Command) (CommandResult, error) {
+	f, err := s.Submit(ctx, c)
+	if err != nil {
+		return CommandResult{}, err
+	}
+
+	return f.Wait(ctx)
+}
+
+// lock takes the order book for the caller till release or unlock, it fails only if the context is done.
+// If the sequencer is set, its goroutine owns the order book, so the caller gets it by the barrier between the commands,
+// otherwise or after the sequencer is stopped - by the lock of the order book.
+func (ob *OrderBook) lock(ctx context.Context) error {
+	if ob.Sequencer != nil {
+		b, err := ob.Sequencer.lock(ctx)
+		if err == nil {
+			ob.barrier = b
+			return nil
+		}
+		if !errors.Is(err, ErrSequencerStopped) {
+			return err
+		}
+
+		// the stopped sequencer gives the lock back when all its commands are done.
+		select {
+		case <-ob.Sequencer.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return ob.mx.LockContext(ctx)
+}
+
+// release releases the order book which is taken by lock.
+func (ob *OrderBook) release() {
+	if b := ob.barrier; b != nil {
+		ob.barrier = nil
+		close(b.released)
+		return
+	}
+
+	ob.mx.Unlock()
+}
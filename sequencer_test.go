@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/apd"
+)
+
+func Test_Sequencer(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	l := &recordingListener{}
+	ob.Listeners = append(ob.Listeners, l)
+	// the small ring buffer makes the producers wait for the free slots.
+	ob.Sequencer = NewSequencer(ob, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		<-ob.Sequencer.Stopped()
+	}()
+	go ob.Sequencer.Run(ctx)
+
+	const (
+		producers = 8
+		orders    = 50
+	)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, producers)
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+
+			for i := 0; i < orders; i++ {
+				side := Ask
+				if p%2 == 1 {
+					side = Bid
+				}
+
+				_, err := ob.Apply(context.Background(), Command{
+					Type:    CommandPlaceLimit,
+					OrderID: OrderID(fmt.Sprintf("%d-%d", p, i)),
+					Side:    side,
+					Price:   apd.New(100, 0),
+					Amount:  apd.New(1, 0),
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// the same amount is bought and sold by the same price - everything is executed.
+	asks, bids := ob.Depth(context.Background(), 0)
+	if len(asks) != 0 || len(bids) != 0 {
+		t.Fatalf("expected empty order book, but got: %v, %v", asks, bids)
+	}
+
+	accepted := 0
+	for _, e := range l.events {
+		if len(e) > 8 && e[:8] == "accepted" {
+			accepted++
+		}
+	}
+	if accepted != producers*orders {
+		t.Fatalf("expected %d accepted orders, but got: %d", producers*orders, accepted)
+	}
+}
+
+func Test_SequencerFuture(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	s := NewSequencer(ob, 0)
+
+	f, err := s.Submit(context.Background(), Command{
+		Type: CommandPlaceLimit, OrderID: "1", Side: Ask, Price: apd.New(100, 0), Amount: apd.New(1, 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// the command isn't applied till the sequencer runs - it's canceled by the deadline.
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer waitCancel()
+	_, err = f.Wait(waitCtx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline err, but got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.Run(ctx)
+
+	<-f.Done()
+	_, err = f.Wait(context.Background())
+	if !errors.Is(err, ErrCommandCanceled) {
+		t.Fatalf("expected canceled err, but got: %v", err)
+	}
+
+	for _, c := range []Command{
+		{Type: CommandPlaceLimit, OrderID: "2", Side: Ask, Price: apd.New(100, 0), Amount: apd.New(1, 0)},
+		{Type: CommandPlaceLimit, OrderID: "3", Side: Bid, Price: apd.New(100, 0), Amount: apd.New(1, 0)},
+	} {
+		f, err = s.Submit(context.Background(), c)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	res, err := f.Wait(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if res.OrdersExecuted != 1 || res.Executions[0].executorOrderID != "2" {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	if _, ok := ob.OrdersDone["1"]; ok {
+		t.Fatalf("canceled command is applied")
+	}
+
+	cancel()
+	<-s.Stopped()
+
+	_, err = s.Submit(context.Background(), Command{Type: CommandCancel, OrderID: "1"})
+	if !errors.Is(err, ErrSequencerStopped) {
+		t.Fatalf("expected stopped err, but got: %v", err)
+	}
+}
+
+func Test_SequencerStop(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	s := NewSequencer(ob, 2)
+
+	futures := make([]*CommandFuture, 0, 2)
+	for i := 0; i < 2; i++ {
+		f, err := s.Submit(context.Background(), Command{Type: CommandCancel, OrderID: OrderID(fmt.Sprint(i))})
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		futures = append(futures, f)
+	}
+
+	// the ring buffer is full - the producer waits till the sequencer is stopped.
+	submitted := make(chan error, 1)
+	go func() {
+		_, err := s.Submit(context.Background(), Command{Type: CommandCancel, OrderID: "2"})
+		submitted <- err
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Run(ctx)
+
+	for _, f := range futures {
+		_, err := f.Wait(context.Background())
+		if !errors.Is(err, ErrSequencerStopped) {
+			t.Fatalf("expected stopped err, but got: %v", err)
+		}
+	}
+
+	err := <-submitted
+	if err != nil && !errors.Is(err, ErrSequencerStopped) {
+		t.Fatalf("unexpected err: %v", err)
+	}
+}
+
+func Test_SequencerDirectCalls(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	ob.Sequencer = NewSequencer(ob, 4)
+
+	// the sequencer isn't running, so the direct call waits for it.
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelTimeout()
+	_, err := ob.PlaceLimitOrder(timeout, &Order{
+		orderID: "a1", operationType: Ask, price: apd.New(100, 0), amount: apd.New(1, 0),
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded err, but got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		<-ob.Sequencer.Stopped()
+	}()
+	go ob.Sequencer.Run(ctx)
+
+	for _, id := range []OrderID{"a1", "a2", "a3"} {
+		_, err = ob.PlaceLimitOrder(context.Background(), &Order{
+			orderID: id, accountID: "seller", operationType: Ask, price: apd.New(100, 0), amount: apd.New(1, 0),
+		})
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	// the order of the direct call gets the executions.
+	o := &Order{orderID: "b1", operationType: Bid, price: apd.New(100, 0), amount: apd.New(1, 0)}
+	executed, amountLeft, err := ob.PlaceMarketOrder(context.Background(), o)
+	if err != nil || executed != 1 || !amountLeft.IsZero() || len(o.executions) != 1 {
+		t.Fatalf("unexpected result: %d, %s, %v", executed, amountLeft, err)
+	}
+
+	err = ob.Rollback(context.Background(), "b1")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	err = ob.Cancel(context.Background(), "a2")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	canceled, err := ob.CancelAll(context.Background(), "seller", nil)
+	if err != nil || len(canceled) != 2 {
+		t.Fatalf("unexpected canceled orders: %v, %v", canceled, err)
+	}
+}
+
+func Test_SequencerBarrier(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	ob.Sequencer = NewSequencer(ob, 4)
+
+	// the sequencer isn't running, so the order book can't be taken.
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelTimeout()
+	_, err := ob.OrderStatus(timeout, "a1")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded err, but got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go ob.Sequencer.Run(ctx)
+
+	_, err = ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID: "a1", accountID: "seller", operationType: Ask, price: apd.New(100, 0), amount: apd.New(1, 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	// the command waits till the order book is released by the caller.
+	err = ob.lock(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	f, err := ob.Sequencer.Submit(context.Background(), Command{Type: CommandCancel, OrderID: "a1"})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	select {
+	case <-f.Done():
+		t.Fatalf("command is applied while the order book is taken")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if _, ok := ob.Orders["a1"]; !ok {
+		t.Fatalf("order is canceled while the order book is taken")
+	}
+	ob.release()
+
+	_, err = f.Wait(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	status, err := ob.OrderStatus(context.Background(), "a1")
+	if err != nil || status.Open {
+		t.Fatalf("unexpected status: %+v, %v", status, err)
+	}
+	hash := ob.StateHash()
+
+	// the stopped sequencer gives the order book back to the lock.
+	cancel()
+	<-ob.Sequencer.Stopped()
+	if ob.StateHash() != hash {
+		t.Fatalf("state is changed after the sequencer is stopped")
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/apd"
+)
+
+// settlement is what the order changes besides the order book: the balances of the accounts, the fees
+// and the ledger. It's planned by the fills the order will have before the matching, so if some balance
+// can't be moved or some entry isn't balanced - the order is rejected and nothing is changed.
+// The moves are applied by commit with the journal of the order.
+type settlement struct {
+	// fills are the executions the order will have, in the order of the matching.
+	fills []*ExecutionReport
+	// moves are all moves of the balances: the reservation, the settlement of the fills, the fees and the releases.
+	moves []balanceMove
+	// entries are the ledger entries of the fills, they are checked already.
+	entries []LedgerEntry
+}
+
+// planFills returns the executions of the order by the side, they are the same as ExecuteOrder makes,
+// but the order book isn't changed.
+func (os *OrderSide) planFills(o *Order) ([]*ExecutionReport, error) {
+	if os.fixed != nil {
+		return os.planFixedFills(o), nil
+	}
+
+	var fills []*ExecutionReport
+	left := apd.New(0, 0).Set(o.amount)
+	for level := os.best(); level != nil && !left.IsZero(); level = os.worse(level) {
+		// the price is worse than the price of the order.
+		res := o.price.Cmp(level.price)
+		if (os.sideType == Ask && res < 0) || (os.sideType == Bid && res > 0) {
+			break
+		}
+
+		for el := level.orders.Front(); el != nil && !left.IsZero(); el = el.Next() {
+			maker := el.Value.(*Order)
+
+			found := maker.amount
+			if found.Cmp(left) > 0 {
+				found = left
+			}
+
+			e := newFill(o, maker, apd.New(0, 0).Set(found))
+			_, err := apd.BaseContext.Sub(left, left, e.amount)
+			if err != nil {
+				return nil, err
+			}
+
+			fills = append(fills, e)
+		}
+	}
+
+	return fills, nil
+}
+
+// planFixedFills returns the executions of the order by the fixed-point side, as executeFixed makes them.
+func (os *OrderSide) planFixedFills(o *Order) []*ExecutionReport {
+	var fills []*ExecutionReport
+	left := o.fixedAmount
+	for level := os.best(); level != nil && left > 0; level = os.worse(level) {
+		// the price is worse than the price of the order.
+		if (o.operationType == Bid && level.fixedPrice > o.fixedPrice) ||
+			(o.operationType == Ask && level.fixedPrice < o.fixedPrice) {
+			break
+		}
+
+		for el := level.orders.Front(); el != nil && left > 0; el = el.Next() {
+			maker := el.Value.(*Order)
+
+			found := maker.fixedAmount
+			if found > left {
+				found = left
+			}
+			left -= found
+
+			fills = append(fills, newFill(o, maker, os.fixed.amountDecimal(found)))
+		}
+	}
+
+	return fills
+}
+
+// newFill returns the planned execution of the order by the maker.
+func newFill(o, maker *Order, amount *apd.Decimal) *ExecutionReport {
+	return &ExecutionReport{
+		initiatorOrderID:   o.orderID,
+		initiatorAccountID: o.accountID,
+		executorOrderID:    maker.orderID,
+		executorAccountID:  maker.accountID,
+		amount:             amount,
+		price:              maker.price,
+	}
+}
+
+// planSettlement plans the settlement of the order by the side. The market order doesn't rest,
+// so the funds of the amount which isn't found are released.
+func (ob *OrderBook) planSettlement(o *Order, side *OrderSide, market bool) (*settlement, error) {
+	s := &settlement{}
+	// the fills are checked by the order feed too.
+	if ob.Accounts == nil && ob.Fees == nil && ob.Ledger == nil && ob.OrderFeed == nil {
+		return s, nil
+	}
+
+	var err error
+	s.fills, err = side.planFills(o)
+	if err != nil {
+		return nil, err
+	}
+
+	if ob.Accounts != nil {
+		asset, funds, err := ob.reservation(o, o.amount)
+		if err != nil {
+			return nil, err
+		}
+		s.moves = append(s.moves, balanceMove{op: opReserve, from: o.accountID, asset: asset, amount: funds})
+
+		for _, e := range s.fills {
+			moves, err := ob.settleMoves(o, e)
+			if err != nil {
+				return nil, err
+			}
+			s.moves = append(s.moves, moves...)
+		}
+	}
+
+	if ob.Fees != nil {
+		moves, err := ob.planFees(o, s.fills)
+		if err != nil {
+			return nil, err
+		}
+		s.moves = append(s.moves, moves...)
+	}
+
+	if ob.Accounts != nil && market {
+		left := apd.New(0, 0).Set(o.amount)
+		for _, e := range s.fills {
+			_, err = apd.BaseContext.Sub(left, left, e.amount)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if !left.IsZero() {
+			asset, funds, err := ob.reservation(o, left)
+			if err != nil {
+				return nil, err
+			}
+			s.moves = append(s.moves, balanceMove{op: opRelease, from: o.accountID, asset: asset, amount: funds})
+		}
+	}
+
+	if ob.Ledger != nil {
+		for _, e := range s.fills {
+			entry, err := ob.tradeEntry(o, e)
+			if err != nil {
+				return nil, err
+			}
+			s.entries = append(s.entries, entry)
+		}
+	}
+
+	return s, nil
+}
+
+// settleMoves returns the moves of the reserved funds of the execution between counterparties:
+// BaseAsset goes from the seller to the buyer and QuoteAsset goes from the buyer to the seller.
+func (ob *OrderBook) settleMoves(o *Order, e *ExecutionReport) ([]balanceMove, error) {
+	buyer, seller := e.initiatorAccountID, e.executorAccountID
+	buyerPrice := o.price
+	if o.operationType == Ask {
+		buyer, seller = seller, buyer
+		buyerPrice = e.price
+	}
+
+	quoteAmount := apd.New(0, 0)
+	_, err := apd.BaseContext.Mul(quoteAmount, e.price, e.amount)
+	if err != nil {
+		return nil, err
+	}
+
+	moves := []balanceMove{
+		{op: opTransferReserved, from: seller, to: buyer, asset: ob.BaseAsset, amount: e.amount},
+		{op: opTransferReserved, from: buyer, to: seller, asset: ob.QuoteAsset, amount: quoteAmount},
+	}
+
+	// the buyer reserved funds by its own price, but was executed by the better one - release the difference.
+	if buyerPrice.Cmp(e.price) > 0 {
+		diff := apd.New(0, 0)
+		_, err = apd.BaseContext.Sub(diff, buyerPrice, e.price)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = apd.BaseContext.Mul(diff, diff, e.amount)
+		if err != nil {
+			return nil, err
+		}
+
+		moves = append(moves, balanceMove{op: opRelease, from: buyer, asset: ob.QuoteAsset, amount: diff})
+	}
+
+	return moves, nil
+}
+
+// commitSettlement sets the planned fees to the executions of the order which is matched already,
+// adds the trading volume of the fills and appends the checked ledger entries.
+func (ob *OrderBook) commitSettlement(o *Order, s *settlement) error {
+	if ob.Fees != nil {
+		for i, e := range o.executions {
+			e.feeAsset, e.takerFee, e.makerFee = s.fills[i].feeAsset, s.fills[i].takerFee, s.fills[i].makerFee
+			ob.Fees.addVolume(e, o.createdAt)
+		}
+	}
+
+	if ob.Ledger != nil {
+		return ob.Ledger.appendEntries(s.entries)
+	}
+
+	return nil
+}
+
+// tradeEntry returns the checked ledger entry of the execution of the order.
+func (ob *OrderBook) tradeEntry(o *Order, e *ExecutionReport) (LedgerEntry, error) {
+	postings, err := ob.tradePostings(o, e)
+	if err != nil {
+		return LedgerEntry{}, err
+	}
+
+	err = checkPostings(postings)
+	if err != nil {
+		return LedgerEntry{}, fmt.Errorf("can't post execution of %s by %s: %w", e.initiatorOrderID, e.executorOrderID, err)
+	}
+
+	return LedgerEntry{
+		Instrument:       ob.Instrument(),
+		InitiatorOrderID: e.initiatorOrderID,
+		ExecutorOrderID:  e.executorOrderID,
+		Postings:         postings,
+		CreatedAt:        o.createdAt,
+	}, nil
+}
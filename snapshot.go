@@ -0,0 +1,743 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"github.com/cockroachdb/apd"
+)
+
+const (
+	// snapshotMagic is written at the beginning of every snapshot.
+	snapshotMagic = "MEOB"
+	// snapshotVersion is a version of the snapshot format.
+	snapshotVersion uint8 = 1
+)
+
+// ErrCorruptedSnapshot is returned when the snapshot has a wrong format or checksum.
+var ErrCorruptedSnapshot = errors.New("corrupted snapshot")
+
+// Snapshot writes the whole state of the order book to w.
+// Format: magic, version, body, crc32 of the magic, version and body.
+// The body has the executions and the orders as tables, so the orders and the executions shared by the price levels,
+// OrdersDone and the executions of both orders are restored as the same objects.
+func (ob *OrderBook) Snapshot(w io.Writer) error {
+	_ = ob.lock(context.Background())
+	defer ob.release()
+
+	_, err := w.Write(ob.snapshot())
+	if err != nil {
+		return fmt.Errorf("can't write snapshot: %w", err)
+	}
+
+	return nil
+}
+
+func (ob *OrderBook) snapshot() []byte {
+	e := &encoder{buf: []byte(snapshotMagic)}
+	e.uint8(snapshotVersion)
+	e.string(string(ob.BaseAsset))
+	e.string(string(ob.QuoteAsset))
+	e.uint64(ob.seq)
+
+	mode := ob.mode()
+	e.bool(mode.FixedPoint != nil)
+	if mode.FixedPoint != nil {
+		e.uvarint(uint64(mode.FixedPoint.PriceDecimals))
+		e.uvarint(uint64(mode.FixedPoint.AmountDecimals))
+	}
+	e.bool(mode.PriceLadder != nil)
+	if mode.PriceLadder != nil {
+		e.decimal(mode.PriceLadder.MinPrice)
+		e.decimal(mode.PriceLadder.MaxPrice)
+		e.decimal(mode.PriceLadder.TickSize)
+	}
+
+	// let's collect the orders: at first from the price levels in queue order, then from OrdersDone.
+	orders := make([]*Order, 0, len(ob.OrdersDone))
+	orderIndex := map[*Order]int{}
+	addOrder := func(o *Order) {
+		if _, ok := orderIndex[o]; !ok {
+			orderIndex[o] = len(orders)
+			orders = append(orders, o)
+		}
+	}
+
+	levels := [][]*OrdersBySpecificPrice{ob.Asks.levels(), ob.Bids.levels()}
+	for _, sideLevels := range levels {
+		for _, level := range sideLevels {
+			for el := level.orders.Front(); el != nil; el = el.Next() {
+				addOrder(el.Value.(*Order))
+			}
+		}
+	}
+
+	doneIDs := make([]OrderID, 0, len(ob.OrdersDone))
+	for id := range ob.OrdersDone {
+		doneIDs = append(doneIDs, id)
+	}
+	sort.Slice(doneIDs, func(i, j int) bool {
+		return doneIDs[i] < doneIDs[j]
+	})
+	for _, id := range doneIDs {
+		addOrder(ob.OrdersDone[id])
+	}
+
+	reports := make([]*ExecutionReport, 0)
+	reportIndex := map[*ExecutionReport]int{}
+	for _, o := range orders {
+		for _, r := range o.executions {
+			if _, ok := reportIndex[r]; !ok {
+				reportIndex[r] = len(reports)
+				reports = append(reports, r)
+			}
+		}
+	}
+
+	e.uvarint(uint64(len(reports)))
+	for _, r := range reports {
+		e.string(string(r.initiatorOrderID))
+		e.string(string(r.initiatorAccountID))
+		e.string(string(r.executorOrderID))
+		e.string(string(r.executorAccountID))
+		e.decimal(r.amount)
+		e.decimal(r.price)
+		e.string(string(r.feeAsset))
+		e.decimal(r.takerFee)
+		e.decimal(r.makerFee)
+	}
+
+	e.uvarint(uint64(len(orders)))
+	for _, o := range orders {
+		e.string(string(o.orderID))
+		e.string(string(o.accountID))
+		e.uint8(uint8(o.operationType))
+		e.decimal(ob.remaining(o))
+		e.decimal(o.price)
+		e.time(o.createdAt)
+		e.uvarint(o.priority)
+		e.uvarint(uint64(len(o.executions)))
+		for _, r := range o.executions {
+			e.uvarint(uint64(reportIndex[r]))
+		}
+	}
+
+	e.uvarint(uint64(len(doneIDs)))
+	for _, id := range doneIDs {
+		e.string(string(id))
+		e.uvarint(uint64(orderIndex[ob.OrdersDone[id]]))
+	}
+
+	for i, sideLevels := range levels {
+		side := []*OrderSide{ob.Asks, ob.Bids}[i]
+		e.uvarint(uint64(len(sideLevels)))
+		for _, level := range sideLevels {
+			e.decimal(level.price)
+			e.decimal(side.total(level))
+			e.uvarint(uint64(level.orders.Len()))
+			for el := level.orders.Front(); el != nil; el = el.Next() {
+				e.uvarint(uint64(orderIndex[el.Value.(*Order)]))
+			}
+		}
+	}
+
+	ob.snapshotParts(e)
+
+	e.buf = binary.BigEndian.AppendUint32(e.buf, crc32.Checksum(e.buf, crcTable))
+
+	return e.buf
+}
+
+// RestoreOrderBook reads the order book written by Snapshot.
+// The restored order book has the same queues on every price level, so the matching works exactly as before.
+// The empty parts get their state from the snapshot and are attached to the order book,
+// the snapshot must have the state of the same parts.
+func RestoreOrderBook(r io.Reader, parts OrderBookParts) (*OrderBook, error) {
+	err := parts.checkEmpty()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("can't read snapshot: %w", err)
+	}
+
+	return restoreOrderBook(data, parts)
+}
+
+func restoreOrderBook(data []byte, parts OrderBookParts) (*OrderBook, error) {
+	if len(data) < len(snapshotMagic)+1+4 || !bytes.HasPrefix(data, []byte(snapshotMagic)) {
+		return nil, fmt.Errorf("%w: wrong header", ErrCorruptedSnapshot)
+	}
+
+	body, checksum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.Checksum(body, crcTable) != checksum {
+		return nil, fmt.Errorf("%w: wrong checksum", ErrCorruptedSnapshot)
+	}
+
+	d := &decoder{buf: body[len(snapshotMagic):]}
+	version := d.uint8()
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version: %d", version)
+	}
+
+	ob := NewOrderBook(Asset(d.string()), Asset(d.string()))
+	ob.seq = d.uint64()
+
+	var mode OrderBookMode
+	if d.bool() {
+		mode.FixedPoint = &FixedPoint{PriceDecimals: int32(d.uvarint()), AmountDecimals: int32(d.uvarint())}
+	}
+	if d.bool() {
+		mode.PriceLadder = &PriceLadder{MinPrice: d.decimal(), MaxPrice: d.decimal(), TickSize: d.decimal()}
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("%w: wrong mode: %v", ErrCorruptedSnapshot, d.err)
+	}
+
+	// the mode is set the same way as for the new order book, before the orders are added.
+	err := ob.setMode(context.Background(), mode)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptedSnapshot, err)
+	}
+
+	reports := make([]*ExecutionReport, d.count())
+	for i := range reports {
+		reports[i] = &ExecutionReport{
+			initiatorOrderID:   OrderID(d.string()),
+			initiatorAccountID: AccountID(d.string()),
+			executorOrderID:    OrderID(d.string()),
+			executorAccountID:  AccountID(d.string()),
+			amount:             d.decimal(),
+			price:              d.decimal(),
+			feeAsset:           Asset(d.string()),
+			takerFee:           d.decimal(),
+			makerFee:           d.decimal(),
+		}
+	}
+
+	orders := make([]*Order, d.count())
+	for i := range orders {
+		o := &Order{
+			orderID:       OrderID(d.string()),
+			accountID:     AccountID(d.string()),
+			operationType: OperationType(d.uint8()),
+			amount:        d.decimal(),
+			price:         d.decimal(),
+			createdAt:     d.time(),
+			priority:      d.uvarint(),
+		}
+
+		n := d.count()
+		if n > 0 {
+			o.executions = make([]*ExecutionReport, n)
+			for j := range o.executions {
+				o.executions[j] = tableItem(d, reports)
+			}
+		}
+		if d.err != nil || o.amount == nil || o.price == nil {
+			return nil, fmt.Errorf("%w: wrong order: %d", ErrCorruptedSnapshot, i)
+		}
+		// the amount of the order is the amount left, so the fixed-point one is set as by the matching.
+		err := ob.fixOrder(o)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorruptedSnapshot, err)
+		}
+
+		orders[i] = o
+	}
+
+	doneCount := d.count()
+	for i := 0; i < doneCount; i++ {
+		id := OrderID(d.string())
+		o := tableItem(d, orders)
+		if d.err != nil {
+			return nil, fmt.Errorf("%w: wrong done order: %s", ErrCorruptedSnapshot, id)
+		}
+
+		ob.OrdersDone[id] = o
+	}
+
+	for _, side := range []*OrderSide{ob.Asks, ob.Bids} {
+		levelCount := d.count()
+		for i := 0; i < levelCount; i++ {
+			price := d.decimal()
+			totalAmount := d.decimal()
+			if d.err != nil || price == nil || totalAmount == nil {
+				return nil, fmt.Errorf("%w: wrong price level: %d", ErrCorruptedSnapshot, i)
+			}
+
+			level := NewOrdersBySpecificPrice(price, totalAmount)
+			orderCount := d.count()
+			for j := 0; j < orderCount; j++ {
+				o := tableItem(d, orders)
+				if d.err != nil {
+					return nil, fmt.Errorf("%w: wrong order of price level: %s", ErrCorruptedSnapshot, price)
+				}
+
+				// the fixed-point level is built from the orders as by the matching.
+				if side.fixed != nil {
+					var err error
+					level.fixedPrice = o.fixedPrice
+					level.fixedTotal, err = addFixed(level.fixedTotal, o.fixedAmount)
+					if err != nil {
+						return nil, fmt.Errorf("%w: %v", ErrCorruptedSnapshot, err)
+					}
+				}
+
+				ob.addOrder(level.orders.PushBack(o))
+			}
+
+			err := side.index.Put(side.key(price, level.fixedPrice), level)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrCorruptedSnapshot, err)
+			}
+		}
+	}
+
+	state, err := restoreParts(d, parts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.finish()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptedSnapshot, err)
+	}
+
+	state.set(parts)
+	ob.attach(parts)
+
+	// the next orders rest after all known ones.
+	for _, o := range orders {
+		side := ob.Bids
+		if o.operationType == Ask {
+			side = ob.Asks
+		}
+		if o.priority > side.priority {
+			side.priority = o.priority
+		}
+	}
+
+	ob.Asks.dirty, ob.Bids.dirty = true, true
+	ob.publishView()
+
+	return ob, nil
+}
+
+// levels returns the price levels of the side sorted by price.
+func (os *OrderSide) levels() []*OrdersBySpecificPrice {
+	return os.index.Values()
+}
+
+// count reads the number of the items, it can't be greater than the rest of the data,
+// because every item takes at least one byte.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		d.err = errShortBuffer
+		return 0
+	}
+
+	return int(n)
+}
+
+// tableItem reads the index of the item in the table and returns the item.
+func tableItem[T any](d *decoder, table []T) (item T) {
+	i := d.uvarint()
+	if d.err != nil {
+		return item
+	}
+	if i >= uint64(len(table)) {
+		d.err = fmt.Errorf("index %d out of range %d", i, len(table))
+		return item
+	}
+
+	return table[i]
+}
+
+// StateHash returns sha256 of the snapshot of the order book, the same state gives the same hash.
+func (ob *OrderBook) StateHash() string {
+	_ = ob.lock(context.Background())
+	defer ob.release()
+
+	sum := sha256.Sum256(ob.snapshot())
+
+	return hex.EncodeToString(sum[:])
+}
+
+// OrderBookMode is the mode of the matching of the order book: the fixed-point decimals and the price ladder,
+// nil ones are the decimal mode and the skip list of the price levels.
+type OrderBookMode struct {
+	FixedPoint  *FixedPoint
+	PriceLadder *PriceLadder
+}
+
+// equal returns true if the modes are the same.
+func (m OrderBookMode) equal(other OrderBookMode) bool {
+	if (m.FixedPoint == nil) != (other.FixedPoint == nil) || (m.PriceLadder == nil) != (other.PriceLadder == nil) {
+		return false
+	}
+	if m.FixedPoint != nil && *m.FixedPoint != *other.FixedPoint {
+		return false
+	}
+
+	return m.PriceLadder == nil || (m.PriceLadder.MinPrice.Cmp(other.PriceLadder.MinPrice) == 0 &&
+		m.PriceLadder.MaxPrice.Cmp(other.PriceLadder.MaxPrice) == 0 &&
+		m.PriceLadder.TickSize.Cmp(other.PriceLadder.TickSize) == 0)
+}
+
+// Mode returns a copy of the mode of the order book.
+func (ob *OrderBook) Mode() OrderBookMode {
+	_ = ob.lock(context.Background())
+	defer ob.release()
+
+	return ob.mode()
+}
+
+func (ob *OrderBook) mode() OrderBookMode {
+	var mode OrderBookMode
+	if ob.Asks.fixed != nil {
+		fp := *ob.Asks.fixed
+		mode.FixedPoint = &fp
+	}
+	if ob.Asks.ladder != nil {
+		ladder := *ob.Asks.ladder
+		mode.PriceLadder = &ladder
+	}
+
+	return mode
+}
+
+// setMode sets the mode of the empty order book by SetFixedPoint and SetPriceLadder.
+func (ob *OrderBook) setMode(ctx context.Context, mode OrderBookMode) error {
+	if mode.FixedPoint != nil {
+		err := ob.SetFixedPoint(ctx, *mode.FixedPoint)
+		if err != nil {
+			return err
+		}
+	}
+
+	if mode.PriceLadder != nil {
+		return ob.SetPriceLadder(ctx, *mode.PriceLadder)
+	}
+
+	return nil
+}
+
+// OrderBookParts are the optional parts of the order book which have the state of the trading: the balances of Accounts,
+// the trading volumes of Fees and the entries of Ledger. The state is written to the snapshot and rebuilt by the recovery,
+// so the parts must be used by one order book. Their settings, like the fee tiers, aren't the state,
+// so the parts are created by the caller and restored empty.
+type OrderBookParts struct {
+	Accounts *Accounts
+	Fees     *FeeSchedule
+	Ledger   *Ledger
+}
+
+// attach attaches the parts to the order book.
+func (ob *OrderBook) attach(parts OrderBookParts) {
+	ob.Accounts = parts.Accounts
+	ob.Fees = parts.Fees
+	ob.Ledger = parts.Ledger
+}
+
+// checkEmpty checks that the parts don't have any state, so the restored state isn't mixed with it.
+func (p OrderBookParts) checkEmpty() error {
+	if p.Accounts != nil {
+		p.Accounts.mx.Lock()
+		n := len(p.Accounts.balances)
+		p.Accounts.mx.Unlock()
+		if n > 0 {
+			return errors.New("accounts to restore must be empty")
+		}
+	}
+
+	if p.Fees != nil {
+		p.Fees.mx.Lock()
+		n := len(p.Fees.volumes)
+		p.Fees.mx.Unlock()
+		if n > 0 {
+			return errors.New("fee schedule to restore must be empty")
+		}
+	}
+
+	if p.Ledger != nil {
+		p.Ledger.mx.Lock()
+		n := len(p.Ledger.entries)
+		p.Ledger.mx.Unlock()
+		if n > 0 {
+			return errors.New("ledger to restore must be empty")
+		}
+	}
+
+	return nil
+}
+
+// snapshotParts writes the state of the parts of the order book, every part is the flag of its presence and the state.
+func (ob *OrderBook) snapshotParts(e *encoder) {
+	e.bool(ob.Accounts != nil)
+	if ob.Accounts != nil {
+		ob.Accounts.snapshot(e)
+	}
+
+	e.bool(ob.Fees != nil)
+	if ob.Fees != nil {
+		ob.Fees.snapshot(e)
+	}
+
+	e.bool(ob.Ledger != nil)
+	if ob.Ledger != nil {
+		ob.Ledger.snapshot(e)
+	}
+}
+
+// snapshot writes the balances sorted by the accounts and the assets, the empty ones are skipped.
+func (a *Accounts) snapshot(e *encoder) {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+
+	keys := make([]balanceKey, 0, len(a.balances))
+	for account, assets := range a.balances {
+		for asset, b := range assets {
+			if !b.Available.IsZero() || !b.Reserved.IsZero() {
+				keys = append(keys, balanceKey{account: account, asset: asset})
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].account != keys[j].account {
+			return keys[i].account < keys[j].account
+		}
+
+		return keys[i].asset < keys[j].asset
+	})
+
+	e.uvarint(uint64(len(keys)))
+	for _, k := range keys {
+		b := a.balances[k.account][k.asset]
+		e.string(string(k.account))
+		e.string(string(k.asset))
+		e.decimal(b.Available)
+		e.decimal(b.Reserved)
+	}
+}
+
+// snapshot writes the trading volumes sorted by the accounts and the days, the zero ones are skipped.
+func (fs *FeeSchedule) snapshot(e *encoder) {
+	fs.mx.Lock()
+	defer fs.mx.Unlock()
+
+	type volumeKey struct {
+		account AccountID
+		day     int64
+	}
+
+	keys := make([]volumeKey, 0, len(fs.volumes))
+	for account, days := range fs.volumes {
+		for d, v := range days {
+			if !v.IsZero() {
+				keys = append(keys, volumeKey{account: account, day: d})
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].account != keys[j].account {
+			return keys[i].account < keys[j].account
+		}
+
+		return keys[i].day < keys[j].day
+	})
+
+	e.uvarint(uint64(len(keys)))
+	for _, k := range keys {
+		e.string(string(k.account))
+		e.uint64(uint64(k.day))
+		e.decimal(fs.volumes[k.account][k.day])
+	}
+}
+
+// snapshot writes the entries in the order of their sequence numbers.
+func (l *Ledger) snapshot(e *encoder) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	e.uvarint(uint64(len(l.entries)))
+	for _, entry := range l.entries {
+		e.string(entry.Instrument)
+		e.string(string(entry.InitiatorOrderID))
+		e.string(string(entry.ExecutorOrderID))
+		e.bool(entry.Reversal)
+		e.time(entry.CreatedAt)
+		e.uvarint(uint64(len(entry.Postings)))
+		for _, p := range entry.Postings {
+			e.string(string(p.Account))
+			e.string(string(p.Asset))
+			e.decimal(p.Amount)
+		}
+	}
+}
+
+// partsState is the state of the parts read from the snapshot, it's set to the parts when the whole snapshot is read.
+type partsState struct {
+	balances map[AccountID]map[Asset]*Balance
+	volumes  map[AccountID]map[int64]*apd.Decimal
+	entries  []LedgerEntry
+}
+
+// restoreParts reads the state of the parts written by snapshotParts. The snapshot must have the state
+// of the same parts as the order book gets.
+func restoreParts(d *decoder, parts OrderBookParts) (partsState, error) {
+	var state partsState
+
+	ok, err := hasPart(d, "balances", parts.Accounts != nil)
+	if err != nil {
+		return state, err
+	}
+	if ok {
+		state.balances, err = restoreBalances(d)
+		if err != nil {
+			return state, err
+		}
+	}
+
+	ok, err = hasPart(d, "fee volumes", parts.Fees != nil)
+	if err != nil {
+		return state, err
+	}
+	if ok {
+		state.volumes, err = restoreVolumes(d)
+		if err != nil {
+			return state, err
+		}
+	}
+
+	ok, err = hasPart(d, "ledger", parts.Ledger != nil)
+	if err != nil {
+		return state, err
+	}
+	if ok {
+		state.entries, err = restoreEntries(d)
+		if err != nil {
+			return state, err
+		}
+	}
+
+	return state, nil
+}
+
+// restoreBalances reads the balances written by Accounts.snapshot.
+func restoreBalances(d *decoder) (map[AccountID]map[Asset]*Balance, error) {
+	balances := map[AccountID]map[Asset]*Balance{}
+	n := d.count()
+	for i := 0; i < n; i++ {
+		account, asset := AccountID(d.string()), Asset(d.string())
+		b := &Balance{Available: d.decimal(), Reserved: d.decimal()}
+		if d.err != nil || b.Available == nil || b.Reserved == nil {
+			return nil, fmt.Errorf("%w: wrong balance: %d", ErrCorruptedSnapshot, i)
+		}
+
+		if balances[account] == nil {
+			balances[account] = map[Asset]*Balance{}
+		}
+		balances[account][asset] = b
+	}
+
+	return balances, nil
+}
+
+// restoreVolumes reads the trading volumes written by FeeSchedule.snapshot.
+func restoreVolumes(d *decoder) (map[AccountID]map[int64]*apd.Decimal, error) {
+	volumes := map[AccountID]map[int64]*apd.Decimal{}
+	n := d.count()
+	for i := 0; i < n; i++ {
+		account, day, v := AccountID(d.string()), int64(d.uint64()), d.decimal()
+		if d.err != nil || v == nil {
+			return nil, fmt.Errorf("%w: wrong fee volume: %d", ErrCorruptedSnapshot, i)
+		}
+
+		if volumes[account] == nil {
+			volumes[account] = map[int64]*apd.Decimal{}
+		}
+		volumes[account][day] = v
+	}
+
+	return volumes, nil
+}
+
+// restoreEntries reads the ledger entries written by Ledger.snapshot.
+func restoreEntries(d *decoder) ([]LedgerEntry, error) {
+	entries := make([]LedgerEntry, d.count())
+	for i := range entries {
+		entry := LedgerEntry{
+			Instrument:       d.string(),
+			InitiatorOrderID: OrderID(d.string()),
+			ExecutorOrderID:  OrderID(d.string()),
+			Reversal:         d.bool(),
+			CreatedAt:        d.time(),
+			Postings:         make([]Posting, d.count()),
+		}
+		for j := range entry.Postings {
+			entry.Postings[j] = Posting{Account: AccountID(d.string()), Asset: Asset(d.string()), Amount: d.decimal()}
+			if d.err != nil || entry.Postings[j].Amount == nil {
+				return nil, fmt.Errorf("%w: wrong ledger entry: %d", ErrCorruptedSnapshot, i)
+			}
+		}
+		if d.err != nil {
+			return nil, fmt.Errorf("%w: wrong ledger entry: %d", ErrCorruptedSnapshot, i)
+		}
+
+		entries[i] = entry
+	}
+
+	return entries, nil
+}
+
+// hasPart reads the flag of the part and checks that the order book gets the part if the snapshot has its state
+// and vice versa.
+func hasPart(d *decoder, name string, attached bool) (bool, error) {
+	ok := d.bool()
+	if d.err != nil {
+		return false, fmt.Errorf("%w: wrong %s: %v", ErrCorruptedSnapshot, name, d.err)
+	}
+	if ok && !attached {
+		return false, fmt.Errorf("snapshot has %s, but order book doesn't get them", name)
+	}
+	if !ok && attached {
+		return false, fmt.Errorf("order book gets %s, but snapshot doesn't have them", name)
+	}
+
+	return ok, nil
+}
+
+// set sets the state to the empty parts.
+func (s partsState) set(parts OrderBookParts) {
+	if parts.Accounts != nil {
+		parts.Accounts.mx.Lock()
+		parts.Accounts.balances = s.balances
+		parts.Accounts.mx.Unlock()
+	}
+
+	if parts.Fees != nil {
+		parts.Fees.mx.Lock()
+		parts.Fees.volumes = s.volumes
+		parts.Fees.mx.Unlock()
+	}
+
+	if parts.Ledger != nil {
+		parts.Ledger.mx.Lock()
+		for _, entry := range s.entries {
+			parts.Ledger.add(entry)
+		}
+		parts.Ledger.mx.Unlock()
+	}
+}
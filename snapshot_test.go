@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/apd"
+)
+
+func Test_SnapshotRestore(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	for _, o := range testOrders() {
+		o.accountID = "trader"
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	// the order "1" is executed completely, the order "11" is executed partially.
+	_, err := ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID:       "100500",
+		operationType: Bid,
+		amount:        apd.New(4, -1),
+		price:         apd.New(20050, 0),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = ob.Snapshot(&buf)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	data := buf.Bytes()
+
+	restored, err := RestoreOrderBook(bytes.NewReader(data), OrderBookParts{})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	if restored.BaseAsset != "BTC" || restored.QuoteAsset != "USDT" {
+		t.Fatalf("unexpected assets: %s-%s", restored.BaseAsset, restored.QuoteAsset)
+	}
+	if len(restored.Orders) != len(ob.Orders) || len(restored.OrdersDone) != len(ob.OrdersDone) {
+		t.Fatalf("unexpected orders: %d, %d", len(restored.Orders), len(restored.OrdersDone))
+	}
+	if len(restored.OrdersByAccount["trader"]) != 13 {
+		t.Fatalf("unexpected orders of account: %d", len(restored.OrdersByAccount["trader"]))
+	}
+
+	// the resting order and the done order are the same object, as the executions of both orders.
+	el := restored.Orders["11"]
+	if el.Value.(*Order) != restored.OrdersDone["11"] {
+		t.Fatalf("resting order isn't the done order")
+	}
+	if restored.OrdersDone["100500"].executions[1] != restored.OrdersDone["11"].executions[0] {
+		t.Fatalf("execution isn't shared")
+	}
+
+	var restoredBuf bytes.Buffer
+	err = restored.Snapshot(&restoredBuf)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if !bytes.Equal(data, restoredBuf.Bytes()) {
+		t.Fatalf("snapshot of the restored order book is different")
+	}
+
+	// the same orders are executed in the same order on both books.
+	createdAt := time.Now()
+	for _, book := range []*OrderBook{ob, restored} {
+		_, err = book.PlaceLimitOrder(context.Background(), &Order{
+			orderID:       "100501",
+			operationType: Ask,
+			amount:        apd.New(2, 0),
+			price:         apd.New(19900, 0),
+			createdAt:     createdAt,
+		})
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	executions, restoredExecutions := ob.OrdersDone["100501"].executions, restored.OrdersDone["100501"].executions
+	if len(executions) != 6 || len(executions) != len(restoredExecutions) {
+		t.Fatalf("unexpected executions: %d, %d", len(executions), len(restoredExecutions))
+	}
+	for i := range executions {
+		if executions[i].executorOrderID != restoredExecutions[i].executorOrderID ||
+			executions[i].amount.Cmp(restoredExecutions[i].amount) != 0 {
+			t.Fatalf("unexpected execution: %d", i)
+		}
+	}
+
+	buf.Reset()
+	restoredBuf.Reset()
+	for book, w := range map[*OrderBook]*bytes.Buffer{ob: &buf, restored: &restoredBuf} {
+		err = book.Snapshot(w)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+	if !bytes.Equal(buf.Bytes(), restoredBuf.Bytes()) {
+		t.Fatalf("order books are different after the execution")
+	}
+}
+
+func Test_SnapshotCorrupted(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	for _, o := range testOrders() {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := ob.Snapshot(&buf)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	data := buf.Bytes()
+	data[len(data)/2] ^= 0xff
+
+	_, err = RestoreOrderBook(bytes.NewReader(data), OrderBookParts{})
+	if !errors.Is(err, ErrCorruptedSnapshot) {
+		t.Fatalf("expected corrupted snapshot err, but got: %v", err)
+	}
+
+	_, err = RestoreOrderBook(bytes.NewReader(data[:3]), OrderBookParts{})
+	if !errors.Is(err, ErrCorruptedSnapshot) {
+		t.Fatalf("expected corrupted snapshot err, but got: %v", err)
+	}
+}
+
+func Test_SnapshotRestoreMode(t *testing.T) {
+	ladder := &PriceLadder{MinPrice: apd.New(19000, 0), MaxPrice: apd.New(21000, 0), TickSize: apd.New(50, 0)}
+	fixed := &FixedPoint{PriceDecimals: 0, AmountDecimals: 1}
+
+	for _, mode := range []OrderBookMode{
+		{FixedPoint: fixed},
+		{PriceLadder: ladder},
+		{FixedPoint: fixed, PriceLadder: ladder},
+	} {
+		ob := NewOrderBook("BTC", "USDT")
+		err := ob.setMode(context.Background(), mode)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		for _, o := range append(testOrders(), &Order{
+			orderID: "100500", operationType: Bid, amount: apd.New(4, -1), price: apd.New(20050, 0),
+		}) {
+			_, err = ob.PlaceLimitOrder(context.Background(), o)
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+		}
+
+		var buf bytes.Buffer
+		err = ob.Snapshot(&buf)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+
+		restored, err := RestoreOrderBook(bytes.NewReader(buf.Bytes()), OrderBookParts{})
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		if !restored.Mode().equal(mode) {
+			t.Fatalf("unexpected mode: %+v", restored.Mode())
+		}
+		if mode.PriceLadder != nil {
+			if _, ok := restored.Asks.index.(*tickLadder); !ok {
+				t.Fatalf("unexpected index: %T", restored.Asks.index)
+			}
+		}
+		if mode.FixedPoint != nil {
+			level, ok := restored.Asks.index.Get(priceKey{n: 20050})
+			if !ok || level.fixedTotal != 6 || level.orders.Front().Value.(*Order).fixedAmount != 4 {
+				t.Fatalf("unexpected price level: %+v", restored.Asks.index.Values())
+			}
+		}
+
+		// the same orders are executed in the same order on both books.
+		createdAt := time.Now()
+		buf.Reset()
+		var restoredBuf bytes.Buffer
+		for book, w := range map[*OrderBook]*bytes.Buffer{ob: &buf, restored: &restoredBuf} {
+			_, err = book.PlaceLimitOrder(context.Background(), &Order{
+				orderID: "100501", operationType: Ask, amount: apd.New(2, 0), price: apd.New(19900, 0), createdAt: createdAt,
+			})
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+
+			err = book.Snapshot(w)
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+		}
+		if !bytes.Equal(buf.Bytes(), restoredBuf.Bytes()) {
+			t.Fatalf("order books are different after the execution: %+v", mode)
+		}
+	}
+}
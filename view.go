@@ -0,0 +1,278 @@
+package main
+
+import "github.com/cockroachdb/apd"
+
+// viewChunk is a number of the price levels in a chunk of the view.
+const viewChunk = 64
+
+// BookView is an immutable view of the price levels of the order book after a command.
+// It's published by the atomic pointer swap, so the readers don't take the lock of the order book
+// and don't slow down the matching. The levels and their decimals are shared by the views,
+// the readers must not modify them.
+type BookView struct {
+	// Version is increased by every published view.
+	Version uint64
+
+	asks viewSide
+	bids viewSide
+}
+
+// viewSide is the price levels of the side from the best price. They are kept by the immutable chunks,
+// so a new view copies only the chunks of the changed levels and shares the rest with the previous view.
+type viewSide struct {
+	chunks [][]PriceLevel
+	size   int
+}
+
+// Depth returns up to limit price levels of both sides starting from the best prices.
+// If limit is 0 - all price levels are returned.
+func (v *BookView) Depth(limit int) (asks, bids []PriceLevel) {
+	return v.asks.levels(limit), v.bids.levels(limit)
+}
+
+// Levels returns the number of the price levels of both sides.
+func (v *BookView) Levels() (asks, bids int) {
+	return v.asks.size, v.bids.size
+}
+
+// BestAsk returns the best ask level.
+func (v *BookView) BestAsk() (PriceLevel, bool) {
+	return v.asks.best()
+}
+
+// BestBid returns the best bid level.
+func (v *BookView) BestBid() (PriceLevel, bool) {
+	return v.bids.best()
+}
+
+// levels returns the copy of up to limit levels, so the caller can't change the view.
+func (s viewSide) levels(limit int) []PriceLevel {
+	n := s.size
+	if limit > 0 && n > limit {
+		n = limit
+	}
+
+	levels := make([]PriceLevel, 0, n)
+	for _, chunk := range s.chunks {
+		if len(levels)+len(chunk) > n {
+			chunk = chunk[:n-len(levels)]
+		}
+		levels = append(levels, chunk...)
+		if len(levels) == n {
+			break
+		}
+	}
+
+	return levels
+}
+
+// best returns the level of the best price.
+func (s viewSide) best() (PriceLevel, bool) {
+	if s.size == 0 {
+		return PriceLevel{}, false
+	}
+
+	return s.chunks[0][0], true
+}
+
+// View returns the last published view of the order book, it doesn't take the lock.
+func (ob *OrderBook) View() *BookView {
+	return ob.view.Load()
+}
+
+// TopOfBook returns the best ask and the best bid, ok is false for the empty side. It doesn't take the lock.
+func (ob *OrderBook) TopOfBook() (ask, bid PriceLevel, askOk, bidOk bool) {
+	v := ob.View()
+	ask, askOk = v.BestAsk()
+	bid, bidOk = v.BestBid()
+
+	return ask, bid, askOk, bidOk
+}
+
+// unlock publishes the view of the changed sides and unlocks the order book.
+func (ob *OrderBook) unlock() {
+	ob.publishView()
+	ob.release()
+}
+
+// publishView publishes a new view if the levels are changed, the unchanged levels are shared with the previous view.
+// It must be called under the lock of the order book.
+func (ob *OrderBook) publishView() {
+	prev := ob.view.Load()
+	if prev != nil && !ob.Asks.viewChanged() && !ob.Bids.viewChanged() {
+		return
+	}
+
+	v := &BookView{}
+	if prev != nil {
+		*v = *prev
+		v.Version++
+	}
+	v.asks = ob.Asks.patchView(v.asks, prev == nil)
+	v.bids = ob.Bids.patchView(v.bids, prev == nil)
+
+	ob.view.Store(v)
+}
+
+// levelChange is a price level which is changed after the last view.
+type levelChange struct {
+	price *apd.Decimal
+	key   priceKey
+}
+
+// viewChanged returns true if the levels of the side are changed after the last view.
+func (os *OrderSide) viewChanged() bool {
+	return os.dirty || len(os.changed) > 0
+}
+
+// patchView returns the levels of the side for the next view: the chunks of the changed levels are copied,
+// the rest are shared with the previous view. All levels are copied if the side is dirty or there is no previous view.
+func (os *OrderSide) patchView(prev viewSide, all bool) viewSide {
+	if !os.viewChanged() && !all {
+		return prev
+	}
+
+	if os.dirty || all {
+		os.dirty, os.changed = false, os.changed[:0]
+
+		var s viewSide
+		s.appendChunk(os.Depth(0))
+
+		return s
+	}
+
+	changed := os.sortChanged()
+	s := viewSide{chunks: make([][]PriceLevel, 0, len(prev.chunks)+1)}
+	k := 0
+	for i, chunk := range prev.chunks {
+		// the changes up to the last level of the chunk are in it, the last chunk takes the rest.
+		last := chunk[len(chunk)-1].Price
+		end := k
+		for end < len(changed) && (i == len(prev.chunks)-1 || !os.better(last, changed[end].price)) {
+			end++
+		}
+
+		if end == k {
+			s.chunks = append(s.chunks, chunk)
+			s.size += len(chunk)
+
+			continue
+		}
+
+		s.appendChunk(os.mergeView(chunk, changed[k:end]))
+		k = end
+	}
+	if k < len(changed) {
+		// there were no levels in the previous view.
+		s.appendChunk(os.mergeView(nil, changed[k:]))
+	}
+	os.changed = os.changed[:0]
+
+	return s
+}
+
+// appendChunk appends the levels by the chunks, the small chunk is joined with the previous one.
+func (s *viewSide) appendChunk(levels []PriceLevel) {
+	if len(levels) == 0 {
+		return
+	}
+	s.size += len(levels)
+
+	if n := len(s.chunks); n > 0 && len(levels) < viewChunk/2 && len(s.chunks[n-1])+len(levels) <= viewChunk {
+		last := s.chunks[n-1]
+		s.chunks[n-1] = append(append(make([]PriceLevel, 0, len(last)+len(levels)), last...), levels...)
+
+		return
+	}
+
+	for len(levels) > 0 {
+		n := len(levels)
+		if n > 2*viewChunk {
+			n = viewChunk
+		}
+
+		s.chunks = append(s.chunks, levels[:n:n])
+		levels = levels[n:]
+	}
+}
+
+// mergeView returns the levels of the previous view with the changed levels, which are sorted from the best price.
+func (os *OrderSide) mergeView(prev []PriceLevel, changed []levelChange) []PriceLevel {
+	levels := make([]PriceLevel, 0, len(prev)+len(changed))
+	pos := 0
+	for _, c := range changed {
+		i := os.searchView(prev, pos, c.price)
+		levels = append(levels, prev[pos:i]...)
+		pos = i
+
+		var price *apd.Decimal
+		if i < len(prev) && prev[i].Price.Cmp(c.price) == 0 {
+			// the level was in the previous view, the price is shared.
+			price = prev[i].Price
+			pos++
+		}
+
+		level, ok := os.index.Get(c.key)
+		if !ok {
+			// the level is removed.
+			continue
+		}
+		if price == nil {
+			price = apd.New(0, 0).Set(level.price)
+		}
+
+		levels = append(levels, PriceLevel{
+			Price:  price,
+			Amount: os.total(level),
+			Orders: level.orders.Len(),
+		})
+	}
+
+	return append(levels, prev[pos:]...)
+}
+
+// sortChanged sorts the changed levels from the best price and drops the duplicates.
+// There are a few of them, so the insertion sort doesn't allocate and is fast enough.
+func (os *OrderSide) sortChanged() []levelChange {
+	changed := os.changed
+	n := 0
+	for _, c := range changed {
+		i := n
+		for i > 0 && os.better(c.price, changed[i-1].price) {
+			i--
+		}
+		if i > 0 && changed[i-1].price.Cmp(c.price) == 0 {
+			continue
+		}
+
+		copy(changed[i+1:n+1], changed[i:n])
+		changed[i] = c
+		n++
+	}
+
+	return changed[:n]
+}
+
+// searchView returns the index of the first level from pos which isn't better than the price.
+func (os *OrderSide) searchView(levels []PriceLevel, pos int, price *apd.Decimal) int {
+	i, j := pos, len(levels)
+	for i < j {
+		h := int(uint(i+j) >> 1)
+		if os.better(levels[h].Price, price) {
+			i = h + 1
+		} else {
+			j = h
+		}
+	}
+
+	return i
+}
+
+// better returns true if the price a is better than b: less for asks and greater for bids.
+func (os *OrderSide) better(a, b *apd.Decimal) bool {
+	if os.sideType == Bid {
+		return a.Cmp(b) > 0
+	}
+
+	return a.Cmp(b) < 0
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/cockroachdb/apd"
+)
+
+func Test_BookView(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	v := ob.View()
+	if asks, bids := v.Levels(); v.Version != 0 || asks != 0 || bids != 0 {
+		t.Fatalf("unexpected view: %+v", v)
+	}
+
+	for _, o := range []*Order{
+		{orderID: "1", operationType: Ask, amount: apd.New(1, 0), price: apd.New(101, 0)},
+		{orderID: "2", operationType: Ask, amount: apd.New(2, 0), price: apd.New(100, 0)},
+		{orderID: "3", operationType: Bid, amount: apd.New(3, 0), price: apd.New(99, 0)},
+	} {
+		_, err := ob.PlaceLimitOrder(context.Background(), o)
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	prev := ob.View()
+	if asks, bids := prev.Levels(); prev.Version != 3 || asks != 2 || bids != 1 {
+		t.Fatalf("unexpected view: %+v", prev)
+	}
+
+	// the readers don't wait for the lock of the order book.
+	ob.mx.Lock()
+	ask, bid, askOk, bidOk := ob.TopOfBook()
+	asks, bids := ob.Depth(context.Background(), 1)
+	ob.mx.Unlock()
+
+	if !askOk || !bidOk || ask.Price.Cmp(apd.New(100, 0)) != 0 || bid.Amount.Cmp(apd.New(3, 0)) != 0 {
+		t.Fatalf("unexpected top of book: %+v, %+v", ask, bid)
+	}
+	if len(asks) != 1 || len(bids) != 1 || asks[0].Price.Cmp(apd.New(100, 0)) != 0 {
+		t.Fatalf("unexpected depth: %+v, %+v", asks, bids)
+	}
+
+	err := ob.Cancel(context.Background(), "2")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	v = ob.View()
+	asks, _ = v.Depth(0)
+	if v.Version != prev.Version+1 || len(asks) != 1 || asks[0].Price.Cmp(apd.New(101, 0)) != 0 {
+		t.Fatalf("unexpected view: %+v", v)
+	}
+	// the previous view isn't changed, the unchanged side is shared.
+	if n, _ := prev.Levels(); n != 2 || &v.bids.chunks[0][0] != &prev.bids.chunks[0][0] {
+		t.Fatalf("previous view is changed: %+v", prev)
+	}
+
+	// the failed command doesn't publish a new view.
+	err = ob.Cancel(context.Background(), "2")
+	if err == nil {
+		t.Fatalf("expected err, but got nil")
+	}
+	if ob.View() != v {
+		t.Fatalf("expected the same view")
+	}
+}
+
+func Test_BookViewConcurrentReads(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for ctx.Err() == nil {
+				v := ob.View()
+				asks, bids := v.Depth(0)
+				for _, l := range append(asks, bids...) {
+					if l.Amount.Sign() <= 0 || l.Orders == 0 {
+						t.Errorf("unexpected level: %+v", l)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 200; i++ {
+		side := Ask
+		if i%2 == 1 {
+			side = Bid
+		}
+
+		_, err := ob.PlaceLimitOrder(context.Background(), &Order{
+			orderID: OrderID(fmt.Sprint(i)), operationType: side,
+			amount: apd.New(int64(i%3+1), 0), price: apd.New(int64(100+i%5), 0),
+		})
+		if err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func Test_BookViewPatch(t *testing.T) {
+	for _, fixed := range []bool{false, true} {
+		ob := NewOrderBook("BTC", "USDT")
+		if fixed {
+			err := ob.SetFixedPoint(context.Background(), FixedPoint{PriceDecimals: 1, AmountDecimals: 0})
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+		}
+
+		rnd := rand.New(rand.NewSource(1))
+		for i := 0; i < 5000; i++ {
+			var err error
+			if rnd.Intn(4) == 0 && len(ob.Orders) > 0 {
+				for id := range ob.Orders {
+					err = ob.Cancel(context.Background(), id)
+					break
+				}
+			} else {
+				// the sides overlap, so some orders are executed, there are hundreds of levels to split the view by chunks.
+				side, price := Ask, rnd.Intn(400)+950
+				if rnd.Intn(2) == 0 {
+					side, price = Bid, rnd.Intn(400)+650
+				}
+
+				_, err = ob.PlaceLimitOrder(context.Background(), &Order{
+					orderID: OrderID(fmt.Sprint(i)), operationType: side,
+					amount: apd.New(int64(rnd.Intn(5)+1), 0), price: apd.New(int64(price), -1),
+				})
+			}
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			// the view is patched by every command, so the wrong patch stays in it till it's checked.
+			if i%10 != 0 {
+				continue
+			}
+
+			v := ob.View()
+			asks, bids := v.Depth(0)
+			if n, m := v.Levels(); n != len(asks) || m != len(bids) {
+				t.Fatalf("unexpected number of levels: %d, %d", n, m)
+			}
+			for _, side := range []struct {
+				view, levels []PriceLevel
+			}{{asks, ob.Asks.Depth(0)}, {bids, ob.Bids.Depth(0)}} {
+				if len(side.view) != len(side.levels) {
+					t.Fatalf("unexpected view: %+v, expected: %+v", side.view, side.levels)
+				}
+				for j, l := range side.levels {
+					vl := side.view[j]
+					if vl.Price.Cmp(l.Price) != 0 || vl.Amount.Cmp(l.Amount) != 0 || vl.Orders != l.Orders {
+						t.Fatalf("unexpected level: %+v, expected: %+v", vl, l)
+					}
+				}
+			}
+		}
+	}
+}
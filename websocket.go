@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// wsWriteTimeout is a timeout of the write to the websocket.
+	wsWriteTimeout = 10 * time.Second
+	// wsPingInterval is an interval of the pings, the client must answer in wsPongTimeout.
+	wsPingInterval = 30 * time.Second
+	wsPongTimeout  = 60 * time.Second
+)
+
+// wsRequest is a message from the websocket client, {"type":"snapshot"} requests a new snapshot.
+type wsRequest struct {
+	Type string `json:"type"`
+}
+
+// upgrader upgrades the HTTP connection to the websocket.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	// the feed is public market data.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// stream streams the market data of the order book to the websocket:
+// the depth snapshot and then the level updates and the trades with the sequence numbers.
+// The slow client gets {"type":"gap"} instead of the dropped updates, it sends {"type":"snapshot"} then.
+func (s *HTTPServer) stream(ob *OrderBook, w http.ResponseWriter, r *http.Request) error {
+	sub, err := ob.SubscribeMarketData(s.streamBuffer)
+	if err != nil {
+		return &apiError{Status: http.StatusNotFound, Code: CodeNotFound, Message: err.Error()}
+	}
+	defer sub.Close()
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has written the error response.
+		return nil
+	}
+	defer conn.Close()
+
+	go s.readStream(conn, sub)
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case m, ok := <-sub.Messages():
+			if !ok {
+				return nil
+			}
+
+			_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			err = conn.WriteJSON(m)
+			if err != nil {
+				return nil
+			}
+
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+			if err != nil {
+				return nil
+			}
+
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
+
+// readStream reads the requests of the client, the subscription is closed when the client is gone.
+func (s *HTTPServer) readStream(conn *websocket.Conn, sub *MarketDataSubscription) {
+	defer sub.Close()
+
+	_ = conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req wsRequest
+		if json.Unmarshal(data, &req) != nil || req.Type != "snapshot" {
+			continue
+		}
+
+		if sub.Resync() != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func readStream(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var m map[string]interface{}
+	err := conn.ReadJSON(&m)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	return m
+}
+
+func Test_WebSocketStream(t *testing.T) {
+	ob := NewOrderBook("BTC", "USDT")
+	ob.MarketData = NewMarketDataFeed()
+
+	exchange := NewExchange()
+	err := exchange.AddBook(ob)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	server := httptest.NewServer(NewHTTPServer(exchange))
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/books/BTC-USDT/stream"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	defer conn.Close()
+
+	m := readStream(t, conn)
+	if m["type"] != "snapshot" || m["seq"] != float64(0) || m["instrument"] != "BTC-USDT" {
+		t.Fatalf("wrong snapshot: %v", m)
+	}
+
+	_, err = ob.PlaceLimitOrder(context.Background(), &Order{
+		orderID: "1", operationType: Ask, price: decimal(t, "20000.10"), amount: decimal(t, "1.5"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	m = readStream(t, conn)
+	if m["type"] != "level" || m["seq"] != float64(1) || m["side"] != "ask" ||
+		m["price"] != "20000.10" || m["amount"] != "1.5" || m["orders"] != float64(1) {
+		t.Fatalf("wrong level: %v", m)
+	}
+
+	_, _, err = ob.PlaceMarketOrder(context.Background(), &Order{
+		orderID: "2", operationType: Bid, price: decimal(t, "20100"), amount: decimal(t, "0.5"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	m = readStream(t, conn)
+	if m["type"] != "trade" || m["seq"] != float64(2) || m["amount"] != "0.5" || m["taker_side"] != "bid" {
+		t.Fatalf("wrong trade: %v", m)
+	}
+	m = readStream(t, conn)
+	if m["type"] != "level" || m["seq"] != float64(3) || m["amount"] != "1.0" {
+		t.Fatalf("wrong level: %v", m)
+	}
+
+	err = conn.WriteJSON(wsRequest{Type: "snapshot"})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+
+	m = readStream(t, conn)
+	asks, _ := m["asks"].([]interface{})
+	if m["type"] != "snapshot" || m["seq"] != float64(3) || len(asks) != 1 {
+		t.Fatalf("wrong snapshot: %v", m)
+	}
+}